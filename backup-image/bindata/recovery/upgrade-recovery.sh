@@ -22,12 +22,15 @@ function usage {
 ${PROG}: Runs post-rollback restore procedure
 
 Options:
-    --dir <dir>:    Location of backup content
+    --dir <dir>:    Location of backup content: a backup generation, or the recovery
+                    partition to use its current generation
 
 Backup options:
     --take-backup:  Take backup
+    --format <fmt>: Format of the backed up directories: raw (default), gzip or zstd
 
 Recovery options:
+    --check:        Check the backup content required by the recovery, and exit
     --force:        Skip ostree deployment check
     --step:         Step through recovery stages
     --resume:       Resume recovery after last successful stage
@@ -160,6 +163,78 @@ function trigger_redeployment {
     echo "##### $(date -u): Completed ${name} redeployment"
 }
 
+#
+# backup_component:
+# Copy a directory in the backup, as is or as a compressed archive.
+# Archives are sorted by name and carry no timestamp of their own, so that
+# unchanged content gives identical archives
+#
+function backup_component {
+    local src=$1
+    local name=$2
+    local rc=
+
+    case "${BACKUP_FORMAT}" in
+        raw)
+            rsync -a ${src}/ ${BACKUP_DIR}/${name}/
+            ;;
+        gzip)
+            tar --sort=name --numeric-owner -C ${src} -cf - . | gzip -n > ${BACKUP_DIR}/${name}.tar.gz
+            rc=("${PIPESTATUS[@]}")
+            [ "${rc[0]}" -eq 0 ] && [ "${rc[1]}" -eq 0 ]
+            ;;
+        zstd)
+            tar --sort=name --numeric-owner -C ${src} -cf - . | zstd -q -T0 > ${BACKUP_DIR}/${name}.tar.zst
+            rc=("${PIPESTATUS[@]}")
+            [ "${rc[0]}" -eq 0 ] && [ "${rc[1]}" -eq 0 ]
+            ;;
+        *)
+            echo "Unsupported backup format: ${BACKUP_FORMAT}" >&2
+            return 1
+            ;;
+    esac
+}
+
+#
+# restore_component:
+# Restore a directory from the backup, unpacking its archive first when it
+# was backed up as one. Extra arguments are passed to rsync
+#
+function restore_component {
+    local name=$1
+    local dest=$2
+    shift 2
+    local src=${BACKUP_DIR}/${name}
+    local unpack=
+    local rc=
+
+    if [ ! -d "${src}" ]; then
+        unpack=${BACKUP_DIR}/.unpack-${name}
+        rm -rf ${unpack}
+        mkdir -p ${unpack}
+        if [ -f ${src}.tar.gz ]; then
+            tar --numeric-owner -xzf ${src}.tar.gz -C ${unpack}
+        elif [ -f ${src}.tar.zst ]; then
+            zstd -dcq ${src}.tar.zst | tar --numeric-owner -xf - -C ${unpack}
+        else
+            echo "No ${name} content in ${BACKUP_DIR}" >&2
+            false
+        fi
+        if [ $? -ne 0 ]; then
+            rm -rf ${unpack}
+            return 1
+        fi
+        src=${unpack}
+    fi
+
+    rsync -avc --delete --no-t "$@" ${src}/ ${dest}/
+    rc=$?
+    if [ -n "${unpack}" ]; then
+        rm -rf ${unpack}
+    fi
+    return ${rc}
+}
+
 #
 # take_backup:
 # Procedure for backing up data prior to upgrade
@@ -185,22 +260,27 @@ function take_backup {
         exit 1
     fi
 
+    oc get clusterversion version -o=jsonpath='{.status.desired.version}' > ${BACKUP_DIR}/ocp-version
+    if [ $? -ne 0 ]; then
+        echo "Failed to record the cluster version, continuing" >&2
+    fi
+
     cat /etc/tmpfiles.d/* | sed 's/#.*//' | awk '{print $2}' | grep '^/etc/' | sed 's#^/etc/##' > ${BACKUP_DIR}/etc.exclude.list
     echo '.updated' >> ${BACKUP_DIR}/etc.exclude.list
     echo 'kubernetes/manifests' >> ${BACKUP_DIR}/etc.exclude.list
-    rsync -a /etc/ ${BACKUP_DIR}/etc/
+    backup_component /etc etc
     if [ $? -ne 0 ]; then
         echo "Failed to backup /etc" >&2
         exit 1
     fi
 
-    rsync -a /usr/local/ ${BACKUP_DIR}/usrlocal/
+    backup_component /usr/local usrlocal
     if [ $? -ne 0 ]; then
         echo "Failed to backup /usr/local" >&2
         exit 1
     fi
 
-    rsync -a /var/lib/kubelet/ ${BACKUP_DIR}/kubelet/
+    backup_component /var/lib/kubelet kubelet
     if [ $? -ne 0 ]; then
         echo "Failed to backup /var/lib/kubelet" >&2
         exit 1
@@ -217,6 +297,29 @@ function take_backup {
     echo "##### $(date -u): Backup complete"
 }
 
+#
+# check_backup_content:
+# Checks the backup holds the content required by the recovery. The directories
+# may have been backed up as is or as compressed archives
+#
+function check_backup_content {
+    local name=
+
+    if [ ! -d "${BACKUP_DIR}/cluster" ]; then
+        echo "Required backup content not found in ${BACKUP_DIR}: cluster" >&2
+        return 1
+    fi
+    for name in etc usrlocal kubelet; do
+        if [ ! -d "${BACKUP_DIR}/${name}" ] && \
+                [ ! -f "${BACKUP_DIR}/${name}.tar.gz" ] && \
+                [ ! -f "${BACKUP_DIR}/${name}.tar.zst" ]; then
+            echo "Required backup content not found in ${BACKUP_DIR}: ${name}" >&2
+            return 1
+        fi
+    done
+    return 0
+}
+
 function is_restore_in_progress {
     test -f "${PROGRESS_FILE}"
 }
@@ -233,6 +336,38 @@ function clear_progress {
     rm -f "${PROGRESS_FILE}"
 }
 
+#
+# proc_stat_field:
+# Field of /proc/<pid>/stat, counted from the state, as the command name may hold spaces
+#
+function proc_stat_field {
+    sed 's/.*) //' "/proc/$1/stat" 2>/dev/null | awk -v field="$2" '{print $field}'
+}
+
+#
+# lock_backup_root:
+# Takes the lock of the recovery partition, as the backup tool does, so that backups, clean ups and
+# recoveries never interleave. The lock is held until the script exits. When the tool running the script
+# already holds it, the tool passes its locked file in the descriptor named by BACKUP_LOCK_FD
+#
+function lock_backup_root {
+    if [ -n "${BACKUP_LOCK_FD}" ]; then
+        if [[ "${BACKUP_LOCK_FD}" =~ ^[0-9]+$ ]] && [[ /dev/fd/${BACKUP_LOCK_FD} -ef ${LOCK_FILE} ]] && flock -n "${BACKUP_LOCK_FD}"; then
+            return 0
+        fi
+        echo "${BACKUP_ROOT} is not locked by the tool running the script, descriptor ${BACKUP_LOCK_FD}" >&2
+        exit 1
+    fi
+
+    exec 9>>"${LOCK_FILE}"
+    if flock -n 9; then
+        echo "$$ $(proc_stat_field $$ 20) $(date -u +%Y-%m-%dT%H:%M:%SZ) $1" > "${LOCK_FILE}"
+        return 0
+    fi
+    echo "${BACKUP_ROOT} is locked by: $(cat "${LOCK_FILE}" 2>/dev/null)" >&2
+    exit 1
+}
+
 function check_active_deployment {
     #
     # If the current deployment is not pinned, assume the platform has not been rolled back
@@ -265,7 +400,7 @@ function restore_files {
     # Restore /usr/local content
     #
     echo "##### $(date -u): Restoring /usr/local content"
-    time rsync -avc --delete --no-t ${BACKUP_DIR}/usrlocal/ /usr/local/
+    time restore_component usrlocal /usr/local
     if [ $? -ne 0 ]; then
         echo "$(date -u): Failed to restore /usr/local content" >&2
         exit 1
@@ -276,7 +411,7 @@ function restore_files {
     # Restore /var/lib/kubelet content
     #
     echo "##### $(date -u): Restoring /var/lib/kubelet content"
-    time rsync -avc --delete --no-t ${BACKUP_DIR}/kubelet/ /var/lib/kubelet/
+    time restore_component kubelet /var/lib/kubelet
     if [ $? -ne 0 ]; then
         echo "$(date -u): Failed to restore /var/lib/kubelet content" >&2
         exit 1
@@ -287,7 +422,7 @@ function restore_files {
     # Restore /etc content
     #
     echo "##### $(date -u): Restoring /etc content"
-    time rsync -avc --delete --no-t --exclude-from ${BACKUP_DIR}/etc.exclude.list ${BACKUP_DIR}/etc/ /etc/
+    time restore_component etc /etc --exclude-from ${BACKUP_DIR}/etc.exclude.list
     if [ $? -ne 0 ]; then
         echo "$(date -u): Failed to restore /etc content" >&2
         exit 1
@@ -397,10 +532,12 @@ declare RESTART_TIMEOUT=1200 # 20 minutes
 declare REDEPLOYMENT_TIMEOUT=1200 # 20 minutes
 declare SKIP_DEPLOY_CHECK="no"
 declare TAKE_BACKUP="no"
+declare BACKUP_FORMAT="raw"
 declare STEPTHROUGH="no"
 declare RESUME="no"
+declare CHECK_ONLY="no"
 
-LONGOPTS="dir:,force,restart,resume,step,take-backup"
+LONGOPTS="check,dir:,force,format:,restart,resume,step,take-backup"
 OPTS=$(getopt -o h --long "${LONGOPTS}" --name "$0" -- "$@")
 
 if [ $? -ne 0 ]; then
@@ -412,6 +549,10 @@ eval set -- "${OPTS}"
 
 while :; do
     case "$1" in
+        --check)
+            CHECK_ONLY="yes"
+            shift
+            ;;
         --dir)
             BACKUP_DIR=$2
             shift 2
@@ -420,6 +561,10 @@ while :; do
             SKIP_DEPLOY_CHECK="yes"
             shift
             ;;
+        --format)
+            BACKUP_FORMAT=$2
+            shift 2
+            ;;
         --restart)
             STEPTHROUGH_RESET="yes"
             shift
@@ -447,8 +592,35 @@ while :; do
     esac
 done
 
+#
+# Backups are kept in generations, use the current one when given the recovery partition
+#
+if [ "${TAKE_BACKUP}" = "no" ] && [ -L "${BACKUP_DIR}/current" ]; then
+    BACKUP_DIR=$(readlink -f "${BACKUP_DIR}/current")
+fi
+
 declare PROGRESS_FILE="${BACKUP_DIR}/progress"
 
+#
+# Check the backup content and exit, if requested
+#
+if [ "${CHECK_ONLY}" = "yes" ]; then
+    check_backup_content
+    exit $?
+fi
+
+#
+# The lock is kept at the root of the recovery partition, above the staging directory and the generations
+#
+declare BACKUP_ROOT=
+BACKUP_ROOT=$(readlink -f "${BACKUP_DIR}")
+if [ "$(basename "${BACKUP_ROOT}")" = "staging" ]; then
+    BACKUP_ROOT=$(dirname "${BACKUP_ROOT}")
+elif [ "$(basename "$(dirname "${BACKUP_ROOT}")")" = "generations" ]; then
+    BACKUP_ROOT=$(dirname "$(dirname "${BACKUP_ROOT}")")
+fi
+declare LOCK_FILE="${BACKUP_ROOT}/.lock"
+
 # shellcheck source=/dev/null
 source /etc/kubernetes/static-pod-resources/etcd-certs/configmaps/etcd-scripts/etcd-common-tools
 
@@ -456,6 +628,7 @@ source /etc/kubernetes/static-pod-resources/etcd-certs/configmaps/etcd-scripts/e
 # Perform backup and exit, if requested
 #
 if [ "${TAKE_BACKUP}" = "yes" ]; then
+    lock_backup_root backup
     take_backup
     exit 0
 fi
@@ -471,14 +644,12 @@ fi
 #
 # Validate arguments
 #
-if [ ! -d "${BACKUP_DIR}/cluster" ] || \
-        [ ! -d "${BACKUP_DIR}/etc" ] || \
-        [ ! -d "${BACKUP_DIR}/usrlocal" ] || \
-        [ ! -d "${BACKUP_DIR}/kubelet" ]; then
-    echo "Required backup content not found in ${BACKUP_DIR}" >&2
+if ! check_backup_content; then
     exit 1
 fi
 
+lock_backup_root recovery
+
 #
 # Clear progress flag, if requested
 #
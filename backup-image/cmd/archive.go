@@ -0,0 +1,202 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats in which the backed up directories are stored
+const (
+	FormatRaw  string = "raw"
+	FormatGzip string = "gzip"
+	FormatZstd string = "zstd"
+)
+
+// archiveExtensions are the extensions of the archives of the backed up directories, by format
+var archiveExtensions = map[string]string{
+	FormatGzip: ".tar.gz",
+	FormatZstd: ".tar.zst",
+}
+
+// StorageSummary sums up the space used by a backup, before and after compression and deduplication
+type StorageSummary struct {
+	// Format is the format of the backed up directories
+	Format string `json:"format"`
+	// SourceBytes is the size of the backed up content
+	SourceBytes int64 `json:"sourceBytes"`
+	// SizeBytes is the size of the files of the backup
+	SizeBytes int64 `json:"sizeBytes"`
+	// DedupBytes is the size of the files shared with the previous generation
+	DedupBytes int64 `json:"dedupBytes"`
+	// StoredBytes is the space used by this backup alone
+	StoredBytes int64 `json:"storedBytes"`
+}
+
+// ValidateFormat checks the format is supported, and that its compressor is found for zstd
+// returns:			error
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatRaw, FormatGzip:
+		return nil
+	case FormatZstd:
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("the zstd format needs zstd on the node: %s", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported backup format %q, expecting %s, %s or %s", format, FormatRaw, FormatGzip, FormatZstd)
+}
+
+// componentCandidates lists the paths a component may be found at in the backup, with their formats: as is,
+// as an archive, and encrypted
+// returns:			paths, formats
+func componentCandidates(BackupPath string, component string) ([]string, []string) {
+	path := filepath.Join(BackupPath, component)
+	paths := []string{path, path + EncryptedSuffix}
+	formats := []string{FormatRaw, FormatRaw}
+	for _, format := range []string{FormatGzip, FormatZstd} {
+		archive := path + archiveExtensions[format]
+		paths = append(paths, archive, archive+EncryptedSuffix)
+		formats = append(formats, format, format)
+	}
+	return paths, formats
+}
+
+// ComponentPath returns the path of a component in the backup, archived or not, or "" when it is missing
+// returns:			string, format
+func ComponentPath(BackupPath string, component string) (string, string) {
+	paths, formats := componentCandidates(BackupPath, component)
+	for i, path := range paths {
+		if _, err := os.Lstat(path); err == nil {
+			return path, formats[i]
+		}
+	}
+	return "", ""
+}
+
+// componentOf returns the component a file of the backup belongs to, by its path relative to the backup
+// returns:			string
+func componentOf(rel string) string {
+	first := strings.TrimSuffix(strings.SplitN(filepath.ToSlash(rel), "/", 2)[0], EncryptedSuffix)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(first, ext) {
+			return strings.TrimSuffix(first, ext)
+		}
+	}
+	return first
+}
+
+// sameOwnership tells whether two files have the same mode and owners, so that either can stand for the other
+// returns:			bool
+func sameOwnership(a os.FileInfo, b os.FileInfo) bool {
+	statA, okA := a.Sys().(*syscall.Stat_t)
+	statB, okB := b.Sys().(*syscall.Stat_t)
+	if !okA || !okB {
+		return false
+	}
+	return a.Mode() == b.Mode() && statA.Uid == statB.Uid && statA.Gid == statB.Gid
+}
+
+// DeduplicateBackup replaces the files of the new backup identical to the ones of the previous backup, by
+// content and ownership, with hard links to them. The deduplicated sizes are added to the manifest components
+// returns:			bytes deduplicated, error
+func DeduplicateBackup(BackupPath string, previousPath string, manifest *Manifest) (int64, error) {
+	previous, err := ReadManifest(previousPath)
+	if err != nil {
+		log.Infof("No previous backup to deduplicate against: %s", err)
+		return 0, nil
+	}
+	previousEntries := make(map[string]ManifestEntry, len(previous.Files))
+	for _, entry := range previous.Files {
+		previousEntries[entry.Path] = entry
+	}
+
+	var total int64
+	for _, entry := range manifest.Files {
+		old, found := previousEntries[entry.Path]
+		if entry.SHA256 == "" || !found || old.SHA256 != entry.SHA256 || old.Size != entry.Size {
+			continue
+		}
+		path := filepath.Join(BackupPath, entry.Path)
+		oldPath := filepath.Join(previousPath, entry.Path)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return total, err
+		}
+		oldInfo, err := os.Lstat(oldPath)
+		if err != nil || !oldInfo.Mode().IsRegular() || oldInfo.Size() != entry.Size || !sameOwnership(info, oldInfo) {
+			continue
+		}
+		if os.SameFile(info, oldInfo) {
+			continue
+		}
+
+		tmp := path + ".dedup"
+		if err := os.Link(oldPath, tmp); err != nil {
+			return total, err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return total, err
+		}
+
+		component := componentOf(entry.Path)
+		summary := manifest.Components[component]
+		summary.DedupBytes += entry.Size
+		manifest.Components[component] = summary
+		total += entry.Size
+	}
+	return total, nil
+}
+
+// SummarizeStorage records the format of the backup and its sizes in the manifest. The size of the archived
+// directories before compression is taken from the estimate made before the backup
+func SummarizeStorage(manifest *Manifest, format string, estimate SpaceEstimate) {
+	storage := StorageSummary{Format: format}
+
+	names := make([]string, 0, len(manifest.Components))
+	for name := range manifest.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		summary := manifest.Components[name]
+		summary.SourceBytes = summary.SizeBytes
+		if summary.Format != "" && summary.Format != FormatRaw {
+			if source, ok := estimate.Components[name]; ok {
+				summary.SourceBytes = source
+			}
+		}
+		manifest.Components[name] = summary
+
+		storage.SourceBytes += summary.SourceBytes
+		storage.SizeBytes += summary.SizeBytes
+		storage.DedupBytes += summary.DedupBytes
+	}
+	storage.StoredBytes = storage.SizeBytes - storage.DedupBytes
+	manifest.Storage = &storage
+}
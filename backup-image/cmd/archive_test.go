@@ -0,0 +1,133 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeBackup writes a minimal backup with its manifest
+func writeBackup(dir string, hosts string) cmd.Manifest {
+	Expect(os.MkdirAll(filepath.Join(dir, "etc"), 0700)).Should(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "etc", "hosts"), []byte(hosts), 0600)).Should(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "etc", "resolv.conf"), []byte("nameserver 10.0.0.1\n"), 0600)).Should(Succeed())
+	writeTarball(filepath.Join(dir, "usrlocal.tar.gz"))
+	Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), []byte("#!/bin/bash\n"), 0700)).Should(Succeed())
+
+	manifest, err := cmd.NewManifest(dir)
+	Expect(err).Should(BeNil())
+	return manifest
+}
+
+var _ = Describe("Archive", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("ValidateFormat", func() {
+		It("accepts the raw and gzip formats and rejects unknown ones", func() {
+			Expect(cmd.ValidateFormat(cmd.FormatRaw)).To(Succeed())
+			Expect(cmd.ValidateFormat(cmd.FormatGzip)).To(Succeed())
+			Expect(cmd.ValidateFormat("xz")).NotTo(Succeed())
+		})
+	})
+
+	Describe("ComponentPath", func() {
+		It("finds the components stored as archives", func() {
+			writeBackup(dir, "127.0.0.1 localhost\n")
+			path, format := cmd.ComponentPath(dir, "usrlocal")
+			Expect(path).To(Equal(filepath.Join(dir, "usrlocal.tar.gz")))
+			Expect(format).To(Equal(cmd.FormatGzip))
+			path, format = cmd.ComponentPath(dir, "etc")
+			Expect(path).To(Equal(filepath.Join(dir, "etc")))
+			Expect(format).To(Equal(cmd.FormatRaw))
+			path, _ = cmd.ComponentPath(dir, "kubelet")
+			Expect(path).To(BeEmpty())
+		})
+
+		It("describes the archives in the manifest", func() {
+			manifest := writeBackup(dir, "127.0.0.1 localhost\n")
+			Expect(manifest.Components).To(HaveKey("usrlocal"))
+			Expect(manifest.Components["usrlocal"].Format).To(Equal(cmd.FormatGzip))
+			Expect(manifest.Components["etc"].Format).To(BeEmpty())
+		})
+	})
+
+	Describe("DeduplicateBackup", func() {
+		It("hard links the files identical to the previous backup", func() {
+			previous := filepath.Join(dir, "previous")
+			Expect(cmd.WriteManifest(previous, writeBackup(previous, "127.0.0.1 localhost\n"))).To(Succeed())
+			current := filepath.Join(dir, "current")
+			manifest := writeBackup(current, "10.0.0.2 sno\n")
+
+			deduplicated, err := cmd.DeduplicateBackup(current, previous, &manifest)
+			Expect(err).Should(BeNil())
+
+			same := func(name string) bool {
+				a, _ := os.Stat(filepath.Join(previous, name))
+				b, _ := os.Stat(filepath.Join(current, name))
+				return os.SameFile(a, b)
+			}
+			Expect(same("etc/resolv.conf")).To(BeTrue())
+			Expect(same("usrlocal.tar.gz")).To(BeTrue())
+			Expect(same("etc/hosts")).To(BeFalse())
+
+			resolv, _ := os.Stat(filepath.Join(current, "etc", "resolv.conf"))
+			archive, _ := os.Stat(filepath.Join(current, "usrlocal.tar.gz"))
+			Expect(deduplicated).To(Equal(resolv.Size() + archive.Size()))
+			Expect(manifest.Components["etc"].DedupBytes).To(Equal(resolv.Size()))
+			Expect(manifest.Components["usrlocal"].DedupBytes).To(Equal(archive.Size()))
+
+			// the backup still matches its manifest
+			Expect(cmd.VerifyChecksums(current, manifest)).To(Succeed())
+		})
+
+		It("leaves files whose mode changed", func() {
+			previous := filepath.Join(dir, "previous")
+			Expect(cmd.WriteManifest(previous, writeBackup(previous, "127.0.0.1 localhost\n"))).To(Succeed())
+			current := filepath.Join(dir, "current")
+			manifest := writeBackup(current, "127.0.0.1 localhost\n")
+			Expect(os.Chmod(filepath.Join(current, "etc", "hosts"), 0644)).To(Succeed())
+
+			_, err := cmd.DeduplicateBackup(current, previous, &manifest)
+			Expect(err).Should(BeNil())
+			a, _ := os.Stat(filepath.Join(previous, "etc", "hosts"))
+			b, _ := os.Stat(filepath.Join(current, "etc", "hosts"))
+			Expect(os.SameFile(a, b)).To(BeFalse())
+		})
+
+		It("does nothing without a previous manifest", func() {
+			manifest := writeBackup(dir, "127.0.0.1 localhost\n")
+			deduplicated, err := cmd.DeduplicateBackup(dir, filepath.Join(dir, "missing"), &manifest)
+			Expect(err).Should(BeNil())
+			Expect(deduplicated).To(BeZero())
+		})
+	})
+
+	Describe("SummarizeStorage", func() {
+		It("reports the sizes before and after compression and deduplication", func() {
+			manifest := cmd.Manifest{Components: map[string]cmd.Component{
+				"etc":      {Files: 2, SizeBytes: 100, DedupBytes: 40},
+				"usrlocal": {Files: 1, SizeBytes: 30, Format: cmd.FormatGzip, DedupBytes: 30},
+			}}
+			estimate := cmd.SpaceEstimate{Components: map[string]int64{"etc": 120, "usrlocal": 90}}
+			cmd.SummarizeStorage(&manifest, cmd.FormatGzip, estimate)
+
+			Expect(manifest.Components["etc"].SourceBytes).To(Equal(int64(100)))
+			Expect(manifest.Components["usrlocal"].SourceBytes).To(Equal(int64(90)))
+			Expect(*manifest.Storage).To(Equal(cmd.StorageSummary{
+				Format: cmd.FormatGzip, SourceBytes: 190, SizeBytes: 130, DedupBytes: 70, StoredBytes: 60,
+			}))
+		})
+	})
+})
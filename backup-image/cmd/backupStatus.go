@@ -0,0 +1,255 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// terminationLog is where the status is written, for the hub to read it from the pod status
+const terminationLog string = "/dev/termination-log"
+
+// BackupStatus describes the backup held in the recovery partition
+type BackupStatus struct {
+	Path               string    `json:"path"`
+	Generation         string    `json:"generation,omitempty"`
+	Exists             bool      `json:"exists"`
+	Complete           bool      `json:"complete"`
+	Timestamp          time.Time `json:"timestamp,omitempty"`
+	SizeBytes          int64     `json:"sizeBytes,omitempty"`
+	OCPVersion         string    `json:"ocpVersion,omitempty"`
+	PinnedDeployment   string    `json:"pinnedDeployment,omitempty"`
+	RecoveryInProgress bool      `json:"recoveryInProgress"`
+}
+
+// BackupTimestamp returns the time of the etcd snapshot of the backup
+// returns:			time.Time, error
+func BackupTimestamp(BackupPath string) (time.Time, error) {
+	snapshots, err := filepath.Glob(filepath.Join(BackupPath, "cluster", "snapshot_*.db"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(snapshots) == 0 {
+		return time.Time{}, fmt.Errorf("no etcd snapshot found in %s", BackupPath)
+	}
+
+	var timestamp time.Time
+	for _, snapshot := range snapshots {
+		info, err := os.Stat(snapshot)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(timestamp) {
+			timestamp = info.ModTime()
+		}
+	}
+	return timestamp.UTC(), nil
+}
+
+// BackupSize returns the size of the regular files under a directory
+// returns:			int64, error
+func BackupSize(BackupPath string) (int64, error) {
+	var size int64
+	err := filepath.Walk(BackupPath, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path != BackupPath {
+			// removed while walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// BackupOCPVersion returns the version of the cluster recorded when the backup was taken. Backups taken before the
+// version was recorded fall back to the OpenShift version of the backed up os-release
+// returns:			string
+func BackupOCPVersion(BackupPath string) string {
+	if version, err := os.ReadFile(filepath.Join(BackupPath, "ocp-version")); err == nil {
+		if v := strings.TrimSpace(string(version)); v != "" {
+			return v
+		}
+	}
+
+	file, err := os.Open(filepath.Join(BackupPath, "etc", "os-release"))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "OPENSHIFT_VERSION="); value != scanner.Text() {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// PinnedDeployment parses the output of "ostree admin status" and returns the pinned deployment with its version
+// returns:			string
+func PinnedDeployment(status string) string {
+	var deployment, version string
+	for _, line := range strings.Split(status, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " *"))
+		switch {
+		case trimmed == "":
+			continue
+		case indent <= 2:
+			// deployments are listed with their details indented below them, the booted one marked with a star
+			deployment = strings.Join(firstFields(strings.TrimPrefix(trimmed, "*"), 2), " ")
+			version = ""
+		case strings.HasPrefix(trimmed, "Version:"):
+			version = strings.Join(firstFields(strings.TrimPrefix(trimmed, "Version:"), 1), " ")
+		case trimmed == "Pinned: yes":
+			if version != "" {
+				return fmt.Sprintf("%s version %s", deployment, version)
+			}
+			return deployment
+		}
+	}
+	return ""
+}
+
+// firstFields returns at most the n first fields of s
+// returns:			[]string
+func firstFields(s string, n int) []string {
+	fields := strings.Fields(s)
+	if len(fields) > n {
+		return fields[:n]
+	}
+	return fields
+}
+
+// GetBackupStatus collects the status of the backup held in BackupPath
+// returns:			BackupStatus
+func GetBackupStatus(BackupPath string) BackupStatus {
+	status := BackupStatus{
+		Path:               BackupPath,
+		RecoveryInProgress: RecoveryInProgress(BackupPath),
+	}
+	if filepath.Base(filepath.Dir(BackupPath)) == GenerationsDir {
+		status.Generation = filepath.Base(BackupPath)
+	}
+
+	if _, err := os.Stat(filepath.Join(BackupPath, recoveryScript)); err != nil {
+		return status
+	}
+	timestamp, err := BackupTimestamp(BackupPath)
+	if err != nil {
+		log.Infof("No usable backup in %s: %s", BackupPath, err)
+		return status
+	}
+	status.Exists = true
+	status.Timestamp = timestamp
+	status.OCPVersion = BackupOCPVersion(BackupPath)
+
+	// the manifest marks the backup as complete and holds the version of the cluster it was taken from
+	if manifest, err := ReadManifest(BackupPath); err == nil {
+		status.Complete = true
+		status.Timestamp = manifest.Timestamp
+		if manifest.ClusterVersion != "" {
+			status.OCPVersion = manifest.ClusterVersion
+		}
+	}
+
+	if status.SizeBytes, err = BackupSize(BackupPath); err != nil {
+		log.Errorf("Couldn't compute the size of the backup, err: %s", err)
+	}
+
+	out, err := exec.Command("ostree", "admin", "status").Output()
+	if err != nil {
+		log.Errorf("Couldn't get the ostree deployments, err: %s", err)
+	} else {
+		status.PinnedDeployment = PinnedDeployment(string(out))
+	}
+	return status
+}
+
+// WriteBackupStatus writes the status of the backup as JSON
+// returns:			error
+func WriteBackupStatus(w io.Writer, status BackupStatus) error {
+	return json.NewEncoder(w).Encode(status)
+}
+
+// BackupStatusReport reports the status of the backup held in BackupPath on stdout and in the termination log
+// returns:			error
+func BackupStatusReport(BackupPath string) error {
+
+	// the termination log is only reachable before changing root directory
+	termination, err := os.OpenFile(terminationLog, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		log.Warnf("Couldn't open %s, the status is only printed, err: %s", terminationLog, err)
+		termination = nil
+	} else {
+		defer termination.Close()
+	}
+
+	// validate the BackupPath and change root directory to /host
+	BackupPath, err = hostBackupPath(BackupPath)
+	if err != nil {
+		return err
+	}
+
+	status := GetBackupStatus(BackupDir(BackupPath))
+	status.Path = BackupPath
+	if err := WriteBackupStatus(os.Stdout, status); err != nil {
+		return err
+	}
+	if termination != nil {
+		return WriteBackupStatus(termination, status)
+	}
+	return nil
+}
+
+// backupStatusCmd represents the backupStatus command
+var backupStatusCmd = &cobra.Command{
+	Use:   "backupStatus",
+	Short: "It will report the backup held in the specified path",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+
+		return BackupStatusReport(BackupPath)
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(backupStatusCmd)
+
+	backupStatusCmd.Flags().StringP("BackupPath", "p", "", "Path where the backup is stored")
+	_ = backupStatusCmd.MarkFlagRequired("BackupPath")
+}
@@ -0,0 +1,95 @@
+package cmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const ostreeStatus = `* rhcos 3c0e5e3d1fdf6fb0f6a2ee4e4ba3c2f9a1b0c1e2d3f4a5b6c7d8e9f0a1b2c3d4.0
+                  Version: 410.84.202205191234-0 (2022-05-19T12:37:09Z)
+  rhcos 9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b.0 (rollback)
+                  Version: 49.84.202110081407-0 (2021-10-08T14:10:51Z)
+                   Pinned: yes
+`
+
+var _ = Describe("BackupStatus", func() {
+	Describe("PinnedDeployment", func() {
+		It("returns the pinned deployment and its version", func() {
+			Expect(cmd.PinnedDeployment(ostreeStatus)).To(Equal(
+				"rhcos 9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b.0 version 49.84.202110081407-0"))
+		})
+
+		It("returns nothing when no deployment is pinned", func() {
+			Expect(cmd.PinnedDeployment("* rhcos abc.0\n    Version: 49.84\n")).To(BeEmpty())
+		})
+	})
+
+	Describe("GetBackupStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = os.MkdirTemp("", "tmpDir")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		Context("no backup was taken", func() {
+			It("reports the backup as missing", func() {
+				status := cmd.GetBackupStatus(dir)
+				Expect(status.Exists).To(BeFalse())
+				Expect(status.RecoveryInProgress).To(BeFalse())
+			})
+		})
+
+		Context("a backup was taken", func() {
+			It("reports its timestamp, size and version", func() {
+				Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), []byte("#!/bin/bash\n"), 0700)).Should(Succeed())
+				Expect(os.MkdirAll(filepath.Join(dir, "cluster"), 0700)).Should(Succeed())
+				snapshot := filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db")
+				Expect(os.WriteFile(snapshot, []byte("etcd"), 0600)).Should(Succeed())
+				taken := time.Date(2022, 5, 20, 10, 10, 10, 0, time.UTC)
+				Expect(os.Chtimes(snapshot, taken, taken)).Should(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "ocp-version"), []byte("4.9.8\n"), 0600)).Should(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "progress"), []byte("started\n"), 0600)).Should(Succeed())
+
+				status := cmd.GetBackupStatus(dir)
+				Expect(status.Exists).To(BeTrue())
+				Expect(status.Timestamp).To(Equal(taken))
+				Expect(status.SizeBytes).To(Equal(int64(12 + 4 + 6 + 8)))
+				Expect(status.OCPVersion).To(Equal("4.9.8"))
+				Expect(status.RecoveryInProgress).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("BackupOCPVersion", func() {
+		It("falls back to the backed up os-release", func() {
+			dir, _ := os.MkdirTemp("", "tmpDir")
+			defer os.RemoveAll(dir)
+			Expect(os.MkdirAll(filepath.Join(dir, "etc"), 0700)).Should(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "etc", "os-release"), []byte("NAME=\"RHCOS\"\nOPENSHIFT_VERSION=\"4.9\"\n"), 0600)).Should(Succeed())
+			Expect(cmd.BackupOCPVersion(dir)).To(Equal("4.9"))
+		})
+	})
+
+	Describe("WriteBackupStatus", func() {
+		It("writes the status as JSON", func() {
+			var buf bytes.Buffer
+			Expect(cmd.WriteBackupStatus(&buf, cmd.BackupStatus{Path: "/var/recovery", Exists: true})).Should(Succeed())
+			var status cmd.BackupStatus
+			Expect(json.Unmarshal(buf.Bytes(), &status)).Should(Succeed())
+			Expect(status.Path).To(Equal("/var/recovery"))
+			Expect(status.Exists).To(BeTrue())
+		})
+	})
+})
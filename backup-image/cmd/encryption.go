@@ -0,0 +1,387 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// EncryptionAlgorithm is the algorithm the backup files are encrypted with
+const EncryptionAlgorithm string = "AES-256-GCM"
+
+// EncryptedSuffix is appended to the name of the encrypted files
+const EncryptedSuffix string = ".enc"
+
+// encryptionMagic starts every encrypted file, identifying the format
+const encryptionMagic string = "SNOBKP\x00\x01"
+
+// encryptionChunk is the size of the chunks the files are encrypted by, so that large files are streamed
+const encryptionChunk int = 64 * 1024
+
+// noncePrefixSize is the size of the random part of the nonces, followed by the chunk counter and the last
+// chunk flag
+const noncePrefixSize int = 7
+
+// EncryptionInfo describes the encryption of a backup in its manifest
+type EncryptionInfo struct {
+	Algorithm      string `json:"algorithm"`
+	KeyFingerprint string `json:"keyFingerprint"`
+}
+
+// ReadEncryptionKey reads a 256-bit key from a file holding it raw, or encoded in base64 or hex
+// returns:			key, error
+func ReadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the encryption key: %s", err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the encryption key in %s must be 32 bytes, raw or encoded in base64 or hex", path)
+}
+
+// encryptionKeyFromFlags reads the key given with --encryption-key-file, which is found in the container and
+// must be read before changing root directory
+// returns:			key, or nil without one, error
+func encryptionKeyFromFlags(cmd *cobra.Command) ([]byte, error) {
+	path, _ := cmd.Flags().GetString("encryption-key-file")
+	if path == "" {
+		return nil, nil
+	}
+	return ReadEncryptionKey(path)
+}
+
+// KeyFingerprint identifies a key without disclosing it
+// returns:			string
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// CheckEncryptionKey checks the key is the one the backup described by the manifest was encrypted with
+// returns:			error
+func CheckEncryptionKey(manifest Manifest, key []byte) error {
+	if manifest.Encryption == nil {
+		return nil
+	}
+	if key == nil {
+		return fmt.Errorf("the backup is encrypted with key %s, an encryption key is required", manifest.Encryption.KeyFingerprint)
+	}
+	if fingerprint := KeyFingerprint(key); fingerprint != manifest.Encryption.KeyFingerprint {
+		return fmt.Errorf("wrong encryption key %s, the backup is encrypted with key %s", fingerprint, manifest.Encryption.KeyFingerprint)
+	}
+	return nil
+}
+
+// newAEAD builds the cipher of the key
+// returns:			cipher.AEAD, error
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of a chunk from the random prefix of the file, the chunk counter and whether
+// it is the last chunk, so that chunks can't be reordered, dropped or truncated unnoticed
+// returns:			nonce
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// EncryptStream encrypts r into w, chunk by chunk
+// returns:			error
+func EncryptStream(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header := append([]byte(encryptionMagic), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(r, encryptionChunk)
+	buf := make([]byte, encryptionChunk)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		if _, err := w.Write(aead.Seal(nil, chunkNonce(prefix, counter, last), buf[:n], header)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return fmt.Errorf("too large to be encrypted")
+		}
+	}
+}
+
+// DecryptStream decrypts r, encrypted by EncryptStream, into w
+// returns:			error
+func DecryptStream(w io.Writer, r io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(encryptionMagic)+noncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		return fmt.Errorf("not an encrypted backup file")
+	}
+	prefix := header[len(encryptionMagic):]
+
+	reader := bufio.NewReaderSize(r, encryptionChunk+aead.Overhead())
+	buf := make([]byte, encryptionChunk+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF {
+			return fmt.Errorf("truncated encrypted file")
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		plain, err := aead.Open(nil, chunkNonce(prefix, counter, last), buf[:n], header)
+		if err != nil {
+			return fmt.Errorf("couldn't decrypt, wrong key or corrupted file")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// transformFile writes dst from src through transform, with the mode and owners of src. dst is written to a
+// temporary file synced and renamed into place, so that it is only ever found complete
+// returns:			error
+func transformFile(src string, dst string, transform func(io.Writer, io.Reader, []byte) error, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := transform(out, in, key); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %s", src, err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := out.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// componentFiles lists the regular files of the backup components, encrypted or not
+// returns:			paths, error
+func componentFiles(BackupPath string) ([]string, error) {
+	files := []string{}
+	for _, component := range BackupComponents {
+		roots, _ := componentCandidates(BackupPath, component)
+		for _, root := range roots {
+			if _, err := os.Lstat(root); err != nil {
+				continue
+			}
+			err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// EncryptBackup encrypts every file of the backup components, replacing it with its encrypted version
+// returns:			EncryptionInfo, error
+func EncryptBackup(BackupPath string, key []byte) (EncryptionInfo, error) {
+	info := EncryptionInfo{Algorithm: EncryptionAlgorithm, KeyFingerprint: KeyFingerprint(key)}
+	files, err := componentFiles(BackupPath)
+	if err != nil {
+		return info, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, EncryptedSuffix) {
+			continue
+		}
+		if err := transformFile(file, file+EncryptedSuffix, EncryptStream, key); err != nil {
+			return info, err
+		}
+		if err := os.Remove(file); err != nil {
+			return info, err
+		}
+	}
+	log.Infof("%d files encrypted with key %s", len(files), info.KeyFingerprint)
+	return info, nil
+}
+
+// DecryptBackup decrypts the encrypted files of the backup components next to them, for the recovery to use.
+// Files already decrypted are left as they are
+// returns:			error
+func DecryptBackup(BackupPath string, key []byte) error {
+	files, err := componentFiles(BackupPath)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, file := range files {
+		if !strings.HasSuffix(file, EncryptedSuffix) {
+			continue
+		}
+		plain := strings.TrimSuffix(file, EncryptedSuffix)
+		if _, err := os.Stat(plain); err == nil {
+			continue
+		}
+		if err := transformFile(file, plain, DecryptStream, key); err != nil {
+			return err
+		}
+		count++
+	}
+	log.Infof("%d files decrypted", count)
+	return nil
+}
+
+// RemoveDecrypted removes the files decrypted for the recovery, leaving the encrypted ones
+// returns:			error
+func RemoveDecrypted(BackupPath string) error {
+	files, err := componentFiles(BackupPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file, EncryptedSuffix) {
+			continue
+		}
+		if err := os.Remove(strings.TrimSuffix(file, EncryptedSuffix)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyEncryptedBackup checks the cluster backup is encrypted, and that every encrypted file has the header of
+// the format, its content being covered by the checksums
+// returns:			message, error
+func VerifyEncryptedBackup(BackupPath string) (string, error) {
+	for _, pattern := range []string{"snapshot_*.db", "static_kuberesources_*.tar.gz"} {
+		if _, err := singleMatch(BackupPath, pattern+EncryptedSuffix); err != nil {
+			return "", err
+		}
+	}
+
+	files, err := componentFiles(BackupPath)
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	header := make([]byte, len(encryptionMagic))
+	for _, path := range files {
+		if !strings.HasSuffix(path, EncryptedSuffix) {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.ReadFull(file, header)
+		file.Close()
+		if err != nil || string(header) != encryptionMagic {
+			return "", fmt.Errorf("%s is not an encrypted backup file", path)
+		}
+		count++
+	}
+	return fmt.Sprintf("%d encrypted files", count), nil
+}
@@ -0,0 +1,151 @@
+package cmd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	var dir string
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("ReadEncryptionKey", func() {
+		It("reads raw, base64 and hex keys", func() {
+			for name, data := range map[string][]byte{
+				"raw":    key,
+				"base64": []byte(base64.StdEncoding.EncodeToString(key) + "\n"),
+				"hex":    []byte(hex.EncodeToString(key)),
+			} {
+				path := filepath.Join(dir, name)
+				Expect(os.WriteFile(path, data, 0600)).To(Succeed())
+				read, err := cmd.ReadEncryptionKey(path)
+				Expect(err).ShouldNot(HaveOccurred(), name)
+				Expect(read).To(Equal(key))
+			}
+		})
+
+		It("rejects keys of the wrong size", func() {
+			path := filepath.Join(dir, "short")
+			Expect(os.WriteFile(path, []byte("secret"), 0600)).To(Succeed())
+			_, err := cmd.ReadEncryptionKey(path)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("EncryptStream", func() {
+		It("round trips content of any size", func() {
+			for _, size := range []int{0, 1, 64 * 1024, 64*1024 + 1, 3 * 64 * 1024} {
+				plain := make([]byte, size)
+				_, _ = rand.Read(plain)
+				var encrypted, decrypted bytes.Buffer
+				Expect(cmd.EncryptStream(&encrypted, bytes.NewReader(plain), key)).To(Succeed())
+				Expect(cmd.DecryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), key)).To(Succeed())
+				Expect(decrypted.Bytes()).To(Equal(plain), "size %d", size)
+			}
+		})
+
+		It("detects a wrong key and a truncated file", func() {
+			plain := make([]byte, 2*64*1024)
+			var encrypted bytes.Buffer
+			Expect(cmd.EncryptStream(&encrypted, bytes.NewReader(plain), key)).To(Succeed())
+
+			other := bytes.Repeat([]byte{0x24}, 32)
+			Expect(cmd.DecryptStream(&bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()), other)).NotTo(Succeed())
+
+			truncated := encrypted.Bytes()[:encrypted.Len()-64*1024]
+			Expect(cmd.DecryptStream(&bytes.Buffer{}, bytes.NewReader(truncated), key)).NotTo(Succeed())
+		})
+	})
+
+	Describe("EncryptBackup", func() {
+		var manifest cmd.Manifest
+
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "cluster"), 0700)).Should(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db"), []byte("etcd"), 0600)).Should(Succeed())
+			writeTarball(filepath.Join(dir, "cluster", "static_kuberesources_2022-05-20_101010.tar.gz"))
+			writeTarball(filepath.Join(dir, "etc.tar.gz"))
+			Expect(os.WriteFile(filepath.Join(dir, "etc.exclude.list"), []byte(".updated\nkubernetes/manifests\n"), 0600)).Should(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), []byte("#!/bin/bash\n"), 0700)).Should(Succeed())
+
+			info, err := cmd.EncryptBackup(dir, key)
+			Expect(err).Should(BeNil())
+			manifest, err = cmd.NewManifest(dir)
+			Expect(err).Should(BeNil())
+			manifest.Encryption = &info
+			Expect(cmd.WriteManifest(dir, manifest)).Should(Succeed())
+		})
+
+		It("replaces the files of the components with encrypted ones", func() {
+			Expect(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db.enc")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "etc.tar.gz.enc")).To(BeAnExistingFile())
+			Expect(filepath.Join(dir, "etc.exclude.list")).To(BeAnExistingFile())
+			Expect(manifest.Components).To(HaveKey("etc"))
+			Expect(manifest.Components["etc"].Format).To(Equal(cmd.FormatGzip))
+		})
+
+		It("passes the verification without the key", func() {
+			report := cmd.VerifyBackup(dir)
+			for _, check := range report.Checks {
+				Expect(check.Passed).To(BeTrue(), check.Name+": "+check.Message)
+			}
+			Expect(checkResult(report, cmd.CheckEncryption).Passed).To(BeTrue())
+		})
+
+		It("checks the key against the manifest", func() {
+			Expect(cmd.CheckEncryptionKey(manifest, key)).To(Succeed())
+			Expect(cmd.CheckEncryptionKey(manifest, nil)).NotTo(Succeed())
+			Expect(cmd.CheckEncryptionKey(manifest, bytes.Repeat([]byte{0x24}, 32))).NotTo(Succeed())
+			Expect(cmd.CheckEncryptionKey(cmd.Manifest{}, nil)).To(Succeed())
+		})
+
+		It("decrypts the backup for the recovery and removes the decrypted files after", func() {
+			Expect(cmd.DecryptBackup(dir, key)).To(Succeed())
+			data, err := os.ReadFile(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db"))
+			Expect(err).Should(BeNil())
+			Expect(string(data)).To(Equal("etcd"))
+			_, err = cmd.VerifyTarball(filepath.Join(dir, "etc.tar.gz"))
+			Expect(err).Should(BeNil())
+
+			Expect(cmd.RemoveDecrypted(dir)).To(Succeed())
+			Expect(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dir, "etc.tar.gz")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dir, "etc.tar.gz.enc")).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("DecryptBackup", func() {
+		It("decrypts a gzip backup the recovery accepts", func() {
+			writeGzipBackup(dir)
+			_, err := cmd.EncryptBackup(dir, key)
+			Expect(err).Should(BeNil())
+			Expect(filepath.Join(dir, "etc.tar.gz")).NotTo(BeAnExistingFile())
+			Expect(cmd.CheckRecoveryContent(dir)).NotTo(Succeed())
+
+			Expect(cmd.DecryptBackup(dir, key)).To(Succeed())
+			Expect(cmd.CheckRecoveryContent(dir)).To(Succeed())
+			for _, name := range []string{"etc", "usrlocal", "kubelet"} {
+				_, err = cmd.VerifyTarball(filepath.Join(dir, name+".tar.gz"))
+				Expect(err).Should(BeNil(), name)
+			}
+		})
+	})
+})
@@ -0,0 +1,405 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// exportExtension and checksumExtension name the exported backup and its checksum in the bucket
+const (
+	exportExtension   string = ".tar"
+	checksumExtension string = ".tar.sha256"
+)
+
+// ExportKey returns the key of the exported generation of a node in the bucket
+// returns:			string
+func ExportKey(prefix string, node string, generation string) string {
+	return path.Join(prefix, node, generation+exportExtension)
+}
+
+// WriteTar writes the content of dir as a tarball, with the modes, owners and times of the files
+// returns:			error
+func WriteTar(w io.Writer, dir string) error {
+	return writeTar(w, dir, dir)
+}
+
+// writeTar writes root and its content as a tarball, named relative to base
+// returns:			error
+func writeTar(w io.Writer, root string, base string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// checkNoSymlink checks no element of the path of an entry below dir is an existing symlink, so that the symlinks
+// extracted, which are restored as they were backed up, are never followed by the extraction
+// returns:			error
+func checkNoSymlink(dir string, name string) error {
+	current := dir
+	for _, element := range strings.Split(name, string(filepath.Separator)) {
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %q through the symlink %s", name, current)
+		}
+	}
+	return nil
+}
+
+// ExtractTar extracts a tarball written by WriteTar into dir, refusing the entries which would land outside of it,
+// directly or through a symlink
+// returns:			error
+func ExtractTar(r io.Reader, dir string) error {
+	return extractTar(r, dir, "")
+}
+
+// extractTar extracts a tarball into dir, refusing the entries which would land outside of it, or outside of its
+// root entry when given
+// returns:			error
+func extractTar(r io.Reader, dir string, root string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to extract %q outside of %s", header.Name, dir)
+		}
+		if root != "" && strings.Split(name, string(filepath.Separator))[0] != root {
+			return fmt.Errorf("refusing to extract %q outside of %s", header.Name, filepath.Join(dir, root))
+		}
+		if err := checkNoSymlink(dir, name); err != nil {
+			return err
+		}
+		target := filepath.Join(dir, name)
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("unsupported entry %q in the exported backup", header.Name)
+		}
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil && os.Geteuid() == 0 {
+			return err
+		}
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+// ExportBackup streams a generation to the bucket with a multipart upload, then uploads its checksum
+// returns:			key of the exported backup, error
+func ExportBackup(client *S3Client, dir string, node string, generation string) (string, error) {
+	key := ExportKey(client.Config.Prefix, node, generation)
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(WriteTar(writer, dir))
+	}()
+	sum, size, err := client.UploadMultipart(key, reader)
+	reader.Close()
+	if err != nil {
+		return "", fmt.Errorf("couldn't export the backup to %s: %s", key, err)
+	}
+
+	// the checksum is uploaded last, so that its presence marks the export as complete
+	if err := client.PutObject(strings.TrimSuffix(key, exportExtension)+checksumExtension, []byte(sum+"\n")); err != nil {
+		return "", fmt.Errorf("couldn't export the checksum of %s: %s", key, err)
+	}
+	log.Infof("Backup exported to %s/%s (%s, sha256 %s)", client.Config.Bucket, key, HumanSize(size), sum)
+	return key, nil
+}
+
+// ExportedGenerations lists the generations of a node exported to the bucket, with their checksum, oldest first
+// returns:			generation names, error
+func ExportedGenerations(client *S3Client, node string) ([]string, error) {
+	prefix := path.Join(client.Config.Prefix, node) + "/"
+	keys, err := client.ListObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	generations := []string{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if strings.HasSuffix(name, checksumExtension) && !strings.Contains(name, "/") {
+			generations = append(generations, strings.TrimSuffix(name, checksumExtension))
+		}
+	}
+	sort.Strings(generations)
+	return generations, nil
+}
+
+// FetchBackup downloads an exported generation, the latest one when none is given, checks it against its checksum,
+// extracts it into the staging directory, checks it against its manifest, then promotes it as the current
+// generation
+// returns:			generation directory, error
+func FetchBackup(client *S3Client, BackupPath string, node string, generation string) (string, error) {
+	if generation == "" {
+		generations, err := ExportedGenerations(client, node)
+		if err != nil {
+			return "", err
+		}
+		if len(generations) == 0 {
+			return "", fmt.Errorf("no backup of node %s exported to %s/%s", node, client.Config.Bucket, client.Config.Prefix)
+		}
+		generation = generations[len(generations)-1]
+	}
+	if err := ValidateGenerationName(generation); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(BackupPath, GenerationsDir, generation)); err == nil {
+		return "", fmt.Errorf("generation %s is already in %s", generation, BackupPath)
+	}
+	key := ExportKey(client.Config.Prefix, node, generation)
+
+	body, err := client.GetObject(strings.TrimSuffix(key, exportExtension) + checksumExtension)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the checksum of %s: %s", key, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, 1024))
+	body.Close()
+	if err != nil {
+		return "", err
+	}
+	expected := strings.TrimSpace(string(data))
+
+	// the export is checked against its checksum before anything is extracted from it
+	download := filepath.Join(BackupPath, StagingDir+exportExtension)
+	defer os.Remove(download)
+	if err := downloadVerified(client, key, download, expected); err != nil {
+		return "", err
+	}
+
+	staging, err := PrepareStaging(BackupPath)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(download)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := ExtractTar(f, staging); err != nil {
+		return "", fmt.Errorf("couldn't extract %s: %s", key, err)
+	}
+
+	dir, err := promoteDownloaded(BackupPath, generation)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Backup fetched from %s/%s into %s", client.Config.Bucket, key, dir)
+	return dir, nil
+}
+
+// downloadVerified downloads an object of the bucket into a file, and checks it against its checksum
+// returns:			error
+func downloadVerified(client *S3Client, key string, path string, expected string) error {
+	body, err := client.GetObject(key)
+	if err != nil {
+		return fmt.Errorf("couldn't get %s: %s", key, err)
+	}
+	defer body.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), body); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't get %s: %s", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != expected {
+		return fmt.Errorf("%s has checksum %s, expecting %s", key, sum, expected)
+	}
+	return nil
+}
+
+// promoteDownloaded checks the backup downloaded into the staging directory against its manifest, then promotes
+// it as the current generation
+// returns:			generation directory, error
+func promoteDownloaded(BackupPath string, generation string) (string, error) {
+	staging := filepath.Join(BackupPath, StagingDir)
+	manifest, err := ReadManifest(staging)
+	if err != nil {
+		return "", err
+	}
+	if err := VerifyChecksums(staging, manifest); err != nil {
+		return "", err
+	}
+	return PromoteStaging(BackupPath, generation)
+}
+
+// prepareBackupPath creates the backup path with the recovery marker when missing, then checks it is dedicated
+// to the backups
+// returns:			error
+func prepareBackupPath(BackupPath string) error {
+	if _, err := os.Stat(BackupPath); os.IsNotExist(err) {
+		if err := os.Mkdir(BackupPath, 0700); err != nil {
+			return err
+		}
+		if err := MarkBackupTarget(BackupPath); err != nil {
+			return err
+		}
+	}
+	return CheckBackupTarget(BackupPath)
+}
+
+// fetchBackupCmd represents the fetchBackup command
+var fetchBackupCmd = &cobra.Command{
+	Use:   "fetchBackup",
+	Short: "It will fetch a backup generation exported to S3-compatible object storage into the specified path",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		generation, _ := cmd.Flags().GetString("generation")
+		node, _ := cmd.Flags().GetString("node")
+
+		config := s3ConfigFromFlags(cmd)
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		if node == "" {
+			node = nodeName()
+		}
+
+		BackupPath, err := hostBackupPath(BackupPath)
+		if err != nil {
+			return err
+		}
+		if err := prepareBackupPath(BackupPath); err != nil {
+			return err
+		}
+		lock, err := AcquireLock(BackupPath, "fetch")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		if RecoveryInProgress(BackupDir(BackupPath)) {
+			return fmt.Errorf("a recovery is in progress from %s", BackupDir(BackupPath))
+		}
+		cmd.SilenceUsage = true
+		_, err = FetchBackup(NewS3Client(config), BackupPath, node, generation)
+		return err
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(fetchBackupCmd)
+
+	fetchBackupCmd.Flags().StringP("BackupPath", "p", "", "Path where the backup is stored")
+	_ = fetchBackupCmd.MarkFlagRequired("BackupPath")
+	fetchBackupCmd.Flags().String("generation", "", "Name of the exported backup generation to fetch (default is the latest one)")
+	fetchBackupCmd.Flags().String("node", "", "Name of the node the backup was exported from (default is this node)")
+	addS3Flags(fetchBackupCmd)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FailureAnnotation is set on the job with the error which made it fail, for the hub to report it
+const FailureAnnotation string = "openshift-ai-image-backup/failure"
+
+const serviceAccountDir string = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// FailureReporter reports the error which made a job fail in the termination log of its pod and as an
+// annotation of the job. Both are set up before changing root directory, as they are only reachable from the container
+type FailureReporter struct {
+	TerminationLog io.WriteCloser
+	// APIServer, Token and Client reach the API server of the spoke, Namespace and Job name the job to annotate
+	APIServer string
+	Token     string
+	Namespace string
+	Job       string
+	Client    *http.Client
+}
+
+// NewFailureReporter sets up the reporter from the container environment. What isn't available is left out,
+// so that reporting a failure never fails the job any further
+// returns:			*FailureReporter
+func NewFailureReporter() *FailureReporter {
+	reporter := &FailureReporter{Job: os.Getenv("JOB_NAME")}
+
+	if termination, err := os.OpenFile(terminationLog, os.O_WRONLY|os.O_TRUNC, 0); err == nil {
+		reporter.TerminationLog = termination
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	token, tokenErr := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	namespace, namespaceErr := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	ca, caErr := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if reporter.Job == "" || host == "" || port == "" || tokenErr != nil || namespaceErr != nil || caErr != nil {
+		log.Debug("The job can't be annotated with its failure")
+		return reporter
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	reporter.APIServer = "https://" + net.JoinHostPort(host, port)
+	reporter.Token = strings.TrimSpace(string(token))
+	reporter.Namespace = strings.TrimSpace(string(namespace))
+	reporter.Client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+	}
+	return reporter
+}
+
+// Report records the error which made the job fail
+func (r *FailureReporter) Report(failure error) {
+	if r.TerminationLog != nil {
+		if _, err := fmt.Fprint(r.TerminationLog, failure.Error()); err != nil {
+			log.Warnf("Couldn't write the termination log, err: %s", err)
+		}
+	}
+	if r.Client == nil {
+		return
+	}
+	if err := r.annotateJob(failure.Error()); err != nil {
+		log.Warnf("Couldn't annotate job %s with its failure, err: %s", r.Job, err)
+	}
+}
+
+// Close releases the termination log
+func (r *FailureReporter) Close() {
+	if r.TerminationLog != nil {
+		r.TerminationLog.Close()
+	}
+}
+
+// annotateJob sets the failure annotation on the job with a merge patch
+// returns:			error
+func (r *FailureReporter) annotateJob(message string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{FailureAnnotation: message},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/apis/batch/v1/namespaces/%s/jobs/%s", r.APIServer, r.Namespace, r.Job)
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(patch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Authorization", "Bearer "+r.Token)
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FailureReporter", func() {
+	It("writes the termination log and annotates the job", func() {
+		var method, path, auth, contentType string
+		var patch map[string]map[string]map[string]string
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, path = r.Method, r.URL.Path
+			auth, contentType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+			body, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(body, &patch)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		termination, _ := os.CreateTemp("", "termination")
+		defer os.Remove(termination.Name())
+
+		reporter := &cmd.FailureReporter{
+			TerminationLog: termination,
+			APIServer:      server.URL,
+			Token:          "token",
+			Namespace:      "backupresource",
+			Job:            "backupresource",
+			Client:         server.Client(),
+		}
+		reporter.Report(errors.New("not enough space"))
+		reporter.Close()
+
+		Expect(method).To(Equal(http.MethodPatch))
+		Expect(path).To(Equal("/apis/batch/v1/namespaces/backupresource/jobs/backupresource"))
+		Expect(auth).To(Equal("Bearer token"))
+		Expect(contentType).To(Equal("application/merge-patch+json"))
+		Expect(patch["metadata"]["annotations"][cmd.FailureAnnotation]).To(Equal("not enough space"))
+
+		content, _ := os.ReadFile(termination.Name())
+		Expect(string(content)).To(Equal("not enough space"))
+	})
+
+	It("only writes the termination log outside of a job", func() {
+		termination, _ := os.CreateTemp("", "termination")
+		defer os.Remove(termination.Name())
+
+		reporter := &cmd.FailureReporter{TerminationLog: termination}
+		reporter.Report(errors.New("failed"))
+		reporter.Close()
+
+		content, _ := os.ReadFile(termination.Name())
+		Expect(string(content)).To(Equal("failed"))
+	})
+})
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Layout of the recovery partition: every backup is a generation, written in the staging directory and
+// promoted by renaming it into the generations directory and swapping the current link to it
+const (
+	StagingDir     string = "staging"
+	GenerationsDir string = "generations"
+	CurrentLink    string = "current"
+)
+
+// pinMarker is the file marking a generation as pinned
+const pinMarker string = ".pinned"
+
+// generationFormat names the generations after the time of the backup, so that they sort chronologically
+const generationFormat string = "20060102T150405Z"
+
+// legacyEntries is the content of the backups taken before generations were introduced
+var legacyEntries = []string{
+	"cluster", "etc", "usrlocal", "kubelet", "extras.tgz", "etc.exclude.list",
+	recoveryScript, ManifestFile, "ocp-version",
+}
+
+// BackupDir resolves the directory holding the current backup: the current generation, or BackupPath itself
+// for a backup taken before generations were introduced
+// returns:			string
+func BackupDir(BackupPath string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Join(BackupPath, CurrentLink))
+	if err != nil {
+		return BackupPath
+	}
+	return dir
+}
+
+// labelPattern restricts generation labels to characters safe in a path and in a command line
+var labelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// generationPattern matches the names of the generations, the time of the backup followed by an optional label
+var generationPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z(-[a-z0-9]([a-z0-9.-]*[a-z0-9])?)?$`)
+
+// ValidateLabel checks a generation label is made of lower case letters, digits, dots and dashes
+// returns:			error
+func ValidateLabel(label string) error {
+	if label != "" && !labelPattern.MatchString(label) {
+		return fmt.Errorf("invalid generation label %q, expecting lower case letters, digits, dots and dashes", label)
+	}
+	return nil
+}
+
+// ValidateGenerationName checks a name is the one of a generation, as named by NewGenerationName and a label
+// returns:			error
+func ValidateGenerationName(name string) error {
+	if !generationPattern.MatchString(name) {
+		return fmt.Errorf("invalid generation name %q", name)
+	}
+	return nil
+}
+
+// NewGenerationName names a generation after the given time
+// returns:			string
+func NewGenerationName(t time.Time) string {
+	return t.UTC().Format(generationFormat)
+}
+
+// PrepareStaging returns an empty staging directory, removing what a failed run may have left in it
+// returns:			staging directory, error
+func PrepareStaging(BackupPath string) (string, error) {
+	staging := filepath.Join(BackupPath, StagingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return "", fmt.Errorf("couldn't clean up the staging directory: %s", err)
+	}
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return "", err
+	}
+	return staging, nil
+}
+
+// PromoteStaging turns the staging directory into a new generation and atomically makes it the current one
+// returns:			generation directory, error
+func PromoteStaging(BackupPath string, name string) (string, error) {
+	generations := filepath.Join(BackupPath, GenerationsDir)
+	if err := os.MkdirAll(generations, 0700); err != nil {
+		return "", err
+	}
+
+	generation := filepath.Join(generations, name)
+	if _, err := os.Lstat(generation); err == nil {
+		return "", fmt.Errorf("generation %s already exists", name)
+	}
+	if err := os.Rename(filepath.Join(BackupPath, StagingDir), generation); err != nil {
+		return "", err
+	}
+	if err := syncDir(generations); err != nil {
+		return "", err
+	}
+
+	// replace the current link in one rename, so that it always points at a complete generation
+	tmp := filepath.Join(BackupPath, CurrentLink+".tmp")
+	_ = os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(GenerationsDir, name), tmp); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(BackupPath, CurrentLink)); err != nil {
+		return "", err
+	}
+	return generation, syncDir(BackupPath)
+}
+
+// Generation describes one backup of the recovery partition
+type Generation struct {
+	Name           string    `json:"name"`
+	Path           string    `json:"path"`
+	Current        bool      `json:"current"`
+	Pinned         bool      `json:"pinned"`
+	Complete       bool      `json:"complete"`
+	Timestamp      time.Time `json:"timestamp,omitempty"`
+	ClusterVersion string    `json:"clusterVersion,omitempty"`
+	SizeBytes      int64     `json:"sizeBytes,omitempty"`
+}
+
+// ListGenerations describes the generations of the recovery partition, oldest first
+// returns:			[]Generation, error
+func ListGenerations(BackupPath string) ([]Generation, error) {
+	generations := []Generation{}
+	entries, err := os.ReadDir(filepath.Join(BackupPath, GenerationsDir))
+	if os.IsNotExist(err) {
+		return generations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	if dir := BackupDir(BackupPath); dir != BackupPath {
+		current = filepath.Base(dir)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		generation := Generation{
+			Name:    entry.Name(),
+			Path:    filepath.Join(BackupPath, GenerationsDir, entry.Name()),
+			Current: entry.Name() == current,
+		}
+		if _, err := os.Stat(filepath.Join(generation.Path, pinMarker)); err == nil {
+			generation.Pinned = true
+		}
+		if manifest, err := ReadManifest(generation.Path); err == nil {
+			generation.Complete = true
+			generation.Timestamp = manifest.Timestamp
+			generation.ClusterVersion = manifest.ClusterVersion
+			for _, component := range manifest.Components {
+				generation.SizeBytes += component.SizeBytes
+			}
+		}
+		generations = append(generations, generation)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Name < generations[j].Name })
+	return generations, nil
+}
+
+// GenerationDir resolves the directory of a generation given by name, or of the current backup when no name is given
+// returns:			string, error
+func GenerationDir(BackupPath string, name string) (string, error) {
+	if name == "" {
+		return BackupDir(BackupPath), nil
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid generation name %q", name)
+	}
+	dir := filepath.Join(BackupPath, GenerationsDir, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("no generation %s in %s", name, BackupPath)
+	}
+	return dir, nil
+}
+
+// PinGeneration pins a generation so that retention never deletes it, or unpins it
+// returns:			error
+func PinGeneration(BackupPath string, name string, pinned bool) error {
+	dir, err := GenerationDir(BackupPath, name)
+	if err != nil {
+		return err
+	}
+	if dir == BackupPath {
+		return fmt.Errorf("no current generation in %s", BackupPath)
+	}
+	marker := filepath.Join(dir, pinMarker)
+	if !pinned {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(marker, []byte{}, 0600)
+}
+
+// PruneGenerations keeps the keep most recent generations, including the current one, along with the pinned ones
+// and those a recovery is in progress from. The other generations are deleted, with the content of a backup taken
+// before generations were introduced
+// returns:			error
+func PruneGenerations(BackupPath string, keep int) error {
+	if keep < 1 {
+		return fmt.Errorf("at least one generation must be kept, got %d", keep)
+	}
+	if BackupDir(BackupPath) == BackupPath {
+		return fmt.Errorf("no current generation in %s", BackupPath)
+	}
+
+	generations, err := ListGenerations(BackupPath)
+	if err != nil {
+		return err
+	}
+	// the current generation is always kept, the most recent others fill the remaining room
+	others := 0
+	for i := len(generations) - 1; i >= 0; i-- {
+		generation := generations[i]
+		switch {
+		case generation.Current:
+			continue
+		case generation.Pinned:
+			log.Infof("Keeping pinned generation %s", generation.Name)
+			continue
+		case RecoveryInProgress(generation.Path):
+			log.Infof("Keeping generation %s, a recovery is in progress from it", generation.Name)
+			continue
+		case others < keep-1:
+			others++
+			continue
+		}
+		log.Infof("Deleting generation %s", generation.Name)
+		if err := os.RemoveAll(generation.Path); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range legacyEntries {
+		if err := os.RemoveAll(filepath.Join(BackupPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the entries of a directory, making renames in it durable
+// returns:			error
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
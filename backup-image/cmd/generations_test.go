@@ -0,0 +1,166 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generations", func() {
+	var dir string
+
+	// stage writes a backup in the staging directory
+	stage := func(content string) string {
+		staging, err := cmd.PrepareStaging(dir)
+		Expect(err).Should(BeNil())
+		Expect(os.WriteFile(filepath.Join(staging, "upgrade-recovery.sh"), []byte(content), 0700)).Should(Succeed())
+		return staging
+	}
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("BackupDir", func() {
+		It("falls back to the backup path without generations", func() {
+			Expect(cmd.BackupDir(dir)).To(Equal(dir))
+		})
+	})
+
+	Describe("NewGenerationName", func() {
+		It("names the generation after the time in UTC", func() {
+			Expect(cmd.NewGenerationName(time.Date(2022, 5, 20, 10, 10, 10, 0, time.UTC))).To(Equal("20220520T101010Z"))
+		})
+	})
+
+	Describe("PrepareStaging", func() {
+		It("cleans up a half-written staging directory", func() {
+			staging := stage("old")
+			Expect(os.WriteFile(filepath.Join(staging, "leftover"), []byte("x"), 0600)).Should(Succeed())
+			staging, err := cmd.PrepareStaging(dir)
+			Expect(err).Should(BeNil())
+			entries, _ := os.ReadDir(staging)
+			Expect(entries).To(BeEmpty())
+		})
+	})
+
+	Describe("PromoteStaging", func() {
+		It("makes the staging directory the current generation", func() {
+			stage("first")
+			generation, err := cmd.PromoteStaging(dir, "20220520T101010Z")
+			Expect(err).Should(BeNil())
+			Expect(generation).To(Equal(filepath.Join(dir, "generations", "20220520T101010Z")))
+			Expect(filepath.Join(dir, "staging")).NotTo(BeADirectory())
+
+			resolved, _ := filepath.EvalSymlinks(generation)
+			Expect(cmd.BackupDir(dir)).To(Equal(resolved))
+			content, _ := os.ReadFile(filepath.Join(cmd.BackupDir(dir), "upgrade-recovery.sh"))
+			Expect(string(content)).To(Equal("first"))
+		})
+
+		It("keeps the previous generation until it is pruned", func() {
+			stage("first")
+			_, err := cmd.PromoteStaging(dir, "20220520T101010Z")
+			Expect(err).Should(BeNil())
+			stage("second")
+			_, err = cmd.PromoteStaging(dir, "20220521T101010Z")
+			Expect(err).Should(BeNil())
+			Expect(filepath.Join(dir, "generations", "20220520T101010Z")).To(BeADirectory())
+
+			Expect(cmd.PruneGenerations(dir, 1)).Should(Succeed())
+			Expect(filepath.Join(dir, "generations", "20220520T101010Z")).NotTo(BeADirectory())
+			content, _ := os.ReadFile(filepath.Join(cmd.BackupDir(dir), "upgrade-recovery.sh"))
+			Expect(string(content)).To(Equal("second"))
+		})
+	})
+
+	Describe("PruneGenerations", func() {
+		promote := func(name string) {
+			stage(name)
+			_, err := cmd.PromoteStaging(dir, name)
+			Expect(err).Should(BeNil())
+		}
+
+		It("keeps the most recent generations and the pinned ones", func() {
+			promote("20220501T000000Z")
+			promote("20220502T000000Z")
+			promote("20220503T000000Z")
+			promote("20220504T000000Z")
+			Expect(cmd.PinGeneration(dir, "20220501T000000Z", true)).Should(Succeed())
+
+			Expect(cmd.PruneGenerations(dir, 2)).Should(Succeed())
+			generations, err := cmd.ListGenerations(dir)
+			Expect(err).Should(BeNil())
+			names := []string{}
+			for _, g := range generations {
+				names = append(names, g.Name)
+			}
+			Expect(names).To(Equal([]string{"20220501T000000Z", "20220503T000000Z", "20220504T000000Z"}))
+			Expect(generations[0].Pinned).To(BeTrue())
+			Expect(generations[2].Current).To(BeTrue())
+		})
+
+		It("keeps a generation a recovery is in progress from", func() {
+			promote("20220501T000000Z")
+			Expect(os.WriteFile(filepath.Join(dir, "generations", "20220501T000000Z", "progress"), []byte("started\n"), 0600)).Should(Succeed())
+			promote("20220502T000000Z")
+			Expect(cmd.PruneGenerations(dir, 1)).Should(Succeed())
+			Expect(filepath.Join(dir, "generations", "20220501T000000Z")).To(BeADirectory())
+		})
+
+		It("refuses to keep no generation", func() {
+			promote("20220501T000000Z")
+			Expect(cmd.PruneGenerations(dir, 0)).NotTo(Succeed())
+		})
+
+		It("deletes a backup taken before generations once a generation is current", func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "etc"), 0700)).Should(Succeed())
+			stage("first")
+			_, err := cmd.PromoteStaging(dir, "20220520T101010Z")
+			Expect(err).Should(BeNil())
+			Expect(cmd.PruneGenerations(dir, 1)).Should(Succeed())
+			Expect(filepath.Join(dir, "etc")).NotTo(BeADirectory())
+		})
+
+		It("refuses to prune without a current generation", func() {
+			Expect(cmd.PruneGenerations(dir, 1)).NotTo(Succeed())
+		})
+	})
+
+	Describe("PinGeneration", func() {
+		It("pins and unpins a generation", func() {
+			stage("first")
+			_, err := cmd.PromoteStaging(dir, "20220520T101010Z")
+			Expect(err).Should(BeNil())
+			Expect(cmd.PinGeneration(dir, "20220520T101010Z", true)).Should(Succeed())
+			generations, _ := cmd.ListGenerations(dir)
+			Expect(generations[0].Pinned).To(BeTrue())
+			Expect(cmd.PinGeneration(dir, "20220520T101010Z", false)).Should(Succeed())
+			generations, _ = cmd.ListGenerations(dir)
+			Expect(generations[0].Pinned).To(BeFalse())
+		})
+
+		It("rejects unknown generations", func() {
+			Expect(cmd.PinGeneration(dir, "missing", true)).NotTo(Succeed())
+			Expect(cmd.PinGeneration(dir, "../etc", true)).NotTo(Succeed())
+		})
+	})
+
+	Describe("ValidateLabel", func() {
+		It("accepts lower case letters, digits, dots and dashes", func() {
+			Expect(cmd.ValidateLabel("pre-4.11")).Should(Succeed())
+			Expect(cmd.ValidateLabel("")).Should(Succeed())
+			Expect(cmd.ValidateLabel("Pre 4.11")).NotTo(Succeed())
+			Expect(cmd.ValidateLabel("../x")).NotTo(Succeed())
+		})
+	})
+})
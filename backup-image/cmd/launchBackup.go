@@ -19,7 +19,6 @@ package cmd
 import (
 	"fmt"
 	"os/exec"
-	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -47,39 +46,75 @@ func RecoveryInProgress(BackupPath string) bool {
 	return true
 }
 
+// ParseBackupPath normalises the BackupPath, stripping trailing slashes
+// returns:			string
 func ParseBackupPath(BackupPath string) string {
-	if check := strings.Contains(BackupPath[len(BackupPath)-1:], "/"); check {
-		BackupPath = BackupPath[:len(BackupPath)-1]
+	if BackupPath == "" {
+		return ""
 	}
-	return BackupPath
+	return filepath.Clean(BackupPath)
+}
+
+// BackupOptions controls the generation written by a backup and the retention of the previous ones
+type BackupOptions struct {
+	// Keep is the number of generations kept, including the new one
+	Keep int
+	// Pin protects the new generation from retention
+	Pin bool
+	// Label is appended to the name of the new generation
+	Label string
+	// Format is the format the backed up directories are stored in, raw copies or compressed archives
+	Format string
+	// Dedup hard links the files identical to the ones of the previous generation
+	Dedup bool
+	// EncryptionKey encrypts the backup components when set
+	EncryptionKey []byte
+	// Export locates the bucket of S3 the backup is exported to, when enabled
+	Export S3Config
+	// Push locates the OCI artifact the backup is pushed as, when enabled
+	Push OCIConfig
 }
 
 //LaunchBackup triggers the backup procedure
 // returns:			error
-func LaunchBackup(BackupPath string) error {
+func LaunchBackup(BackupPath string, opts BackupOptions) error {
 
-	// check for slash in the BackupPath
-	BackupPath = ParseBackupPath(BackupPath)
-
-	//change root directory to /host
-	if err := syscall.Chroot(host); err != nil {
-		log.Errorf("Couldn't do chroot to %s, err: %s", host, err)
+	if opts.Keep < 1 {
+		return fmt.Errorf("--keep must be at least 1, got %d", opts.Keep)
+	}
+	if err := ValidateLabel(opts.Label); err != nil {
 		return err
 	}
 
-	// During recovery, this container may get relaunched, as it will be in "Running"
-	// state when the backup is taken. We'll check to see if a recovery is already
-	// in progress then, and just exit cleanly if so.
-	if RecoveryInProgress(BackupPath) {
-		log.Info("Cannot take backup. Recovery is currently in progress")
-		return nil
+	if opts.Format == "" {
+		opts.Format = FormatRaw
+	}
+	// the recovery restores the directories from their archives, decrypted next to the encrypted ones
+	if opts.EncryptionKey != nil && opts.Format == FormatRaw {
+		return fmt.Errorf("an encrypted backup stores the directories as archives, --format gzip or zstd is required")
+	}
+	if opts.Export.Enabled() {
+		if err := opts.Export.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate the BackupPath and change root directory to /host
+	BackupPath, err := hostBackupPath(BackupPath)
+	if err != nil {
+		return err
 	}
 
-	if err := os.Chdir("/"); err != nil {
-		log.Error("Couldn't do chdir")
+	// the compressor is looked up on the host
+	if err = ValidateFormat(opts.Format); err != nil {
+		log.Error(err)
 		return err
 	}
 
+	if os.Getenv("KUBECONFIG") == "" {
+		os.Setenv("KUBECONFIG", localKubeconfig)
+	}
+
 	// validate path
 	if _, err := os.Stat(BackupPath); os.IsNotExist(err) {
 		// create path
@@ -88,16 +123,55 @@ func LaunchBackup(BackupPath string) error {
 			log.Error(err)
 			return err
 		}
+		if err = MarkBackupTarget(BackupPath); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
+	// nothing is deleted from a directory which isn't dedicated to the backups
+	if err = CheckBackupTarget(BackupPath); err != nil {
+		log.Error(err)
+		return err
 	}
 
-	err := Cleanup(BackupPath)
+	// no other backup, clean up or recovery runs until the backup is complete
+	lock, err := AcquireLock(BackupPath, "backup")
 	if err != nil {
-		log.Errorf("Old directories couldn't be deleted, err: %s\n", err)
+		log.Error(err)
+		return err
+	}
+	defer lock.Release()
+
+	// During recovery, this container may get relaunched, as it will be in "Running"
+	// state when the backup is taken. We'll check to see if a recovery is already
+	// in progress then, and just exit cleanly if so. The recovery is checked once
+	// locked, as it may have started since this container was launched.
+	if RecoveryInProgress(BackupDir(BackupPath)) {
+		log.Info("Cannot take backup. Recovery is currently in progress")
+		return nil
+	}
+
+	// the new backup is taken aside, the previous one being kept until the new one is complete
+	staging, err := PrepareStaging(BackupPath)
+	if err != nil {
+		log.Error(err)
+		return err
 	}
+	log.Infof("Taking the new backup in %s", staging)
 
-	log.Info("Old contents have been cleaned up")
+	// fail before the backup starts rather than halfway through it
+	estimate, err := EstimateBackupSize(backedUpDirs, etcdDB, MachineConfigExtraFiles())
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if err = CheckDiskSpace(BackupPath, estimate); err != nil {
+		log.Error(err)
+		return err
+	}
 
-	scriptname := filepath.Join(BackupPath, recoveryScript)
+	scriptname := filepath.Join(staging, recoveryScript)
 	scriptcontent, _ := recovery_assets.Asset(fmt.Sprintf("recovery/%s", recoveryScript))
 	err = os.WriteFile(scriptname, scriptcontent, 0700)
 	if err != nil {
@@ -107,12 +181,86 @@ func LaunchBackup(BackupPath string) error {
 	log.Info("Upgrade recovery script written")
 
 	// Take backup
-	backupCmd := fmt.Sprintf("%s --take-backup --dir %s", scriptname, BackupPath)
-	err = ExecuteCmd(backupCmd)
+	backupCmd := fmt.Sprintf("%s --take-backup --dir %s --format %s", scriptname, staging, opts.Format)
+	err = ExecuteLockedCmd(backupCmd, lock)
 	if err != nil {
 		return err
 	}
 
+	var encryption EncryptionInfo
+	if opts.EncryptionKey != nil {
+		if encryption, err = EncryptBackup(staging, opts.EncryptionKey); err != nil {
+			log.Errorf("Couldn't encrypt the backup, err: %s", err)
+			return err
+		}
+	}
+
+	// the manifest is written last, marking the backup as complete
+	manifest, err := NewManifest(staging)
+	if err != nil {
+		log.Errorf("Couldn't describe the backup, err: %s", err)
+		return err
+	}
+	if opts.EncryptionKey != nil {
+		manifest.Encryption = &encryption
+	}
+	if opts.Dedup {
+		deduplicated, err := DeduplicateBackup(staging, BackupDir(BackupPath), &manifest)
+		if err != nil {
+			log.Errorf("Couldn't deduplicate the backup, err: %s", err)
+			return err
+		}
+		log.Infof("%s shared with the previous backup", HumanSize(deduplicated))
+	}
+	SummarizeStorage(&manifest, opts.Format, estimate)
+	log.Infof("Backup of %s stored as %s, %s of it not shared with the previous backup",
+		HumanSize(manifest.Storage.SourceBytes), HumanSize(manifest.Storage.SizeBytes), HumanSize(manifest.Storage.StoredBytes))
+	if err = WriteManifest(staging, manifest); err != nil {
+		log.Errorf("Couldn't write the backup manifest, err: %s", err)
+		return err
+	}
+	log.Infof("Backup manifest written with %d files", len(manifest.Files))
+
+	name := NewGenerationName(manifest.Timestamp)
+	if opts.Label != "" {
+		name = fmt.Sprintf("%s-%s", name, opts.Label)
+	}
+	generation, err := PromoteStaging(BackupPath, name)
+	if err != nil {
+		log.Errorf("Couldn't promote the new backup, err: %s", err)
+		return err
+	}
+	log.Infof("Backup promoted to %s", generation)
+
+	if opts.Pin {
+		if err = PinGeneration(BackupPath, name, true); err != nil {
+			log.Errorf("Couldn't pin generation %s, err: %s", name, err)
+			return err
+		}
+		log.Infof("Generation %s pinned", name)
+	}
+
+	// the previous backups are only deleted once the new one is current
+	if err = PruneGenerations(BackupPath, opts.Keep); err != nil {
+		log.Errorf("Previous backups couldn't be deleted, err: %s", err)
+	} else {
+		log.Infof("Previous backups have been cleaned up, keeping %d generation(s) and the pinned ones", opts.Keep)
+	}
+
+	// the backup is kept on the node even when its export fails
+	if opts.Export.Enabled() {
+		if _, err = ExportBackup(NewS3Client(opts.Export), generation, nodeName(), name); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	if opts.Push.Enabled() {
+		if _, err = PushBackup(NewOCIClient(opts.Push), generation, name); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+
 	log.Info(strings.Repeat("-", 60))
 	log.Info("backup has successfully finished ...")
 
@@ -120,9 +268,25 @@ func LaunchBackup(BackupPath string) error {
 
 }
 
-// Cleanup deletes all old subdirectories and files in the recovery partition
+// Cleanup deletes all old subdirectories and files in the recovery partition, which must be under one of the
+// allowed prefixes and be a mount point or carry the recovery marker, the marker and the lock being kept. It fails
+// while a backup or a recovery runs
 // returns: 			error
-func Cleanup(path string) error {
+func Cleanup(path string, allowed []string) error {
+	path, err := ValidateBackupPath(path, allowed)
+	if err != nil {
+		return err
+	}
+	if !hasMarker(path) {
+		if mountPoint, err := IsMountPoint(path); err != nil || !mountPoint {
+			return fmt.Errorf("refusing to clean up %s, it is neither a mount point nor marked as dedicated to the backups", path)
+		}
+	}
+	lock, err := AcquireLock(path, "cleanup")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	log.Info(strings.Repeat("-", 60))
 	log.Info("Cleaning up old content...")
 	log.Info(strings.Repeat("-", 60))
@@ -136,6 +300,9 @@ func Cleanup(path string) error {
 
 		// Get name of file and its full path.
 		name := fileNames.Name()
+		if name == RecoveryMarker || name == LockFile {
+			continue
+		}
 		fullPath := path + "/" + name
 		log.Info("\nfullpath: ", fullPath)
 
@@ -154,6 +321,19 @@ func Cleanup(path string) error {
 //ExecuteCmd execute shell commands
 //returns: 			error
 func ExecuteCmd(cmd string) error {
+	return executeCmd(cmd, nil)
+}
+
+// ExecuteLockedCmd executes a shell command on behalf of the holder of the lock of the recovery partition. The
+// locked file is passed to the command as its descriptor 3, named by LockFDEnv
+// returns: 			error
+func ExecuteLockedCmd(cmd string, lock *HostLock) error {
+	return executeCmd(cmd, lock)
+}
+
+// executeCmd executes a shell command, passing it the locked file if any
+// returns: 			error
+func executeCmd(cmd string, lock *HostLock) error {
 
 	logger := log.StandardLogger()
 	lw := logger.Writer()
@@ -163,6 +343,11 @@ func ExecuteCmd(cmd string) error {
 
 	execCmd.Stdout = lw
 	execCmd.Stderr = lw
+	if lock != nil {
+		// the first extra file is the descriptor 3 of the command
+		execCmd.ExtraFiles = []*os.File{lock.file}
+		execCmd.Env = append(os.Environ(), fmt.Sprintf("%s=3", LockFDEnv))
+	}
 
 	err := execCmd.Run()
 
@@ -183,9 +368,38 @@ var launchBackupCmd = &cobra.Command{
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		BackupPath, _ := cmd.Flags().GetString("BackupPath")
-
-		// start launching the backup of the resource
-		return LaunchBackup(BackupPath)
+		keep, _ := cmd.Flags().GetInt("keep")
+		pin, _ := cmd.Flags().GetBool("pin")
+		label, _ := cmd.Flags().GetString("name")
+		format, _ := cmd.Flags().GetString("format")
+		dedup, _ := cmd.Flags().GetBool("dedup")
+
+		// the failure is reported from the container, before changing root directory
+		reporter := NewFailureReporter()
+		defer reporter.Close()
+
+		key, err := encryptionKeyFromFlags(cmd)
+		var push OCIConfig
+		if err == nil {
+			push, err = ociConfigFromFlags(cmd, "push-oci")
+		}
+		if err == nil {
+			// start launching the backup of the resource
+			err = LaunchBackup(BackupPath, BackupOptions{
+				Keep:          keep,
+				Pin:           pin,
+				Label:         label,
+				Format:        format,
+				Dedup:         dedup,
+				EncryptionKey: key,
+				Export:        s3ConfigFromFlags(cmd),
+				Push:          push,
+			})
+		}
+		if err != nil {
+			reporter.Report(err)
+		}
+		return err
 	},
 }
 
@@ -195,6 +409,15 @@ func init() {
 
 	launchBackupCmd.Flags().StringP("BackupPath", "p", "", "Path where to store the backup")
 	_ = launchBackupCmd.MarkFlagRequired("BackupPath")
+	launchBackupCmd.Flags().Int("keep", 1, "Number of backup generations kept, including the new one, besides the pinned ones")
+	launchBackupCmd.Flags().Bool("pin", false, "Pin the new backup generation so that retention never deletes it")
+	launchBackupCmd.Flags().String("name", "", "Label appended to the name of the new backup generation, e.g. pre-4.11")
+	launchBackupCmd.Flags().String("format", FormatRaw, "Format of the backed up directories: raw, gzip or zstd")
+	launchBackupCmd.Flags().Bool("dedup", true, "Hard link the files identical to the ones of the previous backup generation")
+	addS3Flags(launchBackupCmd)
+	launchBackupCmd.Flags().String("push-oci", "", "Reference the backup is pushed to as an OCI artifact, registry/repository[:tag] (default tag is the generation name)")
+	addOCIFlags(launchBackupCmd)
+	launchBackupCmd.Flags().String("encryption-key-file", "", "File holding the 256-bit key encrypting the backup, raw or encoded in base64 or hex")
 
 	// bind to viper
 	_ = viper.BindPFlag("BackupPath", launchBackupCmd.Flags().Lookup("BackupPath"))
@@ -24,6 +24,12 @@ var _ = Describe("LaunchBackup", func() {
 				Expect(BackupPath).To(Equal("foo"))
 			})
 		})
+
+		Context("When BackupPath is empty", func() {
+			It("returns an empty path", func() {
+				Expect(cmd.ParseBackupPath("")).To(Equal(""))
+			})
+		})
 	})
 
 	Describe("RecoveryInProgress", func() {
@@ -56,30 +62,49 @@ var _ = Describe("LaunchBackup", func() {
 		Context("cleans up files", func() {
 			It("cleanup with dir", func() {
 				dir, _ := os.MkdirTemp("", "tmpDir")
-				err := cmd.Cleanup(dir)
-				defer func(path string) {
-					err := os.RemoveAll(path)
-					if err != nil {
-						return
-					}
-				}(dir)
+				defer os.RemoveAll(dir)
+				Expect(cmd.MarkBackupTarget(dir)).To(Succeed())
+				err := cmd.Cleanup(dir, []string{dir})
 				Expect(err).Should(BeNil())
 			})
 
 			It("cleanup with dir and file", func() {
 				dir, _ := os.MkdirTemp("", "tmpDir")
+				defer os.RemoveAll(dir)
+				Expect(cmd.MarkBackupTarget(dir)).To(Succeed())
 				_, err := os.OpenFile(dir+"/foo", os.O_RDONLY|os.O_CREATE, 0755)
-				if err != nil {
-					return
-				}
-				defer func(path string) {
-					err := os.RemoveAll(path)
-					if err != nil {
-						return
-					}
-				}(dir)
-				err = cmd.Cleanup("dir")
 				Expect(err).Should(BeNil())
+				err = cmd.Cleanup(dir, []string{dir})
+				Expect(err).Should(BeNil())
+				Expect(dir + "/foo").ShouldNot(BeAnExistingFile())
+				Expect(dir + "/" + cmd.RecoveryMarker).Should(BeAnExistingFile())
+			})
+		})
+
+		Context("refuses unsafe paths", func() {
+			It("refuses a dir which is not dedicated to the backups", func() {
+				dir, _ := os.MkdirTemp("", "tmpDir")
+				defer os.RemoveAll(dir)
+				_, err := os.OpenFile(dir+"/foo", os.O_RDONLY|os.O_CREATE, 0755)
+				Expect(err).Should(BeNil())
+				Expect(cmd.Cleanup(dir, []string{dir})).ShouldNot(Succeed())
+				Expect(dir + "/foo").Should(BeAnExistingFile())
+			})
+
+			It("refuses a dir outside of the allowed prefixes", func() {
+				dir, _ := os.MkdirTemp("", "tmpDir")
+				defer os.RemoveAll(dir)
+				Expect(cmd.MarkBackupTarget(dir)).To(Succeed())
+				_, err := os.OpenFile(dir+"/foo", os.O_RDONLY|os.O_CREATE, 0755)
+				Expect(err).Should(BeNil())
+				Expect(cmd.Cleanup(dir, cmd.DefaultAllowedPrefixes)).ShouldNot(Succeed())
+				Expect(dir + "/foo").Should(BeAnExistingFile())
+			})
+
+			It("refuses the root, system, relative and empty paths", func() {
+				for _, path := range []string{"/", "//", "/etc", "/var/lib/etcd/member", "dir", ""} {
+					Expect(cmd.Cleanup(path, []string{"/"})).ShouldNot(Succeed(), path)
+				}
 			})
 		})
 	})
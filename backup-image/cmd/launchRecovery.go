@@ -0,0 +1,346 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages recorded by the recovery script in the progress file
+const (
+	StageRestoreFiles   string = "restore_files"
+	StageRestoreCluster string = "restore_cluster"
+	StagePostRestore    string = "post_restore_steps"
+)
+
+const recoveryUnit string = "upgrade-recovery.service"
+const recoveryUnitDir string = "/etc/systemd/system"
+const localKubeconfig string = "/etc/kubernetes/static-pod-resources/kube-apiserver-certs/secrets/node-kubeconfigs/localhost.kubeconfig"
+
+// rebootDelay leaves the time to the job to report its completion before the node reboots
+const rebootDelay string = "30"
+
+// RecoveryStages reads the stages recorded in the progress file of the backup
+// returns:			[]string, error
+func RecoveryStages(BackupPath string) ([]string, error) {
+	file, err := os.Open(filepath.Join(BackupPath, "progress"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var stages []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if stage := strings.TrimSpace(scanner.Text()); stage != "" {
+			stages = append(stages, stage)
+		}
+	}
+	return stages, scanner.Err()
+}
+
+// NextRecoveryStage returns the next stage of the recovery given the stages already recorded
+// returns:			string
+func NextRecoveryStage(stages []string) string {
+	done := map[string]bool{}
+	for _, stage := range stages {
+		done[stage] = true
+	}
+	switch {
+	case !done[StageRestoreFiles]:
+		return StageRestoreFiles
+	case !done[StageRestoreCluster]:
+		return StageRestoreCluster
+	default:
+		return StagePostRestore
+	}
+}
+
+// RecoveryUnit renders the systemd unit restoring the cluster on the next boot
+// returns:			string
+func RecoveryUnit(BackupPath string) string {
+	script := filepath.Join(BackupPath, recoveryScript)
+	return fmt.Sprintf(`[Unit]
+Description=Restore the cluster from the upgrade recovery backup
+Wants=network-online.target crio.service
+After=network-online.target crio.service
+
+[Service]
+Type=oneshot
+Environment=KUBECONFIG=%s
+ExecStart=%s --step --dir %s
+ExecStartPost=/usr/bin/systemctl disable %s
+RemainAfterExit=yes
+TimeoutStartSec=infinity
+
+[Install]
+WantedBy=multi-user.target
+`, localKubeconfig, script, BackupPath, recoveryUnit)
+}
+
+// installRecoveryUnit writes and enables the unit restoring the cluster on the next boot
+// returns:			error
+func installRecoveryUnit(BackupPath string) error {
+	unit := filepath.Join(recoveryUnitDir, recoveryUnit)
+	if err := os.WriteFile(unit, []byte(RecoveryUnit(BackupPath)), 0644); err != nil {
+		log.Error(err)
+		return err
+	}
+	return ExecuteCmd(fmt.Sprintf("systemctl daemon-reload && systemctl enable %s", recoveryUnit))
+}
+
+// removeRecoveryUnit disables and removes the unit once the recovery is complete
+// returns:			error
+func removeRecoveryUnit() error {
+	unit := filepath.Join(recoveryUnitDir, recoveryUnit)
+	if _, err := os.Stat(unit); os.IsNotExist(err) {
+		return nil
+	}
+	if err := ExecuteCmd(fmt.Sprintf("systemctl disable %s", recoveryUnit)); err != nil {
+		return err
+	}
+	if err := os.Remove(unit); err != nil {
+		log.Error(err)
+		return err
+	}
+	return ExecuteCmd("systemctl daemon-reload")
+}
+
+// RecoveryOptions are the options of the recovery
+type RecoveryOptions struct {
+	// Generation is the generation restored, the current one when empty
+	Generation string
+	// Force skips the check of the ostree deployment
+	Force bool
+	// EncryptionKey decrypts an encrypted backup
+	EncryptionKey []byte
+	// Fetch fetches the backup from the bucket of S3 when it isn't found in the backup path
+	Fetch bool
+	// S3 locates the bucket the backup is fetched from
+	S3 S3Config
+}
+
+// CheckRecoveryContent runs the recovery script of the backup to check the backup holds the content the recovery
+// requires, its components being backed up as is or as compressed archives
+// returns:			error
+func CheckRecoveryContent(BackupPath string) error {
+	return ExecuteCmd(fmt.Sprintf("%s --check --dir %s", filepath.Join(BackupPath, recoveryScript), BackupPath))
+}
+
+// fetchMissingBackup fetches the generation to restore from the bucket, unless it is found in the backup path
+// returns:			error
+func fetchMissingBackup(BackupPath string, opts RecoveryOptions) error {
+	if opts.Generation != "" {
+		if _, err := GenerationDir(BackupPath, opts.Generation); err == nil {
+			return nil
+		}
+	} else if _, err := os.Stat(filepath.Join(BackupDir(BackupPath), recoveryScript)); err == nil {
+		return nil
+	}
+	log.Infof("No backup to restore in %s, fetching it from %s", BackupPath, opts.S3.Bucket)
+	if err := prepareBackupPath(BackupPath); err != nil {
+		return err
+	}
+	_, err := FetchBackup(NewS3Client(opts.S3), BackupPath, nodeName(), opts.Generation)
+	return err
+}
+
+// LaunchRecovery runs the next stage of the recovery of the node from its backup
+// returns:			error
+func LaunchRecovery(BackupPath string, opts RecoveryOptions) error {
+	generation, force, key := opts.Generation, opts.Force, opts.EncryptionKey
+	if opts.Fetch {
+		if err := opts.S3.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate the BackupPath and change root directory to /host
+	BackupPath, err := hostBackupPath(BackupPath)
+	if err != nil {
+		return err
+	}
+
+	// no backup or clean up runs while the recovery runs its stage, the script run below inheriting the lock
+	lock, err := AcquireLock(BackupPath, "recovery")
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer lock.Release()
+
+	if opts.Fetch {
+		if err := fetchMissingBackup(BackupPath, opts); err != nil {
+			log.Errorf("Couldn't fetch the backup, err: %s", err)
+			return err
+		}
+	}
+
+	// the recovery runs from the given generation, or else the current one, by its resolved path so that
+	// the unit keeps using it
+	BackupPath, err = GenerationDir(BackupPath, generation)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	log.Infof("Recovering from the backup in %s", BackupPath)
+
+	scriptname := filepath.Join(BackupPath, recoveryScript)
+	if _, err := os.Stat(scriptname); err != nil {
+		log.Errorf("No recovery script found in %s, was a backup taken? err: %s", BackupPath, err)
+		return err
+	}
+
+	if os.Getenv("KUBECONFIG") == "" {
+		os.Setenv("KUBECONFIG", localKubeconfig)
+	}
+
+	stages, err := RecoveryStages(BackupPath)
+	if err != nil {
+		log.Errorf("Couldn't read the recovery progress, err: %s", err)
+		return err
+	}
+
+	stage := NextRecoveryStage(stages)
+	log.Info(strings.Repeat("-", 60))
+	log.Infof("Recovery stage: %s", stage)
+	log.Info(strings.Repeat("-", 60))
+
+	// an encrypted backup is decrypted next to the encrypted files for the restore stages, once the key has been
+	// checked against the manifest
+	manifest, err := ReadManifest(BackupPath)
+	encrypted := err == nil && manifest.Encryption != nil
+	if encrypted && stage != StagePostRestore {
+		if err := CheckEncryptionKey(manifest, key); err != nil {
+			log.Error(err)
+			return err
+		}
+		if err := DecryptBackup(BackupPath, key); err != nil {
+			log.Errorf("Couldn't decrypt the backup, err: %s", err)
+			return err
+		}
+	}
+
+	// the content is checked once decrypted, before the restore stages start
+	if stage != StagePostRestore {
+		if err := CheckRecoveryContent(BackupPath); err != nil {
+			log.Errorf("The backup in %s can't be restored, err: %s", BackupPath, err)
+			return err
+		}
+	}
+
+	args := ""
+	if force {
+		args = " --force"
+	}
+
+	switch stage {
+	case StageRestoreFiles:
+		if err := ExecuteLockedCmd(fmt.Sprintf("%s --step --dir %s%s", scriptname, BackupPath, args), lock); err != nil {
+			return err
+		}
+		// the cluster is restored on the next boot, as the restored files only take effect then
+		if err := installRecoveryUnit(BackupPath); err != nil {
+			return err
+		}
+		if err := ExecuteCmd(fmt.Sprintf("systemd-run --on-active=%s systemctl reboot", rebootDelay)); err != nil {
+			return err
+		}
+		log.Infof("Files restored, the node reboots in %s seconds", rebootDelay)
+
+	case StageRestoreCluster:
+		if err := ExecuteLockedCmd(fmt.Sprintf("%s --step --dir %s", scriptname, BackupPath), lock); err != nil {
+			return err
+		}
+		log.Info("Cluster restored, run the recovery again for the post restore steps")
+
+	default:
+		if err := ExecuteLockedCmd(fmt.Sprintf("%s --resume --dir %s", scriptname, BackupPath), lock); err != nil {
+			return err
+		}
+		if err := removeRecoveryUnit(); err != nil {
+			return err
+		}
+		if encrypted {
+			if err := RemoveDecrypted(BackupPath); err != nil {
+				log.Errorf("Couldn't remove the decrypted backup files, err: %s", err)
+				return err
+			}
+		}
+		log.Info(strings.Repeat("-", 60))
+		log.Info("recovery has successfully finished ...")
+	}
+
+	return nil
+}
+
+// launchRecoveryCmd represents the launchRecovery command
+var launchRecoveryCmd = &cobra.Command{
+	Use:   "launchRecovery",
+	Short: "It will run the next stage of the recovery from the backup in the specified path",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		generation, _ := cmd.Flags().GetString("generation")
+		force, _ := cmd.Flags().GetBool("force")
+
+		// the failure is reported from the container, before changing root directory
+		reporter := NewFailureReporter()
+		defer reporter.Close()
+
+		fetch, _ := cmd.Flags().GetBool("fetch")
+
+		key, err := encryptionKeyFromFlags(cmd)
+		if err == nil {
+			err = LaunchRecovery(BackupPath, RecoveryOptions{
+				Generation:    generation,
+				Force:         force,
+				EncryptionKey: key,
+				Fetch:         fetch,
+				S3:            s3ConfigFromFlags(cmd),
+			})
+		}
+		if err != nil {
+			reporter.Report(err)
+		}
+		return err
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(launchRecoveryCmd)
+
+	launchRecoveryCmd.Flags().StringP("BackupPath", "p", "", "Path where the backup is stored")
+	_ = launchRecoveryCmd.MarkFlagRequired("BackupPath")
+	launchRecoveryCmd.Flags().String("generation", "", "Name of the backup generation to restore, as listed by listBackups (default is the current one)")
+	launchRecoveryCmd.Flags().String("encryption-key-file", "", "File holding the 256-bit key the backup is encrypted with, raw or encoded in base64 or hex")
+	launchRecoveryCmd.Flags().Bool("fetch", false, "Fetch the backup from S3-compatible object storage when it isn't found in the specified path")
+	addS3Flags(launchRecoveryCmd)
+	launchRecoveryCmd.Flags().Bool("force", false, "Skip the check that the platform has been rolled back to the pinned ostree deployment")
+}
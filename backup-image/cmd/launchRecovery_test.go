@@ -0,0 +1,118 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/internal/recovery_assets"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeRecoveryScript writes the recovery script in the backup, as the backup does
+func writeRecoveryScript(dir string) {
+	script, err := recovery_assets.Asset("recovery/upgrade-recovery.sh")
+	Expect(err).Should(BeNil())
+	Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), script, 0700)).Should(Succeed())
+}
+
+// writeGzipBackup writes a backup taken in the gzip format: the cluster directory and the archives of the other
+// components
+func writeGzipBackup(dir string) {
+	Expect(os.MkdirAll(filepath.Join(dir, "cluster"), 0700)).Should(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db"), []byte("etcd"), 0600)).Should(Succeed())
+	writeTarball(filepath.Join(dir, "cluster", "static_kuberesources_2022-05-20_101010.tar.gz"))
+	for _, name := range []string{"etc", "usrlocal", "kubelet"} {
+		writeTarball(filepath.Join(dir, name+".tar.gz"))
+	}
+	writeRecoveryScript(dir)
+}
+
+var _ = Describe("LaunchRecovery", func() {
+	Describe("RecoveryStages", func() {
+		Context("progressfile doesn't exist", func() {
+			It("returns no stage", func() {
+				dir, _ := os.MkdirTemp("", "tmpDir")
+				defer os.RemoveAll(dir)
+				stages, err := cmd.RecoveryStages(dir)
+				Expect(err).Should(BeNil())
+				Expect(stages).To(BeEmpty())
+			})
+		})
+
+		Context("progressfile exists", func() {
+			It("returns the recorded stages", func() {
+				dir, _ := os.MkdirTemp("", "tmpDir")
+				defer os.RemoveAll(dir)
+				err := os.WriteFile(filepath.Join(dir, "progress"), []byte("started\nrestore_files\n\n"), 0644)
+				Expect(err).Should(BeNil())
+				stages, err := cmd.RecoveryStages(dir)
+				Expect(err).Should(BeNil())
+				Expect(stages).To(Equal([]string{"started", "restore_files"}))
+			})
+		})
+	})
+
+	Describe("NextRecoveryStage", func() {
+		It("starts by restoring the files", func() {
+			Expect(cmd.NextRecoveryStage(nil)).To(Equal(cmd.StageRestoreFiles))
+			Expect(cmd.NextRecoveryStage([]string{"started"})).To(Equal(cmd.StageRestoreFiles))
+		})
+
+		It("restores the cluster once the files are restored", func() {
+			Expect(cmd.NextRecoveryStage([]string{"started", "restore_files"})).To(Equal(cmd.StageRestoreCluster))
+		})
+
+		It("runs the post restore steps once the cluster is restored", func() {
+			stages := []string{"started", "restore_files", "restore_cluster"}
+			Expect(cmd.NextRecoveryStage(stages)).To(Equal(cmd.StagePostRestore))
+		})
+	})
+
+	Describe("RecoveryUnit", func() {
+		It("restores the cluster from the backup path and disables itself", func() {
+			unit := cmd.RecoveryUnit("/var/recovery")
+			Expect(unit).To(ContainSubstring("ExecStart=/var/recovery/upgrade-recovery.sh --step --dir /var/recovery"))
+			Expect(unit).To(ContainSubstring("ExecStartPost=/usr/bin/systemctl disable upgrade-recovery.service"))
+		})
+	})
+
+	Describe("CheckRecoveryContent", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = os.MkdirTemp("", "tmpDir")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("accepts a backup taken in the gzip format", func() {
+			writeGzipBackup(dir)
+			Expect(cmd.CheckRecoveryContent(dir)).To(Succeed())
+		})
+
+		It("accepts a backup taken in the raw format", func() {
+			for _, name := range []string{"cluster", "etc", "usrlocal", "kubelet"} {
+				Expect(os.MkdirAll(filepath.Join(dir, name), 0700)).Should(Succeed())
+			}
+			writeRecoveryScript(dir)
+			Expect(cmd.CheckRecoveryContent(dir)).To(Succeed())
+		})
+
+		It("rejects a backup missing a component", func() {
+			writeGzipBackup(dir)
+			Expect(os.Remove(filepath.Join(dir, "usrlocal.tar.gz"))).To(Succeed())
+			Expect(cmd.CheckRecoveryContent(dir)).NotTo(Succeed())
+		})
+
+		It("rejects a backup without the cluster directory", func() {
+			writeGzipBackup(dir)
+			Expect(os.RemoveAll(filepath.Join(dir, "cluster"))).To(Succeed())
+			Expect(cmd.CheckRecoveryContent(dir)).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chrootHost changes the root directory to the host
+// returns:			error
+func chrootHost() error {
+	if err := syscall.Chroot(host); err != nil {
+		log.Errorf("Couldn't do chroot to %s, err: %s", host, err)
+		return err
+	}
+	if err := os.Chdir("/"); err != nil {
+		log.Error("Couldn't do chdir")
+		return err
+	}
+	return nil
+}
+
+// WriteGenerations renders the generations as JSON or as a table
+// returns:			error
+func WriteGenerations(w io.Writer, generations []Generation, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(generations)
+	}
+
+	tw := tabwriter.NewWriter(w, 10, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, "GENERATION\tCURRENT\tPINNED\tCOMPLETE\tTIMESTAMP\tCLUSTER VERSION\tSIZE (BYTES)")
+	for _, g := range generations {
+		timestamp := ""
+		if !g.Timestamp.IsZero() {
+			timestamp = g.Timestamp.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%t\t%t\t%s\t%s\t%d\n", g.Name, g.Current, g.Pinned, g.Complete, timestamp, g.ClusterVersion, g.SizeBytes)
+	}
+	return tw.Flush()
+}
+
+// listBackupsCmd represents the listBackups command
+var listBackupsCmd = &cobra.Command{
+	Use:   "listBackups",
+	Short: "It will list the backup generations held in the specified path",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unsupported output format %q, expecting text or json", output)
+		}
+
+		BackupPath, err := hostBackupPath(BackupPath)
+		if err != nil {
+			return err
+		}
+		generations, err := ListGenerations(BackupPath)
+		if err != nil {
+			return err
+		}
+		return WriteGenerations(os.Stdout, generations, output)
+	},
+}
+
+// pinBackupCmd represents the pinBackup command
+var pinBackupCmd = &cobra.Command{
+	Use:   "pinBackup",
+	Short: "It will pin, or unpin, a backup generation so that retention never deletes it",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		generation, _ := cmd.Flags().GetString("generation")
+		unpin, _ := cmd.Flags().GetBool("unpin")
+
+		BackupPath, err := hostBackupPath(BackupPath)
+		if err != nil {
+			return err
+		}
+		if err = CheckBackupTarget(BackupPath); err != nil {
+			return err
+		}
+		lock, err := AcquireLock(BackupPath, "pin")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		if err = PinGeneration(BackupPath, generation, !unpin); err != nil {
+			return err
+		}
+		if unpin {
+			log.Infof("Generation %s unpinned", generation)
+		} else {
+			log.Infof("Generation %s pinned", generation)
+		}
+		return nil
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(listBackupsCmd)
+	rootCmd.AddCommand(pinBackupCmd)
+
+	listBackupsCmd.Flags().StringP("BackupPath", "p", "", "Path where the backups are stored")
+	_ = listBackupsCmd.MarkFlagRequired("BackupPath")
+	listBackupsCmd.Flags().StringP("output", "o", "text", "Format of the list: text or json")
+
+	pinBackupCmd.Flags().StringP("BackupPath", "p", "", "Path where the backups are stored")
+	_ = pinBackupCmd.MarkFlagRequired("BackupPath")
+	pinBackupCmd.Flags().String("generation", "", "Name of the generation, as listed by listBackups")
+	_ = pinBackupCmd.MarkFlagRequired("generation")
+	pinBackupCmd.Flags().Bool("unpin", false, "Unpin the generation instead")
+}
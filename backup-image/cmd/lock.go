@@ -0,0 +1,175 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LockFile locks the recovery partition while a backup, a clean up, a fetch or a recovery runs. The recovery
+// script takes the same lock
+const LockFile string = ".lock"
+
+// LockFDEnv names the descriptor of the locked file passed to the recovery script by the tool holding the lock, the
+// script checking it holds the lock rather than taking it
+const LockFDEnv string = "BACKUP_LOCK_FD"
+
+// LockHolder describes the process holding the lock, as recorded in the lock file:
+// <pid> <start time in clock ticks after boot> <since> <operation>
+type LockHolder struct {
+	PID       int
+	StartTime uint64
+	Since     time.Time
+	Operation string
+}
+
+// String describes the holder for the logs and the errors
+// returns:			string
+func (h LockHolder) String() string {
+	return fmt.Sprintf("%s (pid %d since %s)", h.Operation, h.PID, h.Since.Format(time.RFC3339))
+}
+
+// processStartTime reads the start time of a process, in clock ticks after boot
+// returns:			uint64, error
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// the command name may hold spaces, the fields are counted from the end of it
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("unexpected /proc/%d/stat", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// ReadLockHolder reads the holder recorded in the lock file of the recovery partition
+// returns:			LockHolder, error
+func ReadLockHolder(BackupPath string) (LockHolder, error) {
+	var holder LockHolder
+	data, err := os.ReadFile(filepath.Join(BackupPath, LockFile))
+	if err != nil {
+		return holder, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 4 {
+		return holder, fmt.Errorf("no holder recorded in %s", LockFile)
+	}
+	if holder.PID, err = strconv.Atoi(fields[0]); err != nil {
+		return holder, fmt.Errorf("invalid pid in %s: %s", LockFile, err)
+	}
+	if holder.StartTime, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return holder, fmt.Errorf("invalid start time in %s: %s", LockFile, err)
+	}
+	if holder.Since, err = time.Parse(time.RFC3339, fields[2]); err != nil {
+		return holder, fmt.Errorf("invalid time in %s: %s", LockFile, err)
+	}
+	holder.Operation = fields[3]
+	return holder, nil
+}
+
+// HostLock is the lock of the recovery partition. It is taken with flock, so that it is released when its holder
+// dies, even with the node rebooting
+type HostLock struct {
+	file *os.File
+	// shared is set for the readers of the partition, which don't record themselves in the lock file
+	shared bool
+}
+
+// lockedError describes why the lock of the recovery partition couldn't be taken
+// returns:			error
+func lockedError(BackupPath string, err error) error {
+	if err != syscall.EWOULDBLOCK {
+		return fmt.Errorf("couldn't lock %s: %s", BackupPath, err)
+	}
+	if holder, err := ReadLockHolder(BackupPath); err == nil {
+		return fmt.Errorf("%s is locked by %s", BackupPath, holder)
+	}
+	return fmt.Errorf("%s is locked by another process", BackupPath)
+}
+
+// AcquireLock takes the lock of the recovery partition for an operation, failing right away when another process
+// holds it. A holder recorded in a lock file which isn't locked anymore died without releasing it, its record is
+// replaced
+// returns:			*HostLock, error
+func AcquireLock(BackupPath string, operation string) (*HostLock, error) {
+	path := filepath.Join(BackupPath, LockFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, lockedError(BackupPath, err)
+	}
+
+	if holder, err := ReadLockHolder(BackupPath); err == nil {
+		log.Warnf("Replacing the stale lock of %s on %s", holder, BackupPath)
+	}
+	start, err := processStartTime(os.Getpid())
+	if err == nil {
+		record := fmt.Sprintf("%d %d %s %s\n", os.Getpid(), start, time.Now().UTC().Format(time.RFC3339), operation)
+		if err = file.Truncate(0); err == nil {
+			_, err = file.WriteAt([]byte(record), 0)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("couldn't record the lock of %s: %s", BackupPath, err)
+	}
+	log.Debugf("Locked %s for %s", BackupPath, operation)
+	return &HostLock{file: file}, nil
+}
+
+// AcquireSharedLock takes the lock of the recovery partition for an operation reading it, failing right away when
+// an operation writing it holds the lock. Readers share the lock and keep the writers out until they are done
+// returns:			*HostLock, error
+func AcquireSharedLock(BackupPath string, operation string) (*HostLock, error) {
+	file, err := os.OpenFile(filepath.Join(BackupPath, LockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, lockedError(BackupPath, err)
+	}
+	log.Debugf("Locked %s for %s, shared", BackupPath, operation)
+	return &HostLock{file: file, shared: true}, nil
+}
+
+// Release clears the record of the holder and releases the lock. The lock file is kept, as removing it would let
+// another process lock a new file while one waits on the old one
+func (l *HostLock) Release() {
+	// the record belongs to the writers, the readers sharing the lock aren't recorded
+	if !l.shared {
+		if err := l.file.Truncate(0); err != nil {
+			log.Warnf("Couldn't clear the lock record, err: %s", err)
+		}
+	}
+	_ = syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
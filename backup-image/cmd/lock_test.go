@@ -0,0 +1,157 @@
+package cmd_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("is exclusive and records its holder", func() {
+		lock, err := cmd.AcquireLock(dir, "backup")
+		Expect(err).Should(BeNil())
+
+		holder, err := cmd.ReadLockHolder(dir)
+		Expect(err).Should(BeNil())
+		Expect(holder.PID).To(Equal(os.Getpid()))
+		Expect(holder.Operation).To(Equal("backup"))
+
+		_, err = cmd.AcquireLock(dir, "recovery")
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("locked by backup"))
+
+		lock.Release()
+		_, err = cmd.ReadLockHolder(dir)
+		Expect(err).Should(HaveOccurred())
+		Expect(filepath.Join(dir, cmd.LockFile)).To(BeAnExistingFile())
+
+		lock, err = cmd.AcquireLock(dir, "recovery")
+		Expect(err).Should(BeNil())
+		lock.Release()
+	})
+
+	It("replaces the stale record of a holder which died", func() {
+		Expect(os.WriteFile(filepath.Join(dir, cmd.LockFile), []byte("999999 1 2022-05-20T10:10:10Z backup\n"), 0600)).To(Succeed())
+		lock, err := cmd.AcquireLock(dir, "cleanup")
+		Expect(err).Should(BeNil())
+		defer lock.Release()
+
+		holder, err := cmd.ReadLockHolder(dir)
+		Expect(err).Should(BeNil())
+		Expect(holder.PID).To(Equal(os.Getpid()))
+		Expect(holder.Operation).To(Equal("cleanup"))
+	})
+
+	It("is shared by the readers, keeping the writers out", func() {
+		first, err := cmd.AcquireSharedLock(dir, "verify")
+		Expect(err).Should(BeNil())
+		second, err := cmd.AcquireSharedLock(dir, "verify")
+		Expect(err).Should(BeNil())
+
+		_, err = cmd.AcquireLock(dir, "backup")
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("locked by another process"))
+
+		first.Release()
+		second.Release()
+		lock, err := cmd.AcquireLock(dir, "backup")
+		Expect(err).Should(BeNil())
+
+		_, err = cmd.AcquireSharedLock(dir, "verify")
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("locked by backup"))
+
+		lock.Release()
+	})
+
+	It("keeps the record of the writer when a reader releases it", func() {
+		Expect(os.WriteFile(filepath.Join(dir, cmd.LockFile), []byte("999999 1 2022-05-20T10:10:10Z backup\n"), 0600)).To(Succeed())
+		lock, err := cmd.AcquireSharedLock(dir, "verify")
+		Expect(err).Should(BeNil())
+		lock.Release()
+
+		holder, err := cmd.ReadLockHolder(dir)
+		Expect(err).Should(BeNil())
+		Expect(holder.PID).To(Equal(999999))
+	})
+
+	Context("run by the recovery script", func() {
+		// lockScript runs the lock function of the recovery script on the lock file of root, as --take-backup does
+		lockScript := func(root string) string {
+			return fmt.Sprintf(`eval "$(sed -n '/^function proc_stat_field {/,/^}/p;/^function lock_backup_root {/,/^}/p' %s)"; BACKUP_ROOT=%s; LOCK_FILE=%s; lock_backup_root backup`,
+				filepath.Join(dir, "upgrade-recovery.sh"), root, filepath.Join(root, cmd.LockFile))
+		}
+
+		BeforeEach(func() {
+			writeRecoveryScript(dir)
+		})
+
+		It("is inherited from the tool holding it", func() {
+			lock, err := cmd.AcquireLock(dir, "backup")
+			Expect(err).Should(BeNil())
+
+			Expect(cmd.ExecuteLockedCmd(lockScript(dir), lock)).To(Succeed())
+			Expect(cmd.ExecuteCmd(lockScript(dir))).NotTo(Succeed())
+
+			// the tool still holds the lock once the script exited
+			_, err = cmd.AcquireLock(dir, "cleanup")
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("locked by backup"))
+
+			lock.Release()
+			Expect(cmd.ExecuteCmd(lockScript(dir))).To(Succeed())
+		})
+
+		It("refuses a descriptor which isn't the lock of the partition", func() {
+			other, _ := os.MkdirTemp("", "tmpDir")
+			defer os.RemoveAll(other)
+			lock, err := cmd.AcquireLock(other, "backup")
+			Expect(err).Should(BeNil())
+			defer lock.Release()
+
+			Expect(cmd.ExecuteLockedCmd(lockScript(dir), lock)).NotTo(Succeed())
+		})
+
+		It("is taken by the script when nobody holds it", func() {
+			Expect(cmd.ExecuteCmd(lockScript(dir))).To(Succeed())
+
+			holder, err := cmd.ReadLockHolder(dir)
+			Expect(err).Should(BeNil())
+			Expect(holder.Operation).To(Equal("backup"))
+			// the script exited, releasing its lock
+			lock, err := cmd.AcquireLock(dir, "cleanup")
+			Expect(err).Should(BeNil())
+			lock.Release()
+		})
+	})
+
+	It("keeps Cleanup from running while the partition is locked", func() {
+		Expect(cmd.MarkBackupTarget(dir)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "old"), []byte("old"), 0600)).To(Succeed())
+		lock, err := cmd.AcquireLock(dir, "backup")
+		Expect(err).Should(BeNil())
+
+		Expect(cmd.Cleanup(dir, []string{dir})).NotTo(Succeed())
+		Expect(filepath.Join(dir, "old")).To(BeAnExistingFile())
+
+		lock.Release()
+		Expect(cmd.Cleanup(dir, []string{dir})).To(Succeed())
+		Expect(filepath.Join(dir, "old")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(dir, cmd.LockFile)).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version is the version of the tool, set at build time
+var Version = "unreleased"
+
+// ManifestVersion is the version of the format of the backup manifest
+const ManifestVersion int = 1
+
+// ManifestFile is written last in the backup, its presence marks the backup as complete
+const ManifestFile string = "manifest.json"
+
+// BackupComponents are the parts of the backup described in the manifest
+var BackupComponents = []string{"cluster", "etc", "usrlocal", "kubelet", "extras.tgz"}
+
+// Manifest describes a complete backup
+type Manifest struct {
+	ManifestVersion  int                  `json:"manifestVersion"`
+	Timestamp        time.Time            `json:"timestamp"`
+	ClusterVersion   string               `json:"clusterVersion,omitempty"`
+	ReleaseImage     string               `json:"releaseImage,omitempty"`
+	NodeName         string               `json:"nodeName,omitempty"`
+	BootedDeployment string               `json:"bootedDeployment,omitempty"`
+	ToolVersion      string               `json:"toolVersion"`
+	ScriptSHA256     string               `json:"scriptSHA256"`
+	Components       map[string]Component `json:"components"`
+	Storage          *StorageSummary      `json:"storage,omitempty"`
+	Encryption       *EncryptionInfo      `json:"encryption,omitempty"`
+	Files            []ManifestEntry      `json:"files"`
+}
+
+// Component sums up one part of the backup. A backed up directory stored as an archive has the format of the
+// archive, and the size of the directory as its source size
+type Component struct {
+	Files       int    `json:"files"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	Format      string `json:"format,omitempty"`
+	SourceBytes int64  `json:"sourceBytes,omitempty"`
+	DedupBytes  int64  `json:"dedupBytes,omitempty"`
+}
+
+// ManifestEntry describes a file of the backup, by its path relative to the backup directory.
+// Symbolic links are recorded with their target instead of a checksum
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// FileSHA256 computes the SHA-256 of a file
+// returns:			hex encoded checksum, error
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ManifestEntries lists and checksums the files of the backup components, in lexical order.
+// A missing component, like extras.tgz when there are no extra files, is left out
+// returns:			entries, per-component summary, error
+func ManifestEntries(BackupPath string) ([]ManifestEntry, map[string]Component, error) {
+	entries := []ManifestEntry{}
+	components := map[string]Component{}
+
+	for _, component := range BackupComponents {
+		root, format := ComponentPath(BackupPath, component)
+		if root == "" {
+			continue
+		}
+
+		summary := Component{}
+		if format != FormatRaw {
+			summary.Format = format
+		}
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(BackupPath, path)
+			if err != nil {
+				return err
+			}
+
+			entry := ManifestEntry{Path: rel, Size: info.Size()}
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				if entry.Link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				if entry.SHA256, err = FileSHA256(path); err != nil {
+					return err
+				}
+			default:
+				// directories and special files carry no content
+				return nil
+			}
+
+			entries = append(entries, entry)
+			summary.Files++
+			summary.SizeBytes += entry.Size
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		components[component] = summary
+	}
+	return entries, components, nil
+}
+
+// BootedDeployment parses the output of "ostree admin status" and returns the booted deployment with its version
+// returns:			string
+func BootedDeployment(status string) string {
+	var deployment, version string
+	for _, line := range strings.Split(status, "\n") {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " *"))
+		switch {
+		case trimmed == "":
+			continue
+		case indent <= 2:
+			if deployment != "" {
+				// the booted deployment is over
+				return deploymentVersion(deployment, version)
+			}
+			if strings.HasPrefix(trimmed, "* ") {
+				deployment = strings.Join(firstFields(strings.TrimPrefix(trimmed, "*"), 2), " ")
+			}
+		case deployment != "" && strings.HasPrefix(trimmed, "Version:"):
+			version = strings.Join(firstFields(strings.TrimPrefix(trimmed, "Version:"), 1), " ")
+		}
+	}
+	return deploymentVersion(deployment, version)
+}
+
+// deploymentVersion renders a deployment with its version, when known
+// returns:			string
+func deploymentVersion(deployment string, version string) string {
+	if deployment != "" && version != "" {
+		return fmt.Sprintf("%s version %s", deployment, version)
+	}
+	return deployment
+}
+
+// nodeName returns the name of the node, as provided by the job or else from the hostname
+// returns:			string
+func nodeName() string {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// commandOutput runs a command and returns its trimmed output, logging and ignoring failures
+// returns:			string
+func commandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		log.Warnf("Couldn't run %s %s, err: %s", name, strings.Join(args, " "), err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// NewManifest describes the backup held in BackupPath
+// returns:			Manifest, error
+func NewManifest(BackupPath string) (Manifest, error) {
+	manifest := Manifest{
+		ManifestVersion: ManifestVersion,
+		Timestamp:       time.Now().UTC(),
+		NodeName:        nodeName(),
+		ToolVersion:     Version,
+	}
+
+	var err error
+	manifest.ScriptSHA256, err = FileSHA256(filepath.Join(BackupPath, recoveryScript))
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Files, manifest.Components, err = ManifestEntries(BackupPath)
+	if err != nil {
+		return manifest, err
+	}
+
+	desired := commandOutput("oc", "get", "clusterversion", "version", "-o=jsonpath={.status.desired.version} {.status.desired.image}")
+	if fields := strings.Fields(desired); len(fields) == 2 {
+		manifest.ClusterVersion, manifest.ReleaseImage = fields[0], fields[1]
+	}
+	manifest.BootedDeployment = BootedDeployment(commandOutput("ostree", "admin", "status"))
+	return manifest, nil
+}
+
+// WriteManifest writes the manifest in the backup directory, through a temporary file renamed once synced
+// so that a manifest is only ever found complete
+// returns:			error
+func WriteManifest(BackupPath string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(BackupPath, ManifestFile+".tmp")
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(BackupPath, ManifestFile))
+}
+
+// ReadManifest reads the manifest of the backup held in BackupPath
+// returns:			Manifest, error
+func ReadManifest(BackupPath string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(BackupPath, ManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("couldn't decode %s: %s", ManifestFile, err)
+	}
+	if manifest.ManifestVersion > ManifestVersion {
+		return manifest, fmt.Errorf("unsupported manifest version %d, expecting at most %d", manifest.ManifestVersion, ManifestVersion)
+	}
+	return manifest, nil
+}
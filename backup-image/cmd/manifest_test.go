@@ -0,0 +1,82 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manifest", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+		Expect(os.MkdirAll(filepath.Join(dir, "cluster"), 0700)).Should(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dir, "etc", "sub"), 0700)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "cluster", "snapshot.db"), []byte("etcd"), 0600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "etc", "sub", "hosts"), []byte("hello"), 0600)).Should(Succeed())
+		Expect(os.Symlink("sub/hosts", filepath.Join(dir, "etc", "link"))).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), []byte("#!/bin/bash\n"), 0700)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("FileSHA256", func() {
+		It("returns the checksum of the file", func() {
+			sum, err := cmd.FileSHA256(filepath.Join(dir, "etc", "sub", "hosts"))
+			Expect(err).Should(BeNil())
+			Expect(sum).To(Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"))
+		})
+	})
+
+	Describe("ManifestEntries", func() {
+		It("lists the files of the components present", func() {
+			entries, components, err := cmd.ManifestEntries(dir)
+			Expect(err).Should(BeNil())
+			Expect(entries).To(HaveLen(3))
+			Expect(entries[0].Path).To(Equal("cluster/snapshot.db"))
+			Expect(entries[1].Path).To(Equal("etc/link"))
+			Expect(entries[1].Link).To(Equal("sub/hosts"))
+			Expect(entries[1].SHA256).To(BeEmpty())
+			Expect(entries[2].Path).To(Equal("etc/sub/hosts"))
+			Expect(components).To(HaveKey("cluster"))
+			Expect(components).To(HaveKey("etc"))
+			Expect(components).NotTo(HaveKey("extras.tgz"))
+			Expect(components["etc"].Files).To(Equal(2))
+		})
+	})
+
+	Describe("WriteManifest", func() {
+		It("writes a manifest which reads back", func() {
+			manifest, err := cmd.NewManifest(dir)
+			Expect(err).Should(BeNil())
+			Expect(manifest.ManifestVersion).To(Equal(cmd.ManifestVersion))
+			Expect(cmd.WriteManifest(dir, manifest)).Should(Succeed())
+			Expect(filepath.Join(dir, "manifest.json.tmp")).NotTo(BeAnExistingFile())
+
+			read, err := cmd.ReadManifest(dir)
+			Expect(err).Should(BeNil())
+			Expect(read.Files).To(Equal(manifest.Files))
+			Expect(read.ScriptSHA256).To(Equal(manifest.ScriptSHA256))
+		})
+
+		It("rejects manifests of a newer format", func() {
+			Expect(os.WriteFile(filepath.Join(dir, cmd.ManifestFile), []byte(`{"manifestVersion": 99}`), 0600)).Should(Succeed())
+			_, err := cmd.ReadManifest(dir)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("BootedDeployment", func() {
+		It("returns the booted deployment and its version", func() {
+			Expect(cmd.BootedDeployment(ostreeStatus)).To(Equal(
+				"rhcos 3c0e5e3d1fdf6fb0f6a2ee4e4ba3c2f9a1b0c1e2d3f4a5b6c7d8e9f0a1b2c3d4.0 version 410.84.202205191234-0"))
+		})
+	})
+})
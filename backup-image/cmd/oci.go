@@ -0,0 +1,824 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Media types of the OCI artifact a backup is pushed as, its manifest being the config of the artifact
+const (
+	OCIManifestMediaType   string = "application/vnd.oci.image.manifest.v1+json"
+	BackupConfigMediaType  string = "application/vnd.redhat-ztp.backup.manifest.v1+json"
+	BackupDirMediaType     string = "application/vnd.redhat-ztp.backup.dir.v1.tar"
+	BackupFileMediaType    string = "application/vnd.redhat-ztp.backup.file.v1"
+	ociTitleAnnotation     string = "org.opencontainers.image.title"
+	ociCreatedAnnotation   string = "org.opencontainers.image.created"
+	generationAnnotation   string = "com.redhat-ztp.backup.generation"
+	DefaultRegistryAuthDir string = "/etc/backup-registry"
+)
+
+// repositoryPattern and tagPattern follow the OCI distribution specification
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// OCIReference locates an artifact in a registry, by tag or by digest
+type OCIReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseOCIReference parses registry/repository[:tag][@digest]. The registry must be given, as the backups are
+// pushed to the mirror registry of the site rather than to a default one
+// returns:			OCIReference, error
+func ParseOCIReference(ref string) (OCIReference, error) {
+	var r OCIReference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		r.Digest = name[i+1:]
+		name = name[:i]
+		if !digestPattern.MatchString(r.Digest) {
+			return r, fmt.Errorf("invalid digest in %q, expecting sha256:<digest>", ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		r.Tag = name[i+1:]
+		name = name[:i]
+		if !tagPattern.MatchString(r.Tag) {
+			return r, fmt.Errorf("invalid tag in %q", ref)
+		}
+	}
+	i := strings.Index(name, "/")
+	if i < 0 || !strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost" {
+		return r, fmt.Errorf("invalid reference %q, expecting registry/repository[:tag]", ref)
+	}
+	r.Registry, r.Repository = name[:i], name[i+1:]
+	if !repositoryPattern.MatchString(r.Repository) {
+		return r, fmt.Errorf("invalid repository in %q", ref)
+	}
+	return r, nil
+}
+
+// String renders the reference
+// returns:			string
+func (r OCIReference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// RegistryAuth holds the credentials to a registry, anonymous when empty
+type RegistryAuth struct {
+	Username string
+	Password string
+}
+
+// ReadRegistryAuth reads the credentials to a registry from a .dockerconfigjson file, as mounted from an image
+// pull secret. A registry without credentials in the file is accessed anonymously
+// returns:			RegistryAuth, error
+func ReadRegistryAuth(path string, registry string) (RegistryAuth, error) {
+	var auth RegistryAuth
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return auth, err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return auth, fmt.Errorf("couldn't decode %s: %s", path, err)
+	}
+	for host, entry := range config.Auths {
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+		if i := strings.Index(host, "/"); i >= 0 {
+			host = host[:i]
+		}
+		if host != registry {
+			continue
+		}
+		if entry.Auth == "" {
+			return RegistryAuth{Username: entry.Username, Password: entry.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return auth, fmt.Errorf("invalid auth of %s in %s: %s", registry, path, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return auth, fmt.Errorf("invalid auth of %s in %s, expecting user:password", registry, path)
+		}
+		return RegistryAuth{Username: parts[0], Password: parts[1]}, nil
+	}
+	log.Debugf("No credentials to %s in %s, accessing it anonymously", registry, path)
+	return auth, nil
+}
+
+// OCIConfig locates the artifact a backup is pushed as or pulled from, with the credentials to its registry
+type OCIConfig struct {
+	Reference OCIReference
+	Auth      RegistryAuth
+	PlainHTTP bool
+}
+
+// Enabled tells whether an artifact is configured
+// returns:			bool
+func (c OCIConfig) Enabled() bool {
+	return c.Reference.Repository != ""
+}
+
+// addOCIFlags registers the flags giving access to the registry
+func addOCIFlags(cmd *cobra.Command) {
+	cmd.Flags().String("registry-auth-file", "", "The .dockerconfigjson holding the credentials to the registry (default is "+DefaultRegistryAuthDir+"/.dockerconfigjson when mounted)")
+	cmd.Flags().Bool("oci-plain-http", false, "Access the registry over plain HTTP")
+}
+
+// ociConfigFromFlags parses the reference given by the flag and reads the credentials to its registry. The
+// credentials are read from the container, before changing root directory to /host
+// returns:			OCIConfig, error
+func ociConfigFromFlags(cmd *cobra.Command, flag string) (OCIConfig, error) {
+	var config OCIConfig
+	ref, _ := cmd.Flags().GetString(flag)
+	if ref == "" {
+		return config, nil
+	}
+	reference, err := ParseOCIReference(ref)
+	if err != nil {
+		return config, err
+	}
+	config.Reference = reference
+	config.PlainHTTP, _ = cmd.Flags().GetBool("oci-plain-http")
+
+	path, _ := cmd.Flags().GetString("registry-auth-file")
+	if path == "" {
+		path = filepath.Join(DefaultRegistryAuthDir, ".dockerconfigjson")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return config, nil
+		}
+	}
+	config.Auth, err = ReadRegistryAuth(path, reference.Registry)
+	return config, err
+}
+
+// OCIDescriptor describes the content of a blob
+type OCIDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIManifest is the manifest of an OCI artifact
+type OCIManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        OCIDescriptor     `json:"config"`
+	Layers        []OCIDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIClient talks to a registry with the OCI distribution API, authenticating with a bearer token or basic
+// authentication as the registry asks for
+type OCIClient struct {
+	Config OCIConfig
+	HTTP   *http.Client
+	// authorization is the header sent once the registry asked for authentication
+	authorization string
+}
+
+// NewOCIClient builds a client for the repository of the configured artifact
+// returns:			*OCIClient
+func NewOCIClient(config OCIConfig) *OCIClient {
+	return &OCIClient{Config: config, HTTP: &http.Client{Timeout: 30 * time.Minute}}
+}
+
+// url returns the URL of a path of the repository
+// returns:			string
+func (c *OCIClient) url(path string) string {
+	scheme := "https"
+	if c.Config.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", scheme, c.Config.Reference.Registry, c.Config.Reference.Repository, path)
+}
+
+// authenticate answers the challenge of the registry, getting a token from its realm for a bearer challenge
+// returns:			error
+func (c *OCIClient) authenticate(challenge string) error {
+	auth := c.Config.Auth
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if auth.Username == "" {
+			return fmt.Errorf("registry %s needs credentials", c.Config.Reference.Registry)
+		}
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication %q from registry %s", scheme, c.Config.Reference.Registry)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("invalid token realm %q from registry %s", params["realm"], c.Config.Reference.Registry)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", c.Config.Reference.Repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't get a token from %s: %s", realm.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&token); err != nil {
+		return fmt.Errorf("couldn't decode the token from %s: %s", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("no token from %s", realm.Host)
+	}
+	c.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g. Bearer realm="...",service="...",scope="..."
+// returns:			scheme, parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return parts[0], params
+}
+
+// send sends a request with the current authorization, the body being streamed only once
+// returns:			*http.Response, error
+func (c *OCIClient) send(method string, target string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.HTTP.Do(req)
+}
+
+// do sends a request with an in-memory body, authenticating and sending it again when the registry asks for it
+// returns:			*http.Response, error
+func (c *OCIClient) do(method string, target string, body []byte, header http.Header, expected ...int) (*http.Response, error) {
+	resp, err := c.send(method, target, bytes.NewReader(body), int64(len(body)), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		if err := c.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, err
+		}
+		if resp, err = c.send(method, target, bytes.NewReader(body), int64(len(body)), header); err != nil {
+			return nil, err
+		}
+	}
+	return checkStatus(method, target, resp, expected...)
+}
+
+// checkStatus closes the response and returns the error of the registry unless its status is expected
+// returns:			*http.Response, error
+func checkStatus(method string, target string, resp *http.Response, expected ...int) (*http.Response, error) {
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	var registryErr struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &registryErr) == nil && len(registryErr.Errors) > 0 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, target, registryErr.Errors[0].Code, registryErr.Errors[0].Message)
+	}
+	return nil, fmt.Errorf("%s %s: %s", method, target, resp.Status)
+}
+
+// BlobExists tells whether the repository already holds a blob
+// returns:			bool, error
+func (c *OCIClient) BlobExists(digest string) (bool, error) {
+	resp, err := c.do(http.MethodHead, c.url("blobs/"+digest), nil, nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// uploadURL resolves the location of an upload session, with the query given added
+// returns:			string, error
+func (c *OCIClient) uploadURL(resp *http.Response, query url.Values) (string, error) {
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("no location for the upload to %s", c.Config.Reference.Repository)
+	}
+	q := location.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	location.RawQuery = q.Encode()
+	return location.String(), nil
+}
+
+// startUpload opens an upload session for a blob
+// returns:			response locating the session, error
+func (c *OCIClient) startUpload() (*http.Response, error) {
+	resp, err := c.do(http.MethodPost, c.url("blobs/uploads/"), nil, nil, http.StatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	return resp, resp.Body.Close()
+}
+
+// PushBlob uploads a blob held in memory unless the repository already holds it
+// returns:			digest, error
+func (c *OCIClient) PushBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if exists, err := c.BlobExists(digest); err != nil || exists {
+		return digest, err
+	}
+	return digest, c.putBlob(bytes.NewReader(data), int64(len(data)), digest)
+}
+
+// putBlob uploads a blob of known digest in a single request
+// returns:			error
+func (c *OCIClient) putBlob(body io.Reader, size int64, digest string) error {
+	resp, err := c.startUpload()
+	if err != nil {
+		return err
+	}
+	location, err := c.uploadURL(resp, url.Values{"digest": {digest}})
+	if err != nil {
+		return err
+	}
+	resp, err = c.send(http.MethodPut, location, body, size, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err == nil {
+		resp, err = checkStatus(http.MethodPut, location, resp, http.StatusCreated)
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PushFile uploads a file in a single request unless the repository already holds it
+// returns:			digest, size, error
+func (c *OCIClient) PushFile(path string) (string, int64, error) {
+	sum, err := FileSHA256(path)
+	if err != nil {
+		return "", 0, err
+	}
+	digest := "sha256:" + sum
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if exists, err := c.BlobExists(digest); err != nil || exists {
+		return digest, info.Size(), err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return digest, info.Size(), c.putBlob(f, info.Size(), digest)
+}
+
+// PushStream uploads a stream whose digest isn't known beforehand, in one chunked request closed once its digest
+// is known
+// returns:			digest, size, error
+func (c *OCIClient) PushStream(r io.Reader) (string, int64, error) {
+	resp, err := c.startUpload()
+	if err != nil {
+		return "", 0, err
+	}
+	location, err := c.uploadURL(resp, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	hash := sha256.New()
+	counter := &countingWriter{}
+	resp, err = c.send(http.MethodPatch, location, io.TeeReader(r, io.MultiWriter(hash, counter)), -1,
+		http.Header{"Content-Type": {"application/octet-stream"}})
+	if err == nil {
+		resp, err = checkStatus(http.MethodPatch, location, resp, http.StatusAccepted, http.StatusNoContent)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	target, err := c.uploadURL(resp, url.Values{"digest": {digest}})
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err = c.send(http.MethodPut, target, nil, 0, nil)
+	if err == nil {
+		resp, err = checkStatus(http.MethodPut, target, resp, http.StatusCreated)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return digest, counter.n, resp.Body.Close()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// PutManifest uploads the manifest of an artifact under a tag
+// returns:			digest of the manifest, error
+func (c *OCIClient) PutManifest(tag string, manifest OCIManifest) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(http.MethodPut, c.url("manifests/"+tag), data, http.Header{"Content-Type": {OCIManifestMediaType}}, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), resp.Body.Close()
+}
+
+// GetManifest downloads the manifest of the configured artifact, checked against its digest when referenced by it
+// returns:			OCIManifest, error
+func (c *OCIClient) GetManifest() (OCIManifest, error) {
+	var manifest OCIManifest
+	ref := c.Config.Reference.Digest
+	if ref == "" {
+		ref = c.Config.Reference.Tag
+	}
+	if ref == "" {
+		return manifest, fmt.Errorf("no tag or digest in %s", c.Config.Reference)
+	}
+	resp, err := c.do(http.MethodGet, c.url("manifests/"+ref), nil, http.Header{"Accept": {OCIManifestMediaType}}, http.StatusOK)
+	if err != nil {
+		return manifest, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return manifest, err
+	}
+	if digest := c.Config.Reference.Digest; digest != "" {
+		sum := sha256.Sum256(data)
+		if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+			return manifest, fmt.Errorf("manifest of %s doesn't match its digest", c.Config.Reference)
+		}
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("couldn't decode the manifest of %s: %s", c.Config.Reference, err)
+	}
+	if manifest.Config.MediaType != BackupConfigMediaType {
+		return manifest, fmt.Errorf("%s is not a backup, its config is of type %q", c.Config.Reference, manifest.Config.MediaType)
+	}
+	return manifest, nil
+}
+
+// GetBlob downloads a blob of the repository
+// returns:			io.ReadCloser, error
+func (c *OCIClient) GetBlob(digest string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, c.url("blobs/"+digest), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PushBackup pushes a generation as an OCI artifact, a layer for every entry of the generation with the manifest
+// of the backup as config. The directories are pushed as tarballs, the files as they are, so that the archives
+// and the files already pushed with a previous generation are not uploaded again
+// returns:			digest of the artifact, error
+func PushBackup(client *OCIClient, dir string, generation string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return "", err
+	}
+	var backup Manifest
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return "", fmt.Errorf("couldn't decode %s: %s", ManifestFile, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	layers := []OCIDescriptor{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == ManifestFile || name == pinMarker {
+			continue
+		}
+		layer := OCIDescriptor{Annotations: map[string]string{ociTitleAnnotation: name}}
+		switch {
+		case entry.IsDir():
+			reader, writer := io.Pipe()
+			go func() {
+				writer.CloseWithError(writeTar(writer, filepath.Join(dir, name), dir))
+			}()
+			layer.MediaType = BackupDirMediaType
+			layer.Digest, layer.Size, err = client.PushStream(reader)
+			reader.Close()
+		case entry.Type().IsRegular():
+			layer.MediaType = BackupFileMediaType
+			layer.Digest, layer.Size, err = client.PushFile(filepath.Join(dir, name))
+		default:
+			err = fmt.Errorf("unsupported entry %s in the backup", name)
+		}
+		if err != nil {
+			return "", fmt.Errorf("couldn't push %s: %s", name, err)
+		}
+		log.Infof("Pushed %s (%s) as %s", name, HumanSize(layer.Size), layer.Digest)
+		layers = append(layers, layer)
+	}
+
+	config, err := client.PushBlob(data)
+	if err != nil {
+		return "", fmt.Errorf("couldn't push the manifest of the backup: %s", err)
+	}
+	tag := client.Config.Reference.Tag
+	if tag == "" {
+		tag = generation
+	}
+	// the manifest of the artifact is pushed last, so that the tag only ever points at a complete backup
+	digest, err := client.PutManifest(tag, OCIManifest{
+		SchemaVersion: 2,
+		MediaType:     OCIManifestMediaType,
+		Config:        OCIDescriptor{MediaType: BackupConfigMediaType, Digest: config, Size: int64(len(data))},
+		Layers:        layers,
+		Annotations: map[string]string{
+			generationAnnotation: generation,
+			ociCreatedAnnotation: backup.Timestamp.UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't push the backup: %s", err)
+	}
+	log.Infof("Backup pushed to %s/%s:%s@%s", client.Config.Reference.Registry, client.Config.Reference.Repository, tag, digest)
+	return digest, nil
+}
+
+// pullBlob downloads a blob with dst, then checks the whole blob against its digest and size
+// returns:			error
+func pullBlob(client *OCIClient, layer OCIDescriptor, dst func(io.Reader) error) error {
+	body, err := client.GetBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	hash := sha256.New()
+	counter := &countingWriter{}
+	reader := io.TeeReader(body, io.MultiWriter(hash, counter))
+	if err := dst(reader); err != nil {
+		return err
+	}
+	// the padding after the end of a tarball is part of the digest
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != layer.Digest || counter.n != layer.Size {
+		return fmt.Errorf("blob %s doesn't match its digest or its size", layer.Digest)
+	}
+	return nil
+}
+
+// PullBackup downloads a backup pushed as an OCI artifact into the staging directory, checks it against the
+// digests of the artifact and its manifest, then promotes it as the current generation, named after the generation
+// it was pushed from unless given
+// returns:			generation directory, error
+func PullBackup(client *OCIClient, BackupPath string, generation string) (string, error) {
+	manifest, err := client.GetManifest()
+	if err != nil {
+		return "", err
+	}
+	if generation == "" {
+		generation = manifest.Annotations[generationAnnotation]
+	}
+	if err := ValidateGenerationName(generation); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(BackupPath, GenerationsDir, generation)); err == nil {
+		return "", fmt.Errorf("generation %s is already in %s", generation, BackupPath)
+	}
+
+	staging, err := PrepareStaging(BackupPath)
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		name := layer.Annotations[ociTitleAnnotation]
+		if name == "" || name == "." || name == ".." || name == ManifestFile || strings.ContainsAny(name, `/\`) {
+			return "", fmt.Errorf("invalid layer title %q in %s", name, client.Config.Reference)
+		}
+		target := filepath.Join(staging, name)
+		switch layer.MediaType {
+		case BackupDirMediaType:
+			err = pullBlob(client, layer, func(r io.Reader) error {
+				return extractTar(r, staging, name)
+			})
+			if _, statErr := os.Stat(target); err == nil && statErr != nil {
+				err = fmt.Errorf("layer %s doesn't hold %s", layer.Digest, name)
+			}
+		case BackupFileMediaType:
+			err = pullBlob(client, layer, func(r io.Reader) error {
+				f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(f, r); err != nil {
+					f.Close()
+					return err
+				}
+				return f.Close()
+			})
+		default:
+			err = fmt.Errorf("unsupported layer of type %q", layer.MediaType)
+		}
+		if err != nil {
+			return "", fmt.Errorf("couldn't pull %s: %s", name, err)
+		}
+	}
+	err = pullBlob(client, manifest.Config, func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(staging, ManifestFile), data, 0600)
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't pull the manifest of the backup: %s", err)
+	}
+
+	dir, err := promoteDownloaded(BackupPath, generation)
+	if err != nil {
+		return "", err
+	}
+	log.Infof("Backup pulled from %s into %s", client.Config.Reference, dir)
+	return dir, nil
+}
+
+// pullBackupCmd represents the pullBackup command
+var pullBackupCmd = &cobra.Command{
+	Use:   "pullBackup",
+	Short: "It will pull a backup pushed as an OCI artifact into the specified path",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		generation, _ := cmd.Flags().GetString("generation")
+
+		config, err := ociConfigFromFlags(cmd, "ref")
+		if err != nil {
+			return err
+		}
+
+		BackupPath, err = hostBackupPath(BackupPath)
+		if err != nil {
+			return err
+		}
+		if err := prepareBackupPath(BackupPath); err != nil {
+			return err
+		}
+		lock, err := AcquireLock(BackupPath, "pull")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		if RecoveryInProgress(BackupDir(BackupPath)) {
+			return fmt.Errorf("a recovery is in progress from %s", BackupDir(BackupPath))
+		}
+		cmd.SilenceUsage = true
+		_, err = PullBackup(NewOCIClient(config), BackupPath, generation)
+		return err
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(pullBackupCmd)
+
+	pullBackupCmd.Flags().StringP("BackupPath", "p", "", "Path where the backup is stored")
+	_ = pullBackupCmd.MarkFlagRequired("BackupPath")
+	pullBackupCmd.Flags().String("ref", "", "Reference of the backup artifact, registry/repository:tag or registry/repository@digest")
+	_ = pullBackupCmd.MarkFlagRequired("ref")
+	pullBackupCmd.Flags().String("generation", "", "Name of the pulled backup generation (default is the generation it was pushed from)")
+	addOCIFlags(pullBackupCmd)
+}
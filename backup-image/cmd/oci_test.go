@@ -0,0 +1,273 @@
+package cmd_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeRegistry is an in-memory stand-in for a registry serving the OCI distribution API, handing out bearer
+// tokens to user:password
+type fakeRegistry struct {
+	sync.Mutex
+	realm     string
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	manifests map[string][]byte
+	// uploaded counts the blobs uploaded
+	uploaded int
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.URL.Path == "/token" {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "tok"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer tok" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry",scope="repository:backups/sno:pull,push"`, f.realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors": [{"code": "UNAUTHORIZED", "message": "authentication required"}]}`)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/backups/sno/")
+	body, _ := io.ReadAll(r.Body)
+	digest := r.URL.Query().Get("digest")
+	switch {
+	case r.Method == http.MethodPost && path == "blobs/uploads/":
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = []byte{}
+		w.Header().Set("Location", "/v2/backups/sno/blobs/uploads/"+id+"?_state=s")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "blobs/uploads/"):
+		id := strings.TrimPrefix(path, "blobs/uploads/")
+		f.uploads[id] = append(f.uploads[id], body...)
+		w.Header().Set("Location", r.URL.String())
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "blobs/uploads/"):
+		id := strings.TrimPrefix(path, "blobs/uploads/")
+		data := append(f.uploads[id], body...)
+		sum := sha256.Sum256(data)
+		if r.URL.Query().Get("_state") != "s" || digest != "sha256:"+hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": [{"code": "DIGEST_INVALID", "message": "digest mismatch"}]}`)
+			return
+		}
+		f.blobs[digest] = data
+		f.uploaded++
+		delete(f.uploads, id)
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "blobs/"):
+		blob, ok := f.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(blob)
+		}
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "manifests/"):
+		Expect(r.Header.Get("Content-Type")).To(Equal(cmd.OCIManifestMediaType))
+		f.manifests[strings.TrimPrefix(path, "manifests/")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "manifests/"):
+		manifest, ok := f.manifests[strings.TrimPrefix(path, "manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", cmd.OCIManifestMediaType)
+		_, _ = w.Write(manifest)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var _ = Describe("OCI", func() {
+	Describe("ParseOCIReference", func() {
+		It("parses references by tag and by digest", func() {
+			ref, err := cmd.ParseOCIReference("mirror.example.com:5000/backups/sno:pre-4.11")
+			Expect(err).Should(BeNil())
+			Expect(ref).To(Equal(cmd.OCIReference{Registry: "mirror.example.com:5000", Repository: "backups/sno", Tag: "pre-4.11"}))
+
+			digest := "sha256:" + strings.Repeat("a", 64)
+			ref, err = cmd.ParseOCIReference("mirror.example.com/backups/sno@" + digest)
+			Expect(err).Should(BeNil())
+			Expect(ref.Digest).To(Equal(digest))
+			Expect(ref.Tag).To(BeEmpty())
+			Expect(ref.String()).To(Equal("mirror.example.com/backups/sno@" + digest))
+		})
+
+		It("refuses references without a registry or with invalid names", func() {
+			for _, ref := range []string{"backups/sno:latest", "sno", "mirror.example.com/Backups", "mirror.example.com/sno@sha256:abc"} {
+				_, err := cmd.ParseOCIReference(ref)
+				Expect(err).Should(HaveOccurred(), ref)
+			}
+		})
+	})
+
+	Describe("ReadRegistryAuth", func() {
+		It("reads the credentials of the registry and falls back to anonymous access", func() {
+			dir, _ := os.MkdirTemp("", "tmpDir")
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, ".dockerconfigjson")
+			auth := base64.StdEncoding.EncodeToString([]byte("user:pass:word"))
+			Expect(os.WriteFile(path, []byte(`{"auths": {"https://mirror.example.com:5000/v2/": {"auth": "`+auth+`"}}}`), 0600)).To(Succeed())
+
+			credentials, err := cmd.ReadRegistryAuth(path, "mirror.example.com:5000")
+			Expect(err).Should(BeNil())
+			Expect(credentials).To(Equal(cmd.RegistryAuth{Username: "user", Password: "pass:word"}))
+
+			credentials, err = cmd.ReadRegistryAuth(path, "quay.io")
+			Expect(err).Should(BeNil())
+			Expect(credentials).To(Equal(cmd.RegistryAuth{}))
+		})
+	})
+
+	Describe("PushBackup and PullBackup", func() {
+		var dir string
+		var registry *fakeRegistry
+		var server *httptest.Server
+		var config cmd.OCIConfig
+		var generation string
+
+		BeforeEach(func() {
+			dir, _ = os.MkdirTemp("", "tmpDir")
+			registry = &fakeRegistry{blobs: map[string][]byte{}, uploads: map[string][]byte{}, manifests: map[string][]byte{}}
+			server = httptest.NewServer(registry)
+			registry.realm = server.URL + "/token"
+			config = cmd.OCIConfig{
+				Reference: cmd.OCIReference{Registry: server.Listener.Addr().String(), Repository: "backups/sno"},
+				Auth:      cmd.RegistryAuth{Username: "user", Password: "password"},
+				PlainHTTP: true,
+			}
+
+			generation = filepath.Join(dir, "node", "generations", "20220520T101010Z-pre-4.11")
+			manifest := writeBackup(generation, "127.0.0.1 localhost\n")
+			Expect(cmd.WriteManifest(generation, manifest)).To(Succeed())
+			Expect(cmd.PinGeneration(filepath.Join(dir, "node"), "20220520T101010Z-pre-4.11", true)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			server.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("round trips a generation through the registry", func() {
+			digest, err := cmd.PushBackup(cmd.NewOCIClient(config), generation, "20220520T101010Z-pre-4.11")
+			Expect(err).Should(BeNil())
+			Expect(digest).To(HavePrefix("sha256:"))
+			Expect(registry.manifests).To(HaveKey("20220520T101010Z-pre-4.11"))
+			// etc, usrlocal.tar.gz, upgrade-recovery.sh and the manifest, without the pin marker
+			Expect(registry.uploaded).To(Equal(4))
+
+			// the files already in the registry are not uploaded again
+			config.Reference.Tag = "latest"
+			_, err = cmd.PushBackup(cmd.NewOCIClient(config), generation, "20220520T101010Z-pre-4.11")
+			Expect(err).Should(BeNil())
+			Expect(registry.uploaded).To(Equal(5))
+
+			restored := filepath.Join(dir, "restored")
+			Expect(os.Mkdir(restored, 0700)).To(Succeed())
+			pulled, err := cmd.PullBackup(cmd.NewOCIClient(config), restored, "")
+			Expect(err).Should(BeNil())
+			Expect(pulled).To(Equal(filepath.Join(restored, "generations", "20220520T101010Z-pre-4.11")))
+			Expect(cmd.BackupDir(restored)).To(Equal(pulled))
+
+			hosts, _ := os.ReadFile(filepath.Join(pulled, "etc", "hosts"))
+			Expect(string(hosts)).To(Equal("127.0.0.1 localhost\n"))
+			info, err := os.Stat(filepath.Join(pulled, "upgrade-recovery.sh"))
+			Expect(err).Should(BeNil())
+			Expect(info.Mode().IsRegular()).To(BeTrue())
+			Expect(filepath.Join(pulled, ".pinned")).NotTo(BeAnExistingFile())
+			Expect(cmd.VerifyBackup(pulled).Checks[0].Passed).To(BeTrue())
+		})
+
+		It("refuses a blob which doesn't match its digest", func() {
+			_, err := cmd.PushBackup(cmd.NewOCIClient(config), generation, "20220520T101010Z-pre-4.11")
+			Expect(err).Should(BeNil())
+			for digest, blob := range registry.blobs {
+				if string(blob) == "#!/bin/bash\n" {
+					registry.blobs[digest] = []byte("#!/bin/sh\n")
+				}
+			}
+
+			restored := filepath.Join(dir, "restored")
+			Expect(os.Mkdir(restored, 0700)).To(Succeed())
+			config.Reference.Tag = "20220520T101010Z-pre-4.11"
+			_, err = cmd.PullBackup(cmd.NewOCIClient(config), restored, "")
+			Expect(err).Should(HaveOccurred())
+			Expect(filepath.Join(restored, "generations")).NotTo(BeADirectory())
+		})
+
+		It("refuses a directory layer holding other entries of the backup", func() {
+			_, err := cmd.PushBackup(cmd.NewOCIClient(config), generation, "20220520T101010Z-pre-4.11")
+			Expect(err).Should(BeNil())
+
+			// the etc layer overwrites the recovery script
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			Expect(tw.WriteHeader(&tar.Header{Name: "etc/", Mode: 0700, Typeflag: tar.TypeDir})).To(Succeed())
+			Expect(tw.WriteHeader(&tar.Header{Name: "upgrade-recovery.sh", Mode: 0700, Size: 10, Typeflag: tar.TypeReg})).To(Succeed())
+			_, err = tw.Write([]byte("#!/bin/sh\n"))
+			Expect(err).Should(BeNil())
+			Expect(tw.Close()).To(Succeed())
+			sum := sha256.Sum256(buf.Bytes())
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+			registry.blobs[digest] = buf.Bytes()
+
+			var manifest cmd.OCIManifest
+			Expect(json.Unmarshal(registry.manifests["20220520T101010Z-pre-4.11"], &manifest)).To(Succeed())
+			for i := range manifest.Layers {
+				if manifest.Layers[i].MediaType == cmd.BackupDirMediaType {
+					manifest.Layers[i].Digest = digest
+					manifest.Layers[i].Size = int64(buf.Len())
+				}
+			}
+			registry.manifests["20220520T101010Z-pre-4.11"], err = json.Marshal(manifest)
+			Expect(err).Should(BeNil())
+
+			restored := filepath.Join(dir, "restored")
+			Expect(os.Mkdir(restored, 0700)).To(Succeed())
+			config.Reference.Tag = "20220520T101010Z-pre-4.11"
+			_, err = cmd.PullBackup(cmd.NewOCIClient(config), restored, "")
+			Expect(err).Should(HaveOccurred())
+			Expect(filepath.Join(restored, "staging", "upgrade-recovery.sh")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(restored, "generations")).NotTo(BeADirectory())
+		})
+
+		It("fails with wrong credentials", func() {
+			config.Auth.Password = "wrong"
+			_, err := cmd.PushBackup(cmd.NewOCIClient(config), generation, "20220520T101010Z-pre-4.11")
+			Expect(err).Should(HaveOccurred())
+			Expect(registry.manifests).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RecoveryMarker marks a directory as dedicated to the backups when it isn't a mount point of its own
+const RecoveryMarker string = ".recovery-partition"
+
+// DefaultAllowedPrefixes are the paths under which backups may be stored, unless --allowed-prefix says otherwise
+var DefaultAllowedPrefixes = []string{"/var/recovery"}
+
+// allowedPrefixes holds the value of the --allowed-prefix flag
+var allowedPrefixes []string
+
+// systemTrees are never used for backups, nor is anything under them
+var systemTrees = []string{
+	"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/ostree", "/proc", "/run", "/sbin", "/sys", "/sysroot", "/usr",
+	"/var/lib/containers", "/var/lib/etcd", "/var/lib/kubelet",
+}
+
+// systemDirs are never used for backups, though dedicated directories may be found under them
+var systemDirs = []string{"/", "/home", "/media", "/mnt", "/opt", "/root", "/srv", "/tmp", "/var", "/var/lib"}
+
+// isUnder tells whether path is dir or is under it, both being clean
+// returns:			bool
+func isUnder(path string, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// checkNotSystemPath normalises an absolute path and refuses the root and system directories
+// returns:			clean path, error
+func checkNotSystemPath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("no backup path given")
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("backup path %q must be absolute", path)
+	}
+	path = filepath.Clean(path)
+
+	for _, dir := range systemDirs {
+		if path == dir {
+			return "", fmt.Errorf("refusing to use system directory %s as backup path", path)
+		}
+	}
+	for _, tree := range systemTrees {
+		if isUnder(path, tree) {
+			return "", fmt.Errorf("refusing to use %s as backup path, it is part of system directory %s", path, tree)
+		}
+	}
+	return path, nil
+}
+
+// ValidateBackupPath normalises the backup path and checks it is under one of the allowed prefixes and is not
+// a system directory
+// returns:			clean path, error
+func ValidateBackupPath(path string, allowed []string) (string, error) {
+	path, err := checkNotSystemPath(path)
+	if err != nil {
+		return "", err
+	}
+	for _, prefix := range allowed {
+		if isUnder(path, filepath.Clean(prefix)) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("backup path %s is not under an allowed prefix (%s), see --allowed-prefix", path, strings.Join(allowed, ", "))
+}
+
+// ResolveBackupPath resolves the symbolic links of the backup path, or of its parent when it doesn't exist yet,
+// and validates the path it actually points at
+// returns:			resolved path, error
+func ResolveBackupPath(path string, allowed []string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		parent, parentErr := filepath.EvalSymlinks(filepath.Dir(path))
+		if parentErr != nil {
+			return "", fmt.Errorf("couldn't resolve the parent of backup path %s: %s", path, parentErr)
+		}
+		resolved, err = filepath.Join(parent, filepath.Base(path)), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve backup path %s: %s", path, err)
+	}
+	if resolved != path {
+		log.Infof("Backup path %s resolves to %s", path, resolved)
+	}
+	return ValidateBackupPath(resolved, allowed)
+}
+
+// IsMountPoint tells whether a directory is the mount point of a filesystem of its own
+// returns:			bool, error
+func IsMountPoint(path string) (bool, error) {
+	var stat, parent syscall.Stat_t
+	if err := syscall.Lstat(path, &stat); err != nil {
+		return false, err
+	}
+	if err := syscall.Lstat(filepath.Dir(path), &parent); err != nil {
+		return false, err
+	}
+	return stat.Dev != parent.Dev, nil
+}
+
+// hasMarker tells whether the directory carries the recovery marker
+// returns:			bool
+func hasMarker(path string) bool {
+	_, err := os.Stat(filepath.Join(path, RecoveryMarker))
+	return err == nil
+}
+
+// holdsBackup tells whether the directory holds a backup taken before the recovery marker was introduced
+// returns:			bool
+func holdsBackup(path string) bool {
+	for _, name := range []string{CurrentLink, GenerationsDir, recoveryScript} {
+		if _, err := os.Lstat(filepath.Join(path, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkBackupTarget marks a directory as dedicated to the backups
+// returns:			error
+func MarkBackupTarget(path string) error {
+	return os.WriteFile(filepath.Join(path, RecoveryMarker), []byte("This directory is dedicated to the upgrade recovery backups\n"), 0600)
+}
+
+// CheckBackupTarget checks the directory is dedicated to the backups before anything is deleted in it: it must be
+// a mount point or carry the recovery marker. A directory holding a previous backup gets the marker
+// returns:			error
+func CheckBackupTarget(path string) error {
+	if hasMarker(path) {
+		return nil
+	}
+	mountPoint, err := IsMountPoint(path)
+	if err != nil {
+		return err
+	}
+	if mountPoint {
+		return nil
+	}
+	if holdsBackup(path) {
+		log.Infof("Marking %s, which holds a previous backup, as dedicated to the backups", path)
+		return MarkBackupTarget(path)
+	}
+	return fmt.Errorf("%s is neither a mount point nor marked as dedicated to the backups, create %s in it to use it",
+		path, filepath.Join(path, RecoveryMarker))
+}
+
+// hostBackupPath validates the backup path, changes root directory to the host and validates the path the backup
+// path actually points at on the host
+// returns:			resolved path, error
+func hostBackupPath(BackupPath string) (string, error) {
+	BackupPath, err := ValidateBackupPath(BackupPath, allowedPrefixes)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	if err := chrootHost(); err != nil {
+		return "", err
+	}
+	BackupPath, err = ResolveBackupPath(BackupPath, allowedPrefixes)
+	if err != nil {
+		log.Error(err)
+		return "", err
+	}
+	return BackupPath, nil
+}
@@ -0,0 +1,79 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PathSafety", func() {
+	Describe("ValidateBackupPath", func() {
+		allowed := []string{"/var/recovery"}
+
+		It("normalises paths under the allowed prefix", func() {
+			for path, expected := range map[string]string{
+				"/var/recovery":            "/var/recovery",
+				"/var/recovery/":           "/var/recovery",
+				"/var/recovery//sno/./":    "/var/recovery/sno",
+				"/var/recovery/sno/../sno": "/var/recovery/sno",
+			} {
+				clean, err := cmd.ValidateBackupPath(path, allowed)
+				Expect(err).ShouldNot(HaveOccurred(), path)
+				Expect(clean).To(Equal(expected))
+			}
+		})
+
+		It("refuses empty, relative and system paths", func() {
+			for _, path := range []string{"", "recovery", "/", "/var", "/etc", "/var/lib/etcd", "/sysroot/ostree", "/var/recovery/../lib/etcd"} {
+				_, err := cmd.ValidateBackupPath(path, []string{"/"})
+				Expect(err).Should(HaveOccurred(), path)
+			}
+		})
+
+		It("refuses paths outside of the allowed prefixes", func() {
+			for _, path := range []string{"/var/recoveryx", "/home/core/recovery", "/var/recovery/../tmp"} {
+				_, err := cmd.ValidateBackupPath(path, allowed)
+				Expect(err).Should(HaveOccurred(), path)
+			}
+		})
+	})
+
+	Describe("ResolveBackupPath", func() {
+		It("validates the target of a symbolic link", func() {
+			dir, _ := os.MkdirTemp("", "pathsafety")
+			defer os.RemoveAll(dir)
+			dir, _ = filepath.EvalSymlinks(dir)
+			Expect(os.Mkdir(filepath.Join(dir, "recovery"), 0700)).To(Succeed())
+			Expect(os.Symlink("/etc", filepath.Join(dir, "recovery", "escape"))).To(Succeed())
+
+			resolved, err := cmd.ResolveBackupPath(filepath.Join(dir, "recovery", "new"), []string{dir})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resolved).To(Equal(filepath.Join(dir, "recovery", "new")))
+
+			_, err = cmd.ResolveBackupPath(filepath.Join(dir, "recovery", "escape"), []string{dir})
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("CheckBackupTarget", func() {
+		It("accepts a marked dir and refuses an unmarked one", func() {
+			dir, _ := os.MkdirTemp("", "pathsafety")
+			defer os.RemoveAll(dir)
+			Expect(cmd.CheckBackupTarget(dir)).ShouldNot(Succeed())
+			Expect(cmd.MarkBackupTarget(dir)).To(Succeed())
+			Expect(cmd.CheckBackupTarget(dir)).To(Succeed())
+		})
+
+		It("marks a dir holding a previous backup", func() {
+			dir, _ := os.MkdirTemp("", "pathsafety")
+			defer os.RemoveAll(dir)
+			Expect(os.Mkdir(filepath.Join(dir, cmd.GenerationsDir), 0700)).To(Succeed())
+			Expect(cmd.CheckBackupTarget(dir)).To(Succeed())
+			Expect(filepath.Join(dir, cmd.RecoveryMarker)).Should(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// etcdDB is the database of etcd, whose snapshot is about as large
+const etcdDB string = "/var/lib/etcd/member/snap/db"
+
+// spaceMarginPercent is added to the estimate, covering the static pod resources and the growth during the backup
+const spaceMarginPercent int64 = 10
+
+// backedUpDirs are the directories copied by the backup
+var backedUpDirs = map[string]string{
+	"etc":      "/etc",
+	"usrlocal": "/usr/local",
+	"kubelet":  "/var/lib/kubelet",
+}
+
+// SpaceEstimate is the estimated size of a backup
+type SpaceEstimate struct {
+	Components map[string]int64
+	Total      int64
+}
+
+// Required returns the space to be available for the backup, margin included
+// returns:			int64
+func (e SpaceEstimate) Required() int64 {
+	return e.Total + e.Total*spaceMarginPercent/100
+}
+
+// String details the estimate by component
+func (e SpaceEstimate) String() string {
+	names := make([]string, 0, len(e.Components))
+	for name := range e.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, HumanSize(e.Components[name])))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// HumanSize renders a size in bytes with a binary unit
+// returns:			string
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// EstimateBackupSize estimates the size of a backup from the directories it copies, the etcd database
+// and the extra files managed by the machine configs
+// returns:			SpaceEstimate, error
+func EstimateBackupSize(dirs map[string]string, etcd string, extras []string) (SpaceEstimate, error) {
+	estimate := SpaceEstimate{Components: map[string]int64{}}
+
+	for name, dir := range dirs {
+		size, err := BackupSize(dir)
+		if err != nil {
+			return estimate, fmt.Errorf("couldn't compute the size of %s: %s", dir, err)
+		}
+		estimate.Components[name] = size
+	}
+
+	info, err := os.Stat(etcd)
+	if err != nil {
+		return estimate, fmt.Errorf("couldn't get the size of the etcd database: %s", err)
+	}
+	estimate.Components["cluster"] = info.Size()
+
+	var extrasSize int64
+	for _, extra := range extras {
+		if info, err := os.Stat(extra); err == nil && info.Mode().IsRegular() {
+			extrasSize += info.Size()
+		}
+	}
+	estimate.Components["extras"] = extrasSize
+
+	for _, size := range estimate.Components {
+		estimate.Total += size
+	}
+	return estimate, nil
+}
+
+// MachineConfigExtraFiles lists the files managed by the machine configs outside of the backed up directories,
+// as the recovery script does
+// returns:			[]string
+func MachineConfigExtraFiles() []string {
+	out := commandOutput("oc", "get", "mc", `-o=jsonpath={range .items[*]}{range .spec.config.storage.files[*]}{.path}{"\n"}`)
+	files := map[string]bool{}
+	for _, path := range strings.Split(out, "\n") {
+		path = strings.TrimSpace(path)
+		if path == "" || strings.HasPrefix(path, "/etc/") || strings.HasPrefix(path, "/usr/local/") || strings.Contains(path, "/var/lib/kubelet/") {
+			continue
+		}
+		files[path] = true
+	}
+
+	extras := make([]string, 0, len(files))
+	for path := range files {
+		extras = append(extras, path)
+	}
+	sort.Strings(extras)
+	return extras
+}
+
+// AvailableSpace returns the space available to the backup on the filesystem holding path
+// returns:			int64, error
+func AvailableSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// CheckDiskSpace fails when the filesystem holding BackupPath can't hold the estimated backup
+// returns:			error
+func CheckDiskSpace(BackupPath string, estimate SpaceEstimate) error {
+	available, err := AvailableSpace(BackupPath)
+	if err != nil {
+		return fmt.Errorf("couldn't get the space available in %s: %s", BackupPath, err)
+	}
+
+	log.Infof("Backup estimated to %s (%s), %s available in %s", HumanSize(estimate.Total), estimate, HumanSize(available), BackupPath)
+	if available < estimate.Required() {
+		return fmt.Errorf("not enough space in %s for the backup: %s required (%s estimated plus %d%% margin, %s), %s available",
+			BackupPath, HumanSize(estimate.Required()), HumanSize(estimate.Total), spaceMarginPercent, estimate, HumanSize(available))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Preflight", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+		Expect(os.MkdirAll(filepath.Join(dir, "etc", "sub"), 0700)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "etc", "sub", "file"), make([]byte, 1000), 0600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "db"), make([]byte, 500), 0600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "extra"), make([]byte, 100), 0600)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("EstimateBackupSize", func() {
+		It("sums the directories, the etcd database and the extra files", func() {
+			estimate, err := cmd.EstimateBackupSize(map[string]string{"etc": filepath.Join(dir, "etc")}, filepath.Join(dir, "db"),
+				[]string{filepath.Join(dir, "extra"), filepath.Join(dir, "missing")})
+			Expect(err).Should(BeNil())
+			Expect(estimate.Components).To(Equal(map[string]int64{"etc": 1000, "cluster": 500, "extras": 100}))
+			Expect(estimate.Total).To(Equal(int64(1600)))
+			Expect(estimate.Required()).To(Equal(int64(1760)))
+		})
+
+		It("fails without an etcd database", func() {
+			_, err := cmd.EstimateBackupSize(map[string]string{}, filepath.Join(dir, "missing"), nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("CheckDiskSpace", func() {
+		It("accepts a backup which fits", func() {
+			Expect(cmd.CheckDiskSpace(dir, cmd.SpaceEstimate{Total: 1})).Should(Succeed())
+		})
+
+		It("rejects a backup which doesn't fit with a clear error", func() {
+			available, err := cmd.AvailableSpace(dir)
+			Expect(err).Should(BeNil())
+			err = cmd.CheckDiskSpace(dir, cmd.SpaceEstimate{Total: available, Components: map[string]int64{"etc": available}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not enough space in " + dir))
+		})
+	})
+
+	Describe("HumanSize", func() {
+		It("renders sizes with binary units", func() {
+			Expect(cmd.HumanSize(512)).To(Equal("512B"))
+			Expect(cmd.HumanSize(1536)).To(Equal("1.5KiB"))
+			Expect(cmd.HumanSize(3 * 1024 * 1024 * 1024)).To(Equal("3.0GiB"))
+		})
+	})
+})
@@ -57,6 +57,7 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.openshift-ai-image-backup.yaml)")
+	rootCmd.PersistentFlags().StringSliceVar(&allowedPrefixes, "allowed-prefix", DefaultAllowedPrefixes, "Paths under which the backups may be stored")
 
 }
 
@@ -0,0 +1,454 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DefaultPartSize is the size of the parts of the multipart uploads
+const DefaultPartSize int64 = 64 * 1024 * 1024
+
+// minPartSize is the minimum size of a part, but the last one, accepted by S3
+const minPartSize int64 = 5 * 1024 * 1024
+
+// emptySHA256 is the checksum of an empty payload
+const emptySHA256 string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config locates the bucket the backups are exported to, and holds the credentials to it
+type S3Config struct {
+	Endpoint        string
+	Bucket          string
+	Prefix          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	PartSize        int64
+}
+
+// S3ConfigFromEnv reads the configuration from the environment, as set from a secret
+// returns:			S3Config
+func S3ConfigFromEnv() S3Config {
+	return S3Config{
+		Endpoint:        os.Getenv("S3_ENDPOINT"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Prefix:          os.Getenv("S3_PREFIX"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		PartSize:        DefaultPartSize,
+	}
+}
+
+// addS3Flags registers the flags locating the bucket, which default to the environment
+func addS3Flags(cmd *cobra.Command) {
+	cmd.Flags().String("s3-endpoint", "", "URL of the S3-compatible endpoint (default is $S3_ENDPOINT)")
+	cmd.Flags().String("s3-bucket", "", "Bucket the backups are exported to (default is $S3_BUCKET)")
+	cmd.Flags().String("s3-prefix", "", "Prefix of the exported backups in the bucket (default is $S3_PREFIX)")
+	cmd.Flags().String("s3-region", "", "Region of the bucket (default is $S3_REGION, or else us-east-1)")
+}
+
+// s3ConfigFromFlags reads the configuration from the environment, overridden by the flags
+// returns:			S3Config
+func s3ConfigFromFlags(cmd *cobra.Command) S3Config {
+	config := S3ConfigFromEnv()
+	for flag, field := range map[string]*string{
+		"s3-endpoint": &config.Endpoint,
+		"s3-bucket":   &config.Bucket,
+		"s3-prefix":   &config.Prefix,
+		"s3-region":   &config.Region,
+	} {
+		if value, _ := cmd.Flags().GetString(flag); value != "" {
+			*field = value
+		}
+	}
+	return config
+}
+
+// Enabled tells whether a bucket is configured
+// returns:			bool
+func (c S3Config) Enabled() bool {
+	return c.Bucket != ""
+}
+
+// Validate checks the configuration is complete
+// returns:			error
+func (c S3Config) Validate() error {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("invalid S3 endpoint %q, expecting http(s)://host[:port]", c.Endpoint)
+	}
+	if c.Bucket == "" {
+		return fmt.Errorf("no S3 bucket given")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return fmt.Errorf("no S3 credentials, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if c.PartSize < minPartSize {
+		return fmt.Errorf("S3 part size must be at least %d bytes", minPartSize)
+	}
+	return nil
+}
+
+// S3Client talks to an S3-compatible endpoint with path-style requests signed with AWS Signature Version 4
+type S3Client struct {
+	Config S3Config
+	HTTP   *http.Client
+	// Now returns the time the requests are signed at
+	Now func() time.Time
+}
+
+// NewS3Client builds a client for the configured endpoint
+// returns:			*S3Client
+func NewS3Client(config S3Config) *S3Client {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PartSize == 0 {
+		config.PartSize = DefaultPartSize
+	}
+	return &S3Client{Config: config, HTTP: &http.Client{Timeout: 30 * time.Minute}, Now: time.Now}
+}
+
+// s3Error is the error document returned by S3
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// uriEncode encodes a string as S3 expects in the canonical request, keeping the slashes when asked to
+// returns:			string
+func uriEncode(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery renders the query sorted by key, encoded as S3 expects
+// returns:			string
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// hmacSHA256 computes the HMAC-SHA256 of data
+// returns:			[]byte
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Sign adds the AWS Signature Version 4 headers to the request, signing the host and all its headers
+func (c *S3Client) Sign(req *http.Request, payloadHash string) {
+	now := c.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.Config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.Config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, true),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join([]string{date, c.Config.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.Config.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.Config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// do sends a signed request for an object of the bucket, or the bucket itself when key is empty, and turns the
+// error responses into errors
+// returns:			*http.Response, error
+func (c *S3Client) do(method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	target := strings.TrimSuffix(c.Config.Endpoint, "/") + "/" + c.Config.Bucket
+	if key != "" {
+		target += "/" + key
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	c.Sign(req, payloadHash)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		var s3err s3Error
+		if xml.Unmarshal(data, &s3err) == nil && s3err.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s", method, key, s3err.Code, s3err.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// PutObject uploads a small object in a single request
+// returns:			error
+func (c *S3Client) PutObject(key string, data []byte) error {
+	sum := md5.Sum(data)
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+	resp, err := c.do(http.MethodPut, key, nil, data, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetObject downloads an object, which the caller must close
+// returns:			io.ReadCloser, error
+func (c *S3Client) GetObject(key string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObjects lists the keys of the objects under prefix
+// returns:			keys, error
+func (c *S3Client) ListObjects(prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// completedPart is a part of a multipart upload, as listed to complete it
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// completeMultipartUpload is the request completing a multipart upload
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// UploadMultipart streams r to an object with a multipart upload. Every part is checked by the endpoint against
+// its Content-MD5, its ETag not being the MD5 of the part with server side encryption. The upload is aborted on error
+// returns:			hex encoded SHA-256 of the object, size, error
+func (c *S3Client) UploadMultipart(key string, r io.Reader) (string, int64, error) {
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return "", 0, fmt.Errorf("couldn't start the upload of %s: %v", key, err)
+	}
+
+	sum, size, parts, err := c.uploadParts(key, initiated.UploadID, r)
+	if err == nil {
+		err = c.completeUpload(key, initiated.UploadID, parts)
+	}
+	if err != nil {
+		if resp, abortErr := c.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return "", 0, err
+	}
+	return sum, size, nil
+}
+
+// uploadParts reads r part by part and uploads each part
+// returns:			hex encoded SHA-256 of the content, size, parts, error
+func (c *S3Client) uploadParts(key string, uploadID string, r io.Reader) (string, int64, []completedPart, error) {
+	hash := sha256.New()
+	buf := make([]byte, c.Config.PartSize)
+	parts := []completedPart{}
+	var size int64
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF && number > 1 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", 0, nil, err
+		}
+		part := buf[:n]
+		hash.Write(part)
+		size += int64(n)
+
+		sum := md5.Sum(part)
+		header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, doErr := c.do(http.MethodPut, key, query, part, header)
+		if doErr != nil {
+			return "", 0, nil, doErr
+		}
+		resp.Body.Close()
+		etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+		if etag == "" {
+			return "", 0, nil, fmt.Errorf("part %d of %s: no ETag returned", number, key)
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: `"` + etag + `"`})
+
+		if err != nil {
+			break
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, parts, nil
+}
+
+// completeUpload completes a multipart upload, whose failure may be reported in a successful response
+// returns:			error
+func (c *S3Client) completeUpload(key string, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var s3err s3Error
+	if xml.Unmarshal(data, &s3err) == nil && s3err.Code != "" {
+		return fmt.Errorf("couldn't complete the upload of %s: %s: %s", key, s3err.Code, s3err.Message)
+	}
+	return nil
+}
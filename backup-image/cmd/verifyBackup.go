@@ -0,0 +1,405 @@
+/*
+ * Copyright 2021 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Checks run by verifyBackup
+const (
+	CheckManifest         string = "manifest"
+	CheckChecksums        string = "checksums"
+	CheckEtcdSnapshot     string = "etcd-snapshot"
+	CheckStaticResources  string = "static-pod-resources"
+	CheckExtras           string = "extras"
+	CheckArchives         string = "archives"
+	CheckEncryption       string = "encryption"
+	CheckEtcExclusionList string = "etc-exclude-list"
+)
+
+// maxReportedFiles bounds the number of files listed in the message of a failed check
+const maxReportedFiles int = 10
+
+// CheckResult is the outcome of one check of the backup
+type CheckResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// VerificationReport is the outcome of the verification of a backup
+type VerificationReport struct {
+	BackupPath string        `json:"backupPath"`
+	Passed     bool          `json:"passed"`
+	Checks     []CheckResult `json:"checks"`
+}
+
+// newCheck builds the result of a check from its error
+// returns:			CheckResult
+func newCheck(name string, message string, err error) CheckResult {
+	if err != nil {
+		return CheckResult{Name: name, Passed: false, Message: err.Error()}
+	}
+	return CheckResult{Name: name, Passed: true, Message: message}
+}
+
+// VerifyChecksums checks every file of the manifest against its size and checksum, and looks for files
+// of the backup components missing from the manifest
+// returns:			error listing the files which don't match
+func VerifyChecksums(BackupPath string, manifest Manifest) error {
+	entries, _, err := ManifestEntries(BackupPath)
+	if err != nil {
+		return err
+	}
+	found := map[string]ManifestEntry{}
+	for _, entry := range entries {
+		found[entry.Path] = entry
+	}
+
+	var mismatches []string
+	for _, expected := range manifest.Files {
+		actual, ok := found[expected.Path]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing", expected.Path))
+		case actual.Link != expected.Link:
+			mismatches = append(mismatches, fmt.Sprintf("%s: link to %q, expecting %q", expected.Path, actual.Link, expected.Link))
+		case actual.Size != expected.Size:
+			mismatches = append(mismatches, fmt.Sprintf("%s: size %d, expecting %d", expected.Path, actual.Size, expected.Size))
+		case actual.SHA256 != expected.SHA256:
+			mismatches = append(mismatches, fmt.Sprintf("%s: checksum mismatch", expected.Path))
+		}
+		delete(found, expected.Path)
+	}
+	for path := range found {
+		mismatches = append(mismatches, fmt.Sprintf("%s: not in the manifest", path))
+	}
+
+	if len(mismatches) > 0 {
+		total := len(mismatches)
+		if total > maxReportedFiles {
+			mismatches = append(mismatches[:maxReportedFiles], "...")
+		}
+		return fmt.Errorf("%d file(s) don't match the manifest: %s", total, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// VerifyTarball reads a gzip compressed tarball through to its end
+// returns:			number of entries, error
+func VerifyTarball(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", path, err)
+	}
+	defer gz.Close()
+
+	count := 0
+	reader := tar.NewReader(gz)
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("%s: %s", path, err)
+		}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return count, fmt.Errorf("%s: %s", path, err)
+		}
+		count++
+	}
+}
+
+// VerifyEtcdSnapshot checks the etcd snapshot taken by cluster-backup.sh is present and readable
+// returns:			snapshot path, error
+func VerifyEtcdSnapshot(BackupPath string) (string, error) {
+	snapshot, err := singleMatch(BackupPath, "snapshot_*.db")
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(snapshot)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	size, err := io.Copy(io.Discard, file)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", snapshot, err)
+	}
+	if size == 0 {
+		return "", fmt.Errorf("%s is empty", snapshot)
+	}
+	return snapshot, nil
+}
+
+// VerifyStaticResources checks the static pod resources tarball taken by cluster-backup.sh is a readable tarball
+// returns:			tarball path, error
+func VerifyStaticResources(BackupPath string) (string, error) {
+	tarball, err := singleMatch(BackupPath, "static_kuberesources_*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	count, err := VerifyTarball(tarball)
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", fmt.Errorf("%s is empty", tarball)
+	}
+	return tarball, nil
+}
+
+// singleMatch finds the latest file of the cluster backup matching the pattern
+// returns:			path, error
+func singleMatch(BackupPath string, pattern string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(BackupPath, "cluster", pattern))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no cluster/%s found", pattern)
+	}
+	// names end with the time of the backup, the last one is the latest
+	return matches[len(matches)-1], nil
+}
+
+// VerifyExtras checks extras.tgz is a readable tarball, when the backup has one
+// returns:			message, error
+func VerifyExtras(BackupPath string, manifest Manifest) (string, error) {
+	extras := filepath.Join(BackupPath, "extras.tgz")
+	if _, err := os.Stat(extras); os.IsNotExist(err) {
+		if _, listed := manifest.Components["extras.tgz"]; listed {
+			return "", fmt.Errorf("extras.tgz is listed in the manifest but missing")
+		}
+		return "no extra files in this backup", nil
+	}
+	count, err := VerifyTarball(extras)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("extras.tgz holds %d entries", count), nil
+}
+
+// VerifyArchives checks the backed up directories stored as archives can be read through
+// returns:			message, error
+func VerifyArchives(BackupPath string) (string, error) {
+	archives := []string{}
+	for _, component := range BackupComponents {
+		path, format := ComponentPath(BackupPath, component)
+		switch format {
+		case FormatGzip:
+			if _, err := VerifyTarball(path); err != nil {
+				return "", err
+			}
+		case FormatZstd:
+			if out, err := exec.Command("zstd", "-tq", path).CombinedOutput(); err != nil {
+				return "", fmt.Errorf("%s: %s %s", path, err, strings.TrimSpace(string(out)))
+			}
+		default:
+			continue
+		}
+		archives = append(archives, filepath.Base(path))
+	}
+	if len(archives) == 0 {
+		return "no archive in this backup", nil
+	}
+	return fmt.Sprintf("%s readable", strings.Join(archives, ", ")), nil
+}
+
+// VerifyEtcExclusionList checks etc.exclude.list holds paths relative to /etc, including the entries the recovery
+// relies on
+// returns:			message, error
+func VerifyEtcExclusionList(BackupPath string) (string, error) {
+	file, err := os.Open(filepath.Join(BackupPath, "etc.exclude.list"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	required := map[string]bool{".updated": false, "kubernetes/manifests": false}
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if filepath.IsAbs(line) || strings.HasPrefix(filepath.Clean(line), "..") {
+			return "", fmt.Errorf("etc.exclude.list: %q is not relative to /etc", line)
+		}
+		if _, ok := required[line]; ok {
+			required[line] = true
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	for entry, found := range required {
+		if !found {
+			return "", fmt.Errorf("etc.exclude.list doesn't exclude %s", entry)
+		}
+	}
+	return fmt.Sprintf("%d entries", count), nil
+}
+
+// VerifyBackup runs all the checks against the backup held in BackupPath
+// returns:			VerificationReport
+func VerifyBackup(BackupPath string) VerificationReport {
+	report := VerificationReport{BackupPath: BackupPath}
+
+	manifest, err := ReadManifest(BackupPath)
+	report.Checks = append(report.Checks, newCheck(CheckManifest, fmt.Sprintf("version %d, %d files", manifest.ManifestVersion, len(manifest.Files)), err))
+	if err == nil {
+		err = VerifyChecksums(BackupPath, manifest)
+		report.Checks = append(report.Checks, newCheck(CheckChecksums, fmt.Sprintf("%d files match the manifest", len(manifest.Files)), err))
+	} else {
+		report.Checks = append(report.Checks, newCheck(CheckChecksums, "", fmt.Errorf("no manifest to check the files against")))
+	}
+
+	// the content of an encrypted backup can't be read, its checksums cover it
+	if manifest.Encryption != nil {
+		message, err := VerifyEncryptedBackup(BackupPath)
+		report.Checks = append(report.Checks, newCheck(CheckEncryption, message, err))
+		message, err = VerifyEtcExclusionList(BackupPath)
+		report.Checks = append(report.Checks, newCheck(CheckEtcExclusionList, message, err))
+		return report.summarize()
+	}
+
+	snapshot, err := VerifyEtcdSnapshot(BackupPath)
+	report.Checks = append(report.Checks, newCheck(CheckEtcdSnapshot, snapshot, err))
+
+	tarball, err := VerifyStaticResources(BackupPath)
+	report.Checks = append(report.Checks, newCheck(CheckStaticResources, tarball, err))
+
+	message, err := VerifyExtras(BackupPath, manifest)
+	report.Checks = append(report.Checks, newCheck(CheckExtras, message, err))
+
+	message, err = VerifyArchives(BackupPath)
+	report.Checks = append(report.Checks, newCheck(CheckArchives, message, err))
+
+	message, err = VerifyEtcExclusionList(BackupPath)
+	report.Checks = append(report.Checks, newCheck(CheckEtcExclusionList, message, err))
+
+	return report.summarize()
+}
+
+// summarize sets the overall result of the report from its checks
+// returns:			VerificationReport
+func (report VerificationReport) summarize() VerificationReport {
+	report.Passed = true
+	for _, check := range report.Checks {
+		report.Passed = report.Passed && check.Passed
+	}
+	return report
+}
+
+// WriteVerificationReport renders the report as JSON or as a table
+// returns:			error
+func WriteVerificationReport(w io.Writer, report VerificationReport, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(w, 10, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAILS")
+	for _, check := range report.Checks {
+		result := "PASS"
+		if !check.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, result, check.Message)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	result := "PASSED"
+	if !report.Passed {
+		result = "FAILED"
+	}
+	_, err := fmt.Fprintf(w, "Backup %s verification %s\n", report.BackupPath, result)
+	return err
+}
+
+// verifyBackupCmd represents the verifyBackup command
+var verifyBackupCmd = &cobra.Command{
+	Use:   "verifyBackup",
+	Short: "It will verify the backup in the specified path against its manifest",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		BackupPath, _ := cmd.Flags().GetString("BackupPath")
+		output, _ := cmd.Flags().GetString("output")
+		if output != "text" && output != "json" {
+			return fmt.Errorf("unsupported output format %q, expecting text or json", output)
+		}
+
+		// validate the BackupPath and change root directory to /host
+		BackupPath, err := hostBackupPath(BackupPath)
+		if err != nil {
+			return err
+		}
+
+		// verify the backup while no backup, clean up or recovery rewrites it
+		lock, err := AcquireSharedLock(BackupPath, "verify")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+
+		cmd.SilenceUsage = true
+		report := VerifyBackup(BackupDir(BackupPath))
+		if err := WriteVerificationReport(os.Stdout, report, output); err != nil {
+			return err
+		}
+		if !report.Passed {
+			return fmt.Errorf("backup verification failed")
+		}
+		return nil
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(verifyBackupCmd)
+
+	verifyBackupCmd.Flags().StringP("BackupPath", "p", "", "Path where the backup is stored")
+	_ = verifyBackupCmd.MarkFlagRequired("BackupPath")
+	verifyBackupCmd.Flags().StringP("output", "o", "text", "Format of the report: text or json")
+}
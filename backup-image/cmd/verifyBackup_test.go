@@ -0,0 +1,131 @@
+package cmd_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+
+	"github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeTarball writes a gzip compressed tarball holding a single file
+func writeTarball(path string) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	Expect(tw.WriteHeader(&tar.Header{Name: "file", Mode: 0600, Size: 4})).Should(Succeed())
+	_, err := tw.Write([]byte("data"))
+	Expect(err).Should(BeNil())
+	Expect(tw.Close()).Should(Succeed())
+	Expect(gz.Close()).Should(Succeed())
+	Expect(os.WriteFile(path, buf.Bytes(), 0600)).Should(Succeed())
+}
+
+// checkResult finds the result of a check in the report
+func checkResult(report cmd.VerificationReport, name string) cmd.CheckResult {
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	return cmd.CheckResult{}
+}
+
+var _ = Describe("VerifyBackup", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir, _ = os.MkdirTemp("", "tmpDir")
+		Expect(os.MkdirAll(filepath.Join(dir, "cluster"), 0700)).Should(Succeed())
+		Expect(os.MkdirAll(filepath.Join(dir, "etc"), 0700)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db"), []byte("etcd"), 0600)).Should(Succeed())
+		writeTarball(filepath.Join(dir, "cluster", "static_kuberesources_2022-05-20_101010.tar.gz"))
+		writeTarball(filepath.Join(dir, "extras.tgz"))
+		Expect(os.WriteFile(filepath.Join(dir, "etc", "hosts"), []byte("hello"), 0600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "etc.exclude.list"), []byte("tmpfiles\n.updated\nkubernetes/manifests\n"), 0600)).Should(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "upgrade-recovery.sh"), []byte("#!/bin/bash\n"), 0700)).Should(Succeed())
+
+		manifest, err := cmd.NewManifest(dir)
+		Expect(err).Should(BeNil())
+		Expect(cmd.WriteManifest(dir, manifest)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("the backup is intact", func() {
+		It("passes every check", func() {
+			report := cmd.VerifyBackup(dir)
+			Expect(report.Checks).To(HaveLen(7))
+			for _, check := range report.Checks {
+				Expect(check.Passed).To(BeTrue(), check.Name+": "+check.Message)
+			}
+			Expect(report.Passed).To(BeTrue())
+		})
+	})
+
+	Context("a file was modified", func() {
+		It("fails the checksums check", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "etc", "hosts"), []byte("world"), 0600)).Should(Succeed())
+			report := cmd.VerifyBackup(dir)
+			Expect(report.Passed).To(BeFalse())
+			check := checkResult(report, cmd.CheckChecksums)
+			Expect(check.Passed).To(BeFalse())
+			Expect(check.Message).To(ContainSubstring("etc/hosts: checksum mismatch"))
+		})
+	})
+
+	Context("a file was added", func() {
+		It("fails the checksums check", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "etc", "new"), []byte("new"), 0600)).Should(Succeed())
+			check := checkResult(cmd.VerifyBackup(dir), cmd.CheckChecksums)
+			Expect(check.Passed).To(BeFalse())
+			Expect(check.Message).To(ContainSubstring("etc/new: not in the manifest"))
+		})
+	})
+
+	Context("the manifest is missing", func() {
+		It("fails the manifest and checksums checks", func() {
+			Expect(os.Remove(filepath.Join(dir, cmd.ManifestFile))).Should(Succeed())
+			report := cmd.VerifyBackup(dir)
+			Expect(checkResult(report, cmd.CheckManifest).Passed).To(BeFalse())
+			Expect(checkResult(report, cmd.CheckChecksums).Passed).To(BeFalse())
+			Expect(checkResult(report, cmd.CheckEtcdSnapshot).Passed).To(BeTrue())
+		})
+	})
+
+	Context("the extras tarball is corrupted", func() {
+		It("fails the extras check", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "extras.tgz"), []byte("garbage"), 0600)).Should(Succeed())
+			Expect(checkResult(cmd.VerifyBackup(dir), cmd.CheckExtras).Passed).To(BeFalse())
+		})
+	})
+
+	Context("the etcd snapshot is missing", func() {
+		It("fails the etcd snapshot check", func() {
+			Expect(os.Remove(filepath.Join(dir, "cluster", "snapshot_2022-05-20_101010.db"))).Should(Succeed())
+			Expect(checkResult(cmd.VerifyBackup(dir), cmd.CheckEtcdSnapshot).Passed).To(BeFalse())
+		})
+	})
+
+	Context("the exclusion list has an absolute path", func() {
+		It("fails the exclusion list check", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "etc.exclude.list"), []byte("/etc/hosts\n.updated\nkubernetes/manifests\n"), 0600)).Should(Succeed())
+			Expect(checkResult(cmd.VerifyBackup(dir), cmd.CheckEtcExclusionList).Passed).To(BeFalse())
+		})
+	})
+
+	Describe("WriteVerificationReport", func() {
+		It("reports the overall result", func() {
+			var buf bytes.Buffer
+			Expect(cmd.WriteVerificationReport(&buf, cmd.VerifyBackup(dir), "text")).Should(Succeed())
+			Expect(buf.String()).To(ContainSubstring("verification PASSED"))
+		})
+	})
+})
@@ -39,4 +39,6 @@ echo "GIT_SHA: ${GIT_SHA}"
 echo "GIT_TREE_STATE: ${GIT_TREE_STATE}"
 echo "RELEASE_STATUS: ${RELEASE_STATUS}"
 
-CGO_ENABLED=0 GOOS=${GOOS} GOARCH=${GOARCH} ${GO} build ${GOFLAGS}  -o bin/${cmd} pkg/main.go
+GLDFLAGS+=" -X github.com/redhat-ztp/openshift-sno-upgrade-recovery/backup-image/cmd.Version=${VERSION:-${GIT_SHA:-unreleased}}"
+
+CGO_ENABLED=0 GOOS=${GOOS} GOARCH=${GOARCH} ${GO} build ${GOFLAGS} -ldflags "${GLDFLAGS}" -o bin/${cmd} pkg/main.go
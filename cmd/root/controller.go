@@ -0,0 +1,678 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Controller reconciles the backuprequests of the hub. It keeps no state besides the backups in flight: the
+// status of the backuprequests and the managedclusterviews on the hub are enough to resume after a restart
+type Controller struct {
+	client    metaclient1.Client
+	namespace string
+
+	mu   sync.Mutex
+	runs map[types.UID]*requestRun
+}
+
+// requestRun runs the backups of the clusters of one backuprequest
+type requestRun struct {
+	client         metaclient1.Client
+	templates      metaclient1.JobTemplates
+	maxConcurrency int
+
+	// ctx is only cancelled when the backuprequest is deleted, stopping the controller leaves the jobs running
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	clusters []metaclient1.ClusterBackupStatus
+	running  map[string]bool
+
+	// windows are the maintenance windows of the clusters which have some, invalid the clusters whose windows
+	// can't be parsed
+	windows  map[string][]metaclient1.MaintenanceWindow
+	invalid  map[string]error
+	deadline *time.Time
+}
+
+// NewController creates a controller reconciling the backuprequests of a namespace, or of all the namespaces
+// returns:			*Controller
+func NewController(client metaclient1.Client, namespace string) *Controller {
+	return &Controller{client: client, namespace: namespace, runs: map[types.UID]*requestRun{}}
+}
+
+// Run reconciles the backuprequests every interval until the context is cancelled
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	log.Infof("Reconciling backuprequests every %s", interval)
+	wait.UntilWithContext(ctx, c.reconcileAll, interval)
+	log.Info("Controller stopped, the backups in flight are resumed on restart")
+}
+
+// reconcileAll reconciles every backuprequest and forgets the ones which are gone
+func (c *Controller) reconcileAll(ctx context.Context) {
+	requests, err := c.client.ListBackupRequests(ctx, c.namespace)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	// the runs of the scheduled backuprequests
+	runs := map[types.UID][]metaclient1.BackupRequest{}
+	for _, request := range requests {
+		if owner := v1.GetControllerOf(&request); owner != nil && owner.Kind == "BackupRequest" {
+			runs[owner.UID] = append(runs[owner.UID], request)
+		}
+	}
+
+	seen := map[types.UID]bool{}
+	for i := range requests {
+		request := &requests[i]
+		seen[request.UID] = true
+		var err error
+		if request.Spec.Schedule != "" {
+			err = c.reconcileSchedule(ctx, request, runs[request.UID])
+		} else {
+			err = c.reconcile(ctx, request)
+		}
+		if err != nil {
+			log.Errorf("Couldn't reconcile backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for uid, run := range c.runs {
+		if !seen[uid] {
+			// removed without the finalizer, the jobs in flight are interrupted
+			run.cancel()
+			delete(c.runs, uid)
+		}
+	}
+}
+
+// reconcile starts, resumes, follows or finalizes the backups of a backuprequest
+// returns:			error
+func (c *Controller) reconcile(ctx context.Context, request *metaclient1.BackupRequest) error {
+	if request.DeletionTimestamp != nil {
+		return c.finalize(ctx, request)
+	}
+	if !request.HasFinalizer() {
+		request.Finalizers = append(request.Finalizers, metaclient1.BackupRequestFinalizer)
+		if err := c.client.UpdateBackupRequest(ctx, request); err != nil {
+			return err
+		}
+	}
+
+	status := &request.Status
+	before, _ := json.Marshal(request.Status)
+	ignoreSpecChange(request)
+	if requestDone(status.Phase) {
+		c.forget(request.UID)
+		return c.updateStatus(ctx, request, before)
+	}
+
+	if len(status.Clusters) == 0 {
+		if err := request.Spec.Validate(); err != nil {
+			return c.failRequest(ctx, request, "InvalidSpec", err)
+		}
+		clusters, err := c.resolveClusters(ctx, request.Spec)
+		if err != nil {
+			return err
+		}
+		if len(clusters) == 0 {
+			return c.failRequest(ctx, request, "NoClusters", fmt.Errorf("no managedcluster matches selector %q and cluster set %q", request.Spec.Selector, request.Spec.ClusterSet))
+		}
+		c.start(request, clusters)
+	}
+
+	run, err := c.run(ctx, request)
+	if err != nil {
+		return err
+	}
+	run.schedule()
+
+	run.sync(status)
+	if requestDone(status.Phase) {
+		log.Infof("Backuprequest %s/%s %s", request.Namespace, request.Name, status.Phase)
+	}
+	return c.updateStatus(ctx, request, before)
+}
+
+// updateStatus updates the status of a backuprequest if it changed since before
+// returns:			error
+func (c *Controller) updateStatus(ctx context.Context, request *metaclient1.BackupRequest, before []byte) error {
+	after, _ := json.Marshal(request.Status)
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	return c.client.UpdateBackupRequestStatus(ctx, request)
+}
+
+// ignoreSpecChange surfaces the edits of the spec of a started backuprequest in a condition: its clusters and
+// its job configuration were read when it started and the edits are never applied
+func ignoreSpecChange(request *metaclient1.BackupRequest) {
+	status := &request.Status
+	if status.Phase == "" || request.Generation == status.ObservedGeneration {
+		return
+	}
+	meta.SetStatusCondition(&status.Conditions, v1.Condition{
+		Type:    metaclient1.ConditionSpecChangeIgnored,
+		Status:  v1.ConditionTrue,
+		Reason:  "AlreadyStarted",
+		Message: fmt.Sprintf("spec changed at generation %d after the backuprequest started at generation %d, recreate the backuprequest to apply it", request.Generation, status.ObservedGeneration),
+	})
+}
+
+// resolveClusters lists the clusters selected by the spec of a backuprequest
+// returns:			cluster names, error
+func (c *Controller) resolveClusters(ctx context.Context, spec metaclient1.BackupRequestSpec) ([]string, error) {
+	if len(spec.Clusters) > 0 {
+		return spec.Clusters, nil
+	}
+	return c.client.ListSpokeClusters(ctx, spec.Selector, spec.ClusterSet)
+}
+
+// start records the clusters of a new backuprequest in its status, they aren't resolved again afterwards
+func (c *Controller) start(request *metaclient1.BackupRequest, clusters []string) {
+	log.Infof("Starting backuprequest %s/%s on %d spoke cluster(s)", request.Namespace, request.Name, len(clusters))
+
+	now := v1.Now()
+	request.Status = metaclient1.BackupRequestStatus{
+		ObservedGeneration: request.Generation,
+		Phase:              metaclient1.RequestPending,
+		StartTime:          &now,
+	}
+	for _, name := range clusters {
+		request.Status.Clusters = append(request.Status.Clusters, metaclient1.ClusterBackupStatus{Name: name, Phase: metaclient1.ClusterPending})
+	}
+}
+
+// failRequest marks a backuprequest which can't start as failed
+// returns:			error
+func (c *Controller) failRequest(ctx context.Context, request *metaclient1.BackupRequest, reason string, cause error) error {
+	log.Errorf("Backuprequest %s/%s failed to start: %s", request.Namespace, request.Name, cause)
+	now := v1.Now()
+	status := &request.Status
+	status.ObservedGeneration = request.Generation
+	status.Phase = metaclient1.RequestFailed
+	status.CompletionTime = &now
+	meta.SetStatusCondition(&status.Conditions, v1.Condition{
+		Type:    metaclient1.ConditionCompleted,
+		Status:  v1.ConditionTrue,
+		Reason:  reason,
+		Message: cause.Error(),
+	})
+	return c.client.UpdateBackupRequestStatus(ctx, request)
+}
+
+// run returns the run of a backuprequest, creating it from the status after a start or a restart
+// returns:			*requestRun, error
+func (c *Controller) run(ctx context.Context, request *metaclient1.BackupRequest) (*requestRun, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if run, ok := c.runs[request.UID]; ok {
+		return run, nil
+	}
+	client, err := c.requestClient(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &requestRun{
+		client:         client,
+		templates:      client.BackupJobTemplates(),
+		maxConcurrency: request.Spec.MaxConcurrency,
+		running:        map[string]bool{},
+	}
+	if !request.Spec.IgnoreMaintenanceWindows {
+		location, err := request.Spec.Location()
+		if err != nil {
+			return nil, err
+		}
+		if run.windows, run.invalid, err = client.MaintenanceWindows(ctx, client.Spoke, location); err != nil {
+			return nil, err
+		}
+	}
+	if request.Spec.Deadline != nil {
+		run.deadline = &request.Spec.Deadline.Time
+	}
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+	for _, cluster := range request.Status.Clusters {
+		run.clusters = append(run.clusters, *cluster.DeepCopy())
+	}
+	c.runs[request.UID] = run
+	return run, nil
+}
+
+// requestClient configures a client running the backup job described by the spec of a backuprequest
+// returns:			metaclient1.Client, error
+func (c *Controller) requestClient(ctx context.Context, request *metaclient1.BackupRequest) (metaclient1.Client, error) {
+	spec := request.Spec
+	client := c.client
+	client.Spoke = nil
+	for _, cluster := range request.Status.Clusters {
+		client.Spoke = append(client.Spoke, cluster.Name)
+	}
+
+	var err error
+	client.BackupPath = metaclient1.DefaultBackupPath
+	if spec.BackupPath != "" {
+		if client.BackupPath, err = validateBackupPath(spec.BackupPath); err != nil {
+			return client, err
+		}
+	}
+	if spec.Image != "" {
+		client.Image = spec.Image
+	}
+	if spec.Transport != "" {
+		client.Transport = spec.Transport
+	}
+	client.Poll = spec.PollOptions()
+	if spec.PullSecret != "" {
+		if client.PullSecretData, err = client.FetchPullSecret(ctx, spec.PullSecret); err != nil {
+			return client, err
+		}
+	}
+	return client, nil
+}
+
+// forget drops the run of a backuprequest which is over
+func (c *Controller) forget(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.runs, uid)
+}
+
+// finalize interrupts the backups of a deleted backuprequest, tears down the jobs left by a previous controller
+// and removes the finalizer once nothing is left on the hub
+// returns:			error
+func (c *Controller) finalize(ctx context.Context, request *metaclient1.BackupRequest) error {
+	if !request.HasFinalizer() {
+		return nil
+	}
+
+	c.mu.Lock()
+	run, ok := c.runs[request.UID]
+	c.mu.Unlock()
+	if ok {
+		run.cancel()
+		if run.active() > 0 {
+			// the interrupted jobs are being torn down
+			return nil
+		}
+	} else {
+		if err := c.teardownLeftovers(ctx, request); err != nil {
+			return err
+		}
+	}
+	c.forget(request.UID)
+
+	finalizers := []string{}
+	for _, finalizer := range request.Finalizers {
+		if finalizer != metaclient1.BackupRequestFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	request.Finalizers = finalizers
+	log.Infof("Backuprequest %s/%s deleted", request.Namespace, request.Name)
+	return c.client.UpdateBackupRequest(ctx, request)
+}
+
+// teardownLeftovers tears down the jobs a previous controller left in flight for a deleted backuprequest
+// returns:			error
+func (c *Controller) teardownLeftovers(ctx context.Context, request *metaclient1.BackupRequest) error {
+	var client metaclient1.Client
+	var err error
+	for _, cluster := range request.Status.Clusters {
+		if cluster.Phase != metaclient1.ClusterLaunching && cluster.Phase != metaclient1.ClusterRunning {
+			continue
+		}
+		if client.KubernetesClient == nil {
+			if client, err = c.requestClient(ctx, request); err != nil {
+				return err
+			}
+		}
+		log.Warnf("Backuprequest %s/%s deleted, tearing down the job of cluster %s", request.Namespace, request.Name, cluster.Name)
+		if err := teardownSpokeJob(client.JobTransport(), cluster.Name, client.BackupJobTemplates()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schedule starts the backup of the pending clusters which are within their maintenance window, and resumes the
+// ones a previous controller left in flight, within the concurrency limit. The clusters still waiting for their
+// window past the deadline are missed
+func (r *requestRun) schedule() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return
+	}
+	now := time.Now()
+	for i := range r.clusters {
+		cluster := &r.clusters[i]
+		if clusterDone(cluster.Phase) || r.running[cluster.Name] {
+			continue
+		}
+		if cluster.Phase == metaclient1.ClusterPending || cluster.Phase == metaclient1.ClusterDeferred {
+			if !r.admit(cluster, now) {
+				continue
+			}
+		}
+		if r.maxConcurrency > 0 && len(r.running) >= r.maxConcurrency {
+			return
+		}
+		r.running[cluster.Name] = true
+		go r.backup(cluster.Name, cluster.Phase)
+	}
+}
+
+// admit checks whether the backup of a waiting cluster may start now, deferring it until its maintenance window
+// opens or recording why it never will
+// returns:			bool
+func (r *requestRun) admit(cluster *metaclient1.ClusterBackupStatus, now time.Time) bool {
+	if err, ok := r.invalid[cluster.Name]; ok {
+		r.close(cluster, metaclient1.ClusterFailed, "InvalidMaintenanceWindow", err.Error())
+		return false
+	}
+	open, next := metaclient1.InMaintenanceWindow(r.windows[cluster.Name], now)
+	if r.deadline != nil && !now.Before(*r.deadline) {
+		r.close(cluster, metaclient1.ClusterMissed, "Missed", fmt.Sprintf("maintenance window didn't open before the next scheduled run at %s", r.deadline.Format(time.RFC3339)))
+		return false
+	}
+	if !open {
+		if cluster.Phase != metaclient1.ClusterDeferred {
+			log.Infof("Backup of cluster %s deferred until its maintenance window opens at %s", cluster.Name, next.Format(time.RFC3339))
+		}
+		cluster.Phase = metaclient1.ClusterDeferred
+		cluster.Message = fmt.Sprintf("outside its maintenance window, deferred until %s", next.Format(time.RFC3339))
+		return false
+	}
+	cluster.Message = ""
+	return true
+}
+
+// close records the outcome of a cluster whose backup never started
+func (r *requestRun) close(cluster *metaclient1.ClusterBackupStatus, phase string, reason string, message string) {
+	log.Warnf("Backup of cluster %s %s: %s", cluster.Name, phase, message)
+	now := v1.Now()
+	cluster.Phase = phase
+	cluster.Message = message
+	cluster.CompletionTime = &now
+	meta.SetStatusCondition(&cluster.Conditions, v1.Condition{
+		Type:    metaclient1.ConditionJobSucceeded,
+		Status:  v1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// active counts the backups in flight
+// returns:			int
+func (r *requestRun) active() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.running)
+}
+
+// backup runs the backup job of a cluster, resuming from the phase recorded in the status when its
+// managedclusterview or manifestwork is still on the hub. A job left half created is deleted and started over
+func (r *requestRun) backup(name string, phase string) {
+	ctx := r.ctx
+	client := r.client
+	transport := client.JobTransport()
+	templates := r.templates
+	record := &Status{ClusterName: name, StartTime: time.Now()}
+
+	resumed := false
+	if phase == metaclient1.ClusterLaunching || phase == metaclient1.ClusterRunning {
+		if watched, _ := transport.JobWatched(ctx, name, templates); watched {
+			log.Infof("Resuming the backup of cluster %s", name)
+			resumed = true
+		} else {
+			// as createSpokeJob does when the creation fails
+			log.Warnf("Backup of cluster %s left half created, starting over", name)
+			if err := transport.ClearJob(ctx, name, templates); err != nil {
+				r.finish(record, metaclient1.Failed, err)
+				return
+			}
+		}
+	}
+
+	if !resumed {
+		r.update(name, metaclient1.ClusterLaunching, PhaseCheckCluster, nil)
+		record.Phase = PhaseCheckCluster
+		if !client.SpokeClusterExists(ctx, name) {
+			r.finish(record, metaclient1.NExist, fmt.Errorf("cluster %s does not exist or is not available", name))
+			return
+		}
+		if status, err := createSpokeJob(ctx, transport, record, templates); err != nil {
+			r.finish(record, status, err)
+			return
+		}
+	}
+
+	if !resumed || phase == metaclient1.ClusterLaunching {
+		record.Phase = PhaseLaunch
+		r.update(name, metaclient1.ClusterLaunching, record.Phase, nil)
+		if err := transport.JobStatus(ctx, name, metaclient1.Launch, templates); err != nil {
+			r.fail(record, templates, fmt.Errorf("couldn't verify the initiation of the job, err: %s", err))
+			return
+		}
+	}
+
+	record.Phase = PhaseCompletion
+	r.update(name, metaclient1.ClusterRunning, record.Phase, &v1.Condition{
+		Type:   metaclient1.ConditionJobLaunched,
+		Status: v1.ConditionTrue,
+		Reason: "Launched",
+	})
+	if err := transport.JobStatus(ctx, name, metaclient1.Complete, templates); err != nil {
+		r.fail(record, templates, fmt.Errorf("couldn't verify if the job has finished, err: %s", err))
+		return
+	}
+
+	status, err := finishSpokeJob(transport, record, templates)
+	r.finish(record, status, err)
+}
+
+// fail tears down the job of a cluster which failed or was interrupted
+func (r *requestRun) fail(record *Status, templates metaclient1.JobTemplates, cause error) {
+	if r.ctx.Err() != nil {
+		status, err := interruptSpokeJob(r.client.JobTransport(), record, templates, cause)
+		r.finish(record, status, err)
+		return
+	}
+	status, err := failSpokeJob(r.client.JobTransport(), record, templates, cause)
+	r.finish(record, status, err)
+}
+
+// update records the progress of the backup of a cluster, with a new condition if any
+func (r *requestRun) update(name string, phase string, hubPhase string, condition *v1.Condition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.clusters {
+		cluster := &r.clusters[i]
+		if cluster.Name != name {
+			continue
+		}
+		if cluster.StartTime == nil {
+			now := v1.Now()
+			cluster.StartTime = &now
+		}
+		cluster.Phase = phase
+		cluster.HubPhase = hubPhase
+		if condition != nil {
+			meta.SetStatusCondition(&cluster.Conditions, *condition)
+		}
+	}
+}
+
+// finish records the outcome of the backup of a cluster, given its job status
+func (r *requestRun) finish(record *Status, status string, err error) {
+	phase := metaclient1.ClusterSucceeded
+	condition := v1.Condition{Type: metaclient1.ConditionJobSucceeded, Status: v1.ConditionTrue, Reason: "Succeeded"}
+	if err != nil || status != metaclient1.Done {
+		phase = metaclient1.ClusterFailed
+		condition = v1.Condition{Type: metaclient1.ConditionJobSucceeded, Status: v1.ConditionFalse, Reason: "Failed"}
+		if status == metaclient1.Interrupted {
+			phase = metaclient1.ClusterInterrupted
+			condition.Reason = "Interrupted"
+		}
+		if err != nil {
+			condition.Message = err.Error()
+		}
+		log.Errorf("Backup of cluster %s %s: %s", record.ClusterName, phase, condition.Message)
+	} else {
+		log.Infof("Backup of cluster %s succeeded", record.ClusterName)
+	}
+
+	r.update(record.ClusterName, phase, record.Phase, &condition)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.clusters {
+		if r.clusters[i].Name == record.ClusterName {
+			now := v1.Now()
+			r.clusters[i].CompletionTime = &now
+			r.clusters[i].Message = condition.Message
+		}
+	}
+	delete(r.running, record.ClusterName)
+}
+
+// sync copies the progress of the clusters into the status of the backuprequest and sums it up
+func (r *requestRun) sync(status *metaclient1.BackupRequestStatus) {
+	r.mu.Lock()
+	status.Clusters = nil
+	for _, cluster := range r.clusters {
+		status.Clusters = append(status.Clusters, *cluster.DeepCopy())
+	}
+	r.mu.Unlock()
+
+	done, succeeded, started := 0, 0, 0
+	for _, cluster := range status.Clusters {
+		if cluster.Phase != metaclient1.ClusterPending && cluster.Phase != metaclient1.ClusterDeferred {
+			started++
+		}
+		if clusterDone(cluster.Phase) {
+			done++
+		}
+		if cluster.Phase == metaclient1.ClusterSucceeded {
+			succeeded++
+		}
+	}
+	total := len(status.Clusters)
+
+	condition := v1.Condition{
+		Type:    metaclient1.ConditionCompleted,
+		Status:  v1.ConditionFalse,
+		Reason:  "InProgress",
+		Message: fmt.Sprintf("%d of %d clusters done", done, total),
+	}
+	switch {
+	case done < total:
+		if started > 0 {
+			status.Phase = metaclient1.RequestRunning
+		}
+	case succeeded == total:
+		status.Phase = metaclient1.RequestCompleted
+		condition.Status, condition.Reason = v1.ConditionTrue, "Succeeded"
+		condition.Message = fmt.Sprintf("backup succeeded on %d clusters", total)
+	default:
+		status.Phase = metaclient1.RequestFailed
+		condition.Status, condition.Reason = v1.ConditionTrue, "Failed"
+		condition.Message = fmt.Sprintf("backup didn't succeed on %d of %d clusters", total-succeeded, total)
+	}
+	if done == total && status.CompletionTime == nil {
+		now := v1.Now()
+		status.CompletionTime = &now
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// clusterDone checks whether the backup of a cluster is over
+// returns:			bool
+func clusterDone(phase string) bool {
+	return phase == metaclient1.ClusterSucceeded || phase == metaclient1.ClusterFailed || phase == metaclient1.ClusterInterrupted || phase == metaclient1.ClusterMissed
+}
+
+// requestDone checks whether a backuprequest is over
+// returns:			bool
+func requestDone(phase string) bool {
+	return phase == metaclient1.RequestCompleted || phase == metaclient1.RequestFailed
+}
+
+var controllerCmd = &cobra.Command{
+	Use:     "controller",
+	Short:   "It will run in the hub and back up the spoke clusters listed by the BackupRequest resources",
+	PreRunE: bindFlags,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		interval := viper.GetDuration("resync-interval")
+		if interval <= 0 {
+			return fmt.Errorf("--resync-interval must be positive")
+		}
+
+		// in-cluster configuration when running as a deployment
+		client, err := metaclient1.New(nil, "", viper.GetString("KubeconfigPath"))
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		log.SetFormatter(&log.JSONFormatter{})
+		NewController(client, viper.GetString("namespace")).Run(ctx, interval)
+		return nil
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(controllerCmd)
+
+	controllerCmd.Flags().StringP("KubeconfigPath", "k", "", "Path to kubeconfig file (default is the in-cluster configuration)")
+	controllerCmd.Flags().StringP("namespace", "n", "", "Namespace whose backuprequests are reconciled (default is all the namespaces)")
+	controllerCmd.Flags().Duration("resync-interval", 15*time.Second, "Interval between two reconciliations of the backuprequests")
+}
@@ -0,0 +1,425 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// backupWork is the name of the manifestwork of the backup job
+const backupWork = "backup-job"
+
+// availableCluster returns a managedcluster of the hub which is available
+func availableCluster(name string) *unstructured.Unstructured {
+	obj := managedCluster(name, nil)
+	obj.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "ManagedClusterConditionAvailable", "status": "True"},
+		},
+	}
+	return obj
+}
+
+// testSpec returns the spec of a backuprequest of clusters, carried by manifestworks polled every few milliseconds
+func testSpec(clusters ...string) metaclient1.BackupRequestSpec {
+	return metaclient1.BackupRequestSpec{
+		Clusters:          clusters,
+		Transport:         metaclient1.TransportManifestWork,
+		LaunchTimeout:     &v1.Duration{Duration: 10 * time.Second},
+		CompletionTimeout: &v1.Duration{Duration: 10 * time.Second},
+		PollInterval:      &v1.Duration{Duration: 5 * time.Millisecond},
+		MaxPollInterval:   &v1.Duration{Duration: 20 * time.Millisecond},
+	}
+}
+
+// newRequest returns a backuprequest of the hub
+func newRequest(t *testing.T, request metaclient1.BackupRequest) *unstructured.Unstructured {
+	t.Helper()
+	request.APIVersion = metaclient1.BackupRequestGVR.GroupVersion().String()
+	request.Kind = "BackupRequest"
+	if request.Namespace == "" {
+		request.Namespace = "default"
+	}
+	request.UID = types.UID(request.Name + "-uid")
+	if request.Generation == 0 {
+		request.Generation = 1
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+// backupWorkWithStatus returns the manifestwork of the backup job of a cluster, applied on the spoke and with the
+// status feedback of the job
+func backupWorkWithStatus(cluster string, feedback map[string]int64) *unstructured.Unstructured {
+	values := []interface{}{}
+	for name, value := range feedback {
+		values = append(values, map[string]interface{}{
+			"name":       name,
+			"fieldValue": map[string]interface{}{"type": "Integer", "integer": value},
+		})
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": metaclient1.ManifestWorkGVR.GroupVersion().String(),
+		"kind":       "ManifestWork",
+		"status": map[string]interface{}{
+			"resourceStatus": map[string]interface{}{
+				"manifests": []interface{}{
+					map[string]interface{}{
+						"resourceMeta": map[string]interface{}{"resource": "jobs"},
+						"conditions": []interface{}{
+							map[string]interface{}{"type": "Applied", "status": "True"},
+						},
+						"statusFeedback": map[string]interface{}{"values": values},
+					},
+				},
+			},
+		},
+	}}
+	obj.SetName(backupWork)
+	obj.SetNamespace(cluster)
+	return obj
+}
+
+// setJobStatus reports the status of the backup job of a cluster in its manifestwork, as the work agent would
+func setJobStatus(t *testing.T, client metaclient1.Client, cluster string, feedback map[string]int64) {
+	t.Helper()
+	setWorkStatus(t, client, cluster, backupWork, feedback)
+}
+
+// setWorkStatus reports the status of the job of a cluster in its manifestwork, as the work agent would
+func setWorkStatus(t *testing.T, client metaclient1.Client, cluster string, name string, feedback map[string]int64) {
+	t.Helper()
+	ctx := context.Background()
+	work, err := client.KubernetesClient.Resource(metaclient1.ManifestWorkGVR).Namespace(cluster).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Object["status"] = backupWorkWithStatus(cluster, feedback).Object["status"]
+	if _, err := client.KubernetesClient.Resource(metaclient1.ManifestWorkGVR).Namespace(cluster).Update(ctx, work, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// works lists the clusters having a manifestwork of the backup job
+func works(t *testing.T, client metaclient1.Client) []string {
+	t.Helper()
+	list, err := client.KubernetesClient.Resource(metaclient1.ManifestWorkGVR).List(context.Background(), v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := []string{}
+	for _, item := range list.Items {
+		clusters = append(clusters, item.GetNamespace())
+	}
+	return clusters
+}
+
+// getRequest reads a backuprequest from the hub
+func getRequest(t *testing.T, client metaclient1.Client, name string) metaclient1.BackupRequest {
+	t.Helper()
+	requests, err := client.ListBackupRequests(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range requests {
+		if request.Name == name {
+			return request
+		}
+	}
+	t.Fatalf("backuprequest %s not found", name)
+	return metaclient1.BackupRequest{}
+}
+
+// clusterPhase returns the phase of a cluster in the status of a backuprequest
+func clusterPhase(request metaclient1.BackupRequest, name string) string {
+	for _, cluster := range request.Status.Clusters {
+		if cluster.Name == name {
+			return cluster.Phase
+		}
+	}
+	return ""
+}
+
+// reconcileUntil reconciles the backuprequests until done holds, failing the test after a while
+func reconcileUntil(t *testing.T, c *Controller, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		c.reconcileAll(context.Background())
+		if done() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControllerPhases(t *testing.T) {
+	tests := []struct {
+		name     string
+		feedback map[string]int64
+		cluster  string
+		request  string
+	}{
+		{"succeeded job", map[string]int64{"succeeded": 1, "failed": 0}, metaclient1.ClusterSucceeded, metaclient1.RequestCompleted},
+		{"failed job", map[string]int64{"succeeded": 0, "failed": 1}, metaclient1.ClusterFailed, metaclient1.RequestFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(availableCluster("spoke1"), newRequest(t, metaclient1.BackupRequest{
+				ObjectMeta: v1.ObjectMeta{Name: "backup"},
+				Spec:       testSpec("spoke1"),
+			}))
+			c := NewController(client, "")
+
+			c.reconcileAll(context.Background())
+			request := getRequest(t, client, "backup")
+			if !request.HasFinalizer() {
+				t.Errorf("finalizer not added")
+			}
+			if request.Status.ObservedGeneration != 1 {
+				t.Errorf("observedGeneration = %d, want 1", request.Status.ObservedGeneration)
+			}
+
+			reconcileUntil(t, c, "the job is launched", func() bool {
+				return len(works(t, client)) == 1
+			})
+			setJobStatus(t, client, "spoke1", map[string]int64{})
+			reconcileUntil(t, c, "the request runs", func() bool {
+				request = getRequest(t, client, "backup")
+				return request.Status.Phase == metaclient1.RequestRunning && clusterPhase(request, "spoke1") == metaclient1.ClusterRunning
+			})
+
+			setJobStatus(t, client, "spoke1", tt.feedback)
+			reconcileUntil(t, c, "the request is over", func() bool {
+				request = getRequest(t, client, "backup")
+				return requestDone(request.Status.Phase)
+			})
+			if request.Status.Phase != tt.request {
+				t.Errorf("request phase = %s, want %s", request.Status.Phase, tt.request)
+			}
+			if phase := clusterPhase(request, "spoke1"); phase != tt.cluster {
+				t.Errorf("cluster phase = %s, want %s", phase, tt.cluster)
+			}
+			if request.Status.CompletionTime == nil {
+				t.Errorf("completionTime not set")
+			}
+			if !meta.IsStatusConditionTrue(request.Status.Conditions, metaclient1.ConditionCompleted) {
+				t.Errorf("condition %s not true", metaclient1.ConditionCompleted)
+			}
+			if left := works(t, client); len(left) != 0 {
+				t.Errorf("manifestworks left on the hub: %v", left)
+			}
+
+			// the request is over, its run is forgotten
+			c.reconcileAll(context.Background())
+			if len(c.runs) != 0 {
+				t.Errorf("%d runs left", len(c.runs))
+			}
+		})
+	}
+}
+
+func TestControllerResume(t *testing.T) {
+	now := v1.Now()
+	client := newFakeClient(
+		availableCluster("spoke1"),
+		backupWorkWithStatus("spoke1", map[string]int64{"succeeded": 1}),
+		newRequest(t, metaclient1.BackupRequest{
+			ObjectMeta: v1.ObjectMeta{Name: "backup", Finalizers: []string{metaclient1.BackupRequestFinalizer}},
+			Spec:       testSpec("spoke1"),
+			Status: metaclient1.BackupRequestStatus{
+				ObservedGeneration: 1,
+				Phase:              metaclient1.RequestRunning,
+				StartTime:          &now,
+				Clusters: []metaclient1.ClusterBackupStatus{
+					{Name: "spoke1", Phase: metaclient1.ClusterRunning, HubPhase: PhaseCompletion, StartTime: &now},
+				},
+			},
+		}),
+	)
+	// a new controller, as after a restart
+	c := NewController(client, "")
+
+	var request metaclient1.BackupRequest
+	reconcileUntil(t, c, "the request is over", func() bool {
+		request = getRequest(t, client, "backup")
+		return requestDone(request.Status.Phase)
+	})
+	if request.Status.Phase != metaclient1.RequestCompleted {
+		t.Errorf("request phase = %s, want %s", request.Status.Phase, metaclient1.RequestCompleted)
+	}
+	for _, action := range client.KubernetesClient.(*fake.FakeDynamicClient).Actions() {
+		if action.GetVerb() == "create" && action.GetResource() == metaclient1.ManifestWorkGVR {
+			t.Errorf("the job was created again instead of being resumed")
+		}
+	}
+}
+
+func TestControllerFinalizer(t *testing.T) {
+	t.Run("leftovers of a previous controller", func(t *testing.T) {
+		now := v1.Now()
+		client := newFakeClient(
+			availableCluster("spoke1"),
+			availableCluster("spoke2"),
+			backupWorkWithStatus("spoke1", map[string]int64{}),
+			newRequest(t, metaclient1.BackupRequest{
+				ObjectMeta: v1.ObjectMeta{Name: "backup", Finalizers: []string{metaclient1.BackupRequestFinalizer}, DeletionTimestamp: &now},
+				Spec:       testSpec("spoke1", "spoke2"),
+				Status: metaclient1.BackupRequestStatus{
+					ObservedGeneration: 1,
+					Phase:              metaclient1.RequestRunning,
+					Clusters: []metaclient1.ClusterBackupStatus{
+						{Name: "spoke1", Phase: metaclient1.ClusterRunning},
+						{Name: "spoke2", Phase: metaclient1.ClusterPending},
+					},
+				},
+			}),
+		)
+		c := NewController(client, "")
+
+		c.reconcileAll(context.Background())
+		if left := works(t, client); len(left) != 0 {
+			t.Errorf("manifestworks left on the hub: %v", left)
+		}
+		if request := getRequest(t, client, "backup"); request.HasFinalizer() {
+			t.Errorf("finalizer not removed")
+		}
+	})
+
+	t.Run("backups in flight", func(t *testing.T) {
+		client := newFakeClient(availableCluster("spoke1"), newRequest(t, metaclient1.BackupRequest{
+			ObjectMeta: v1.ObjectMeta{Name: "backup"},
+			Spec:       testSpec("spoke1"),
+		}))
+		c := NewController(client, "")
+
+		reconcileUntil(t, c, "the job is launched", func() bool {
+			return len(works(t, client)) == 1
+		})
+
+		request := getRequest(t, client, "backup")
+		now := v1.Now()
+		request.DeletionTimestamp = &now
+		if err := client.UpdateBackupRequest(context.Background(), &request); err != nil {
+			t.Fatal(err)
+		}
+		reconcileUntil(t, c, "the finalizer is removed", func() bool {
+			return !getRequest(t, client, "backup").HasFinalizer()
+		})
+		if left := works(t, client); len(left) != 0 {
+			t.Errorf("manifestworks left on the hub: %v", left)
+		}
+		if len(c.runs) != 0 {
+			t.Errorf("%d runs left", len(c.runs))
+		}
+	})
+}
+
+func TestControllerMaxConcurrency(t *testing.T) {
+	spec := testSpec("spoke1", "spoke2", "spoke3")
+	spec.MaxConcurrency = 1
+	client := newFakeClient(
+		availableCluster("spoke1"),
+		availableCluster("spoke2"),
+		availableCluster("spoke3"),
+		newRequest(t, metaclient1.BackupRequest{ObjectMeta: v1.ObjectMeta{Name: "backup"}, Spec: spec}),
+	)
+	c := NewController(client, "")
+
+	done := map[string]bool{}
+	reconcileUntil(t, c, "every cluster is backed up", func() bool {
+		running := works(t, client)
+		if len(running) > 1 {
+			t.Fatalf("%d jobs running at once, want at most 1: %v", len(running), running)
+		}
+		for _, cluster := range running {
+			if !done[cluster] {
+				done[cluster] = true
+				setJobStatus(t, client, cluster, map[string]int64{"succeeded": 1})
+			}
+		}
+		return requestDone(getRequest(t, client, "backup").Status.Phase)
+	})
+	if len(done) != 3 {
+		t.Errorf("%d clusters backed up, want 3", len(done))
+	}
+	if phase := getRequest(t, client, "backup").Status.Phase; phase != metaclient1.RequestCompleted {
+		t.Errorf("request phase = %s, want %s", phase, metaclient1.RequestCompleted)
+	}
+}
+
+func TestControllerSpecChange(t *testing.T) {
+	client := newFakeClient(availableCluster("spoke1"), availableCluster("spoke2"), newRequest(t, metaclient1.BackupRequest{
+		ObjectMeta: v1.ObjectMeta{Name: "backup"},
+		Spec:       testSpec("spoke1"),
+	}))
+	c := NewController(client, "")
+
+	reconcileUntil(t, c, "the job is launched", func() bool {
+		return len(works(t, client)) == 1
+	})
+	request := getRequest(t, client, "backup")
+	if meta.FindStatusCondition(request.Status.Conditions, metaclient1.ConditionSpecChangeIgnored) != nil {
+		t.Errorf("condition %s set without any spec change", metaclient1.ConditionSpecChangeIgnored)
+	}
+
+	// the fake hub doesn't bump the generation
+	request.Spec.Clusters = []string{"spoke1", "spoke2"}
+	request.Generation = 2
+	if err := client.UpdateBackupRequest(context.Background(), &request); err != nil {
+		t.Fatal(err)
+	}
+	c.reconcileAll(context.Background())
+
+	request = getRequest(t, client, "backup")
+	condition := meta.FindStatusCondition(request.Status.Conditions, metaclient1.ConditionSpecChangeIgnored)
+	if condition == nil || condition.Status != v1.ConditionTrue {
+		t.Fatalf("condition %s = %v, want true", metaclient1.ConditionSpecChangeIgnored, condition)
+	}
+	if request.Status.ObservedGeneration != 1 {
+		t.Errorf("observedGeneration = %d, want 1", request.Status.ObservedGeneration)
+	}
+	if len(request.Status.Clusters) != 1 {
+		t.Errorf("%d clusters in the status, want 1", len(request.Status.Clusters))
+	}
+
+	// the condition stays once the request is over
+	setJobStatus(t, client, "spoke1", map[string]int64{"succeeded": 1})
+	reconcileUntil(t, c, "the request is over", func() bool {
+		request = getRequest(t, client, "backup")
+		return requestDone(request.Status.Phase)
+	})
+	if !meta.IsStatusConditionTrue(request.Status.Conditions, metaclient1.ConditionSpecChangeIgnored) {
+		t.Errorf("condition %s lost", metaclient1.ConditionSpecChangeIgnored)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addSpokeFlags registers the flags connecting to the hub and selecting the spokes
+func addSpokeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("Spoke", "s", "", "Comma separated names of the Spoke clusters")
+	cmd.Flags().StringP("selector", "l", "", "Label selector matching the ManagedClusters, e.g. du-profile=site-a")
+	cmd.Flags().String("cluster-set", "", "Name of the ManagedClusterSet whose clusters are selected")
+
+	// required, but checked by newSpokeClient so that it can be set in the config file
+	cmd.Flags().StringP("KubeconfigPath", "k", "", "Path to kubeconfig file (required)")
+
+	cmd.Flags().StringP("BackupPath", "p", metaclient1.DefaultBackupPath, "Path of recovery partition where backups are stored")
+}
+
+// addJobFlags registers the flags configuring the job launched on the spokes and how it is polled
+func addJobFlags(cmd *cobra.Command, completionTimeout time.Duration) {
+	cmd.Flags().String("image", metaclient1.DefaultImage, "Image running the job on the spokes, by tag or digest")
+	cmd.Flags().String("pull-secret", "", "Image pull secret on the hub, as namespace/name, propagated to the spokes to pull the image")
+
+	cmd.Flags().Duration("launch-timeout", metaclient1.DefaultLaunchTimeout, "Maximum time to wait for the job to be launched on a spoke")
+	cmd.Flags().Duration("completion-timeout", completionTimeout, "Maximum time to wait for the job to complete on a spoke")
+	cmd.Flags().Duration("poll-interval", metaclient1.DefaultPollInterval, "Initial interval between two checks of the job status, backing off exponentially")
+	cmd.Flags().Duration("max-poll-interval", metaclient1.DefaultMaxPollInterval, "Maximum interval between two checks of the job status")
+}
+
+// addBackupFlags registers the flags configuring the backup taken on the spokes, read by backupArgsFromFlags
+func addBackupFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("keep", 1, "Number of backup generations kept on the spokes, including the new one, besides the pinned ones")
+	cmd.Flags().Bool("pin", false, "Pin the new backup generation so that retention never deletes it")
+	cmd.Flags().String("name", "", "Label appended to the name of the new backup generation, e.g. pre-4.11")
+	cmd.Flags().String("format", "raw", "Format of the backed up directories on the spokes: raw, gzip or zstd")
+	cmd.Flags().String("encryption-secret", "", "Secret on the hub, as namespace/name, whose key is propagated to the spokes to encrypt the backup")
+	cmd.Flags().String("push-oci", "", "Reference the backups are pushed to as OCI artifacts, registry/repository[:tag] (default tag is the generation name)")
+	cmd.Flags().String("registry-auth-secret", "", "Image pull secret on the hub, as namespace/name, holding the credentials the backups are pushed with")
+	cmd.Flags().String("s3-secret", "", "Secret on the hub, as namespace/name, locating the S3 bucket the backups are exported to, with its credentials")
+	cmd.Flags().Bool("dedup", true, "Hard link the files identical to the ones of the previous backup generation")
+	cmd.Flags().String("transport", metaclient1.TransportAction, "Transport of the backup job to the spokes: managedclusteraction or manifestwork")
+}
+
+// addLaunchFlags registers the flags controlling the waves, the failure policy and the run report
+func addLaunchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", OutputTable, "Format of the run report: table, json, yaml or junit")
+	cmd.Flags().String("report-file", "", "File where the run report is written (default is stdout)")
+
+	cmd.Flags().Int("max-concurrency", 10, "Maximum number of spokes handled at the same time (0 means no limit)")
+	cmd.Flags().Int("batch-size", 0, "Number of spokes per wave, each wave starting once the previous one is over (0 means a single wave)")
+	cmd.Flags().Int("canary", 0, "Number of spokes handled in a first canary wave before the others (0 disables the canary wave)")
+	cmd.Flags().Float64("canary-threshold", 1, "Minimum success rate of the canary wave, between 0 and 1, required to carry on")
+	cmd.Flags().Bool("fail-fast", false, "Cancel the outstanding spokes after the first failure")
+	cmd.Flags().Float64("min-success-ratio", 0, "Ratio of spokes, between 0 and 1, which must succeed for the run to succeed. Waves are no longer launched once it can't be reached (0 disables the policy: every spoke must succeed and all waves are launched)")
+}
+
+// bindFlags binds the flags of the running command to viper, so that they can also be set in the config file.
+// Binding only the running command keeps commands sharing flag names from overriding each other.
+// returns:			error
+func bindFlags(cmd *cobra.Command, args []string) error {
+	return viper.BindPFlags(cmd.Flags())
+}
+
+// launchOptionsFromFlags reads the wave, failure policy and report flags
+// returns:			LaunchOptions, output format, report file, error
+func launchOptionsFromFlags(cmd *cobra.Command) (LaunchOptions, string, string, error) {
+	output := viper.GetString("output")
+	reportFile := viper.GetString("report-file")
+	if err := validateOutput(output); err != nil {
+		return LaunchOptions{}, "", "", err
+	}
+
+	opts := LaunchOptions{Progress: os.Stdout}
+	if output != OutputTable && reportFile == "" {
+		// keep stdout for the report
+		opts.Progress = os.Stderr
+	}
+	opts.MaxConcurrency = viper.GetInt("max-concurrency")
+	opts.BatchSize = viper.GetInt("batch-size")
+	opts.CanarySize = viper.GetInt("canary")
+	opts.CanaryThreshold = viper.GetFloat64("canary-threshold")
+	opts.FailFast = viper.GetBool("fail-fast")
+	opts.MinSuccessRatio = viper.GetFloat64("min-success-ratio")
+	if err := opts.Validate(); err != nil {
+		return LaunchOptions{}, "", "", err
+	}
+	return opts, output, reportFile, nil
+}
+
+// newSpokeClient creates the hub client from the flags, configures the job and resolves the spokes
+// returns:			client, error
+func newSpokeClient(ctx context.Context, cmd *cobra.Command, progress io.Writer) (metaclient1.Client, error) {
+	// get spoke cluster
+	Spoke := viper.GetString("Spoke")
+	Selector := viper.GetString("selector")
+	ClusterSet := viper.GetString("cluster-set")
+	// the clusters of a clustergroupupgrade are read by the command
+	CGU := ""
+	if cmd.Flags().Lookup("cgu") != nil {
+		CGU = viper.GetString("cgu")
+	}
+	if CGU != "" {
+		if Spoke != "" || Selector != "" || ClusterSet != "" {
+			return metaclient1.Client{}, fmt.Errorf("--cgu cannot be combined with --Spoke, --selector or --cluster-set")
+		}
+	} else if err := validateSpokeFlags(Spoke, Selector, ClusterSet); err != nil {
+		return metaclient1.Client{}, err
+	}
+
+	Clustername := []string{}
+	if Spoke != "" {
+		splittedParam := strings.Split(Spoke, ",")
+		for _, v := range splittedParam {
+			Clustername = append(Clustername, strings.TrimSpace(v))
+		}
+	}
+
+	KubeconfigPath := viper.GetString("KubeconfigPath")
+	if KubeconfigPath == "" {
+		return metaclient1.Client{}, fmt.Errorf("required flag \"KubeconfigPath\" not set")
+	}
+	BackupPath, err := validateBackupPath(viper.GetString("BackupPath"))
+	if err != nil {
+		return metaclient1.Client{}, err
+	}
+
+	client, err := metaclient1.New(Clustername, BackupPath, KubeconfigPath)
+	if err != nil {
+		return client, err
+	}
+
+	client.Poll = metaclient1.PollOptions{
+		LaunchTimeout:     viper.GetDuration("launch-timeout"),
+		CompletionTimeout: viper.GetDuration("completion-timeout"),
+		Interval:          viper.GetDuration("poll-interval"),
+		MaxInterval:       viper.GetDuration("max-poll-interval"),
+	}
+	if err := client.Poll.Validate(); err != nil {
+		return client, err
+	}
+
+	client.Image = viper.GetString("image")
+	if err := metaclient1.ValidateImage(client.Image); err != nil {
+		return client, err
+	}
+	// only the backup job has a transport to choose, the other jobs run through managedclusteractions
+	if cmd.Flags().Lookup("transport") != nil {
+		client.Transport = viper.GetString("transport")
+		if err := metaclient1.ValidateTransport(client.Transport); err != nil {
+			return client, err
+		}
+	}
+	if pullSecret := viper.GetString("pull-secret"); pullSecret != "" {
+		client.PullSecretData, err = client.FetchPullSecret(ctx, pullSecret)
+		if err != nil {
+			return client, err
+		}
+	}
+	if encryptionSecret := viper.GetString("encryption-secret"); encryptionSecret != "" {
+		client.EncryptionKeyData, err = client.FetchEncryptionKey(ctx, encryptionSecret)
+		if err != nil {
+			return client, err
+		}
+	}
+
+	if s3Secret := viper.GetString("s3-secret"); s3Secret != "" {
+		client.S3SecretData, err = client.FetchS3Secret(ctx, s3Secret)
+		if err != nil {
+			return client, err
+		}
+	}
+	if registryAuthSecret := viper.GetString("registry-auth-secret"); registryAuthSecret != "" {
+		client.RegistryAuthData, err = client.FetchPullSecret(ctx, registryAuthSecret)
+		if err != nil {
+			return client, err
+		}
+	}
+
+	if Spoke == "" && CGU == "" {
+		client.Spoke, err = resolveSpokes(ctx, client, Selector, ClusterSet, progress)
+		if err != nil {
+			return client, err
+		}
+	}
+	return client, nil
+}
+
+// runLaunch launches a job on every spoke wave by wave, then writes the run report
+// returns:			nil when the run succeeded, *ExitError otherwise
+func runLaunch(ctx context.Context, command string, client metaclient1.Client, launch spokeLauncher, opts LaunchOptions, output string, reportFile string) error {
+	startTime := time.Now()
+	waves, err := multiSpokeLaunch(ctx, client, opts, launch)
+	if err != nil {
+		return err
+	}
+
+	if interrupted := interruptedSpokes(waves); len(interrupted) > 0 {
+		fmt.Fprintf(opts.Progress, "%s interrupted on spoke cluster(s): %s\n", command, strings.Join(interrupted, ", "))
+	}
+
+	report := newReport(command, waves, startTime, time.Now())
+	if reportFile != "" {
+		err = writeReportFile(reportFile, report, output)
+	} else if output != OutputTable {
+		err = writeReport(os.Stdout, report, output)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Publish != nil {
+		if err := opts.Publish(report); err != nil {
+			return err
+		}
+	}
+
+	return runResult(report.Summary, opts.MinSuccessRatio)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestNewSpokeClientConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// config holds the keys of the config file
+		config map[string]string
+		args   []string
+		cgu    bool
+		err    string
+	}{
+		{
+			name:   "kubeconfig is required",
+			config: map[string]string{"Spoke": "spoke1"},
+			err:    `required flag "KubeconfigPath" not set`,
+		},
+		{
+			name:   "spokes are read from the config file",
+			config: map[string]string{"Spoke": "spoke1", "selector": "du-profile=site-a", "KubeconfigPath": "/nonexistent"},
+			err:    "--Spoke cannot be combined with --selector or --cluster-set",
+		},
+		{
+			name:   "flags override the config file",
+			config: map[string]string{"Spoke": "spoke1", "selector": "du-profile=site-a", "KubeconfigPath": "/nonexistent"},
+			args:   []string{"--selector="},
+			err:    "/nonexistent",
+		},
+		{
+			name:   "backup path is read from the config file",
+			config: map[string]string{"Spoke": "spoke1", "KubeconfigPath": "/nonexistent", "BackupPath": "/etc"},
+			err:    "invalid --BackupPath",
+		},
+		{
+			name:   "kubeconfig is read from the config file",
+			config: map[string]string{"Spoke": "spoke1", "KubeconfigPath": "/nonexistent"},
+			err:    "/nonexistent",
+		},
+		{
+			name:   "clustergroupupgrade of the config file is ignored by the commands not having the flag",
+			config: map[string]string{"Spoke": "spoke1", "KubeconfigPath": "/nonexistent", "cgu": "ztp/upgrade"},
+			err:    "/nonexistent",
+		},
+		{
+			name:   "clustergroupupgrade is read from the config file",
+			config: map[string]string{"Spoke": "spoke1", "KubeconfigPath": "/nonexistent", "cgu": "ztp/upgrade"},
+			cgu:    true,
+			err:    "--cgu cannot be combined with --Spoke, --selector or --cluster-set",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			for key, value := range test.config {
+				viper.SetDefault(key, value)
+			}
+			cmd := &cobra.Command{Use: "test"}
+			addSpokeFlags(cmd)
+			if test.cgu {
+				cmd.Flags().String("cgu", "", "")
+			}
+			if err := cmd.ParseFlags(test.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := bindFlags(cmd, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := newSpokeClient(context.Background(), cmd, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error = %v, want it to contain %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of the run report
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputJUnit = "junit"
+)
+
+// Summary counts the spokes by final status
+type Summary struct {
+	Total       int `json:"total"`
+	Succeeded   int `json:"succeeded"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Interrupted int `json:"interrupted"`
+}
+
+// Report is the machine readable result of a triggerBackup or triggerRecovery run
+type Report struct {
+	Command   string    `json:"command"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Summary   Summary   `json:"summary"`
+	Clusters  []Status  `json:"clusters"`
+	waves     []Wave
+}
+
+// validateOutput checks the report format is supported
+// returns:			error
+func validateOutput(output string) error {
+	switch output {
+	case OutputTable, OutputJSON, OutputYAML, OutputJUnit:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q, expecting one of table, json, yaml or junit", output)
+}
+
+// newReport gathers the per-cluster records of all the waves
+// returns:			Report
+func newReport(command string, waves []Wave, start time.Time, end time.Time) Report {
+	report := Report{Command: command, StartTime: start, EndTime: end, Clusters: []Status{}, waves: waves}
+	for _, wave := range waves {
+		for _, v := range wave.Status {
+			report.Clusters = append(report.Clusters, v)
+			report.Summary.Total++
+			switch v.ClusterStatus {
+			case metaclient1.Done:
+				report.Summary.Succeeded++
+			case metaclient1.Skipped:
+				report.Summary.Skipped++
+			case metaclient1.Interrupted:
+				report.Summary.Interrupted++
+			default:
+				report.Summary.Failed++
+			}
+		}
+	}
+	return report
+}
+
+// writeReportFile writes the report to a file in the given format
+// returns:			error
+func writeReportFile(path string, report Report, output string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create report file %s: %s", path, err)
+	}
+	if err := writeReport(f, report, output); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeReport renders the report in the given format
+// returns:			error
+func writeReport(w io.Writer, report Report, output string) error {
+	switch output {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case OutputYAML:
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case OutputJUnit:
+		return writeJUnit(w, report)
+	default:
+		for _, wave := range report.waves {
+			printStatus(w, wave)
+		}
+		return nil
+	}
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       float64          `xml:"time,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// hubObjects describes the phase reached by a spoke and the hub objects used, as the other formats report them
+// returns:			string
+func hubObjects(v Status) string {
+	objects := fmt.Sprintf("phase: %s, managedclusteractions: %v, managedclusterviews: %v", v.Phase, v.Actions, v.Views)
+	if len(v.Works) > 0 {
+		objects += fmt.Sprintf(", manifestworks: %v", v.Works)
+	}
+	return objects
+}
+
+// writeJUnit renders the report as JUnit XML, one test suite per wave and one test case per spoke
+// returns:			error
+func writeJUnit(w io.Writer, report Report) error {
+	suites := junitTestSuites{
+		Name: report.Command,
+		Time: report.EndTime.Sub(report.StartTime).Seconds(),
+	}
+	for _, wave := range report.waves {
+		suite := junitTestSuite{Name: wave.Name}
+		for _, v := range wave.Status {
+			testCase := junitTestCase{
+				Name:      v.ClusterName,
+				ClassName: report.Command + "." + wave.Name,
+				SystemOut: hubObjects(v),
+			}
+			if !v.StartTime.IsZero() {
+				testCase.Time = v.EndTime.Sub(v.StartTime).Seconds()
+			}
+			switch v.ClusterStatus {
+			case metaclient1.Done:
+			case metaclient1.Skipped:
+				testCase.Skipped = &junitMessage{Message: v.ClusterError}
+				suite.Skipped++
+			default:
+				testCase.Failure = &junitMessage{Message: v.ClusterError, Type: v.ClusterStatus, Text: hubObjects(v)}
+				suite.Failures++
+			}
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.TestSuites = append(suites.TestSuites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
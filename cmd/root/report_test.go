@@ -0,0 +1,110 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+)
+
+// update rewrites the golden files of the tests with the output they get
+var update = flag.Bool("update", false, "update the golden files")
+
+// testReport returns the report of a backup run over a canary and a batch, with a spoke of every status
+func testReport() Report {
+	start := time.Date(2022, 5, 20, 10, 10, 10, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	waves := []Wave{
+		{Name: "canary", Spokes: []string{"sno1"}, Status: []Status{
+			{ClusterName: "sno1", Wave: "canary", ClusterStatus: metaclient1.Done, Phase: PhaseDone,
+				StartTime: at(0), EndTime: at(4), Actions: []string{"backup-create-job"}, Views: []string{"backup-create-clusterview"}},
+		}},
+		{Name: "batch-1", Spokes: []string{"sno2", "sno3", "sno4", "sno5"}, Status: []Status{
+			{ClusterName: "sno2", Wave: "batch-1", ClusterStatus: metaclient1.Failed, Phase: PhaseCompletion,
+				ClusterError: "job failed: BackoffLimitExceeded", StartTime: at(4), EndTime: at(9), Works: []string{"backup-job"}},
+			{ClusterName: "sno3", Wave: "batch-1", ClusterStatus: metaclient1.NExist, Phase: PhaseCheckCluster,
+				ClusterError: "cluster sno3 does not exist", StartTime: at(4), EndTime: at(4)},
+			{ClusterName: "sno4", Wave: "batch-1", ClusterStatus: metaclient1.Interrupted, Phase: PhaseLaunch,
+				ClusterError: "context canceled", StartTime: at(4), EndTime: at(6)},
+			{ClusterName: "sno5", Wave: "batch-1", ClusterStatus: metaclient1.Skipped,
+				ClusterError: "backup cancelled before launch", StartTime: at(6), EndTime: at(6)},
+		}},
+	}
+	return newReport("triggerBackup", waves, start, at(10))
+}
+
+func TestNewReport(t *testing.T) {
+	report := testReport()
+	want := Summary{Total: 5, Succeeded: 1, Failed: 2, Skipped: 1, Interrupted: 1}
+	if report.Summary != want {
+		t.Errorf("newReport() summary = %+v, want %+v", report.Summary, want)
+	}
+	if len(report.Clusters) != 5 || report.Clusters[0].ClusterName != "sno1" || report.Clusters[4].ClusterName != "sno5" {
+		t.Errorf("newReport() clusters = %v, want sno1 to sno5 in the order of the waves", report.Clusters)
+	}
+
+	empty := newReport("triggerBackup", nil, time.Time{}, time.Time{})
+	if empty.Summary != (Summary{}) || empty.Clusters == nil {
+		t.Errorf("newReport() of no wave = %+v, want an empty summary and an empty list of clusters", empty)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	for output, golden := range map[string]string{
+		OutputJSON:  "report.json",
+		OutputYAML:  "report.yaml",
+		OutputJUnit: "report.xml",
+		OutputTable: "report.txt",
+	} {
+		t.Run(output, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeReport(&out, testReport(), output); err != nil {
+				t.Fatalf("writeReport() error = %v", err)
+			}
+			path := filepath.Join("testdata", golden)
+			if *update {
+				if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("writeReport() = \n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	for _, output := range []string{OutputTable, OutputJSON, OutputYAML, OutputJUnit} {
+		if err := validateOutput(output); err != nil {
+			t.Errorf("validateOutput(%q) error = %v", output, err)
+		}
+	}
+	if err := validateOutput("xml"); err == nil {
+		t.Errorf("validateOutput(%q) accepted an unsupported format", "xml")
+	}
+}
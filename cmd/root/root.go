@@ -18,6 +18,7 @@
 package root
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -33,15 +34,50 @@ var cfgFile string
 var rootCmd = &cobra.Command{
 	Use:   "trigger-backup",
 	Short: "CLI tool to trigger backup tasks for spoke clusters",
+	// the error is printed once by execute, whatever the command
+	SilenceErrors: true,
+}
+
+// Exit codes of the CLI
+const (
+	ExitSuccess        = 0
+	ExitUsageError     = 1 // usage or connection error
+	ExitPartialFailure = 2 // the backup failed on some of the spokes
+	ExitAllFailed      = 3 // the backup failed on all the spokes
+)
+
+// ExitError is returned by a command which wants the CLI to exit with a specific code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if code := execute(os.Args[1:]); code != ExitSuccess {
+		os.Exit(code)
+	}
+}
+
+// execute runs the command line, printing its error on stderr so that stdout only carries the output of the command
+// returns:			exit code
+func execute(args []string) int {
+	rootCmd.SetArgs(args)
+	err := rootCmd.Execute()
+	if err == nil {
+		return ExitSuccess
+	}
+	fmt.Fprintln(rootCmd.ErrOrStderr(), "Error:", err)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
 	}
+	return ExitUsageError
 }
 
 func init() {
@@ -64,8 +100,8 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(ExitUsageError)
 		}
 
 		// Search config in home directory with name ".openshift-ai-trigger-backup" (without extension).
@@ -77,6 +113,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
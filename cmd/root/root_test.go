@@ -0,0 +1,126 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// fakeListKinds maps the resources listed on the hub to their list kinds
+var fakeListKinds = map[schema.GroupVersionResource]string{
+	metaclient1.ManagedClusterGVR:      "ManagedClusterList",
+	metaclient1.ClusterGroupUpgradeGVR: "ClusterGroupUpgradeList",
+	metaclient1.ConfigMapGVR:           "ConfigMapList",
+	metaclient1.ManifestWorkGVR:        "ManifestWorkList",
+	metaclient1.BackupRequestGVR:       "BackupRequestList",
+	metaclient1.SecretGVR:              "SecretList",
+}
+
+// newFakeClient returns a client of a fake hub holding objects
+func newFakeClient(objects ...runtime.Object) metaclient1.Client {
+	return metaclient1.Client{
+		BackupPath:       metaclient1.DefaultBackupPath,
+		KubernetesClient: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...),
+		Poll:             metaclient1.DefaultPollOptions(),
+		Image:            metaclient1.DefaultImage,
+		Transport:        metaclient1.TransportAction,
+	}
+}
+
+// managedCluster returns a managedcluster of the hub, with its labels
+func managedCluster(name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.open-cluster-management.io/v1",
+		"kind":       "ManagedCluster",
+	}}
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func TestExecute(t *testing.T) {
+	failing := &cobra.Command{
+		Use: "failing",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return &ExitError{Code: ExitPartialFailure, Err: fmt.Errorf("the backup failed on 1 of 2 spoke(s)")}
+		},
+	}
+	rootCmd.AddCommand(failing)
+	defer rootCmd.RemoveCommand(failing)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		err  string
+	}{
+		{
+			name: "usage error",
+			args: []string{"status", "--bogus"},
+			code: ExitUsageError,
+			err:  "Error: unknown flag: --bogus",
+		},
+		{
+			name: "connection error",
+			args: []string{"status", "-k", "/nonexistent", "-s", "spoke1"},
+			code: ExitUsageError,
+			err:  "Error: stat /nonexistent",
+		},
+		{
+			name: "failed run",
+			args: []string{"failing"},
+			code: ExitPartialFailure,
+			err:  "Error: the backup failed on 1 of 2 spoke(s)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			var stdout, stderr bytes.Buffer
+			rootCmd.SetOut(&stdout)
+			rootCmd.SetErr(&stderr)
+			defer rootCmd.SetOut(nil)
+			defer rootCmd.SetErr(nil)
+
+			if code := execute(test.args); code != test.code {
+				t.Errorf("exit code = %d, want %d", code, test.code)
+			}
+			if count := strings.Count(stderr.String(), "Error:"); count != 1 {
+				t.Errorf("error printed %d times, want once:\n%s", count, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), test.err) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), test.err)
+			}
+			if strings.Contains(stdout.String(), "Error:") {
+				t.Errorf("error printed on stdout: %q", stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reconcileSchedule starts the runs of a scheduled backuprequest, each run being a backuprequest of its own owned
+// by it, and records the outcome of the runs per cluster. A run which is due while the previous one is still active,
+// or while the controller was down, is missed
+// returns:			error
+func (c *Controller) reconcileSchedule(ctx context.Context, request *metaclient1.BackupRequest, runs []metaclient1.BackupRequest) error {
+	status := &request.Status
+	if err := request.Spec.Validate(); err != nil {
+		if status.Phase == metaclient1.RequestFailed {
+			return nil
+		}
+		return c.failRequest(ctx, request, "InvalidSpec", err)
+	}
+	// a fixed spec resumes the schedule
+	status.CompletionTime = nil
+	meta.RemoveStatusCondition(&status.Conditions, metaclient1.ConditionCompleted)
+	location, _ := request.Spec.Location()
+	schedule, _ := metaclient1.ParseCron(request.Spec.Schedule, location)
+	before, _ := json.Marshal(request.Status)
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreationTimestamp.Before(&runs[j].CreationTimestamp)
+	})
+	var active *metaclient1.BackupRequest
+	for i := range runs {
+		if !requestDone(runs[i].Status.Phase) {
+			active = &runs[i]
+		}
+	}
+
+	now := time.Now()
+	last := request.CreationTimestamp.Time
+	if status.LastScheduleTime != nil {
+		last = status.LastScheduleTime.Time
+	}
+	due, missed := time.Time{}, 0
+	for next := schedule.Next(last); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		if !due.IsZero() {
+			missed++
+		}
+		due = next
+	}
+	if !due.IsZero() {
+		if active != nil {
+			log.Warnf("Scheduled backuprequest %s/%s missed its run at %s, run %s still active", request.Namespace, request.Name, due.Format(time.RFC3339), active.Name)
+			missed++
+		} else {
+			run, err := c.startRun(ctx, request, schedule, due)
+			if err != nil {
+				return err
+			}
+			active = run
+			runs = append(runs, *run)
+		}
+		if missed > 0 {
+			lastMissed := v1.NewTime(due)
+			status.MissedRuns += missed
+			status.LastMissedTime = &lastMissed
+		}
+		lastSchedule := v1.NewTime(due)
+		status.LastScheduleTime = &lastSchedule
+	}
+
+	status.ObservedGeneration = request.Generation
+	status.ActiveRun = ""
+	status.Phase = metaclient1.RequestScheduled
+	if active != nil {
+		status.ActiveRun = active.Name
+		status.Phase = metaclient1.RequestRunning
+	}
+	if next := schedule.Next(now); !next.IsZero() {
+		nextSchedule := v1.NewTime(next)
+		status.NextScheduleTime = &nextSchedule
+	}
+
+	overdueAfter := 2 * schedule.Period(now)
+	if request.Spec.OverdueAfter != nil {
+		overdueAfter = request.Spec.OverdueAfter.Duration
+	}
+	recordRuns(request, runs, now, overdueAfter)
+
+	if err := c.pruneRuns(ctx, request, runs); err != nil {
+		return err
+	}
+
+	after, _ := json.Marshal(request.Status)
+	if bytes.Equal(before, after) {
+		return nil
+	}
+	return c.client.UpdateBackupRequestStatus(ctx, request)
+}
+
+// startRun creates the run of a scheduled backuprequest due at a time. Its deadline is the next run, the clusters
+// whose maintenance window didn't open by then are missed
+// returns:			*metaclient1.BackupRequest, error
+func (c *Controller) startRun(ctx context.Context, request *metaclient1.BackupRequest, schedule *metaclient1.CronSchedule, due time.Time) (*metaclient1.BackupRequest, error) {
+	spec := request.Spec
+	spec.Schedule = ""
+	spec.OverdueAfter = nil
+	spec.HistoryLimit = nil
+	if next := schedule.Next(due); !next.IsZero() {
+		deadline := v1.NewTime(next)
+		spec.Deadline = &deadline
+	}
+
+	controller := true
+	run := &metaclient1.BackupRequest{
+		ObjectMeta: v1.ObjectMeta{
+			// as a cronjob names its jobs
+			Name:      fmt.Sprintf("%s-%d", request.Name, due.Unix()/60),
+			Namespace: request.Namespace,
+			Labels:    map[string]string{metaclient1.ScheduleLabel: request.Name},
+			OwnerReferences: []v1.OwnerReference{{
+				APIVersion:         metaclient1.BackupRequestGVR.GroupVersion().String(),
+				Kind:               "BackupRequest",
+				Name:               request.Name,
+				UID:                request.UID,
+				Controller:         &controller,
+				BlockOwnerDeletion: &controller,
+			}},
+		},
+		Spec: spec,
+	}
+	run.CreationTimestamp = v1.Now()
+
+	err := c.client.CreateBackupRequest(ctx, run)
+	if errors.IsAlreadyExists(err) {
+		// created before the status could record it
+		return run, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start the run of scheduled backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+	}
+	log.Infof("Scheduled backuprequest %s/%s started run %s", request.Namespace, request.Name, run.Name)
+	return run, nil
+}
+
+// recordRuns records the last run and the last successful backup of every cluster of a scheduled backuprequest,
+// marking overdue the clusters without a successful backup for longer than overdueAfter. The clusters which are
+// not in the last run anymore, the selector having changed, are dropped
+func recordRuns(request *metaclient1.BackupRequest, runs []metaclient1.BackupRequest, now time.Time, overdueAfter time.Duration) {
+	status := &request.Status
+	records := map[string]*metaclient1.ClusterBackupStatus{}
+	for i := range status.Clusters {
+		records[status.Clusters[i].Name] = &status.Clusters[i]
+	}
+
+	// the clusters of the last run whose clusters are resolved
+	latest := -1
+	for i, run := range runs {
+		if len(run.Status.Clusters) > 0 {
+			latest = i
+		}
+	}
+
+	names := []string{}
+	for i, run := range runs {
+		for _, cluster := range run.Status.Clusters {
+			record, ok := records[cluster.Name]
+			if !ok {
+				record = &metaclient1.ClusterBackupStatus{Name: cluster.Name}
+				records[cluster.Name] = record
+			}
+			record.Phase = cluster.Phase
+			record.Message = cluster.Message
+			if cluster.Phase == metaclient1.ClusterSucceeded && cluster.CompletionTime != nil &&
+				(record.LastSuccessTime == nil || record.LastSuccessTime.Before(cluster.CompletionTime)) {
+				record.LastSuccessTime = cluster.CompletionTime.DeepCopy()
+			}
+			if i == latest {
+				names = append(names, cluster.Name)
+			}
+		}
+	}
+	if latest < 0 {
+		for name := range records {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	overdue := []string{}
+	clusters := []metaclient1.ClusterBackupStatus{}
+	for _, name := range names {
+		record := records[name]
+		since := request.CreationTimestamp.Time
+		if record.LastSuccessTime != nil {
+			since = record.LastSuccessTime.Time
+		}
+		record.Overdue = overdueAfter > 0 && now.Sub(since) > overdueAfter
+		if record.Overdue {
+			overdue = append(overdue, name)
+		}
+		clusters = append(clusters, *record.DeepCopy())
+	}
+	status.Clusters = clusters
+
+	condition := v1.Condition{
+		Type:    metaclient1.ConditionBackupsOverdue,
+		Status:  v1.ConditionFalse,
+		Reason:  "UpToDate",
+		Message: fmt.Sprintf("every cluster was backed up within %s", overdueAfter),
+	}
+	if len(overdue) > 0 {
+		shown := overdue
+		if len(shown) > 10 {
+			shown = append(shown[:10:10], "...")
+		}
+		condition.Status, condition.Reason = v1.ConditionTrue, "Overdue"
+		condition.Message = fmt.Sprintf("%d cluster(s) not backed up within %s: %s", len(overdue), overdueAfter, strings.Join(shown, ", "))
+	}
+	if !meta.IsStatusConditionPresentAndEqual(status.Conditions, condition.Type, condition.Status) && condition.Status == v1.ConditionTrue {
+		log.Warnf("Scheduled backuprequest %s/%s: %s", request.Namespace, request.Name, condition.Message)
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// pruneRuns deletes the oldest finished runs of a scheduled backuprequest beyond its history limit
+// returns:			error
+func (c *Controller) pruneRuns(ctx context.Context, request *metaclient1.BackupRequest, runs []metaclient1.BackupRequest) error {
+	limit := metaclient1.DefaultHistoryLimit
+	if request.Spec.HistoryLimit != nil {
+		limit = *request.Spec.HistoryLimit
+	}
+
+	finished := []metaclient1.BackupRequest{}
+	for _, run := range runs {
+		if requestDone(run.Status.Phase) && run.DeletionTimestamp == nil {
+			finished = append(finished, run)
+		}
+	}
+	for i := 0; i < len(finished)-limit; i++ {
+		log.Infof("Deleting run %s of scheduled backuprequest %s/%s", finished[i].Name, request.Namespace, request.Name)
+		if err := c.client.DeleteBackupRequest(ctx, &finished[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// teardownTimeout bounds the teardown of a spoke, which may run after the launch context is cancelled
+const teardownTimeout = 2 * time.Minute
+
+// runSpokeJob creates the hub objects launching a job on a spoke and watching it, through the transport of the
+// client, waits for the job to complete and tears everything down, recording the phase reached and the hub objects
+// used in the record. A job which failed is torn down as well, so that it can be launched again
+// returns:			Job status, error
+func runSpokeJob(ctx context.Context, client metaclient1.Client, record *Status, templates metaclient1.JobTemplates) (string, error) {
+	name := record.ClusterName
+	transport := client.JobTransport()
+
+	if status, err := createSpokeJob(ctx, transport, record, templates); err != nil {
+		return status, err
+	}
+
+	// check job status via managedclusterview or manifestwork
+	record.Phase = PhaseLaunch
+	err := transport.JobStatus(ctx, name, metaclient1.Launch, templates)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interruptSpokeJob(transport, record, templates, err)
+		}
+		return failSpokeJob(transport, record, templates, fmt.Errorf("couldn't verify the initiation of the job, err: %s", err))
+	}
+
+	record.Phase = PhaseCompletion
+	err = transport.JobStatus(ctx, name, metaclient1.Complete, templates)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interruptSpokeJob(transport, record, templates, err)
+		}
+		return failSpokeJob(transport, record, templates, fmt.Errorf("couldn't verify if the job has finished, err: %s", err))
+	}
+
+	return finishSpokeJob(transport, record, templates)
+}
+
+// createSpokeJob creates the hub objects launching a job on a spoke and the ones watching it. The transport deletes
+// what it created when the creation of the job fails, the job is torn down when it can't be watched
+// returns:			Job status, error when the creation failed
+func createSpokeJob(ctx context.Context, transport metaclient1.Transport, record *Status, templates metaclient1.JobTemplates) (string, error) {
+	name := record.ClusterName
+	objects, _ := transport.Objects(templates)
+
+	log.Info("Creating Kubernetes objects")
+
+	record.Phase = PhaseCreateActions
+	record.Actions = objects.Actions
+	record.Works = objects.Works
+	err := transport.CreateJob(ctx, name, templates)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interruptSpokeJob(transport, record, templates, err)
+		}
+		return metaclient1.Failed, err
+	}
+
+	// create managedclusterview object
+	record.Phase = PhaseCreateView
+	record.Views = objects.Views
+	err = transport.WatchJob(ctx, name, templates)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interruptSpokeJob(transport, record, templates, err)
+		}
+		return failSpokeJob(transport, record, templates, err)
+	}
+	return "", nil
+}
+
+// finishSpokeJob tears down a job which completed on a spoke
+// returns:			Job status, error
+func finishSpokeJob(transport metaclient1.Transport, record *Status, templates metaclient1.JobTemplates) (string, error) {
+	record.Phase = PhaseTeardown
+	recordTeardown(transport, record, templates)
+	if err := teardownSpokeJob(transport, record.ClusterName, templates); err != nil {
+		return metaclient1.Failed, err
+	}
+
+	record.Phase = PhaseDone
+	return metaclient1.Done, nil
+}
+
+// failSpokeJob tears down a job which failed, or whose outcome couldn't be read, so that nothing is left behind on
+// the hub nor on the spoke
+// returns:			Job status, error
+func failSpokeJob(transport metaclient1.Transport, record *Status, templates metaclient1.JobTemplates, cause error) (string, error) {
+	log.Warnf("Job of cluster %s failed, tearing down its artifacts", record.ClusterName)
+	recordTeardown(transport, record, templates)
+	if err := teardownSpokeJob(transport, record.ClusterName, templates); err != nil {
+		return metaclient1.Failed, fmt.Errorf("%s, and teardown failed: %s", cause, err)
+	}
+	return metaclient1.Failed, cause
+}
+
+// interruptSpokeJob tears down a job interrupted by a cancelled context
+// returns:			Job status, error
+func interruptSpokeJob(transport metaclient1.Transport, record *Status, templates metaclient1.JobTemplates, cause error) (string, error) {
+	log.Warnf("Job of cluster %s interrupted, tearing down its artifacts", record.ClusterName)
+	recordTeardown(transport, record, templates)
+	if err := teardownSpokeJob(transport, record.ClusterName, templates); err != nil {
+		return metaclient1.Interrupted, fmt.Errorf("job interrupted (%s) and teardown failed: %s", cause, err)
+	}
+	return metaclient1.Interrupted, fmt.Errorf("job interrupted: %s", cause)
+}
+
+// recordTeardown records the hub objects created to tear down a job
+func recordTeardown(transport metaclient1.Transport, record *Status, templates metaclient1.JobTemplates) {
+	_, teardown := transport.Objects(templates)
+	record.Actions = append(record.Actions, teardown.Actions...)
+}
+
+// teardownSpokeJob deletes the hub objects of a job, then deletes the namespace in the spoke, which will delete the
+// job and associated pod. It does not depend on the launch context so that it also runs after a cancellation.
+// returns:			error
+func teardownSpokeJob(transport metaclient1.Transport, name string, templates metaclient1.JobTemplates) error {
+	ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+	defer cancel()
+
+	return transport.TeardownJob(ctx, name, templates)
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newWorkClient returns a client of a fake hub carrying the jobs by manifestwork, polled every few milliseconds
+func newWorkClient(objects ...runtime.Object) metaclient1.Client {
+	client := newFakeClient(objects...)
+	client.Transport = metaclient1.TransportManifestWork
+	client.Poll = metaclient1.PollOptions{
+		LaunchTimeout:     10 * time.Second,
+		CompletionTimeout: 10 * time.Second,
+		Interval:          5 * time.Millisecond,
+		MaxInterval:       20 * time.Millisecond,
+	}
+	return client
+}
+
+func TestRunSpokeJob(t *testing.T) {
+	tests := []struct {
+		name          string
+		existing      bool
+		launchTimeout time.Duration
+		// job is run once the manifestwork of the job is on the hub, cancel interrupting the job
+		job    func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc)
+		status string
+		phase  string
+		err    string
+	}{
+		{
+			name: "completed job is torn down",
+			job: func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {
+				setJobStatus(t, client, "spoke1", map[string]int64{"succeeded": 1, "failed": 0, "active": 0})
+			},
+			status: metaclient1.Done,
+			phase:  PhaseDone,
+		},
+		{
+			name: "failed job is torn down",
+			job: func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {
+				setJobStatus(t, client, "spoke1", map[string]int64{"succeeded": 0, "failed": 1, "active": 0})
+			},
+			status: metaclient1.Failed,
+			phase:  PhaseCompletion,
+			err:    "couldn't verify if the job has finished",
+		},
+		{
+			name:          "job never launched is torn down after the launch timeout",
+			launchTimeout: 50 * time.Millisecond,
+			job:           func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {},
+			status:        metaclient1.Failed,
+			phase:         PhaseLaunch,
+			err:           "couldn't verify the initiation of the job",
+		},
+		{
+			name: "cancelled launch is torn down",
+			job: func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {
+				cancel()
+			},
+			status: metaclient1.Interrupted,
+			phase:  PhaseLaunch,
+			err:    "job interrupted",
+		},
+		{
+			name: "cancelled job is torn down",
+			job: func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {
+				setJobStatus(t, client, "spoke1", map[string]int64{"succeeded": 0, "failed": 0, "active": 1})
+				// the first get sees the job launched, the next one polls its completion
+				polled := countActions(client, "get", metaclient1.ManifestWorkGVR) + 2
+				eventually(t, "the job is seen running", func() bool { return countActions(client, "get", metaclient1.ManifestWorkGVR) > polled })
+				cancel()
+			},
+			status: metaclient1.Interrupted,
+			phase:  PhaseCompletion,
+			err:    "job interrupted",
+		},
+		{
+			name:     "job which can't be created fails",
+			existing: true,
+			job:      func(t *testing.T, client metaclient1.Client, cancel context.CancelFunc) {},
+			status:   metaclient1.Failed,
+			phase:    PhaseCreateActions,
+			err:      "already exists",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if test.existing {
+				objects = append(objects, backupWorkWithStatus("spoke1", nil))
+			}
+			client := newWorkClient(objects...)
+			if test.launchTimeout != 0 {
+				client.Poll.LaunchTimeout = test.launchTimeout
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			type result struct {
+				status string
+				err    error
+			}
+			done := make(chan result)
+			record := &Status{ClusterName: "spoke1"}
+			go func() {
+				status, err := runSpokeJob(ctx, client, record, client.BackupJobTemplates())
+				done <- result{status, err}
+			}()
+			if !test.existing {
+				eventually(t, "the manifestwork is created", func() bool { return len(works(t, client)) == 1 })
+			}
+			test.job(t, client, cancel)
+
+			var got result
+			select {
+			case got = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the job")
+			}
+			if got.status != test.status {
+				t.Errorf("status = %q, want %q", got.status, test.status)
+			}
+			if record.Phase != test.phase {
+				t.Errorf("phase = %q, want %q", record.Phase, test.phase)
+			}
+			if test.err == "" && got.err != nil {
+				t.Errorf("unexpected error: %s", got.err)
+			}
+			if test.err != "" && (got.err == nil || !strings.Contains(got.err.Error(), test.err)) {
+				t.Errorf("error = %v, want it to contain %q", got.err, test.err)
+			}
+			if !test.existing {
+				if left := works(t, client); len(left) != 0 {
+					t.Errorf("manifestworks left on the hub: %v", left)
+				}
+			}
+		})
+	}
+}
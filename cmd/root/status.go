@@ -0,0 +1,192 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// launchStatusPod runs the read-only pod reporting the backup held by a spoke, recording it in the record
+// returns:			Job status, error
+func launchStatusPod(ctx context.Context, client metaclient1.Client, record *Status) (string, error) {
+	name := record.ClusterName
+
+	if err := ctx.Err(); err != nil {
+		return metaclient1.Interrupted, fmt.Errorf("status of cluster %s interrupted before launch: %s", name, err)
+	}
+
+	// check whether the spoke exists
+	record.Phase = PhaseCheckCluster
+	if !client.SpokeClusterExists(ctx, name) {
+		return metaclient1.NExist, fmt.Errorf("cluster %s does not exist", name)
+	}
+
+	// the termination message of the pod is read through its managedclusterview, whatever the transport
+	transport := metaclient1.NewActionTransport(client)
+	templates := client.StatusPodTemplates()
+	if status, err := createSpokeJob(ctx, transport, record, templates); err != nil {
+		return status, err
+	}
+
+	record.Phase = PhaseCompletion
+	message, err := client.PodTerminationMessage(ctx, name, templates.Views)
+	if err != nil {
+		if ctx.Err() != nil {
+			return interruptSpokeJob(transport, record, templates, err)
+		}
+		if _, teardownErr := finishSpokeJob(transport, record, templates); teardownErr != nil {
+			log.Errorf("Couldn't tear down the status pod of cluster %s: %s", name, teardownErr)
+		}
+		return metaclient1.Failed, err
+	}
+
+	if status, err := finishSpokeJob(transport, record, templates); err != nil {
+		return status, err
+	}
+
+	backup, err := metaclient1.ParseBackupStatus(message)
+	if err != nil {
+		return metaclient1.Failed, err
+	}
+	record.Backup = &backup
+	return metaclient1.Done, nil
+}
+
+// writeBackupStatus writes the backup held by every spoke as a table or as json
+// returns:			error
+func writeBackupStatus(out io.Writer, status []Status, output string) error {
+	if output == OutputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+	printBackupStatus(out, status)
+	return nil
+}
+
+// printBackupStatus prints the backup held by every spoke as a table
+func printBackupStatus(out io.Writer, status []Status) {
+	w := tabwriter.NewWriter(out, 10, 0, 0, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Cluster Name\t Backup\t Timestamp\t Size\t OCP Version\t Pinned Deployment\t Recovery In Progress\t Error\t")
+	for _, v := range status {
+		backup, timestamp, size, version, pinned, recovery := "UNKNOWN", "", "", "", "", ""
+		if v.Backup != nil {
+			backup, recovery = "NONE", fmt.Sprintf("%t", v.Backup.RecoveryInProgress)
+			if v.Backup.Exists {
+				backup = "INCOMPLETE"
+				if v.Backup.Complete {
+					backup = "COMPLETE"
+				}
+				timestamp = v.Backup.Timestamp.Format(time.RFC3339)
+				size = humanSize(v.Backup.SizeBytes)
+				version, pinned = v.Backup.OCPVersion, v.Backup.PinnedDeployment
+			}
+		}
+		clusterError := v.ClusterError
+		if clusterError == "" {
+			clusterError = metaclient1.NErr
+		}
+		fmt.Fprintln(w, strings.Join([]string{v.ClusterName, backup, timestamp, size, version, pinned, recovery, clusterError}, "\t "), "\t")
+	}
+	w.Flush()
+}
+
+// humanSize renders a size in bytes with a binary unit
+// returns:			string
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+var statusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "It will report the backup currently held by the spoke clusters",
+	PreRunE: bindFlags,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		output := viper.GetString("output")
+		if output != OutputTable && output != OutputJSON {
+			return fmt.Errorf("unsupported output format %q, expecting one of table or json", output)
+		}
+		opts := LaunchOptions{MaxConcurrency: viper.GetInt("max-concurrency"), Progress: os.Stdout}
+		if output != OutputTable {
+			opts.Progress = os.Stderr
+		}
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+
+		client, err := newSpokeClient(ctx, cmd, opts.Progress)
+		if err != nil {
+			return err
+		}
+
+		// from now on, errors are about the run, not about the usage
+		cmd.SilenceUsage = true
+
+		startTime := time.Now()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		wave := Wave{Name: "status", Spokes: client.Spoke}
+		wave.Status = runWave(ctx, cancel, client, wave, opts, launchStatusPod)
+		status := wave.Status
+
+		if err := writeBackupStatus(os.Stdout, status, output); err != nil {
+			return err
+		}
+
+		report := newReport("status", []Wave{wave}, startTime, time.Now())
+		return runResult(report.Summary, 0)
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(statusCmd)
+
+	addSpokeFlags(statusCmd)
+	addJobFlags(statusCmd, 5*time.Minute)
+
+	statusCmd.Flags().StringP("output", "o", OutputTable, "Format of the status: table or json")
+	statusCmd.Flags().Int("max-concurrency", 10, "Maximum number of spokes queried at the same time (0 means no limit)")
+}
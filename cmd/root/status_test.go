@@ -0,0 +1,94 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+)
+
+// testStatus returns the status of spokes holding a complete, an incomplete and no backup, and of a spoke which
+// couldn't be queried
+func testStatus() []Status {
+	taken := time.Date(2022, 5, 20, 10, 10, 10, 0, time.UTC)
+	return []Status{
+		{ClusterName: "sno1", ClusterStatus: metaclient1.Done, Phase: PhaseDone, Backup: &metaclient1.BackupStatus{
+			Path: "/var/recovery", Generation: "20220520T101010Z", Exists: true, Complete: true, Timestamp: taken,
+			SizeBytes: 3 * 1024 * 1024 * 1024 / 2, OCPVersion: "4.10.13", PinnedDeployment: "8a3f1c",
+		}},
+		{ClusterName: "sno2", ClusterStatus: metaclient1.Done, Phase: PhaseDone, Backup: &metaclient1.BackupStatus{
+			Path: "/var/recovery", Exists: true, Timestamp: taken, SizeBytes: 512, RecoveryInProgress: true,
+		}},
+		{ClusterName: "sno3", ClusterStatus: metaclient1.Done, Phase: PhaseDone, Backup: &metaclient1.BackupStatus{
+			Path: "/var/recovery",
+		}},
+		{ClusterName: "sno4", ClusterStatus: metaclient1.NExist, Phase: PhaseCheckCluster,
+			ClusterError: "cluster sno4 does not exist"},
+	}
+}
+
+func TestWriteBackupStatus(t *testing.T) {
+	for output, golden := range map[string]string{
+		OutputJSON:  "status.json",
+		OutputTable: "status.txt",
+	} {
+		t.Run(output, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeBackupStatus(&out, testStatus(), output); err != nil {
+				t.Fatalf("writeBackupStatus() error = %v", err)
+			}
+			path := filepath.Join("testdata", golden)
+			if *update {
+				if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("writeBackupStatus() = \n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{1536, "1.5KiB"},
+		{5 * 1024 * 1024, "5.0MiB"},
+		{3 * 1024 * 1024 * 1024 / 2, "1.5GiB"},
+		{2 * 1024 * 1024 * 1024 * 1024, "2.0TiB"},
+	}
+	for _, test := range tests {
+		if got := humanSize(test.size); got != test.want {
+			t.Errorf("humanSize(%d) = %q, want %q", test.size, got, test.want)
+		}
+	}
+}
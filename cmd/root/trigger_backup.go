@@ -17,166 +17,318 @@
 package root
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
 	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"k8s.io/apimachinery/pkg/api/errors"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Phases reached by the backup of a spoke cluster
+const (
+	PhaseCheckCluster  = "CheckCluster"
+	PhaseCreateActions = "CreateActions"
+	PhaseCreateView    = "CreateView"
+	PhaseLaunch        = "Launch"
+	PhaseCompletion    = "Completion"
+	PhaseTeardown      = "Teardown"
+	PhaseDone          = "Done"
+)
+
+// Status records the outcome of the backup of one spoke cluster
 type Status struct {
-	ClusterName   string
-	ClusterStatus string
-	ClusterError  interface{}
+	ClusterName   string    `json:"clusterName"`
+	Wave          string    `json:"wave,omitempty"`
+	ClusterStatus string    `json:"status"`
+	Phase         string    `json:"phase,omitempty"`
+	ClusterError  string    `json:"error,omitempty"`
+	Stage         string    `json:"stage,omitempty"`
+	StartTime     time.Time `json:"startTime,omitempty"`
+	EndTime       time.Time `json:"endTime,omitempty"`
+	Actions       []string  `json:"managedClusterActions,omitempty"`
+	Views         []string  `json:"managedClusterViews,omitempty"`
+	Works         []string  `json:"manifestWorks,omitempty"`
+	// Backup is the backup held by the spoke, as reported by the status command
+	Backup *metaclient1.BackupStatus `json:"backup,omitempty"`
 }
 
-// multiSpokeLaunch initiates backup to all the provided spoke clusters concurrently
-// returns:			error
-func multiSpokeLaunch(client metaclient1.Client) error {
-	status := []Status{}
-	var mu sync.Mutex
-	ch := make(chan string, len(client.Spoke))
-	var wg sync.WaitGroup
-	log.Infof("Backup will be launched concurrently on clusters: %s", client.Spoke)
-	for _, v := range client.Spoke {
-		wg.Add(1)
-		go func(client metaclient1.Client, v string, ch chan string, wg *sync.WaitGroup) {
-			retStatus, err := launchBackupJobs(client, v, ch, wg)
-			mu.Lock()
-			if err != nil {
-				status = append(status, Status{v, retStatus, err})
-			} else {
-				status = append(status, Status{v, retStatus, metaclient1.NErr})
+// multiSpokeLaunch initiates backup, or another job, to all the provided spoke clusters, wave by wave.
+// A failing canary wave, an unreachable success ratio or a cancelled context stops the remaining
+// waves from being launched.
+// returns:			per-wave results, error
+func multiSpokeLaunch(ctx context.Context, client metaclient1.Client, opts LaunchOptions, launch spokeLauncher) ([]Wave, error) {
+	waves := planWaves(client.Spoke, opts)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stopped := ""
+	for i := range waves {
+		if stopped == "" && ctx.Err() != nil {
+			stopped = "backup interrupted"
+		}
+		if stopped != "" {
+			waves[i].Status = skipWave(waves[i], stopped)
+			continue
+		}
+
+		waves[i].Status = runWave(ctx, cancel, client, waves[i], opts, launch)
+		printStatus(opts.Progress, waves[i])
+
+		if opts.MinSuccessRatio > 0 && unreachableRatio(waves[:i+1], len(client.Spoke), opts.MinSuccessRatio) {
+			stopped = fmt.Sprintf("success ratio can no longer reach %.2f", opts.MinSuccessRatio)
+			log.Errorf("Stopping the backup: %s", stopped)
+			continue
+		}
+
+		if waves[i].Name == "canary" {
+			ratio := successRatio(waves[i].Status)
+			if ratio < opts.CanaryThreshold {
+				stopped = fmt.Sprintf("canary success rate %.2f below threshold %.2f", ratio, opts.CanaryThreshold)
+				log.Errorf("Stopping the backup: %s", stopped)
+				continue
 			}
-			fmt.Printf("The value received from chan: %s is %s and %v\n", v, retStatus, err)
-			mu.Unlock()
-		}(client, v, ch, &wg)
+			log.Infof("Canary success rate %.2f meets threshold %.2f, carrying on", ratio, opts.CanaryThreshold)
+		}
 	}
-	wg.Wait()
-	close(ch)
+	return waves, nil
+}
 
-	fmt.Println(strings.Repeat("-", 85))
-	w := tabwriter.NewWriter(os.Stdout, 10, 0, 0, ' ', tabwriter.Debug)
+// printStatus prints the per-cluster results of a wave
+func printStatus(out io.Writer, wave Wave) {
+	fmt.Fprintln(out, strings.Repeat("-", 85))
+	fmt.Fprintf(out, "Wave: %s\n", wave.Name)
+	w := tabwriter.NewWriter(out, 10, 0, 0, ' ', tabwriter.Debug)
 	fmt.Fprintln(w, "Cluster Name\tCluster Status\t Error\t")
-	for _, v := range status {
-		fmt.Fprintln(w, v.ClusterName, "\t", v.ClusterStatus, "\t", v.ClusterError, "\t")
+	for _, v := range wave.Status {
+		clusterError := v.ClusterError
+		if clusterError == "" {
+			clusterError = metaclient1.NErr
+		}
+		fmt.Fprintln(w, v.ClusterName, "\t", v.ClusterStatus, "\t", clusterError, "\t")
 	}
 	w.Flush()
-	return nil
 }
 
-// launchBackupJobs calls various Client functions to launch k8s jobs to trigger backup
+// launchBackupJobs calls various Client functions to launch k8s jobs to trigger backup,
+// recording the phase reached and the hub objects used in the record
 // returns:			Job status, error
-func launchBackupJobs(client metaclient1.Client, name string, ch chan string, wg *sync.WaitGroup) (string, error) {
-
-	defer wg.Done()
+func launchBackupJobs(ctx context.Context, client metaclient1.Client, record *Status) (string, error) {
+	name := record.ClusterName
 
 	log.SetFormatter(&log.JSONFormatter{})
 	log.SetLevel(log.DebugLevel)
+
+	if err := ctx.Err(); err != nil {
+		return metaclient1.Interrupted, fmt.Errorf("backup of cluster %s interrupted before launch: %s", name, err)
+	}
+
 	// check whether the spoke exists
-	if !client.SpokeClusterExists(name) {
+	record.Phase = PhaseCheckCluster
+	if !client.SpokeClusterExists(ctx, name) {
 		return metaclient1.NExist, fmt.Errorf("cluster %s does not exist", name)
 
 	}
 	log.Info("Cluster exists!")
 
-	log.Info("Creating Kubernetes objects")
+	return runSpokeJob(ctx, client, record, client.BackupJobTemplates())
+}
 
-	err := client.LaunchKubernetesObjects(name, metaclient1.ActionCreateTemplates)
-	if err != nil {
-		log.Errorf("Couldn't launch k8s ManagedClusterAction objects in the %s cluster err: %s", name, err)
-		log.Info("Deleting all mca objects")
-		if _, err = client.ManageObjects(name, metaclient1.ActionCreateTemplates, metaclient1.MCA, "delete"); err != nil {
-			return metaclient1.Failed, fmt.Errorf("couldn't delete k8s ManagedClusterAction objects in the %s cluster err: %s", name, err)
-			//	return err
-		}
-		return name, err
+// runResult maps the outcome of the run to the exit code of the CLI
+// returns:			nil when the run succeeded, *ExitError otherwise
+func runResult(summary Summary, minSuccessRatio float64) error {
+	if summary.Succeeded == summary.Total {
+		return nil
+	}
+	if minSuccessRatio > 0 && float64(summary.Succeeded) >= minSuccessRatio*float64(summary.Total) {
+		log.Warnf("Backup failed on %d of %d spoke clusters, within the min success ratio %.2f", summary.Total-summary.Succeeded, summary.Total, minSuccessRatio)
+		return nil
 	}
-	log.Info("Successfully created all K8s mca objects")
+	err := fmt.Errorf("backup didn't succeed on %d of %d spoke clusters", summary.Total-summary.Succeeded, summary.Total)
+	if summary.Succeeded == 0 {
+		return &ExitError{ExitAllFailed, err}
+	}
+	return &ExitError{ExitPartialFailure, err}
+}
 
-	// create managedclusterview object
-	_, err = client.ManageObjects(name, metaclient1.ViewCreateTemplates, metaclient1.MCV, "get")
-	if err != nil {
-		if errors.IsAlreadyExists(err) {
-			_, err = client.ManageObjects(name, metaclient1.ViewCreateTemplates, metaclient1.MCV, "delete")
-			if err != nil {
-				return metaclient1.Failed, fmt.Errorf("couldn't delete existing ManagedclusterView object in the %s cluster err: %s", name, err)
-				//	return err
-			}
-		}
-		if errors.IsNotFound(err) {
-			err = client.LaunchKubernetesObjects(name, metaclient1.ViewCreateTemplates)
-			if err != nil {
-				return metaclient1.Failed, fmt.Errorf("couldn't launch k8s ManagedclusterView object the %s cluster err: %s", name, err)
-				//	return err
+// interruptedSpokes lists the spokes whose backup was interrupted
+// returns:			[]string
+func interruptedSpokes(waves []Wave) []string {
+	spokes := []string{}
+	for _, wave := range waves {
+		for _, v := range wave.Status {
+			if v.ClusterStatus == metaclient1.Interrupted {
+				spokes = append(spokes, v.ClusterName)
 			}
 		}
 	}
-	log.Info("Successfully created ManagedclusterView object")
+	return spokes
+}
 
-	// check job status via managedclusterview
-	err = client.JobStatus(name, metaclient1.Launch)
-	if err != nil {
-		return metaclient1.Failed, fmt.Errorf("couldn't verify the initiation of the job, err: %s", err)
+// validateSpokeFlags makes sure the spokes are either listed by name or selected, but not both
+// returns:			error
+func validateSpokeFlags(spoke string, selector string, clusterSet string) error {
+	if spoke == "" && selector == "" && clusterSet == "" {
+		return fmt.Errorf("one of --Spoke, --selector or --cluster-set must be provided")
 	}
+	if spoke != "" && (selector != "" || clusterSet != "") {
+		return fmt.Errorf("--Spoke cannot be combined with --selector or --cluster-set")
+	}
+	return nil
+}
 
-	err = client.JobStatus(name, metaclient1.Complete)
+// validateBackupPath refuses the backup paths the spokes would refuse, outside of their recovery partition
+// returns:			clean path, error
+func validateBackupPath(path string) (string, error) {
+	path, err := metaclient1.ValidateBackupPath(path)
 	if err != nil {
-		return metaclient1.Failed, fmt.Errorf("couldn't verify if the job has finished, err: %s", err)
+		return "", fmt.Errorf("invalid --BackupPath: %s", err)
 	}
+	return path, nil
+}
 
-	// delete managedclusterview
-	_, err = client.ManageObjects(name, metaclient1.ViewCreateTemplates, metaclient1.MCV, "delete")
+// resolveSpokes lists the managedclusters matching the selector and/or managedclusterset on the hub
+// returns:			cluster names, error
+func resolveSpokes(ctx context.Context, client metaclient1.Client, selector string, clusterSet string, out io.Writer) ([]string, error) {
+	spokes, err := client.ListSpokeClusters(ctx, selector, clusterSet)
 	if err != nil {
-		return metaclient1.Failed, fmt.Errorf("couldn't delete existing ManagedclusterView object in the %s cluster err: %s", name, err)
+		return nil, err
+	}
+	if len(spokes) == 0 {
+		return nil, fmt.Errorf("no managedcluster matches selector %q and cluster set %q", selector, clusterSet)
 	}
 
-	//delete the namespace in the spoke, which will delete the completed job and associated pod.
-	err = client.LaunchKubernetesObjects(name, metaclient1.JobDeleteTemplates)
-	if err != nil {
-		return metaclient1.Failed, fmt.Errorf("couldn't launch k8 objects in the %s cluster err: %s", name, err)
-		//	return err
+	fmt.Fprintf(out, "Resolved %d spoke cluster(s): %s\n", len(spokes), strings.Join(spokes, ", "))
+	return spokes, nil
+}
+
+// generationLabel restricts the labels of the backup generations, as the backup image does
+var generationLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// backupArgsFromFlags reads the retention flags passed to the backup job
+// returns:			backup job arguments, error
+func backupArgsFromFlags() ([]string, error) {
+	keep := viper.GetInt("keep")
+	if keep < 1 {
+		return nil, fmt.Errorf("--keep must be at least 1")
+	}
+	args := []string{"--keep", strconv.Itoa(keep)}
+
+	if viper.GetBool("pin") {
+		args = append(args, "--pin")
+	}
+	if name := viper.GetString("name"); name != "" {
+		if !generationLabel.MatchString(name) {
+			return nil, fmt.Errorf("invalid --name %q, expecting lower case letters, digits, dots and dashes", name)
+		}
+		args = append(args, "--name", name)
+	}
+	switch format := viper.GetString("format"); format {
+	case "raw":
+	case "gzip", "zstd":
+		args = append(args, "--format", format)
+	default:
+		return nil, fmt.Errorf("unsupported --format %q, expecting raw, gzip or zstd", format)
+	}
+	if viper.GetString("encryption-secret") != "" && viper.GetString("format") == "raw" {
+		return nil, fmt.Errorf("--encryption-secret stores the backups as archives, --format gzip or zstd is required")
 	}
-	log.Info("Successfully deleted all Kubernetes objects")
+	if !viper.GetBool("dedup") {
+		args = append(args, "--dedup=false")
+	}
+	if ref := viper.GetString("push-oci"); ref != "" {
+		if err := metaclient1.ValidateImage(ref); err != nil {
+			return nil, err
+		}
+		args = append(args, "--push-oci", ref)
+	}
+	return args, nil
+}
+
+// publishCGUResults writes the backup result of every cluster of the clustergroupupgrade on the hub, where its
+// owner can read them. Results are published even when the run was interrupted
+// returns:			error
+func publishCGUResults(client metaclient1.Client, plan metaclient1.CGUPlan, report Report) error {
+	ctx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+	defer cancel()
 
-	return metaclient1.Done, nil
+	results := map[string]string{}
+	for _, v := range report.Clusters {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		results[v.ClusterName] = string(data)
+	}
+	summary := fmt.Sprintf("%d/%d succeeded, %d failed, %d skipped, %d interrupted, finished at %s", report.Summary.Succeeded,
+		report.Summary.Total, report.Summary.Failed, report.Summary.Skipped, report.Summary.Interrupted, report.EndTime.UTC().Format(time.RFC3339))
+	return client.PublishCGUResults(ctx, plan, results, summary)
 }
 
 var triggerBackupCmd = &cobra.Command{
-	Use:   "triggerBackup",
-	Short: "It will trigger the backup of the resources in the spoke cluster",
+	Use:     "triggerBackup",
+	Short:   "It will trigger the backup of the resources in the spoke cluster",
+	PreRunE: bindFlags,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// get spoke cluster
-		Spoke, _ := cmd.Flags().GetString("Spoke")
-		splittedParam := strings.Split(Spoke, ",")
-		Clustername := []string{}
-		for _, v := range splittedParam {
-			Clustername = append(Clustername, strings.TrimSpace(v))
-		}
+		// stop polling and tear down the hub artifacts on SIGINT/SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-		BackupPath, _ := cmd.Flags().GetString("BackupPath")
-		KubeconfigPath, _ := cmd.Flags().GetString("KubeconfigPath")
+		opts, output, reportFile, err := launchOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
 
-		client, err := metaclient1.New(Clustername, BackupPath, KubeconfigPath)
+		backupArgs, err := backupArgsFromFlags()
 		if err != nil {
 			return err
 		}
 
-		//	err = launchBackupJobs(client)
-		err = multiSpokeLaunch(client)
+		cgu := viper.GetString("cgu")
+		if cgu != "" && (opts.BatchSize > 0 || opts.CanarySize > 0) {
+			return fmt.Errorf("--cgu follows the batches of the clustergroupupgrade, it cannot be combined with --batch-size or --canary")
+		}
+
+		client, err := newSpokeClient(ctx, cmd, opts.Progress)
 		if err != nil {
 			return err
 		}
+		client.BackupArgs = backupArgs
 
-		return nil
+		// from now on, errors are about the run, not about the usage
+		cmd.SilenceUsage = true
+
+		if cgu != "" {
+			plan, err := client.GetCGUPlan(ctx, cgu)
+			if err != nil {
+				return err
+			}
+			client.Spoke = plan.Clusters
+			opts.Batches = plan.Batches
+			if !cmd.Flags().Changed("max-concurrency") && plan.MaxConcurrency > 0 {
+				opts.MaxConcurrency = plan.MaxConcurrency
+			}
+			fmt.Fprintf(opts.Progress, "Clustergroupupgrade %s: %d spoke cluster(s) in %d batch(es)\n", cgu, len(plan.Clusters), len(plan.Batches))
+			opts.Publish = func(report Report) error {
+				return publishCGUResults(client, plan, report)
+			}
+		}
+
+		//	err = launchBackupJobs(client)
+		return runLaunch(ctx, "triggerBackup", client, launchBackupJobs, opts, output, reportFile)
 	},
 }
 
@@ -184,20 +336,10 @@ func init() {
 
 	rootCmd.AddCommand(triggerBackupCmd)
 
-	triggerBackupCmd.Flags().StringP("Spoke", "s", "", "Name of the Spoke cluster")
-	if err := triggerBackupCmd.MarkFlagRequired("Spoke"); err != nil {
-		return
-	}
-
-	triggerBackupCmd.Flags().StringP("KubeconfigPath", "k", "", "Path to kubeconfig file")
-	if err := triggerBackupCmd.MarkFlagRequired("KubeconfigPath"); err != nil {
-		return
-	}
-
-	triggerBackupCmd.Flags().StringP("BackupPath", "p", "/var/recovery", "Path of recovery partition where backups will be stored")
+	addSpokeFlags(triggerBackupCmd)
+	addJobFlags(triggerBackupCmd, metaclient1.DefaultCompletionTimeout)
+	addLaunchFlags(triggerBackupCmd)
+	addBackupFlags(triggerBackupCmd)
 
-	// bind to viper
-	_ = viper.BindPFlag("Spoke", triggerBackupCmd.Flags().Lookup("Spoke"))
-	_ = viper.BindPFlag("BackupPath", triggerBackupCmd.Flags().Lookup("BackupPath"))
-	_ = viper.BindPFlag("KubeconfigPath", triggerBackupCmd.Flags().Lookup("KubeconfigPath"))
+	triggerBackupCmd.Flags().String("cgu", "", "ClusterGroupUpgrade on the hub, as namespace/name, whose clusters are backed up in its batches, with its maxConcurrency")
 }
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+)
+
+func TestValidateSpokeFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		spoke      string
+		selector   string
+		clusterSet string
+		wantErr    bool
+	}{
+		{name: "spoke", spoke: "sno1,sno2"},
+		{name: "selector", selector: "du-profile=site-a"},
+		{name: "cluster set", clusterSet: "prod"},
+		{name: "selector and cluster set", selector: "du-profile=site-a", clusterSet: "prod"},
+		{name: "nothing", wantErr: true},
+		{name: "spoke and selector", spoke: "sno1", selector: "du-profile=site-a", wantErr: true},
+		{name: "spoke and cluster set", spoke: "sno1", clusterSet: "prod", wantErr: true},
+		{name: "spoke, selector and cluster set", spoke: "sno1", selector: "du-profile=site-a", clusterSet: "prod", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSpokeFlags(tt.spoke, tt.selector, tt.clusterSet); (err != nil) != tt.wantErr {
+				t.Errorf("validateSpokeFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveSpokes(t *testing.T) {
+	client := newFakeClient(
+		managedCluster("sno2", map[string]string{"du-profile": "site-a", metaclient1.ClusterSetLabel: "prod"}),
+		managedCluster("sno1", map[string]string{"du-profile": "site-a", metaclient1.ClusterSetLabel: "prod"}),
+		managedCluster("sno3", map[string]string{"du-profile": "site-a", metaclient1.ClusterSetLabel: "lab"}),
+	)
+	tests := []struct {
+		name       string
+		selector   string
+		clusterSet string
+		want       []string
+		wantOut    string
+		wantErr    bool
+	}{
+		{name: "selector", selector: "du-profile=site-a", want: []string{"sno1", "sno2", "sno3"},
+			wantOut: "Resolved 3 spoke cluster(s): sno1, sno2, sno3\n"},
+		{name: "selector and cluster set", selector: "du-profile=site-a", clusterSet: "prod", want: []string{"sno1", "sno2"},
+			wantOut: "Resolved 2 spoke cluster(s): sno1, sno2\n"},
+		{name: "no match", clusterSet: "staging", wantErr: true},
+		{name: "invalid selector", selector: "du-profile in (site-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := resolveSpokes(context.Background(), client, tt.selector, tt.clusterSet, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSpokes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveSpokes() = %v, want %v", got, tt.want)
+			}
+			if out.String() != tt.wantOut {
+				t.Errorf("resolveSpokes() printed %q, want %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	tests := []struct {
+		name            string
+		summary         Summary
+		minSuccessRatio float64
+		want            int
+	}{
+		{name: "all succeeded", summary: Summary{Total: 3, Succeeded: 3}, want: ExitSuccess},
+		{name: "no spoke", summary: Summary{}, want: ExitSuccess},
+		{name: "some failed", summary: Summary{Total: 3, Succeeded: 2, Failed: 1}, want: ExitPartialFailure},
+		{name: "all failed", summary: Summary{Total: 3, Failed: 3}, want: ExitAllFailed},
+		{name: "skipped and interrupted", summary: Summary{Total: 3, Succeeded: 1, Skipped: 1, Interrupted: 1}, want: ExitPartialFailure},
+		{name: "all interrupted", summary: Summary{Total: 2, Interrupted: 2}, want: ExitAllFailed},
+		{name: "within the min success ratio", summary: Summary{Total: 4, Succeeded: 3, Failed: 1}, minSuccessRatio: 0.75, want: ExitSuccess},
+		{name: "below the min success ratio", summary: Summary{Total: 4, Succeeded: 2, Failed: 2}, minSuccessRatio: 0.75, want: ExitPartialFailure},
+		{name: "none succeeded with a min success ratio", summary: Summary{Total: 4, Failed: 4}, minSuccessRatio: 0.5, want: ExitAllFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runResult(tt.summary, tt.minSuccessRatio)
+			code := ExitSuccess
+			if err != nil {
+				// the exit code is found through the errors wrapping it, as Execute does
+				var exitErr *ExitError
+				if !errors.As(fmt.Errorf("wrapped: %w", err), &exitErr) {
+					t.Fatalf("runResult() error = %v, want an *ExitError", err)
+				}
+				code = exitErr.Code
+			}
+			if code != tt.want {
+				t.Errorf("runResult() exit code = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
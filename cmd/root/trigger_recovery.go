@@ -0,0 +1,186 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages of the recovery of a spoke cluster, as recorded by upgrade-recovery.sh
+const (
+	StageRestoreFiles   = "restore_files"
+	StageReboot         = "reboot"
+	StageRestoreCluster = "restore_cluster"
+	StagePostRestore    = "post_restore_steps"
+)
+
+// generationName matches the names of the backup generations, the time of the backup followed by an optional label
+var generationName = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z(-[a-z0-9]([a-z0-9.-]*[a-z0-9])?)?$`)
+
+// RecoveryOptions controls how the recovery goes through its stages
+type RecoveryOptions struct {
+	// Resume skips the stages up to the reboot, for spokes which already came back Available
+	Resume        bool
+	RebootTimeout time.Duration
+	Progress      io.Writer
+}
+
+// reportStage records the stage reached by the recovery of a spoke and reports it
+func reportStage(opts RecoveryOptions, record *Status, stage string) {
+	record.Stage = stage
+	fmt.Fprintf(opts.Progress, "%s cluster %s: %s\n", time.Now().UTC().Format(time.RFC3339), record.ClusterName, stage)
+}
+
+// launchRecoveryJobs returns the launcher running the recovery of a spoke stage by stage:
+// the recovery job restores the files and reboots the node, the spoke restores the cluster while booting,
+// and once it is back Available a second recovery job runs the post restore steps
+// returns:			spokeLauncher
+func launchRecoveryJobs(opts RecoveryOptions) spokeLauncher {
+	return func(ctx context.Context, client metaclient1.Client, record *Status) (string, error) {
+		name := record.ClusterName
+
+		log.SetFormatter(&log.JSONFormatter{})
+		log.SetLevel(log.DebugLevel)
+
+		if err := ctx.Err(); err != nil {
+			return metaclient1.Interrupted, fmt.Errorf("recovery of cluster %s interrupted before launch: %s", name, err)
+		}
+
+		// check whether the spoke exists
+		record.Phase = PhaseCheckCluster
+		if !client.SpokeClusterExists(ctx, name) {
+			return metaclient1.NExist, fmt.Errorf("cluster %s does not exist", name)
+		}
+
+		templates := client.RecoveryJobTemplates()
+		if !opts.Resume {
+			reportStage(opts, record, StageRestoreFiles)
+			if status, err := runSpokeJob(ctx, client, record, templates); err != nil {
+				return status, fmt.Errorf("stage %s failed: %s", StageRestoreFiles, err)
+			}
+
+			// the node reboots once the files are restored, then restores the cluster while booting
+			reportStage(opts, record, StageReboot)
+			if err := client.WaitForSpokeAvailability(ctx, name, false, opts.RebootTimeout); err != nil {
+				return stageFailure(ctx, StageReboot, err)
+			}
+
+			reportStage(opts, record, StageRestoreCluster)
+			if err := client.WaitForSpokeAvailability(ctx, name, true, opts.RebootTimeout); err != nil {
+				return stageFailure(ctx, StageRestoreCluster, err)
+			}
+		}
+
+		reportStage(opts, record, StagePostRestore)
+		if status, err := runSpokeJob(ctx, client, record, templates); err != nil {
+			return status, fmt.Errorf("stage %s failed: %s", StagePostRestore, err)
+		}
+
+		fmt.Fprintf(opts.Progress, "%s cluster %s: recovery complete\n", time.Now().UTC().Format(time.RFC3339), name)
+		return metaclient1.Done, nil
+	}
+}
+
+// stageFailure maps the failure of a stage waiting for the spoke to the Job status
+// returns:			Job status, error
+func stageFailure(ctx context.Context, stage string, err error) (string, error) {
+	if ctx.Err() != nil {
+		return metaclient1.Interrupted, fmt.Errorf("recovery interrupted during stage %s: %s", stage, err)
+	}
+	return metaclient1.Failed, fmt.Errorf("stage %s failed: %s", stage, err)
+}
+
+var triggerRecoveryCmd = &cobra.Command{
+	Use:     "triggerRecovery",
+	Short:   "It will trigger the recovery of the spoke cluster from its backup",
+	PreRunE: bindFlags,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// stop polling and tear down the hub artifacts on SIGINT/SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		opts, output, reportFile, err := launchOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		recovery := RecoveryOptions{
+			Resume:        viper.GetBool("resume"),
+			RebootTimeout: viper.GetDuration("reboot-timeout"),
+			Progress:      opts.Progress,
+		}
+		if recovery.RebootTimeout <= 0 {
+			return fmt.Errorf("--reboot-timeout must be positive")
+		}
+
+		client, err := newSpokeClient(ctx, cmd, opts.Progress)
+		if err != nil {
+			return err
+		}
+		if viper.GetBool("fetch") {
+			if len(client.S3SecretData) == 0 {
+				return fmt.Errorf("--fetch needs --s3-secret")
+			}
+			client.RecoveryArgs = append(client.RecoveryArgs, "--fetch")
+		}
+		if viper.GetBool("force") {
+			client.RecoveryArgs = append(client.RecoveryArgs, "--force")
+		}
+		if generation := viper.GetString("generation"); generation != "" {
+			if !generationName.MatchString(generation) {
+				return fmt.Errorf("invalid --generation %q, expecting a name listed by listBackups", generation)
+			}
+			client.RecoveryArgs = append(client.RecoveryArgs, "--generation", generation)
+		}
+
+		// from now on, errors are about the run, not about the usage
+		cmd.SilenceUsage = true
+
+		return runLaunch(ctx, "triggerRecovery", client, launchRecoveryJobs(recovery), opts, output, reportFile)
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(triggerRecoveryCmd)
+
+	addSpokeFlags(triggerRecoveryCmd)
+	addJobFlags(triggerRecoveryCmd, 90*time.Minute)
+	addLaunchFlags(triggerRecoveryCmd)
+
+	triggerRecoveryCmd.Flags().Bool("resume", false, "Resume the recovery of spokes which already came back Available after the reboot")
+	triggerRecoveryCmd.Flags().String("generation", "", "Name of the backup generation to restore, as listed by listBackups (default is the current one)")
+	triggerRecoveryCmd.Flags().String("encryption-secret", "", "Secret on the hub, as namespace/name, whose key is propagated to the spokes to decrypt the backup")
+	triggerRecoveryCmd.Flags().String("s3-secret", "", "Secret on the hub, as namespace/name, locating the S3 bucket the backups are exported to, with its credentials")
+	triggerRecoveryCmd.Flags().Bool("fetch", false, "Fetch the backup from the S3 bucket when the spoke doesn't hold it anymore")
+	triggerRecoveryCmd.Flags().Bool("force", false, "Skip the check that the platform has been rolled back to the pinned ostree deployment")
+	triggerRecoveryCmd.Flags().Duration("reboot-timeout", 45*time.Minute, "Maximum time to wait for a spoke to go down, then to come back Available, around the reboot")
+}
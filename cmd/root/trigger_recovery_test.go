@@ -0,0 +1,203 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// recoveryWork is the name of the manifestwork of the recovery job
+const recoveryWork = "recovery-job"
+
+// completeRecoveryJob waits for the manifestwork of the recovery job of a cluster, then reports the job succeeded
+// and waits for its teardown
+func completeRecoveryJob(t *testing.T, client metaclient1.Client, cluster string) {
+	t.Helper()
+	works := client.KubernetesClient.Resource(metaclient1.ManifestWorkGVR).Namespace(cluster)
+	eventually(t, "the recovery job is created", func() bool {
+		_, err := works.Get(context.Background(), recoveryWork, v1.GetOptions{})
+		return err == nil
+	})
+	setWorkStatus(t, client, cluster, recoveryWork, map[string]int64{"succeeded": 1, "failed": 0, "active": 0})
+	eventually(t, "the recovery job is torn down", func() bool {
+		_, err := works.Get(context.Background(), recoveryWork, v1.GetOptions{})
+		return err != nil
+	})
+}
+
+// setAvailability sets the status of the Available condition of a managedcluster, then waits for the hub to read it
+func setAvailability(t *testing.T, client metaclient1.Client, cluster string, status string) {
+	t.Helper()
+	obj := availableCluster(cluster)
+	obj.Object["status"].(map[string]interface{})["conditions"].([]interface{})[0].(map[string]interface{})["status"] = status
+	clusters := client.KubernetesClient.Resource(metaclient1.ManagedClusterGVR)
+	current, err := clusters.Get(context.Background(), cluster, v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Object["status"] = obj.Object["status"]
+	if _, err := clusters.Update(context.Background(), current, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	read := countActions(client, "get", metaclient1.ManagedClusterGVR)
+	eventually(t, "the availability is read", func() bool {
+		return countActions(client, "get", metaclient1.ManagedClusterGVR) > read
+	})
+}
+
+func TestLaunchRecoveryJobs(t *testing.T) {
+	tests := []struct {
+		name   string
+		resume bool
+		// hubDown makes the managedcluster unreadable once the files are restored
+		hubDown bool
+		// spoke plays the part of the spoke, and of its work agent, while the recovery runs
+		spoke  func(t *testing.T, client metaclient1.Client)
+		status string
+		stages []string
+		jobs   int
+		err    string
+	}{
+		{
+			name: "recovery goes through every stage",
+			spoke: func(t *testing.T, client metaclient1.Client) {
+				completeRecoveryJob(t, client, "spoke1")
+				setAvailability(t, client, "spoke1", "Unknown")
+				setAvailability(t, client, "spoke1", "True")
+				completeRecoveryJob(t, client, "spoke1")
+			},
+			status: metaclient1.Done,
+			stages: []string{StageRestoreFiles, StageReboot, StageRestoreCluster, StagePostRestore},
+			jobs:   2,
+		},
+		{
+			name:   "resumed recovery only runs the post restore steps",
+			resume: true,
+			spoke: func(t *testing.T, client metaclient1.Client) {
+				completeRecoveryJob(t, client, "spoke1")
+			},
+			status: metaclient1.Done,
+			stages: []string{StagePostRestore},
+			jobs:   1,
+		},
+		{
+			name: "spoke which doesn't reboot fails the reboot stage",
+			spoke: func(t *testing.T, client metaclient1.Client) {
+				completeRecoveryJob(t, client, "spoke1")
+			},
+			status: metaclient1.Failed,
+			stages: []string{StageRestoreFiles, StageReboot},
+			jobs:   1,
+			err:    "stage reboot failed: cluster spoke1 availability didn't become false",
+		},
+		{
+			name:    "unreadable managedcluster isn't taken for the reboot",
+			hubDown: true,
+			spoke: func(t *testing.T, client metaclient1.Client) {
+				completeRecoveryJob(t, client, "spoke1")
+			},
+			status: metaclient1.Failed,
+			stages: []string{StageRestoreFiles, StageReboot},
+			jobs:   1,
+			err:    "last: connection refused",
+		},
+		{
+			name: "spoke which doesn't come back fails the restore stage",
+			spoke: func(t *testing.T, client metaclient1.Client) {
+				completeRecoveryJob(t, client, "spoke1")
+				setAvailability(t, client, "spoke1", "Unknown")
+			},
+			status: metaclient1.Failed,
+			stages: []string{StageRestoreFiles, StageReboot, StageRestoreCluster},
+			jobs:   1,
+			err:    "stage restore_cluster failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newWorkClient(availableCluster("spoke1"))
+			var down int32
+			client.KubernetesClient.(*fake.FakeDynamicClient).PrependReactor("get", "managedclusters", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if atomic.LoadInt32(&down) == 1 {
+					return true, nil, fmt.Errorf("connection refused")
+				}
+				return false, nil, nil
+			})
+
+			var progress bytes.Buffer
+			opts := RecoveryOptions{Resume: test.resume, RebootTimeout: 10 * time.Second, Progress: &progress}
+			if test.err != "" {
+				opts.RebootTimeout = 100 * time.Millisecond
+			}
+
+			type result struct {
+				status string
+				err    error
+			}
+			done := make(chan result)
+			record := &Status{ClusterName: "spoke1"}
+			go func() {
+				status, err := launchRecoveryJobs(opts)(context.Background(), client, record)
+				done <- result{status, err}
+			}()
+			test.spoke(t, client)
+			if test.hubDown {
+				atomic.StoreInt32(&down, 1)
+			}
+
+			var got result
+			select {
+			case got = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the recovery")
+			}
+			if got.status != test.status {
+				t.Errorf("status = %q, want %q (error: %v)", got.status, test.status, got.err)
+			}
+			if test.err == "" && got.err != nil {
+				t.Errorf("unexpected error: %s", got.err)
+			}
+			if test.err != "" && (got.err == nil || !strings.Contains(got.err.Error(), test.err)) {
+				t.Errorf("error = %v, want it to contain %q", got.err, test.err)
+			}
+			stages := regexp.MustCompile(`cluster spoke1: ([a-z_]+)\n`).FindAllStringSubmatch(progress.String(), -1)
+			reported := []string{}
+			for _, stage := range stages {
+				reported = append(reported, stage[1])
+			}
+			if strings.Join(reported, ",") != strings.Join(test.stages, ",") {
+				t.Errorf("stages = %v, want %v", reported, test.stages)
+			}
+			if jobs := countActions(client, "create", metaclient1.ManifestWorkGVR); jobs != test.jobs {
+				t.Errorf("%d recovery jobs launched, want %d", jobs, test.jobs)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UpgradeWatcher follows the clusterversion of the spokes and backs them up before their upgrades. The gate of every
+// spoke is recorded on its managedcluster, so that the watcher keeps no state across restarts besides the retries
+type UpgradeWatcher struct {
+	client     metaclient1.Client
+	selector   string
+	clusterSet string
+	// retry is the minimum interval between two backups of a spoke whose backup failed
+	retry time.Duration
+	// named backs up under a generation named after the upgrade when --name isn't set
+	named bool
+
+	mu       sync.Mutex
+	running  map[string]bool
+	attempts map[string]time.Time
+	slots    chan struct{}
+	wg       sync.WaitGroup
+
+	// viewed lists the spokes whose clusterversion view may be on the hub, only used by check and Run
+	viewed map[string]bool
+}
+
+// Run checks the spokes every interval until the context is cancelled, then waits for the backups in flight, which
+// are torn down, and deletes the clusterversion views
+func (w *UpgradeWatcher) Run(ctx context.Context, interval time.Duration) {
+	log.Infof("Watching the upgrades of the spokes every %s", interval)
+	wait.UntilWithContext(ctx, w.check, interval)
+	w.wg.Wait()
+
+	teardownCtx, cancel := context.WithTimeout(context.Background(), teardownTimeout)
+	defer cancel()
+	w.unwatch(teardownCtx, nil)
+}
+
+// unwatch deletes the clusterversion views of the spokes which aren't watched anymore, they are retried at the next
+// check when the deletion fails
+func (w *UpgradeWatcher) unwatch(ctx context.Context, spokes []string) {
+	watched := map[string]bool{}
+	for _, name := range spokes {
+		watched[name] = true
+	}
+	for name := range w.viewed {
+		if watched[name] {
+			continue
+		}
+		if err := w.client.DeleteClusterVersionView(ctx, name); err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Debugf("Stopped watching the clusterversion of cluster %s", name)
+		delete(w.viewed, name)
+	}
+}
+
+// spokes lists the spokes to watch, selected again at every check so that new spokes are followed
+// returns:			cluster names, error
+func (w *UpgradeWatcher) spokes(ctx context.Context) ([]string, error) {
+	if w.selector == "" && w.clusterSet == "" {
+		return w.client.Spoke, nil
+	}
+	return w.client.ListSpokeClusters(ctx, w.selector, w.clusterSet)
+}
+
+// check updates the upgrade gate of every spoke, and starts a backup on the spokes with a staged upgrade and no
+// backup of their current version
+func (w *UpgradeWatcher) check(ctx context.Context) {
+	spokes, err := w.spokes(ctx)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	w.unwatch(ctx, spokes)
+
+	for _, name := range spokes {
+		w.mu.Lock()
+		running := w.running[name]
+		w.mu.Unlock()
+		if running {
+			continue
+		}
+
+		w.viewed[name] = true
+		version, err := w.client.SpokeClusterVersion(ctx, name)
+		if err != nil {
+			log.Debugf("Couldn't read the clusterversion of cluster %s: %s", name, err)
+			continue
+		}
+		gate, err := w.client.GetUpgradeGate(ctx, name)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		if gate.BackupVersion == version.Current {
+			w.setGate(ctx, name, gate, metaclient1.BackupGate(version.Current, parseGateTime(gate.BackupTime)))
+			continue
+		}
+		closed := metaclient1.UpgradeGate{Message: fmt.Sprintf("no backup of version %s", version.Current)}
+		if !version.Staged() {
+			w.setGate(ctx, name, gate, closed)
+			continue
+		}
+
+		w.mu.Lock()
+		last, retried := w.attempts[name]
+		w.mu.Unlock()
+		if retried && time.Since(last) < w.retry {
+			continue
+		}
+		log.Infof("Upgrade of cluster %s from %s to %s staged without a backup of %s, backing it up", name, version.Current, version.Desired, version.Current)
+		closed.Message = fmt.Sprintf("upgrade to %s staged, backing up version %s", version.Desired, version.Current)
+		if !w.setGate(ctx, name, gate, closed) {
+			continue
+		}
+
+		w.mu.Lock()
+		w.running[name] = true
+		w.attempts[name] = time.Now()
+		w.mu.Unlock()
+		w.wg.Add(1)
+		go w.backup(ctx, name, version)
+	}
+}
+
+// setGate records the gate of a spoke when it changed
+// returns:			false when it couldn't be recorded
+func (w *UpgradeWatcher) setGate(ctx context.Context, name string, current metaclient1.UpgradeGate, gate metaclient1.UpgradeGate) bool {
+	if gate.Safe == current.Safe && gate.Message == current.Message {
+		return true
+	}
+	if err := w.client.SetUpgradeGate(ctx, name, gate); err != nil {
+		log.Error(err)
+		return false
+	}
+	log.Infof("Upgrade gate of cluster %s: safe=%t, %s", name, gate.Safe, gate.Message)
+	return true
+}
+
+// backup backs up the current version of a spoke, then opens its upgrade gate
+func (w *UpgradeWatcher) backup(ctx context.Context, name string, version metaclient1.ClusterVersion) {
+	defer w.wg.Done()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, name)
+		w.mu.Unlock()
+	}()
+
+	select {
+	case w.slots <- struct{}{}:
+		defer func() { <-w.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	client := w.client
+	if w.named && generationLabel.MatchString("pre-"+version.Desired) {
+		client.BackupArgs = append(append([]string{}, client.BackupArgs...), "--name", "pre-"+version.Desired)
+	}
+	record := &Status{ClusterName: name, StartTime: time.Now()}
+	if _, err := launchBackupJobs(ctx, client, record); err != nil {
+		log.Errorf("Backup of cluster %s before its upgrade to %s failed in phase %s: %s", name, version.Desired, record.Phase, err)
+		gate := metaclient1.UpgradeGate{Message: fmt.Sprintf("backup of version %s failed, retrying in %s: %s", version.Current, w.retry, err)}
+		if err := w.client.SetUpgradeGate(context.Background(), name, gate); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+	w.openGate(ctx, name, version, time.Now())
+}
+
+// openGate records the backup of the current version of a spoke, the spoke is safe to upgrade
+func (w *UpgradeWatcher) openGate(ctx context.Context, name string, version metaclient1.ClusterVersion, taken time.Time) {
+	if err := w.client.SetUpgradeGate(ctx, name, metaclient1.BackupGate(version.Current, taken)); err != nil {
+		log.Error(err)
+		return
+	}
+	log.Infof("Cluster %s holds a backup of version %s, safe to upgrade to %s", name, version.Current, version.Desired)
+}
+
+// parseGateTime parses the time of the backup recorded on a managedcluster
+// returns:			time.Time
+func parseGateTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
+
+var watchUpgradesCmd = &cobra.Command{
+	Use:     "watchUpgrades",
+	Short:   "It will back up the spoke clusters whose upgrade is staged, and gate their upgrades on the backup",
+	PreRunE: bindFlags,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// stop watching and tear down the backups in flight on SIGINT/SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		interval := viper.GetDuration("interval")
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		retry := viper.GetDuration("retry-interval")
+		if retry < 0 {
+			return fmt.Errorf("--retry-interval must not be negative")
+		}
+		maxConcurrency := viper.GetInt("max-concurrency")
+		if maxConcurrency < 1 {
+			return fmt.Errorf("--max-concurrency must be at least 1")
+		}
+
+		backupArgs, err := backupArgsFromFlags()
+		if err != nil {
+			return err
+		}
+
+		client, err := newSpokeClient(ctx, cmd, os.Stdout)
+		if err != nil {
+			return err
+		}
+		client.BackupArgs = backupArgs
+
+		// from now on, errors are about the run, not about the usage
+		cmd.SilenceUsage = true
+
+		watcher := &UpgradeWatcher{
+			client:   client,
+			retry:    retry,
+			named:    viper.GetString("name") == "",
+			running:  map[string]bool{},
+			attempts: map[string]time.Time{},
+			slots:    make(chan struct{}, maxConcurrency),
+			viewed:   map[string]bool{},
+		}
+		if viper.GetString("Spoke") == "" {
+			watcher.selector = viper.GetString("selector")
+			watcher.clusterSet = viper.GetString("cluster-set")
+		}
+		watcher.Run(ctx, interval)
+		return nil
+	},
+}
+
+func init() {
+
+	rootCmd.AddCommand(watchUpgradesCmd)
+
+	addSpokeFlags(watchUpgradesCmd)
+	addJobFlags(watchUpgradesCmd, metaclient1.DefaultCompletionTimeout)
+	addBackupFlags(watchUpgradesCmd)
+
+	watchUpgradesCmd.Flags().Duration("interval", time.Minute, "Interval between two checks of the clusterversion of the spokes")
+	watchUpgradesCmd.Flags().Duration("retry-interval", 30*time.Minute, "Minimum interval between two backups of a spoke whose backup failed")
+	watchUpgradesCmd.Flags().Int("max-concurrency", 10, "Maximum number of spokes backed up at the same time")
+}
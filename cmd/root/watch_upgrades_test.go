@@ -0,0 +1,268 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// clusterVersionViewGVR represents the managedclusterviews on the hub
+var clusterVersionViewGVR = schema.GroupVersionResource{Group: "view.open-cluster-management.io", Version: "v1beta1", Resource: metaclient1.MCV}
+
+// clusterVersionView returns the clusterversion view of a spoke, desired being empty when no update is requested
+func clusterVersionView(cluster string, current string, desired string) *unstructured.Unstructured {
+	result := map[string]interface{}{
+		"status": map[string]interface{}{
+			"history": []interface{}{map[string]interface{}{"state": "Completed", "version": current}},
+		},
+	}
+	if desired != "" {
+		result["spec"] = map[string]interface{}{"desiredUpdate": map[string]interface{}{"version": desired}}
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterVersionViewGVR.GroupVersion().String(),
+		"kind":       "ManagedClusterView",
+		"status":     map[string]interface{}{"result": result},
+	}}
+	obj.SetName(metaclient1.ClusterVersionViewTemplates[0].ResourceName)
+	obj.SetNamespace(cluster)
+	return obj
+}
+
+// newWatcher returns a watcher of the spokes of a client, backing them up through manifestworks
+func newWatcher(client metaclient1.Client, retry time.Duration) *UpgradeWatcher {
+	client.Transport = metaclient1.TransportManifestWork
+	client.Poll = testSpec().PollOptions()
+	return &UpgradeWatcher{
+		client:   client,
+		retry:    retry,
+		running:  map[string]bool{},
+		attempts: map[string]time.Time{},
+		slots:    make(chan struct{}, 1),
+		viewed:   map[string]bool{},
+	}
+}
+
+// countActions counts the actions of a verb on a resource of the fake hub
+func countActions(client metaclient1.Client, verb string, resource schema.GroupVersionResource) int {
+	count := 0
+	for _, action := range client.KubernetesClient.(*fake.FakeDynamicClient).Actions() {
+		if action.GetVerb() == verb && action.GetResource() == resource {
+			count++
+		}
+	}
+	return count
+}
+
+// eventually polls done, failing the test after a while
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpgradeWatcherGate(t *testing.T) {
+	taken := time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		desired string
+		// gates are recorded on the managedcluster before the check
+		gates []metaclient1.UpgradeGate
+		// feedback is the status of the backup job, nil when no backup is expected
+		feedback map[string]int64
+		want     metaclient1.UpgradeGate
+	}{
+		{
+			name: "no upgrade staged",
+			want: metaclient1.UpgradeGate{Message: "no backup of version 4.10.3"},
+		},
+		{
+			name:  "backup of the current version",
+			gates: []metaclient1.UpgradeGate{metaclient1.BackupGate("4.10.3", taken), {Message: "no backup of version 4.10.3"}},
+			want:  metaclient1.BackupGate("4.10.3", taken),
+		},
+		{
+			name:  "backup of a previous version",
+			gates: []metaclient1.UpgradeGate{metaclient1.BackupGate("4.9.0", taken)},
+			want:  metaclient1.UpgradeGate{Message: "no backup of version 4.10.3", BackupVersion: "4.9.0", BackupTime: "2022-06-04T01:00:00Z"},
+		},
+		{
+			name:    "upgrade staged after a backup of the current version",
+			desired: "4.11.2",
+			gates:   []metaclient1.UpgradeGate{metaclient1.BackupGate("4.10.3", taken)},
+			want:    metaclient1.BackupGate("4.10.3", taken),
+		},
+		{
+			name:     "upgrade staged without a backup",
+			desired:  "4.11.2",
+			feedback: map[string]int64{"succeeded": 1},
+			want:     metaclient1.UpgradeGate{Safe: true, Message: "backup of version 4.10.3 taken", BackupVersion: "4.10.3"},
+		},
+		{
+			name:     "backup failed",
+			desired:  "4.11.2",
+			feedback: map[string]int64{"failed": 1},
+			want:     metaclient1.UpgradeGate{Message: "backup of version 4.10.3 failed, retrying in 1h0m0s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(availableCluster("spoke1"), clusterVersionView("spoke1", "4.10.3", tt.desired))
+			ctx := context.Background()
+			for _, gate := range tt.gates {
+				if err := client.SetUpgradeGate(ctx, "spoke1", gate); err != nil {
+					t.Fatal(err)
+				}
+			}
+			client.Spoke = []string{"spoke1"}
+			w := newWatcher(client, time.Hour)
+
+			w.check(ctx)
+			if tt.feedback != nil {
+				gate, _ := client.GetUpgradeGate(ctx, "spoke1")
+				if gate.Safe || gate.Message != "upgrade to 4.11.2 staged, backing up version 4.10.3" {
+					t.Errorf("gate during the backup = %+v", gate)
+				}
+				eventually(t, "the job is launched", func() bool {
+					return len(works(t, client)) == 1
+				})
+				setJobStatus(t, client, "spoke1", tt.feedback)
+				w.wg.Wait()
+			} else if creates := countActions(client, "create", metaclient1.ManifestWorkGVR); creates != 0 {
+				t.Errorf("%d backups started, want none", creates)
+			}
+
+			got, err := client.GetUpgradeGate(ctx, "spoke1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want.BackupTime == "" && got.BackupTime != "" {
+				if _, err := time.Parse(time.RFC3339, got.BackupTime); err != nil {
+					t.Errorf("invalid backup time %q", got.BackupTime)
+				}
+				got.BackupTime = ""
+			}
+			if strings.HasPrefix(got.Message, tt.want.Message+":") {
+				// the cause of the failure
+				got.Message = tt.want.Message
+			}
+			if got != tt.want {
+				t.Errorf("gate = %+v, want %+v", got, tt.want)
+			}
+
+			// nothing changed, the gate isn't patched again and no backup is started
+			patches, creates := countActions(client, "patch", metaclient1.ManagedClusterGVR), countActions(client, "create", metaclient1.ManifestWorkGVR)
+			w.check(ctx)
+			w.wg.Wait()
+			if n := countActions(client, "patch", metaclient1.ManagedClusterGVR); n != patches {
+				t.Errorf("gate patched %d more times", n-patches)
+			}
+			if n := countActions(client, "create", metaclient1.ManifestWorkGVR); n != creates {
+				t.Errorf("%d more backups started", n-creates)
+			}
+		})
+	}
+}
+
+func TestUpgradeWatcherRetry(t *testing.T) {
+	client := newFakeClient(availableCluster("spoke1"), clusterVersionView("spoke1", "4.10.3", "4.11.2"))
+	client.Spoke = []string{"spoke1"}
+	w := newWatcher(client, time.Hour)
+	ctx := context.Background()
+
+	w.check(ctx)
+	eventually(t, "the job is launched", func() bool {
+		return len(works(t, client)) == 1
+	})
+	setJobStatus(t, client, "spoke1", map[string]int64{"failed": 1})
+	w.wg.Wait()
+
+	// retried once the retry interval is over
+	w.retry = 0
+	w.check(ctx)
+	eventually(t, "the job is launched again", func() bool {
+		return countActions(client, "create", metaclient1.ManifestWorkGVR) == 2 && len(works(t, client)) == 1
+	})
+	setJobStatus(t, client, "spoke1", map[string]int64{"succeeded": 1})
+	w.wg.Wait()
+
+	if gate, _ := client.GetUpgradeGate(ctx, "spoke1"); !gate.Safe || gate.BackupVersion != "4.10.3" {
+		t.Errorf("gate = %+v, want a backup of 4.10.3", gate)
+	}
+}
+
+func TestUpgradeWatcherViews(t *testing.T) {
+	labels := map[string]string{"du-profile": "site-a"}
+	spoke1, spoke2 := availableCluster("spoke1"), availableCluster("spoke2")
+	spoke1.SetLabels(labels)
+	spoke2.SetLabels(labels)
+	client := newFakeClient(spoke1, spoke2, clusterVersionView("spoke1", "4.10.3", ""), clusterVersionView("spoke2", "4.10.3", ""))
+	w := newWatcher(client, time.Hour)
+	w.selector = "du-profile=site-a"
+	ctx := context.Background()
+
+	views := func() []string {
+		names := []string{}
+		for _, name := range []string{"spoke1", "spoke2"} {
+			if _, err := client.KubernetesClient.Resource(clusterVersionViewGVR).Namespace(name).Get(ctx, metaclient1.ClusterVersionViewTemplates[0].ResourceName, v1.GetOptions{}); err == nil {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	w.check(ctx)
+	if got := views(); len(got) != 2 {
+		t.Fatalf("views = %v, want both spokes", got)
+	}
+
+	// spoke2 leaves the selection
+	cluster, err := client.KubernetesClient.Resource(metaclient1.ManagedClusterGVR).Get(ctx, "spoke2", v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.SetLabels(nil)
+	if _, err := client.KubernetesClient.Resource(metaclient1.ManagedClusterGVR).Update(ctx, cluster, v1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	w.check(ctx)
+	if got := views(); len(got) != 1 || got[0] != "spoke1" {
+		t.Errorf("views = %v, want [spoke1]", got)
+	}
+
+	// the watcher stops
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	w.Run(stopped, time.Hour)
+	if got := views(); len(got) != 0 {
+		t.Errorf("views = %v, want none", got)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LaunchOptions controls how the spokes are split in waves and how many of them are backed up at once
+type LaunchOptions struct {
+	MaxConcurrency  int
+	BatchSize       int
+	CanarySize      int
+	CanaryThreshold float64
+	// FailFast cancels the outstanding spokes after the first failure
+	FailFast bool
+	// MinSuccessRatio stops launching waves once the ratio of succeeded spokes can't be reached anymore
+	MinSuccessRatio float64
+	// Batches, when set, are the waves in order, replacing BatchSize and CanarySize
+	Batches [][]string
+	// Progress receives the human readable per-wave results
+	Progress io.Writer
+	// Publish, when set, receives the run report once written
+	Publish func(Report) error
+}
+
+// spokeLauncher runs a job on a single spoke, recording its progress in record. runWave runs the launchers
+// concurrently and waits for them
+type spokeLauncher func(ctx context.Context, client metaclient1.Client, record *Status) (string, error)
+
+// Wave holds the spokes of one wave and their per-cluster results
+type Wave struct {
+	Name   string
+	Spokes []string
+	Status []Status
+}
+
+// Validate checks the launch options are consistent
+// returns:			error
+func (o LaunchOptions) Validate() error {
+	if o.MaxConcurrency < 0 {
+		return fmt.Errorf("--max-concurrency must not be negative")
+	}
+	if o.BatchSize < 0 {
+		return fmt.Errorf("--batch-size must not be negative")
+	}
+	if o.CanarySize < 0 {
+		return fmt.Errorf("--canary must not be negative")
+	}
+	if o.CanaryThreshold < 0 || o.CanaryThreshold > 1 {
+		return fmt.Errorf("--canary-threshold must be between 0 and 1")
+	}
+	if o.MinSuccessRatio < 0 || o.MinSuccessRatio > 1 {
+		return fmt.Errorf("--min-success-ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// planWaves splits the spokes in an optional canary wave followed by batches of BatchSize, unless the batches
+// are given
+// returns:			[]Wave
+func planWaves(spokes []string, opts LaunchOptions) []Wave {
+	waves := []Wave{}
+	if len(opts.Batches) > 0 {
+		for i, batch := range opts.Batches {
+			waves = append(waves, Wave{Name: fmt.Sprintf("batch-%d", i+1), Spokes: batch})
+		}
+		return waves
+	}
+	remaining := spokes
+
+	if opts.CanarySize > 0 && len(remaining) > 0 {
+		size := opts.CanarySize
+		if size > len(remaining) {
+			size = len(remaining)
+		}
+		waves = append(waves, Wave{Name: "canary", Spokes: remaining[:size]})
+		remaining = remaining[size:]
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize == 0 {
+		batchSize = len(remaining)
+	}
+	for i := 0; len(remaining) > 0; i++ {
+		size := batchSize
+		if size > len(remaining) {
+			size = len(remaining)
+		}
+		waves = append(waves, Wave{Name: fmt.Sprintf("batch-%d", i+1), Spokes: remaining[:size]})
+		remaining = remaining[size:]
+	}
+	return waves
+}
+
+// runWave launches the backup on every spoke of the wave, never running more than MaxConcurrency at once.
+// With FailFast, the first failure cancels the backups in flight and the spokes not launched yet are skipped.
+// returns:			per-cluster Status, in the order of the wave spokes
+func runWave(ctx context.Context, cancel context.CancelFunc, client metaclient1.Client, wave Wave, opts LaunchOptions, launch spokeLauncher) []Status {
+	status := make([]Status, len(wave.Spokes))
+	maxConcurrency := opts.MaxConcurrency
+	if maxConcurrency == 0 {
+		maxConcurrency = len(wave.Spokes)
+	}
+	sem := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+
+	log.Infof("Jobs will be launched on %s clusters: %s (max concurrency: %d)", wave.Name, wave.Spokes, maxConcurrency)
+	for i, v := range wave.Spokes {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			now := time.Now()
+			status[i] = Status{ClusterName: v, Wave: wave.Name, ClusterStatus: metaclient1.Skipped, ClusterError: "backup cancelled before launch", StartTime: now, EndTime: now}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, v string) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			record := Status{ClusterName: v, Wave: wave.Name, StartTime: time.Now()}
+			retStatus, err := launch(ctx, client, &record)
+			record.ClusterStatus = retStatus
+			record.EndTime = time.Now()
+			if err != nil {
+				record.ClusterError = err.Error()
+			}
+			status[i] = record
+			if opts.FailFast && failed(record) {
+				log.Errorf("Job of cluster %s failed, cancelling the outstanding spokes", v)
+				cancel()
+			}
+			log.Debugf("Job of cluster %s finished with status %s, err: %v", v, retStatus, err)
+		}(i, v)
+	}
+	wg.Wait()
+	return status
+}
+
+// successRatio computes the fraction of the clusters whose backup is done
+// returns:			float64
+func successRatio(status []Status) float64 {
+	if len(status) == 0 {
+		return 1
+	}
+	done := 0
+	for _, v := range status {
+		if v.ClusterStatus == metaclient1.Done {
+			done++
+		}
+	}
+	return float64(done) / float64(len(status))
+}
+
+// failed tells whether the backup of a cluster failed, as opposed to succeeded, skipped or interrupted
+// returns:			bool
+func failed(status Status) bool {
+	switch status.ClusterStatus {
+	case metaclient1.Done, metaclient1.Skipped, metaclient1.Interrupted:
+		return false
+	}
+	return true
+}
+
+// unreachableRatio tells whether the min success ratio can still be reached once the given waves are over
+// returns:			bool
+func unreachableRatio(waves []Wave, total int, minSuccessRatio float64) bool {
+	notSucceeded := 0
+	for _, wave := range waves {
+		for _, v := range wave.Status {
+			if v.ClusterStatus != metaclient1.Done {
+				notSucceeded++
+			}
+		}
+	}
+	return float64(total-notSucceeded) < minSuccessRatio*float64(total)
+}
+
+// skipWave marks all the spokes of a wave which won't be launched
+// returns:			[]Status
+func skipWave(wave Wave, reason string) []Status {
+	status := []Status{}
+	now := time.Now()
+	for _, v := range wave.Spokes {
+		status = append(status, Status{ClusterName: v, Wave: wave.Name, ClusterStatus: metaclient1.Skipped, ClusterError: reason, StartTime: now, EndTime: now})
+	}
+	return status
+}
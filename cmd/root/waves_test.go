@@ -0,0 +1,307 @@
+/*
+ * Copyright 2022 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	metaclient1 "github.com/redhat-ztp/openshift-sno-upgrade-recovery/pkg/client"
+)
+
+// fakeLauncher launches the job of a spoke with the result given by results, done when missing, honouring the
+// cancellation of the context. It counts the jobs running at once in running and keeps the highest count in peak
+type fakeLauncher struct {
+	results  map[string]string
+	running  int32
+	peak     int32
+	launched []string
+	mu       sync.Mutex
+}
+
+func (f *fakeLauncher) launch(ctx context.Context, client metaclient1.Client, record *Status) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return metaclient1.Interrupted, err
+	}
+	f.mu.Lock()
+	f.launched = append(f.launched, record.ClusterName)
+	f.mu.Unlock()
+
+	running := atomic.AddInt32(&f.running, 1)
+	defer atomic.AddInt32(&f.running, -1)
+	for {
+		peak := atomic.LoadInt32(&f.peak)
+		if running <= peak || atomic.CompareAndSwapInt32(&f.peak, peak, running) {
+			break
+		}
+	}
+
+	result, ok := f.results[record.ClusterName]
+	if !ok || result == metaclient1.Done {
+		return metaclient1.Done, nil
+	}
+	return result, fmt.Errorf("job of %s %s", record.ClusterName, result)
+}
+
+// spokeNames returns the names of n spokes
+func spokeNames(n int) []string {
+	spokes := []string{}
+	for i := 1; i <= n; i++ {
+		spokes = append(spokes, fmt.Sprintf("sno%d", i))
+	}
+	return spokes
+}
+
+// clusterStatus returns the status of every cluster of the waves, by cluster name
+func clusterStatus(waves []Wave) map[string]string {
+	status := map[string]string{}
+	for _, wave := range waves {
+		for _, v := range wave.Status {
+			status[v.ClusterName] = v.ClusterStatus
+		}
+	}
+	return status
+}
+
+func TestPlanWaves(t *testing.T) {
+	tests := []struct {
+		name   string
+		spokes []string
+		opts   LaunchOptions
+		want   []Wave
+	}{
+		{
+			name:   "single batch",
+			spokes: spokeNames(3),
+			want:   []Wave{{Name: "batch-1", Spokes: []string{"sno1", "sno2", "sno3"}}},
+		},
+		{
+			name:   "batches",
+			spokes: spokeNames(5),
+			opts:   LaunchOptions{BatchSize: 2},
+			want: []Wave{
+				{Name: "batch-1", Spokes: []string{"sno1", "sno2"}},
+				{Name: "batch-2", Spokes: []string{"sno3", "sno4"}},
+				{Name: "batch-3", Spokes: []string{"sno5"}},
+			},
+		},
+		{
+			name:   "canary first",
+			spokes: spokeNames(5),
+			opts:   LaunchOptions{CanarySize: 1, BatchSize: 3},
+			want: []Wave{
+				{Name: "canary", Spokes: []string{"sno1"}},
+				{Name: "batch-1", Spokes: []string{"sno2", "sno3", "sno4"}},
+				{Name: "batch-2", Spokes: []string{"sno5"}},
+			},
+		},
+		{
+			name:   "canary larger than the spokes",
+			spokes: spokeNames(2),
+			opts:   LaunchOptions{CanarySize: 3},
+			want:   []Wave{{Name: "canary", Spokes: []string{"sno1", "sno2"}}},
+		},
+		{
+			name:   "given batches",
+			spokes: spokeNames(3),
+			opts:   LaunchOptions{CanarySize: 1, BatchSize: 1, Batches: [][]string{{"sno3"}, {"sno1", "sno2"}}},
+			want: []Wave{
+				{Name: "batch-1", Spokes: []string{"sno3"}},
+				{Name: "batch-2", Spokes: []string{"sno1", "sno2"}},
+			},
+		},
+		{
+			name: "no spoke",
+			want: []Wave{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planWaves(tt.spokes, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planWaves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWave(t *testing.T) {
+	tests := []struct {
+		name     string
+		spokes   int
+		opts     LaunchOptions
+		results  map[string]string
+		want     map[string]string
+		wantPeak int32
+	}{
+		{
+			name:   "all done",
+			spokes: 3,
+			want:   map[string]string{"sno1": metaclient1.Done, "sno2": metaclient1.Done, "sno3": metaclient1.Done},
+		},
+		{
+			name:     "max concurrency",
+			spokes:   6,
+			opts:     LaunchOptions{MaxConcurrency: 2},
+			want:     map[string]string{"sno1": "DONE", "sno2": "DONE", "sno3": "DONE", "sno4": "DONE", "sno5": "DONE", "sno6": "DONE"},
+			wantPeak: 2,
+		},
+		{
+			name:    "failure without fail fast",
+			spokes:  3,
+			opts:    LaunchOptions{MaxConcurrency: 1},
+			results: map[string]string{"sno1": metaclient1.Failed},
+			want:    map[string]string{"sno1": metaclient1.Failed, "sno2": metaclient1.Done, "sno3": metaclient1.Done},
+		},
+		{
+			name:    "fail fast",
+			spokes:  3,
+			opts:    LaunchOptions{MaxConcurrency: 1, FailFast: true},
+			results: map[string]string{"sno1": metaclient1.Failed},
+			want:    map[string]string{"sno1": metaclient1.Failed, "sno2": metaclient1.Skipped, "sno3": metaclient1.Skipped},
+		},
+		{
+			name:    "fail fast ignores skipped spokes",
+			spokes:  2,
+			opts:    LaunchOptions{MaxConcurrency: 1, FailFast: true},
+			results: map[string]string{"sno1": metaclient1.Skipped},
+			want:    map[string]string{"sno1": metaclient1.Skipped, "sno2": metaclient1.Done},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			launcher := &fakeLauncher{results: tt.results}
+			wave := Wave{Name: "batch-1", Spokes: spokeNames(tt.spokes)}
+
+			status := runWave(ctx, cancel, metaclient1.Client{}, wave, tt.opts, launcher.launch)
+			if got := clusterStatus([]Wave{{Status: status}}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runWave() = %v, want %v", got, tt.want)
+			}
+			for i, v := range status {
+				if v.ClusterName != wave.Spokes[i] || v.Wave != wave.Name {
+					t.Errorf("runWave() status %d is %s of %s, want %s of %s", i, v.ClusterName, v.Wave, wave.Spokes[i], wave.Name)
+				}
+			}
+			if tt.wantPeak > 0 && launcher.peak > tt.wantPeak {
+				t.Errorf("runWave() ran %d jobs at once, want at most %d", launcher.peak, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestUnreachableRatio(t *testing.T) {
+	wave := func(status ...string) Wave {
+		w := Wave{}
+		for _, s := range status {
+			w.Status = append(w.Status, Status{ClusterStatus: s})
+		}
+		return w
+	}
+	tests := []struct {
+		name     string
+		waves    []Wave
+		total    int
+		minRatio float64
+		want     bool
+	}{
+		{name: "all done", waves: []Wave{wave("DONE", "DONE")}, total: 4, minRatio: 1, want: false},
+		{name: "one failure out of four at 0.75", waves: []Wave{wave("DONE", "FAILED")}, total: 4, minRatio: 0.75, want: false},
+		{name: "two failures out of four at 0.75", waves: []Wave{wave("FAILED"), wave("DONE", "FAILED")}, total: 4, minRatio: 0.75, want: true},
+		{name: "skipped spokes count as not succeeded", waves: []Wave{wave("SKIPPED", "SKIPPED")}, total: 4, minRatio: 0.75, want: true},
+		{name: "no ratio", waves: []Wave{wave("FAILED", "FAILED")}, total: 2, minRatio: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unreachableRatio(tt.waves, tt.total, tt.minRatio); got != tt.want {
+				t.Errorf("unreachableRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiSpokeLaunch(t *testing.T) {
+	tests := []struct {
+		name    string
+		spokes  int
+		opts    LaunchOptions
+		results map[string]string
+		want    map[string]string
+	}{
+		{
+			name:   "canary then batches",
+			spokes: 4,
+			opts:   LaunchOptions{CanarySize: 1, BatchSize: 2, CanaryThreshold: 1},
+			want:   map[string]string{"sno1": "DONE", "sno2": "DONE", "sno3": "DONE", "sno4": "DONE"},
+		},
+		{
+			name:    "canary below threshold",
+			spokes:  4,
+			opts:    LaunchOptions{CanarySize: 2, BatchSize: 2, CanaryThreshold: 1},
+			results: map[string]string{"sno2": metaclient1.Failed},
+			want:    map[string]string{"sno1": "DONE", "sno2": "FAILED", "sno3": "SKIPPED", "sno4": "SKIPPED"},
+		},
+		{
+			name:    "canary meeting a lower threshold",
+			spokes:  4,
+			opts:    LaunchOptions{CanarySize: 2, BatchSize: 2, CanaryThreshold: 0.5},
+			results: map[string]string{"sno2": metaclient1.Failed},
+			want:    map[string]string{"sno1": "DONE", "sno2": "FAILED", "sno3": "DONE", "sno4": "DONE"},
+		},
+		{
+			name:    "fail fast skips the next waves",
+			spokes:  4,
+			opts:    LaunchOptions{BatchSize: 1, FailFast: true},
+			results: map[string]string{"sno2": metaclient1.Failed},
+			want:    map[string]string{"sno1": "DONE", "sno2": "FAILED", "sno3": "SKIPPED", "sno4": "SKIPPED"},
+		},
+		{
+			name:    "min success ratio out of reach",
+			spokes:  6,
+			opts:    LaunchOptions{BatchSize: 2, MinSuccessRatio: 0.8},
+			results: map[string]string{"sno1": metaclient1.Failed, "sno3": metaclient1.Failed},
+			want:    map[string]string{"sno1": "FAILED", "sno2": "DONE", "sno3": "FAILED", "sno4": "DONE", "sno5": "SKIPPED", "sno6": "SKIPPED"},
+		},
+		{
+			name:    "min success ratio still reachable",
+			spokes:  6,
+			opts:    LaunchOptions{BatchSize: 2, MinSuccessRatio: 0.8},
+			results: map[string]string{"sno1": metaclient1.Failed},
+			want:    map[string]string{"sno1": "FAILED", "sno2": "DONE", "sno3": "DONE", "sno4": "DONE", "sno5": "DONE", "sno6": "DONE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Progress = io.Discard
+			launcher := &fakeLauncher{results: tt.results}
+			client := metaclient1.Client{Spoke: spokeNames(tt.spokes)}
+
+			waves, err := multiSpokeLaunch(context.Background(), client, tt.opts, launcher.launch)
+			if err != nil {
+				t.Fatalf("multiSpokeLaunch() error = %v", err)
+			}
+			if got := clusterStatus(waves); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("multiSpokeLaunch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBackupPath is the recovery partition of the spokes, the only prefix under which the backup image stores
+// the backups by default
+const DefaultBackupPath = "/var/recovery"
+
+// ValidateBackupPath normalises the backup path and checks it is under the prefix the backup image allows on the
+// spokes, so that a path the spokes would refuse is refused before any job is launched
+// returns:			clean path, error
+func ValidateBackupPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("backup path %q must be absolute", path)
+	}
+	path = filepath.Clean(path)
+	if path != DefaultBackupPath && !strings.HasPrefix(path, DefaultBackupPath+"/") {
+		return "", fmt.Errorf("backup path %s must be under %s, the prefix the backup image allows", path, DefaultBackupPath)
+	}
+	return path, nil
+}
@@ -0,0 +1,30 @@
+package client
+
+import "testing"
+
+func TestValidateBackupPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/var/recovery", want: "/var/recovery"},
+		{path: "/var/recovery/", want: "/var/recovery"},
+		{path: "/var/recovery/sno", want: "/var/recovery/sno"},
+		{path: "/var/recovery/../lib/etcd", wantErr: true},
+		{path: "/var/recovery-old", wantErr: true},
+		{path: "/", wantErr: true},
+		{path: "var/recovery", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ValidateBackupPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateBackupPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ValidateBackupPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// BackupRequestGVR represents the backuprequest resource on the hub, reconciled by the controller
+var BackupRequestGVR = schema.GroupVersionResource{
+	Group:    "ztp.openshift.io",
+	Version:  "v1alpha1",
+	Resource: "backuprequests",
+}
+
+// BackupRequestFinalizer lets the controller tear down the hub artifacts of a backuprequest deleted while its
+// backups are in flight
+const BackupRequestFinalizer = "ztp.openshift.io/backup-teardown"
+
+// Phases of a backuprequest
+const (
+	RequestPending   = "Pending"
+	RequestRunning   = "Running"
+	RequestCompleted = "Completed"
+	RequestFailed    = "Failed"
+	// RequestScheduled is the phase of a scheduled backuprequest between two runs
+	RequestScheduled = "Scheduled"
+)
+
+// Phases of the backup of one cluster of a backuprequest
+const (
+	ClusterPending     = "Pending"
+	ClusterDeferred    = "Deferred"
+	ClusterLaunching   = "Launching"
+	ClusterRunning     = "Running"
+	ClusterSucceeded   = "Succeeded"
+	ClusterFailed      = "Failed"
+	ClusterInterrupted = "Interrupted"
+	// ClusterMissed is the phase of a cluster whose maintenance window didn't open before the next scheduled run
+	ClusterMissed = "Missed"
+)
+
+// Conditions of a backuprequest and of its clusters
+const (
+	// ConditionCompleted is set on the backuprequest, true once the backup of every cluster is over
+	ConditionCompleted = "Completed"
+	// ConditionJobLaunched is set on a cluster, true once its backup job runs on the spoke
+	ConditionJobLaunched = "JobLaunched"
+	// ConditionJobSucceeded is set on a cluster once its backup job is over
+	ConditionJobSucceeded = "JobSucceeded"
+	// ConditionBackupsOverdue is set on a scheduled backuprequest, true when some clusters have no recent enough backup
+	ConditionBackupsOverdue = "BackupsOverdue"
+	// ConditionSpecChangeIgnored is set on a backuprequest, true when its spec changed after it started
+	ConditionSpecChangeIgnored = "SpecChangeIgnored"
+)
+
+// ScheduleLabel is set on the backuprequests started by a scheduled backuprequest, to its name
+const ScheduleLabel = "ztp.openshift.io/schedule"
+
+// DefaultHistoryLimit is the number of finished runs of a scheduled backuprequest kept on the hub
+const DefaultHistoryLimit = 3
+
+// BackupRequestSpec selects the clusters to back up and configures their backup job
+type BackupRequestSpec struct {
+	// Clusters lists the clusters by name, exclusive with Selector and ClusterSet
+	Clusters   []string `json:"clusters,omitempty"`
+	Selector   string   `json:"selector,omitempty"`
+	ClusterSet string   `json:"clusterSet,omitempty"`
+
+	Image      string `json:"image,omitempty"`
+	PullSecret string `json:"pullSecret,omitempty"`
+	BackupPath string `json:"backupPath,omitempty"`
+	// Transport carries the backup job to the clusters: managedclusteraction, the default, or manifestwork
+	Transport string `json:"transport,omitempty"`
+
+	LaunchTimeout     *v1.Duration `json:"launchTimeout,omitempty"`
+	CompletionTimeout *v1.Duration `json:"completionTimeout,omitempty"`
+	PollInterval      *v1.Duration `json:"pollInterval,omitempty"`
+	MaxPollInterval   *v1.Duration `json:"maxPollInterval,omitempty"`
+
+	// MaxConcurrency bounds the clusters backed up at the same time, 0 means no limit
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+
+	// Schedule is a cron expression starting a new run of the backuprequest, as a backuprequest of its own
+	Schedule string `json:"schedule,omitempty"`
+	// TimeZone is the time zone of the schedule and of the maintenance windows without one, UTC by default
+	TimeZone string `json:"timeZone,omitempty"`
+	// OverdueAfter is the age past which the backup of a cluster is overdue, twice the schedule period by default
+	OverdueAfter *v1.Duration `json:"overdueAfter,omitempty"`
+	// HistoryLimit is the number of finished runs kept, DefaultHistoryLimit by default
+	HistoryLimit *int `json:"historyLimit,omitempty"`
+
+	// IgnoreMaintenanceWindows backs up the clusters right away, even outside their maintenance windows
+	IgnoreMaintenanceWindows bool `json:"ignoreMaintenanceWindows,omitempty"`
+	// Deadline is set by the schedule on its runs: the clusters still waiting for their window then are missed
+	Deadline *v1.Time `json:"deadline,omitempty"`
+}
+
+// ClusterBackupStatus records the backup of one cluster of a backuprequest
+type ClusterBackupStatus struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	// HubPhase is the last phase reached on the hub, as in the run report of triggerBackup
+	HubPhase       string         `json:"hubPhase,omitempty"`
+	StartTime      *v1.Time       `json:"startTime,omitempty"`
+	CompletionTime *v1.Time       `json:"completionTime,omitempty"`
+	Message        string         `json:"message,omitempty"`
+	Conditions     []v1.Condition `json:"conditions,omitempty"`
+
+	// LastSuccessTime and Overdue are only recorded by a scheduled backuprequest, over its runs
+	LastSuccessTime *v1.Time `json:"lastSuccessTime,omitempty"`
+	Overdue         bool     `json:"overdue,omitempty"`
+}
+
+// BackupRequestStatus records the progress of a backuprequest. The clusters are resolved once, when the request
+// starts, so that the status is all the controller needs to resume after a restart
+type BackupRequestStatus struct {
+	ObservedGeneration int64                 `json:"observedGeneration,omitempty"`
+	Phase              string                `json:"phase,omitempty"`
+	StartTime          *v1.Time              `json:"startTime,omitempty"`
+	CompletionTime     *v1.Time              `json:"completionTime,omitempty"`
+	Clusters           []ClusterBackupStatus `json:"clusters,omitempty"`
+	Conditions         []v1.Condition        `json:"conditions,omitempty"`
+
+	// the runs of a scheduled backuprequest
+	LastScheduleTime *v1.Time `json:"lastScheduleTime,omitempty"`
+	NextScheduleTime *v1.Time `json:"nextScheduleTime,omitempty"`
+	ActiveRun        string   `json:"activeRun,omitempty"`
+	// MissedRuns counts the runs skipped as the controller was down or the previous run still active
+	MissedRuns     int      `json:"missedRuns,omitempty"`
+	LastMissedTime *v1.Time `json:"lastMissedTime,omitempty"`
+}
+
+// BackupRequest asks the controller running on the hub to back up a set of spoke clusters
+type BackupRequest struct {
+	v1.TypeMeta   `json:",inline"`
+	v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupRequestSpec   `json:"spec,omitempty"`
+	Status BackupRequestStatus `json:"status,omitempty"`
+}
+
+// Validate checks the spec of a backuprequest before its clusters are resolved
+// returns:			error
+func (s BackupRequestSpec) Validate() error {
+	if len(s.Clusters) == 0 && s.Selector == "" && s.ClusterSet == "" {
+		return fmt.Errorf("one of clusters, selector or clusterSet must be provided")
+	}
+	if len(s.Clusters) > 0 && (s.Selector != "" || s.ClusterSet != "") {
+		return fmt.Errorf("clusters cannot be combined with selector or clusterSet")
+	}
+	if _, err := ClusterSelector(s.Selector, s.ClusterSet); err != nil {
+		return err
+	}
+	if s.BackupPath != "" {
+		if _, err := ValidateBackupPath(s.BackupPath); err != nil {
+			return fmt.Errorf("invalid backupPath: %s", err)
+		}
+	}
+	if s.Image != "" {
+		if err := ValidateImage(s.Image); err != nil {
+			return err
+		}
+	}
+	if err := ValidateTransport(s.Transport); err != nil {
+		return err
+	}
+	if s.MaxConcurrency < 0 {
+		return fmt.Errorf("maxConcurrency must not be negative")
+	}
+	location, err := s.Location()
+	if err != nil {
+		return err
+	}
+	if s.Schedule != "" {
+		if _, err := ParseCron(s.Schedule, location); err != nil {
+			return err
+		}
+	}
+	if s.OverdueAfter != nil && s.OverdueAfter.Duration <= 0 {
+		return fmt.Errorf("overdueAfter must be positive")
+	}
+	if s.HistoryLimit != nil && *s.HistoryLimit < 0 {
+		return fmt.Errorf("historyLimit must not be negative")
+	}
+	return s.PollOptions().Validate()
+}
+
+// Location loads the time zone of the spec
+// returns:			*time.Location, error
+func (s BackupRequestSpec) Location() (*time.Location, error) {
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeZone %q: %s", s.TimeZone, err)
+	}
+	return location, nil
+}
+
+// PollOptions returns the poll options of the spec, falling back to the defaults
+// returns:			PollOptions
+func (s BackupRequestSpec) PollOptions() PollOptions {
+	poll := DefaultPollOptions()
+	if s.LaunchTimeout != nil {
+		poll.LaunchTimeout = s.LaunchTimeout.Duration
+	}
+	if s.CompletionTimeout != nil {
+		poll.CompletionTimeout = s.CompletionTimeout.Duration
+	}
+	if s.PollInterval != nil {
+		poll.Interval = s.PollInterval.Duration
+	}
+	if s.MaxPollInterval != nil {
+		poll.MaxInterval = s.MaxPollInterval.Duration
+	}
+	return poll
+}
+
+// DeepCopy copies the status of a cluster, so that it can be shared with the goroutine backing it up
+// returns:			*ClusterBackupStatus
+func (s ClusterBackupStatus) DeepCopy() *ClusterBackupStatus {
+	out := s
+	if s.StartTime != nil {
+		out.StartTime = s.StartTime.DeepCopy()
+	}
+	if s.CompletionTime != nil {
+		out.CompletionTime = s.CompletionTime.DeepCopy()
+	}
+	if s.LastSuccessTime != nil {
+		out.LastSuccessTime = s.LastSuccessTime.DeepCopy()
+	}
+	out.Conditions = nil
+	for _, condition := range s.Conditions {
+		out.Conditions = append(out.Conditions, *condition.DeepCopy())
+	}
+	return &out
+}
+
+// HasFinalizer checks whether the controller's finalizer is set on the backuprequest
+// returns:			bool
+func (r BackupRequest) HasFinalizer() bool {
+	for _, finalizer := range r.Finalizers {
+		if finalizer == BackupRequestFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateBackupRequest creates a backuprequest
+// returns:			error
+func (c Client) CreateBackupRequest(ctx context.Context, request *BackupRequest) error {
+	obj, err := toUnstructured(request)
+	if err != nil {
+		return err
+	}
+	_, err = c.KubernetesClient.Resource(BackupRequestGVR).Namespace(request.Namespace).Create(ctx, obj, v1.CreateOptions{})
+	return err
+}
+
+// DeleteBackupRequest deletes a backuprequest, deleting one which is already gone is not an error
+// returns:			error
+func (c Client) DeleteBackupRequest(ctx context.Context, request *BackupRequest) error {
+	err := c.KubernetesClient.Resource(BackupRequestGVR).Namespace(request.Namespace).Delete(ctx, request.Name, v1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("couldn't delete backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+	}
+	return nil
+}
+
+// ListBackupRequests lists the backuprequests of a namespace, or of all the namespaces when it is empty
+// returns:			[]BackupRequest, error
+func (c Client) ListBackupRequests(ctx context.Context, namespace string) ([]BackupRequest, error) {
+	list, err := c.KubernetesClient.Resource(BackupRequestGVR).Namespace(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list backuprequests: %s", err)
+	}
+
+	requests := []BackupRequest{}
+	for _, item := range list.Items {
+		var request BackupRequest
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &request); err != nil {
+			log.Errorf("Skipping the invalid backuprequest %s/%s: %s", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// UpdateBackupRequest updates the metadata and spec of a backuprequest, refreshing it from the hub
+// returns:			error
+func (c Client) UpdateBackupRequest(ctx context.Context, request *BackupRequest) error {
+	obj, err := toUnstructured(request)
+	if err != nil {
+		return err
+	}
+	updated, err := c.KubernetesClient.Resource(BackupRequestGVR).Namespace(request.Namespace).Update(ctx, obj, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't update backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+	}
+	*request = BackupRequest{}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(updated.Object, request)
+}
+
+// UpdateBackupRequestStatus updates the status of a backuprequest, refreshing it from the hub
+// returns:			error
+func (c Client) UpdateBackupRequestStatus(ctx context.Context, request *BackupRequest) error {
+	obj, err := toUnstructured(request)
+	if err != nil {
+		return err
+	}
+	updated, err := c.KubernetesClient.Resource(BackupRequestGVR).Namespace(request.Namespace).UpdateStatus(ctx, obj, v1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't update the status of backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+	}
+	*request = BackupRequest{}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(updated.Object, request)
+}
+
+// toUnstructured converts a backuprequest for the dynamic client
+// returns:			*unstructured.Unstructured, error
+func toUnstructured(request *BackupRequest) (*unstructured.Unstructured, error) {
+	request.APIVersion = BackupRequestGVR.GroupVersion().String()
+	request.Kind = "BackupRequest"
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(request)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't convert backuprequest %s/%s: %s", request.Namespace, request.Name, err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ClusterGroupUpgradeGVR represents the clustergroupupgrade resource of TALM on the hub
+var ClusterGroupUpgradeGVR = schema.GroupVersionResource{
+	Group:    "ran.openshift.io",
+	Version:  "v1alpha1",
+	Resource: "clustergroupupgrades",
+}
+
+// ConfigMapGVR represents the configmap resource on the hub
+var ConfigMapGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "configmaps",
+}
+
+// Annotations of a clustergroupupgrade whose clusters were backed up
+const (
+	// CGUBackupResultsAnnotation is the name of the configmap holding the backup result of every cluster
+	CGUBackupResultsAnnotation = "ztp.openshift.io/backup-results"
+	// CGUBackupSummaryAnnotation sums up the backup of the clusters
+	CGUBackupSummaryAnnotation = "ztp.openshift.io/backup-summary"
+)
+
+// CGUPlan is the remediation plan of a clustergroupupgrade: its clusters, in batches upgraded one after the other
+type CGUPlan struct {
+	Namespace      string
+	Name           string
+	UID            types.UID
+	Clusters       []string
+	Batches        [][]string
+	MaxConcurrency int
+}
+
+// ResultsConfigMap returns the name of the configmap holding the backup results of the clustergroupupgrade
+// returns:			string
+func (p CGUPlan) ResultsConfigMap() string {
+	return p.Name + "-backup-results"
+}
+
+// GetCGUPlan reads the clusters and the remediation batches of a clustergroupupgrade. The batches are the
+// remediation plan of its status once TALM computed it, otherwise they are computed as TALM does: the canaries
+// first, then the other clusters, maxConcurrency clusters per batch
+// returns:			CGUPlan, error
+func (c Client) GetCGUPlan(ctx context.Context, ref string) (CGUPlan, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return CGUPlan{}, fmt.Errorf("invalid clustergroupupgrade %q, expecting namespace/name", ref)
+	}
+
+	log.WithFields(log.Fields{"ClusterGroupUpgrade": "Fetching"}).Debugf("Fetching the clustergroupupgrade: %s", ref)
+	cgu, err := c.KubernetesClient.Resource(ClusterGroupUpgradeGVR).Namespace(parts[0]).Get(ctx, parts[1], v1.GetOptions{})
+	if err != nil {
+		return CGUPlan{}, fmt.Errorf("couldn't get clustergroupupgrade %s: %s", ref, err)
+	}
+	plan := CGUPlan{Namespace: parts[0], Name: parts[1], UID: cgu.GetUID()}
+
+	maxConcurrency, _, _ := unstructured.NestedInt64(cgu.Object, "spec", "remediationStrategy", "maxConcurrency")
+	plan.MaxConcurrency = int(maxConcurrency)
+
+	remediationPlan, found, _ := unstructured.NestedSlice(cgu.Object, "status", "remediationPlan")
+	if found && len(remediationPlan) > 0 {
+		for i, batch := range remediationPlan {
+			clusters, ok := batch.([]interface{})
+			if !ok {
+				return plan, fmt.Errorf("invalid batch %d in the remediation plan of clustergroupupgrade %s", i+1, ref)
+			}
+			names := []string{}
+			for _, cluster := range clusters {
+				if name, ok := cluster.(string); ok {
+					names = append(names, name)
+				}
+			}
+			plan.Batches = append(plan.Batches, names)
+			plan.Clusters = append(plan.Clusters, names...)
+		}
+		return plan, nil
+	}
+
+	if plan.Clusters, err = c.cguClusters(ctx, cgu); err != nil {
+		return plan, fmt.Errorf("couldn't select the clusters of clustergroupupgrade %s: %s", ref, err)
+	}
+	if len(plan.Clusters) == 0 {
+		return plan, fmt.Errorf("clustergroupupgrade %s selects no cluster", ref)
+	}
+
+	canaries, _, _ := unstructured.NestedStringSlice(cgu.Object, "spec", "remediationStrategy", "canaries")
+	ordered := []string{}
+	isCanary := map[string]bool{}
+	for _, name := range canaries {
+		for _, cluster := range plan.Clusters {
+			if cluster == name && !isCanary[name] {
+				isCanary[name] = true
+				ordered = append(ordered, name)
+			}
+		}
+	}
+	canaryCount := len(ordered)
+	for _, cluster := range plan.Clusters {
+		if !isCanary[cluster] {
+			ordered = append(ordered, cluster)
+		}
+	}
+	plan.Clusters = ordered
+
+	size := plan.MaxConcurrency
+	if size <= 0 {
+		size = len(ordered)
+	}
+	plan.Batches = append(plan.Batches, chunk(ordered[:canaryCount], size)...)
+	plan.Batches = append(plan.Batches, chunk(ordered[canaryCount:], size)...)
+	return plan, nil
+}
+
+// cguClusters lists the clusters of a clustergroupupgrade, each once: the ones it names, then the ones its selectors
+// match
+// returns:			cluster names, error
+func (c Client) cguClusters(ctx context.Context, cgu *unstructured.Unstructured) ([]string, error) {
+	clusters, _, _ := unstructured.NestedStringSlice(cgu.Object, "spec", "clusters")
+	selectors, _, _ := unstructured.NestedStringSlice(cgu.Object, "spec", "clusterSelector")
+
+	labelSelectors, _, _ := unstructured.NestedSlice(cgu.Object, "spec", "clusterLabelSelectors")
+	for _, item := range labelSelectors {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid clusterLabelSelectors")
+		}
+		var selector v1.LabelSelector
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &selector); err != nil {
+			return nil, fmt.Errorf("invalid clusterLabelSelectors: %s", err)
+		}
+		parsed, err := v1.LabelSelectorAsSelector(&selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clusterLabelSelectors: %s", err)
+		}
+		selectors = append(selectors, parsed.String())
+	}
+
+	seen := map[string]bool{}
+	named := []string{}
+	for _, name := range clusters {
+		if !seen[name] {
+			seen[name] = true
+			named = append(named, name)
+		}
+	}
+	selected := []string{}
+	for _, selector := range selectors {
+		names, err := c.ListSpokeClusters(ctx, selector, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				selected = append(selected, name)
+			}
+		}
+	}
+	sort.Strings(selected)
+	return append(named, selected...), nil
+}
+
+// chunk splits clusters in batches of a given size, a single batch when the size isn't positive
+// returns:			[][]string
+func chunk(clusters []string, size int) [][]string {
+	if size <= 0 {
+		size = len(clusters)
+	}
+	batches := [][]string{}
+	for len(clusters) > 0 {
+		if size > len(clusters) {
+			size = len(clusters)
+		}
+		batches = append(batches, clusters[:size])
+		clusters = clusters[size:]
+	}
+	return batches
+}
+
+// PublishCGUResults writes the backup result of every cluster of a clustergroupupgrade into a configmap owned by
+// it, and annotates the clustergroupupgrade with the name of the configmap and a summary
+// returns:			error
+func (c Client) PublishCGUResults(ctx context.Context, plan CGUPlan, results map[string]string, summary string) error {
+	name := plan.ResultsConfigMap()
+	data := map[string]interface{}{}
+	for cluster, result := range results {
+		data[cluster] = result
+	}
+
+	configMaps := c.KubernetesClient.Resource(ConfigMapGVR).Namespace(plan.Namespace)
+	configMap, err := configMaps.Get(ctx, name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+		}}
+		configMap.SetName(name)
+		configMap.SetNamespace(plan.Namespace)
+		configMap.SetOwnerReferences([]v1.OwnerReference{{
+			APIVersion: ClusterGroupUpgradeGVR.GroupVersion().String(),
+			Kind:       "ClusterGroupUpgrade",
+			Name:       plan.Name,
+			UID:        plan.UID,
+		}})
+		configMap.SetAnnotations(map[string]string{CGUBackupSummaryAnnotation: summary})
+		configMap.Object["data"] = data
+		_, err = configMaps.Create(ctx, configMap, v1.CreateOptions{})
+	} else if err == nil {
+		annotations := configMap.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[CGUBackupSummaryAnnotation] = summary
+		configMap.SetAnnotations(annotations)
+		configMap.Object["data"] = data
+		_, err = configMaps.Update(ctx, configMap, v1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't write the backup results of clustergroupupgrade %s/%s in configmap %s: %s", plan.Namespace, plan.Name, name, err)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				CGUBackupResultsAnnotation: name,
+				CGUBackupSummaryAnnotation: summary,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.KubernetesClient.Resource(ClusterGroupUpgradeGVR).Namespace(plan.Namespace).Patch(ctx, plan.Name, types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't annotate clustergroupupgrade %s/%s: %s", plan.Namespace, plan.Name, err)
+	}
+	log.Infof("Backup results written in configmap %s/%s", plan.Namespace, name)
+	return nil
+}
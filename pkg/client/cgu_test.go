@@ -0,0 +1,279 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// clusterGroupUpgrade returns the clustergroupupgrade ztp-group/upgrade of the hub, with its spec and status
+func clusterGroupUpgrade(spec map[string]interface{}, status map[string]interface{}) *unstructured.Unstructured {
+	obj := newObject(ClusterGroupUpgradeGVR.GroupVersion().String(), "ClusterGroupUpgrade", "ztp-group", "upgrade", nil)
+	obj.Object["spec"] = spec
+	if status != nil {
+		obj.Object["status"] = status
+	}
+	return obj
+}
+
+// cguSpokes returns the managedclusters selected by the clustergroupupgrades of the tests
+func cguSpokes() []*unstructured.Unstructured {
+	return []*unstructured.Unstructured{
+		managedCluster("sno1", map[string]string{"du-profile": "site-a"}),
+		managedCluster("sno2", map[string]string{"du-profile": "site-a", "env": "prod"}),
+		managedCluster("sno3", map[string]string{"env": "prod"}),
+		managedCluster("sno4", map[string]string{"du-profile": "site-b"}),
+	}
+}
+
+func TestGetCGUPlan(t *testing.T) {
+	five := []interface{}{"sno1", "sno2", "sno3", "sno4", "sno5"}
+	tests := []struct {
+		name    string
+		ref     string
+		spec    map[string]interface{}
+		status  map[string]interface{}
+		want    CGUPlan
+		wantErr bool
+	}{
+		{
+			name: "maxConcurrency batches",
+			spec: map[string]interface{}{
+				"clusters":            five,
+				"remediationStrategy": map[string]interface{}{"maxConcurrency": int64(2)},
+			},
+			want: CGUPlan{
+				Clusters:       []string{"sno1", "sno2", "sno3", "sno4", "sno5"},
+				Batches:        [][]string{{"sno1", "sno2"}, {"sno3", "sno4"}, {"sno5"}},
+				MaxConcurrency: 2,
+			},
+		},
+		{
+			name: "no maxConcurrency",
+			spec: map[string]interface{}{"clusters": five},
+			want: CGUPlan{
+				Clusters: []string{"sno1", "sno2", "sno3", "sno4", "sno5"},
+				Batches:  [][]string{{"sno1", "sno2", "sno3", "sno4", "sno5"}},
+			},
+		},
+		{
+			name: "canaries first",
+			spec: map[string]interface{}{
+				"clusters": five,
+				"remediationStrategy": map[string]interface{}{
+					"maxConcurrency": int64(2),
+					"canaries":       []interface{}{"sno4", "sno9", "sno4"},
+				},
+			},
+			want: CGUPlan{
+				Clusters:       []string{"sno4", "sno1", "sno2", "sno3", "sno5"},
+				Batches:        [][]string{{"sno4"}, {"sno1", "sno2"}, {"sno3", "sno5"}},
+				MaxConcurrency: 2,
+			},
+		},
+		{
+			name: "canaries in batches of maxConcurrency",
+			spec: map[string]interface{}{
+				"clusters": five,
+				"remediationStrategy": map[string]interface{}{
+					"maxConcurrency": int64(1),
+					"canaries":       []interface{}{"sno5", "sno2"},
+				},
+			},
+			want: CGUPlan{
+				Clusters:       []string{"sno5", "sno2", "sno1", "sno3", "sno4"},
+				Batches:        [][]string{{"sno5"}, {"sno2"}, {"sno1"}, {"sno3"}, {"sno4"}},
+				MaxConcurrency: 1,
+			},
+		},
+		{
+			name: "remediation plan of the status",
+			spec: map[string]interface{}{
+				"clusters":            five,
+				"remediationStrategy": map[string]interface{}{"maxConcurrency": int64(2)},
+			},
+			status: map[string]interface{}{
+				"remediationPlan": []interface{}{[]interface{}{"sno3"}, []interface{}{"sno1", "sno5"}},
+			},
+			want: CGUPlan{
+				Clusters:       []string{"sno3", "sno1", "sno5"},
+				Batches:        [][]string{{"sno3"}, {"sno1", "sno5"}},
+				MaxConcurrency: 2,
+			},
+		},
+		{
+			name:   "empty remediation plan",
+			spec:   map[string]interface{}{"clusters": []interface{}{"sno1", "sno2"}},
+			status: map[string]interface{}{"remediationPlan": []interface{}{}},
+			want: CGUPlan{
+				Clusters: []string{"sno1", "sno2"},
+				Batches:  [][]string{{"sno1", "sno2"}},
+			},
+		},
+		{
+			name:    "invalid remediation plan",
+			spec:    map[string]interface{}{"clusters": []interface{}{"sno1"}},
+			status:  map[string]interface{}{"remediationPlan": []interface{}{"sno1"}},
+			wantErr: true,
+		},
+		{
+			name: "selected clusters",
+			spec: map[string]interface{}{
+				"clusters":            []interface{}{"sno4"},
+				"clusterSelector":     []interface{}{"du-profile=site-a"},
+				"remediationStrategy": map[string]interface{}{"maxConcurrency": int64(2)},
+			},
+			want: CGUPlan{
+				Clusters:       []string{"sno4", "sno1", "sno2"},
+				Batches:        [][]string{{"sno4", "sno1"}, {"sno2"}},
+				MaxConcurrency: 2,
+			},
+		},
+		{
+			name:    "no cluster selected",
+			spec:    map[string]interface{}{"clusterSelector": []interface{}{"du-profile=site-c"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid reference",
+			ref:     "upgrade",
+			wantErr: true,
+		},
+		{
+			name:    "missing namespace",
+			ref:     "/upgrade",
+			wantErr: true,
+		},
+		{
+			name:    "missing clustergroupupgrade",
+			ref:     "ztp-group/other",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{clusterGroupUpgrade(tt.spec, tt.status)}
+			for _, spoke := range cguSpokes() {
+				objects = append(objects, spoke)
+			}
+			client := newFakeClient(objects...)
+			ref := tt.ref
+			if ref == "" {
+				ref = "ztp-group/upgrade"
+			}
+
+			got, err := client.GetCGUPlan(context.Background(), ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetCGUPlan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			tt.want.Namespace, tt.want.Name = "ztp-group", "upgrade"
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetCGUPlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCGUClusters(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    map[string]interface{}
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "named clusters in order",
+			spec: map[string]interface{}{"clusters": []interface{}{"sno3", "sno1"}},
+			want: []string{"sno3", "sno1"},
+		},
+		{
+			name: "named clusters once",
+			spec: map[string]interface{}{"clusters": []interface{}{"sno3", "sno1", "sno3"}},
+			want: []string{"sno3", "sno1"},
+		},
+		{
+			name: "cluster selector",
+			spec: map[string]interface{}{"clusterSelector": []interface{}{"du-profile=site-a"}},
+			want: []string{"sno1", "sno2"},
+		},
+		{
+			name: "cluster label selectors",
+			spec: map[string]interface{}{"clusterLabelSelectors": []interface{}{
+				map[string]interface{}{"matchLabels": map[string]interface{}{"env": "prod"}},
+				map[string]interface{}{"matchExpressions": []interface{}{
+					map[string]interface{}{"key": "du-profile", "operator": "In", "values": []interface{}{"site-b"}},
+				}},
+			}},
+			want: []string{"sno2", "sno3", "sno4"},
+		},
+		{
+			name: "selectors de-duplicated",
+			spec: map[string]interface{}{
+				"clusters":              []interface{}{"sno2"},
+				"clusterSelector":       []interface{}{"du-profile=site-a", "env=prod"},
+				"clusterLabelSelectors": []interface{}{map[string]interface{}{"matchLabels": map[string]interface{}{"env": "prod"}}},
+			},
+			want: []string{"sno2", "sno1", "sno3"},
+		},
+		{
+			name: "invalid cluster label selector",
+			spec: map[string]interface{}{"clusterLabelSelectors": []interface{}{
+				map[string]interface{}{"matchExpressions": []interface{}{
+					map[string]interface{}{"key": "du-profile", "operator": "Like", "values": []interface{}{"site-b"}},
+				}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cluster selector",
+			spec:    map[string]interface{}{"clusterSelector": []interface{}{"du-profile in (site-a"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			for _, spoke := range cguSpokes() {
+				objects = append(objects, spoke)
+			}
+			client := newFakeClient(objects...)
+
+			got, err := client.cguClusters(context.Background(), clusterGroupUpgrade(tt.spec, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cguClusters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cguClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters []string
+		size     int
+		want     [][]string
+	}{
+		{name: "even", clusters: []string{"a", "b", "c", "d"}, size: 2, want: [][]string{{"a", "b"}, {"c", "d"}}},
+		{name: "remainder", clusters: []string{"a", "b", "c"}, size: 2, want: [][]string{{"a", "b"}, {"c"}}},
+		{name: "larger size", clusters: []string{"a", "b"}, size: 5, want: [][]string{{"a", "b"}}},
+		{name: "one per batch", clusters: []string{"a", "b"}, size: 1, want: [][]string{{"a"}, {"b"}}},
+		{name: "no size", clusters: []string{"a", "b"}, size: 0, want: [][]string{{"a", "b"}}},
+		{name: "no clusters", size: 2, want: [][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chunk(tt.clusters, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
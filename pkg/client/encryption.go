@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// EncryptionKeyTemplates populates templates for creation of managedclusteraction resource to propagate the
+// encryption key of the backup job
+var EncryptionKeyTemplates = []ResourceTemplate{
+	{"backup-create-encryptionkey", mngClusterActCreateEncryptionKey},
+}
+
+// RecoveryEncryptionKeyTemplates populates templates for creation of managedclusteraction resource to propagate the
+// encryption key of the recovery job
+var RecoveryEncryptionKeyTemplates = []ResourceTemplate{
+	{"recovery-create-encryptionkey", mngClusterActCreateEncryptionKey},
+}
+
+// withEncryptionKey inserts the encryption key templates right before the job template, which always comes last,
+// when an encryption key is configured
+// returns:			[]ResourceTemplate
+func (c Client) withEncryptionKey(actions []ResourceTemplate, encryptionKey []ResourceTemplate) []ResourceTemplate {
+	if c.EncryptionKeyData == "" {
+		return actions
+	}
+	return beforeJob(actions, encryptionKey)
+}
+
+// beforeJob inserts templates right before the job template, which always comes last
+// returns:			[]ResourceTemplate
+func beforeJob(actions []ResourceTemplate, inserted []ResourceTemplate) []ResourceTemplate {
+	templates := []ResourceTemplate{}
+	templates = append(templates, actions[:len(actions)-1]...)
+	templates = append(templates, inserted...)
+	return append(templates, actions[len(actions)-1])
+}
+
+// FetchEncryptionKey reads the key of an encryption secret on the hub, referenced as namespace/name, so that it
+// can be propagated to the spokes
+// returns:			base64 encoded key, error
+func (c Client) FetchEncryptionKey(ctx context.Context, ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid encryption secret %q, expecting namespace/name", ref)
+	}
+
+	log.WithFields(log.Fields{"EncryptionKey": "Fetching"}).Debugf("Fetching the encryption secret: %s", ref)
+	secret, err := c.KubernetesClient.Resource(SecretGVR).Namespace(parts[0]).Get(ctx, parts[1], v1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get encryption secret %s: %s", ref, err)
+	}
+
+	data, found, err := unstructured.NestedString(secret.Object, "data", "key")
+	if err != nil || !found || data == "" {
+		return "", fmt.Errorf("encryption secret %s has no key data", ref)
+	}
+	if _, err := base64.StdEncoding.DecodeString(data); err != nil {
+		return "", fmt.Errorf("encryption secret %s: %s", ref, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultImage is the image running the backup job on the spokes
+const DefaultImage = "quay.io/redhat_ztp/openshift-ai-image-backup:latest"
+
+// SecretGVR represents the secret resource on the hub
+var SecretGVR = schema.GroupVersionResource{
+	Group:    "",
+	Version:  "v1",
+	Resource: "secrets",
+}
+
+// ValidateImage checks the backup image is referenced by tag or digest
+// returns:			error
+func ValidateImage(image string) error {
+	if image == "" || strings.ContainsAny(image, " \t\n\"") {
+		return fmt.Errorf("invalid image reference %q", image)
+	}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		if !strings.HasPrefix(name[i+1:], "sha256:") {
+			return fmt.Errorf("invalid image digest in %q, expecting sha256:<digest>", image)
+		}
+		name = name[:i]
+	}
+	if name == "" || strings.HasSuffix(name, ":") || strings.HasSuffix(name, "/") {
+		return fmt.Errorf("invalid image reference %q", image)
+	}
+	return nil
+}
+
+// RegistryAuthTemplates populates templates for creation of managedclusteraction resource to propagate the
+// credentials the backup job pushes the backup to a registry with
+var RegistryAuthTemplates = []ResourceTemplate{
+	{"backup-create-registryauth", mngClusterActCreateRegistryAuth},
+}
+
+// withRegistryAuth inserts the registry credentials templates right before the job template, which always comes
+// last, when registry credentials are configured
+// returns:			[]ResourceTemplate
+func (c Client) withRegistryAuth(actions []ResourceTemplate, registryAuth []ResourceTemplate) []ResourceTemplate {
+	if c.RegistryAuthData == "" {
+		return actions
+	}
+	return beforeJob(actions, registryAuth)
+}
+
+// FetchPullSecret reads the .dockerconfigjson of an image pull secret on the hub, referenced as namespace/name,
+// so that it can be propagated to the spokes
+// returns:			base64 encoded .dockerconfigjson, error
+func (c Client) FetchPullSecret(ctx context.Context, ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid pull secret %q, expecting namespace/name", ref)
+	}
+
+	log.WithFields(log.Fields{"PullSecret": "Fetching"}).Debugf("Fetching the pull secret: %s", ref)
+	secret, err := c.KubernetesClient.Resource(SecretGVR).Namespace(parts[0]).Get(ctx, parts[1], v1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get pull secret %s: %s", ref, err)
+	}
+
+	secretType, _, _ := unstructured.NestedString(secret.Object, "type")
+	if secretType != "kubernetes.io/dockerconfigjson" {
+		return "", fmt.Errorf("pull secret %s is of type %q, expecting kubernetes.io/dockerconfigjson", ref, secretType)
+	}
+	data, found, err := unstructured.NestedString(secret.Object, "data", ".dockerconfigjson")
+	if err != nil || !found || data == "" {
+		return "", fmt.Errorf("pull secret %s has no .dockerconfigjson data", ref)
+	}
+	return data, nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"bytes"
 	"context"
+	goerrors "errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -26,16 +27,16 @@ import (
 
 // MCA, MCV represnts the corresponding resources
 var (
-	MCA          = "managedclusteractions"
-	MCV          = "managedclusterviews"
-	Failed       = "FAILED"
-	Done         = "DONE"
-	NExist       = "NON-EXISTENT"
-	NErr         = "NO ERROR"
-	TimeInterval = 5
-	TimeOut      = 120
-	Launch       = "launched"
-	Complete     = "completed"
+	MCA         = "managedclusteractions"
+	MCV         = "managedclusterviews"
+	Failed      = "FAILED"
+	Done        = "DONE"
+	NExist      = "NON-EXISTENT"
+	Interrupted = "INTERRUPTED"
+	Skipped     = "SKIPPED"
+	NErr        = "NO ERROR"
+	Launch      = "launched"
+	Complete    = "completed"
 )
 
 // Client provides a k8s dynamic client
@@ -44,13 +45,65 @@ type Client struct {
 	BackupPath       string
 	KubeconfigPath   string
 	KubernetesClient dynamic.Interface
+	Poll             PollOptions
+	// Image is the reference, by tag or digest, of the image running the backup job on the spokes
+	Image string
+	// PullSecretData is the base64 encoded .dockerconfigjson propagated to the spokes to pull Image
+	PullSecretData string
+	// EncryptionKeyData is the base64 encoded key propagated to the spokes to encrypt and decrypt the backups
+	EncryptionKeyData string
+	// S3SecretData is the base64 encoded data of the secret propagated to the spokes to export and fetch the backups
+	S3SecretData map[string]string
+	// RegistryAuthData is the base64 encoded .dockerconfigjson propagated to the spokes to push the backups
+	RegistryAuthData string
+	// RecoveryArgs are the extra arguments passed to the recovery job
+	RecoveryArgs []string
+	// BackupArgs are the extra arguments passed to the backup job
+	BackupArgs []string
+	// Transport carries the jobs to the spokes: managedclusteraction, the default, or manifestwork
+	Transport string
 }
 
 // TemplateData provides template rendering data
 type TemplateData struct {
-	ResourceName string
-	ClusterName  string
-	RecoveryPath string
+	ResourceName   string
+	ClusterName    string
+	RecoveryPath   string
+	Image          string
+	PullSecretData string
+	// EncryptionKeyData is the base64 encoded key mounted in the jobs
+	EncryptionKeyData string
+	// S3SecretData is the base64 encoded data of the secret set as the environment of the jobs
+	S3SecretData map[string]string
+	// RegistryAuthData is the base64 encoded .dockerconfigjson mounted in the backup job
+	RegistryAuthData string
+	// RecoveryArgs are the extra arguments of the recovery job
+	RecoveryArgs []string
+	// BackupArgs are the extra arguments of the backup job
+	BackupArgs []string
+}
+
+// String renders the template data without the pull secret, the encryption key, the S3 secret and the registry
+// credentials, so that it can be logged
+func (d TemplateData) String() string {
+	pullSecret := ""
+	if d.PullSecretData != "" {
+		pullSecret = "<redacted>"
+	}
+	encryptionKey := ""
+	if d.EncryptionKeyData != "" {
+		encryptionKey = "<redacted>"
+	}
+	s3Secret := ""
+	if len(d.S3SecretData) > 0 {
+		s3Secret = "<redacted>"
+	}
+	registryAuth := ""
+	if d.RegistryAuthData != "" {
+		registryAuth = "<redacted>"
+	}
+	return fmt.Sprintf("{ResourceName: %s, ClusterName: %s, RecoveryPath: %s, Image: %s, PullSecretData: %s, EncryptionKeyData: %s, S3SecretData: %s, RegistryAuthData: %s, RecoveryArgs: %v, BackupArgs: %v}",
+		d.ResourceName, d.ClusterName, d.RecoveryPath, d.Image, pullSecret, encryptionKey, s3Secret, registryAuth, d.RecoveryArgs, d.BackupArgs)
 }
 
 // ResourceTemplate define a resource template structure
@@ -68,6 +121,11 @@ var ActionCreateTemplates = []ResourceTemplate{
 	{"backup-create-job", mngClusterActCreateJob},
 }
 
+// PullSecretTemplates populates templates for creation of managedclusteraction resource to propagate the image pull secret
+var PullSecretTemplates = []ResourceTemplate{
+	{"backup-create-pullsecret", mngClusterActCreatePullSecret},
+}
+
 // ViewCreateTemplates populates templates for creation of managedclusterview resource
 var ViewCreateTemplates = []ResourceTemplate{
 	{"backup-create-clusterview", mngClusterViewJob},
@@ -78,11 +136,50 @@ var JobDeleteTemplates = []ResourceTemplate{
 	{"backup-delete-ns", mngClusterActDeleteNS},
 }
 
+// ActionTemplates returns the templates of the managedclusteractions launching the backup job, propagating
+// the image pull secret, the encryption key, the S3 secret and the registry credentials before the job is created
+// when they are configured
+// returns:			[]ResourceTemplate
+func (c Client) ActionTemplates() []ResourceTemplate {
+	actions := c.withEncryptionKey(c.withPullSecret(ActionCreateTemplates, PullSecretTemplates), EncryptionKeyTemplates)
+	return c.withRegistryAuth(c.withS3Secret(actions, S3SecretTemplates), RegistryAuthTemplates)
+}
+
+// withPullSecret inserts the pull secret templates right before the job template, which always comes last,
+// when an image pull secret is configured
+// returns:			[]ResourceTemplate
+func (c Client) withPullSecret(actions []ResourceTemplate, pullSecret []ResourceTemplate) []ResourceTemplate {
+	if c.PullSecretData == "" {
+		return actions
+	}
+	templates := []ResourceTemplate{}
+	templates = append(templates, actions[:len(actions)-1]...)
+	templates = append(templates, pullSecret...)
+	return append(templates, actions[len(actions)-1])
+}
+
+// ResourceNames lists the names of the resources created from templates
+// returns:			[]string
+func ResourceNames(template []ResourceTemplate) []string {
+	names := []string{}
+	for _, item := range template {
+		names = append(names, item.ResourceName)
+	}
+	return names
+}
+
 // New creates a new instance of k8s client
 // returns:			client, error
 func New(Spoke []string, BackupPath string, KubeconfigPath string) (Client, error) {
 	rand.Seed(time.Now().UnixNano())
-	c := Client{Spoke, BackupPath, KubeconfigPath, nil}
+	c := Client{
+		Spoke:          Spoke,
+		BackupPath:     BackupPath,
+		KubeconfigPath: KubeconfigPath,
+		Poll:           DefaultPollOptions(),
+		Image:          DefaultImage,
+		Transport:      TransportAction,
+	}
 
 	var clientset dynamic.Interface
 
@@ -121,17 +218,11 @@ func New(Spoke []string, BackupPath string, KubeconfigPath string) (Client, erro
 
 // SpokeClusterExists verifies if a provided spoke cluster do exist or not
 // returns:			bool
-func (c Client) SpokeClusterExists(name string) bool {
+func (c Client) SpokeClusterExists(ctx context.Context, name string) bool {
 
 	// using client, get if spoke cluster with given name exists
-	gvr := schema.GroupVersionResource{
-		Group:    "cluster.open-cluster-management.io",
-		Version:  "v1",
-		Resource: "managedclusters",
-	}
-
 	log.WithFields(log.Fields{"SpokeStatus": "Checking"}).Debugf("Checking if the Spoke cluster: %s exist...", name)
-	foundSpokeCluster, err := c.KubernetesClient.Resource(gvr).Get(context.Background(), name, v1.GetOptions{})
+	foundSpokeCluster, err := c.KubernetesClient.Resource(ManagedClusterGVR).Get(ctx, name, v1.GetOptions{})
 
 	if err != nil {
 		log.Error(err)
@@ -177,22 +268,39 @@ func (c Client) GetConfig() (*rest.Config, error) {
 	return config, nil
 }
 
+// templateData returns the data rendering the templates of a spoke
+// returns:			TemplateData
+func (c Client) templateData(clusterName string) TemplateData {
+	return TemplateData{
+		ResourceName:      "",
+		ClusterName:       clusterName,
+		RecoveryPath:      c.BackupPath,
+		Image:             c.Image,
+		PullSecretData:    c.PullSecretData,
+		EncryptionKeyData: c.EncryptionKeyData,
+		S3SecretData:      c.S3SecretData,
+		RegistryAuthData:  c.RegistryAuthData,
+		RecoveryArgs:      c.RecoveryArgs,
+		BackupArgs:        c.BackupArgs,
+	}
+}
+
 // LaunchKubernetesObjects creates managedclusteraction and managedclusterview resources from template
+// The creation stops as soon as the context is cancelled
 // returns:			error
-func (c Client) LaunchKubernetesObjects(clusterName string, template []ResourceTemplate) error {
+func (c Client) LaunchKubernetesObjects(ctx context.Context, clusterName string, template []ResourceTemplate) error {
 	config, err := c.GetConfig()
 	if err != nil {
 		log.Error(err)
 		return err
 	}
 
-	newdata := TemplateData{
-		ResourceName: "",
-		ClusterName:  clusterName,
-		RecoveryPath: c.BackupPath,
-	}
+	newdata := c.templateData(clusterName)
 
 	for _, item := range template {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		obj := &unstructured.Unstructured{}
 		newdata.ResourceName = item.ResourceName
 
@@ -237,7 +345,7 @@ func (c Client) LaunchKubernetesObjects(clusterName string, template []ResourceT
 		}
 		log.WithFields(log.Fields{"LaunchKubernetesObjects": "Creating Resource"}).Debugf("CREATING the resource: [%s] at namespace: [backupresource] of spoke: [%s] ....", item.ResourceName, clusterName)
 		//	log.Debugf("CREATING the resource: [%s] at namespace: [backupresource] of spoke: [%s] ....", item.ResourceName, clusterName)
-		err = c.CreateKubernetesObjects(clusterName, obj, resource)
+		err = c.CreateKubernetesObjects(ctx, clusterName, obj, resource)
 		if err != nil {
 			log.Error(err)
 			return err
@@ -280,9 +388,9 @@ func (c Client) RenderYamlTemplate(resourceName string, templatebody string, dat
 // CreateKubernetesObjects creates specific mca and mcv object targeted to spoke cluster based on
 // unstructured object and gvr
 // returns:			error
-func (c Client) CreateKubernetesObjects(clusterName string, obj *unstructured.Unstructured, resource schema.GroupVersionResource) error {
+func (c Client) CreateKubernetesObjects(ctx context.Context, clusterName string, obj *unstructured.Unstructured, resource schema.GroupVersionResource) error {
 
-	_, err := c.KubernetesClient.Resource(resource).Namespace(clusterName).Create(context.Background(), obj, v1.CreateOptions{})
+	_, err := c.KubernetesClient.Resource(resource).Namespace(clusterName).Create(ctx, obj, v1.CreateOptions{})
 	if err != nil {
 		log.Debugf("err is : %s", err)
 		return err
@@ -290,13 +398,17 @@ func (c Client) CreateKubernetesObjects(clusterName string, obj *unstructured.Un
 	return nil
 }
 
-// ManageObjects can query and delete k8s resource
+// ManageObjects can query and delete k8s resource, deleting a resource which is already gone is not an error
 // returns:			*unstructured.Unstructured (view data)
 //                   error
-func (c Client) ManageObjects(clusterName string, template []ResourceTemplate, resourceType string, action string) (*unstructured.Unstructured, error) {
+func (c Client) ManageObjects(ctx context.Context, clusterName string, template []ResourceTemplate, resourceType string, action string) (*unstructured.Unstructured, error) {
 
+	group := "view.open-cluster-management.io"
+	if resourceType == MCA {
+		group = "action.open-cluster-management.io"
+	}
 	gvr := schema.GroupVersionResource{
-		Group:    "view.open-cluster-management.io",
+		Group:    group,
 		Version:  "v1beta1",
 		Resource: resourceType,
 	}
@@ -306,14 +418,18 @@ func (c Client) ManageObjects(clusterName string, template []ResourceTemplate, r
 	for _, items := range template {
 		switch action {
 		case "get":
-			view, err := c.KubernetesClient.Resource(gvr).Namespace(clusterName).Get(context.Background(), items.ResourceName, v1.GetOptions{})
+			view, err := c.KubernetesClient.Resource(gvr).Namespace(clusterName).Get(ctx, items.ResourceName, v1.GetOptions{})
 			if err != nil {
 				return view, err
 			}
 			return view, nil
 
 		case "delete":
-			err := c.KubernetesClient.Resource(gvr).Namespace(clusterName).Delete(context.Background(), items.ResourceName, v1.DeleteOptions{})
+			err := c.KubernetesClient.Resource(gvr).Namespace(clusterName).Delete(ctx, items.ResourceName, v1.DeleteOptions{})
+			if errors.IsNotFound(err) {
+				log.Debugf("The %s resource named: [%s] for cluster: %s is already gone", resourceType, items.ResourceName, clusterName)
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -338,41 +454,70 @@ func (c Client) ViewProcessing(viewConditions []interface{}) (string, string) {
 	return status, t
 }
 
-// JobStatus uses timeout to verify the state of the job in a predefined window
+// JobStatus polls the state of the job watched by the view until it reaches the launched or completed phase, each phase having its
+// own timeout. The poll interval backs off exponentially with jitter, and polling stops as soon as the context
+// is cancelled
 // returns: 	error
-func (c Client) JobStatus(clusterName string, action string) error {
+func (c Client) JobStatus(ctx context.Context, clusterName string, action string, view []ResourceTemplate) error {
+	return c.pollJob(ctx, clusterName, action, "managedclusterview", func() (string, error) {
+		return c.CheckStatus(ctx, MCV, clusterName, action, view)
+	})
+}
+
+// pollJob polls the state of a job with check until it reaches the launched or completed phase, within the
+// timeout of the phase, backing off exponentially with jitter. A failed job stops the polling right away
+// returns: 	error
+func (c Client) pollJob(ctx context.Context, clusterName string, action string, source string, check func() (string, error)) error {
 
-	ticker := time.NewTicker(time.Second * time.Duration(TimeInterval)).C
-	timeout := time.After(time.Second * time.Duration(TimeOut))
+	timeout := c.Poll.Timeout(action)
+	deadline := time.After(timeout)
+	interval := c.Poll.Interval
+	ticker := time.NewTimer(jitter(interval))
+	defer ticker.Stop()
 
+	lastCondition := "none"
 OuterLoop:
 	for {
 		select {
-		case <-timeout:
+		case <-ctx.Done():
+			log.WithFields(log.Fields{"cancel": "Checking"}).Debugf("stopped polling the job status of cluster: %s", clusterName)
+			return ctx.Err()
+
+		case <-deadline:
 			log.WithFields(log.Fields{"timeout": "Checking"}).Debug("function timedout")
-			return fmt.Errorf("couldn't verify the backup job completion before a predefined time window")
+			return fmt.Errorf("%s phase timed out after %s for cluster: %s, last %s condition: %s", phaseName(action), timeout, clusterName, source, lastCondition)
 
-		case <-ticker:
-			if err := c.CheckStatus(MCV, clusterName, action); err != nil {
-				fmt.Printf("err: %v", err)
-			} else {
+		case <-ticker.C:
+			condition, err := check()
+			if condition != "" {
+				lastCondition = condition
+			}
+			if err == nil {
 				break OuterLoop
 			}
+			var failure *JobFailedError
+			if goerrors.As(err, &failure) {
+				// a failed job won't recover, there is no point waiting for the timeout
+				return err
+			}
+			log.Debugf("%s phase not reached yet for cluster: %s, err: %v", phaseName(action), clusterName, err)
+			interval = c.Poll.Backoff(interval)
+			ticker.Reset(jitter(interval))
 		}
 	}
 	return nil
 }
 
 // CheckStatus checks whether the job launched on the spoke was successfully launched and finished
-// returns: 	error
-func (c Client) CheckStatus(resourceType string, clusterName string, action string) error {
+// returns: 	last managedclusterview condition found, error
+func (c Client) CheckStatus(ctx context.Context, resourceType string, clusterName string, action string, view []ResourceTemplate) (string, error) {
 
 	log.Debug("####### Checking status of kubernetes job #######")
 
-	clusterView, err := c.ManageObjects(clusterName, ViewCreateTemplates, resourceType, "get")
+	clusterView, err := c.ManageObjects(ctx, clusterName, view, resourceType, "get")
 	if err != nil {
 		log.Errorf("Couldn't find managedclusterview from %s cluster; err: %s", c.Spoke, err)
-		return err
+		return "", err
 	}
 	log.Debug("Found managedclusterview object")
 
@@ -388,24 +533,28 @@ func (c Client) CheckStatus(resourceType string, clusterName string, action stri
 
 	if err != nil {
 		log.Error(err)
-		return err
+		return "", err
 	}
 	log.Debugf("conditions: %s", conditions)
 	if !exists {
-		return fmt.Errorf("unable to traverse object, maybe result field is yet not available")
+		return "", fmt.Errorf("unable to traverse object, maybe result field is yet not available")
 	}
 	value, t := c.ViewProcessing(conditions)
+	condition := fmt.Sprintf("type=%s status=%s", t, value)
+	if action == Complete && t == "Failed" && value == "True" {
+		return condition, jobFailure(clusterName, clusterView, conditions)
+	}
 	if value == "True" {
 		switch t {
 		case "Processing":
 			log.Debug("The job has successfully launched")
-			return nil
+			return condition, nil
 		case "Complete":
 			log.Debug("The job has successfully finished")
-			return nil
+			return condition, nil
 		}
 	}
 
-	return fmt.Errorf("expecting the status to be either Processing or Complete but found: %s for cluster: %s", t, clusterName)
+	return condition, fmt.Errorf("expecting the status to be either Processing or Complete but found: %s for cluster: %s", t, clusterName)
 
 }
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+// fakeListKinds maps the resources the client lists on the hub to their list kinds
+var fakeListKinds = map[schema.GroupVersionResource]string{
+	ManagedClusterGVR:      "ManagedClusterList",
+	ClusterGroupUpgradeGVR: "ClusterGroupUpgradeList",
+	ConfigMapGVR:           "ConfigMapList",
+	ManifestWorkGVR:        "ManifestWorkList",
+	BackupRequestGVR:       "BackupRequestList",
+	SecretGVR:              "SecretList",
+}
+
+// newFakeClient returns a client of a fake hub holding objects
+func newFakeClient(objects ...runtime.Object) Client {
+	return Client{
+		BackupPath:       DefaultBackupPath,
+		KubernetesClient: fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), fakeListKinds, objects...),
+		Poll:             DefaultPollOptions(),
+		Image:            DefaultImage,
+		Transport:        TransportAction,
+	}
+}
+
+// newObject returns an object of the hub, with its labels
+func newObject(apiVersion string, kind string, namespace string, name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+	}}
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+// managedCluster returns a managedcluster of the hub, with its labels
+func managedCluster(name string, labels map[string]string) *unstructured.Unstructured {
+	return newObject("cluster.open-cluster-management.io/v1", "ManagedCluster", "", name, labels)
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// JobTemplates groups the templates of the managedclusteractions launching a job on a spoke, of the
+// managedclusterview watching it and of the managedclusteractions deleting it. Name names the manifestwork
+// carrying the resources of the managedclusteractions instead
+type JobTemplates struct {
+	Actions []ResourceTemplate
+	Views   []ResourceTemplate
+	Deletes []ResourceTemplate
+	Name    string
+}
+
+// RecoveryCreateTemplates populates templates for creation of managedclusteraction resources launching the recovery job
+var RecoveryCreateTemplates = []ResourceTemplate{
+	{"recovery-create-namespace", mngClusterActCreateNS},
+	{"recovery-create-serviceaccount", mngClusterActCreateSA},
+	{"recovery-create-rolebinding", mngClusterActCreateRB},
+	{"recovery-create-job", mngClusterActCreateRecoveryJob},
+}
+
+// RecoveryPullSecretTemplates populates templates for creation of managedclusteraction resource to propagate the image
+// pull secret of the recovery job
+var RecoveryPullSecretTemplates = []ResourceTemplate{
+	{"recovery-create-pullsecret", mngClusterActCreatePullSecret},
+}
+
+// RecoveryViewTemplates populates templates for creation of managedclusterview resource watching the recovery job
+var RecoveryViewTemplates = []ResourceTemplate{
+	{"recovery-create-clusterview", mngClusterViewRecoveryJob},
+}
+
+// RecoveryDeleteTemplates populates templates for creation of managedclusteraction resource to delete the namespace
+// of the recovery job in the spoke
+var RecoveryDeleteTemplates = []ResourceTemplate{
+	{"recovery-delete-ns", mngClusterActDeleteNS},
+}
+
+// BackupJobTemplates returns the templates launching, watching and deleting the backup job
+// returns:			JobTemplates
+func (c Client) BackupJobTemplates() JobTemplates {
+	return JobTemplates{c.ActionTemplates(), ViewCreateTemplates, JobDeleteTemplates, "backup-job"}
+}
+
+// RecoveryJobTemplates returns the templates launching, watching and deleting the recovery job
+// returns:			JobTemplates
+func (c Client) RecoveryJobTemplates() JobTemplates {
+	actions := c.withEncryptionKey(c.withPullSecret(RecoveryCreateTemplates, RecoveryPullSecretTemplates), RecoveryEncryptionKeyTemplates)
+	actions = c.withS3Secret(actions, RecoveryS3SecretTemplates)
+	return JobTemplates{actions, RecoveryViewTemplates, RecoveryDeleteTemplates, "recovery-job"}
+}
+
+// WaitForSpokeAvailability polls the managedcluster until its availability matches the expected one. The spoke is
+// only taken as unavailable when its Available condition says so, errors reading the managedcluster are retried
+// returns:			error
+func (c Client) WaitForSpokeAvailability(ctx context.Context, name string, available bool, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	interval := c.Poll.Interval
+	ticker := time.NewTimer(jitter(interval))
+	defer ticker.Stop()
+
+	last := "availability not read yet"
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-deadline:
+			return fmt.Errorf("cluster %s availability didn't become %t within %s, last: %s", name, available, timeout, last)
+
+		case <-ticker.C:
+			status, err := c.spokeAvailability(ctx, name)
+			switch {
+			case err != nil:
+				log.Warnf("Couldn't read the availability of cluster %s, retrying: %s", name, err)
+				last = err.Error()
+			case status == "":
+				last = "Available condition not reported"
+			case (status == string(v1.ConditionTrue)) == available:
+				log.Debugf("Cluster %s availability is %t", name, available)
+				return nil
+			default:
+				last = fmt.Sprintf("Available condition %s", status)
+			}
+			interval = c.Poll.Backoff(interval)
+			ticker.Reset(jitter(interval))
+		}
+	}
+}
+
+// spokeAvailability reads the status of the Available condition of a managedcluster, False or Unknown once the hub
+// lost contact with the spoke, empty when the condition isn't reported
+// returns:			condition status, error
+func (c Client) spokeAvailability(ctx context.Context, name string) (string, error) {
+	cluster, err := c.KubernetesClient.Resource(ManagedClusterGVR).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	conditions, _, err := unstructured.NestedSlice(cluster.Object, "status", "conditions")
+	if err != nil {
+		return "", err
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "ManagedClusterConditionAvailable" {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		return status, nil
+	}
+	return "", nil
+}
+
+// FailureAnnotation is set on the job by the backup image with the error which made it fail
+const FailureAnnotation = "openshift-ai-image-backup/failure"
+
+// JobFailedError reports a job which failed on a spoke
+type JobFailedError struct {
+	ClusterName string
+	Reason      string
+	Message     string
+}
+
+func (e *JobFailedError) Error() string {
+	return fmt.Sprintf("job failed on cluster: %s, reason: %s, %s", e.ClusterName, e.Reason, e.Message)
+}
+
+// jobFailure builds the error of a failed job from the job returned by a managedclusterview, preferring the
+// error reported by the backup image to the message of the Failed condition
+// returns:			*JobFailedError
+func jobFailure(clusterName string, jobView *unstructured.Unstructured, conditions []interface{}) *JobFailedError {
+	failure := &JobFailedError{ClusterName: clusterName}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Failed" {
+			continue
+		}
+		failure.Reason, _ = condition["reason"].(string)
+		failure.Message, _ = condition["message"].(string)
+	}
+	if message, found, _ := unstructured.NestedString(jobView.Object, "status", "result", "metadata", "annotations", FailureAnnotation); found && message != "" {
+		failure.Message = message
+	}
+	return failure
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// clusterWithAvailability returns a managedcluster of the hub whose Available condition has the status, or no
+// condition at all when the status is empty
+func clusterWithAvailability(name string, status string) *unstructured.Unstructured {
+	obj := managedCluster(name, nil)
+	if status != "" {
+		obj.Object["status"] = map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "ManagedClusterConditionJoined", "status": "True"},
+				map[string]interface{}{"type": "ManagedClusterConditionAvailable", "status": status},
+			},
+		}
+	}
+	return obj
+}
+
+func TestWaitForSpokeAvailability(t *testing.T) {
+	tests := []struct {
+		name      string
+		cluster   *unstructured.Unstructured
+		available bool
+		// getErrors is the number of reads of the managedcluster failing before it is returned
+		getErrors int
+		err       string
+	}{
+		{name: "available", cluster: clusterWithAvailability("spoke1", "True"), available: true},
+		{name: "unavailable after a lost lease", cluster: clusterWithAvailability("spoke1", "Unknown")},
+		{name: "unavailable", cluster: clusterWithAvailability("spoke1", "False")},
+		{name: "read errors are retried", cluster: clusterWithAvailability("spoke1", "Unknown"), getErrors: 2},
+		{
+			name:    "missing condition isn't unavailable",
+			cluster: clusterWithAvailability("spoke1", ""),
+			err:     "last: Available condition not reported",
+		},
+		{
+			name:      "read errors aren't unavailable",
+			cluster:   clusterWithAvailability("spoke1", "True"),
+			getErrors: 1000,
+			err:       "last: connection refused",
+		},
+		{
+			name:    "missing cluster isn't unavailable",
+			cluster: clusterWithAvailability("spoke2", "True"),
+			err:     `"spoke1" not found`,
+		},
+		{
+			name:      "still unavailable",
+			cluster:   clusterWithAvailability("spoke1", "False"),
+			available: true,
+			err:       "availability didn't become true within 50ms, last: Available condition False",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient(test.cluster)
+			client.Poll = PollOptions{Interval: time.Millisecond, MaxInterval: 2 * time.Millisecond}
+			failed := 0
+			client.KubernetesClient.(*fake.FakeDynamicClient).PrependReactor("get", "managedclusters", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if failed < test.getErrors {
+					failed++
+					return true, nil, fmt.Errorf("connection refused")
+				}
+				return false, nil, nil
+			})
+
+			err := client.WaitForSpokeAvailability(context.Background(), "spoke1", test.available, 50*time.Millisecond)
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("error = %v, want it to contain %q", err, test.err)
+			}
+			if test.err == "" && failed != test.getErrors {
+				t.Errorf("%d reads failed, want %d", failed, test.getErrors)
+			}
+		})
+	}
+}
+
+func TestWaitForSpokeAvailabilityCancelled(t *testing.T) {
+	client := newFakeClient(clusterWithAvailability("spoke1", "True"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.WaitForSpokeAvailability(ctx, "spoke1", false, time.Minute); err != context.Canceled {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+)
+
+// ManifestWorkGVR represents the manifestwork resource on the hub
+var ManifestWorkGVR = schema.GroupVersionResource{
+	Group:    "work.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "manifestworks",
+}
+
+// Status feedback of the job carried by a manifestwork. The work agent only reports scalar JSON paths, the
+// conditions and annotations of the job can't be fed back
+const (
+	feedbackSucceeded = "succeeded"
+	feedbackFailed    = "failed"
+	feedbackActive    = "active"
+)
+
+// ManifestWorkTransport carries the job through a manifestwork and watches it through the status feedback of the
+// manifestwork
+type ManifestWorkTransport struct {
+	client Client
+}
+
+// NewManifestWorkTransport creates the manifestwork transport of a client
+// returns:			*ManifestWorkTransport
+func NewManifestWorkTransport(c Client) *ManifestWorkTransport {
+	return &ManifestWorkTransport{client: c}
+}
+
+// Objects names the manifestwork of the job, whose deletion tears the job down
+// returns:			HubObjects, HubObjects
+func (t *ManifestWorkTransport) Objects(templates JobTemplates) (HubObjects, HubObjects) {
+	return HubObjects{Works: []string{templates.Name}}, HubObjects{}
+}
+
+// CreateJob creates the manifestwork holding the resources the managedclusteractions of the job would create: the
+// namespace, the service account, the binding, the secrets and the job, with a status feedback on the job
+// returns:			error
+func (t *ManifestWorkTransport) CreateJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	work, err := t.manifestWork(clusterName, templates)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"ManifestWork": "Creating"}).Debugf("Creating the manifestwork: [%s] of spoke: [%s]", templates.Name, clusterName)
+	_, err = t.client.KubernetesClient.Resource(ManifestWorkGVR).Namespace(clusterName).Create(ctx, work, v1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't create manifestwork %s in the %s cluster err: %s", templates.Name, clusterName, err)
+	}
+	log.Infof("Successfully created manifestwork %s", templates.Name)
+	return nil
+}
+
+// manifestWork renders the manifestwork of the job from the managedclusteractions of the job
+// returns:			*unstructured.Unstructured, error
+func (t *ManifestWorkTransport) manifestWork(clusterName string, templates JobTemplates) (*unstructured.Unstructured, error) {
+	data := t.client.templateData(clusterName)
+	manifests := []interface{}{}
+	var job *unstructured.Unstructured
+
+	for _, item := range templates.Actions {
+		w, err := t.client.RenderYamlTemplate(item.ResourceName, item.Template, data)
+		if err != nil {
+			return nil, err
+		}
+		action := &unstructured.Unstructured{}
+		dec := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+		if _, _, err := dec.Decode(w.Bytes(), nil, action); err != nil {
+			return nil, fmt.Errorf("couldn't decode template %s: %s", item.ResourceName, err)
+		}
+
+		manifest, found, err := unstructured.NestedMap(action.Object, "spec", "kube", "template")
+		if err != nil || !found {
+			return nil, fmt.Errorf("template %s creates no resource", item.ResourceName)
+		}
+		resource := &unstructured.Unstructured{Object: manifest}
+		if namespace, _, _ := unstructured.NestedString(action.Object, "spec", "kube", "namespace"); namespace != "" && resource.GetNamespace() == "" {
+			resource.SetNamespace(namespace)
+		}
+		if resource.GetKind() == "Job" {
+			job = resource
+		}
+		manifests = append(manifests, resource.Object)
+	}
+	if job == nil {
+		return nil, fmt.Errorf("templates of %s create no job", templates.Name)
+	}
+
+	work := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": ManifestWorkGVR.GroupVersion().String(),
+		"kind":       "ManifestWork",
+		"spec": map[string]interface{}{
+			"workload": map[string]interface{}{
+				"manifests": manifests,
+			},
+			"manifestConfigs": []interface{}{
+				map[string]interface{}{
+					"resourceIdentifier": map[string]interface{}{
+						"group":     "batch",
+						"resource":  "jobs",
+						"name":      job.GetName(),
+						"namespace": job.GetNamespace(),
+					},
+					"feedbackRules": []interface{}{
+						map[string]interface{}{
+							"type": "JSONPaths",
+							"jsonPaths": []interface{}{
+								map[string]interface{}{"name": feedbackSucceeded, "path": ".status.succeeded"},
+								map[string]interface{}{"name": feedbackFailed, "path": ".status.failed"},
+								map[string]interface{}{"name": feedbackActive, "path": ".status.active"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+	work.SetName(templates.Name)
+	work.SetNamespace(clusterName)
+	return work, nil
+}
+
+// WatchJob has nothing to create, the manifestwork reports the status of the job
+// returns:			error
+func (t *ManifestWorkTransport) WatchJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	return nil
+}
+
+// JobWatched checks whether the manifestwork of the job is on the hub
+// returns:			bool, error
+func (t *ManifestWorkTransport) JobWatched(ctx context.Context, clusterName string, templates JobTemplates) (bool, error) {
+	_, err := t.client.KubernetesClient.Resource(ManifestWorkGVR).Namespace(clusterName).Get(ctx, templates.Name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ClearJob has nothing to delete, the manifestwork is created at once
+// returns:			error
+func (t *ManifestWorkTransport) ClearJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	return nil
+}
+
+// JobStatus polls the job through the manifestwork: the job is launched once applied on the spoke, and completed
+// once its status feedback reports a succeeded pod
+// returns:			error
+func (t *ManifestWorkTransport) JobStatus(ctx context.Context, clusterName string, action string, templates JobTemplates) error {
+	return t.client.pollJob(ctx, clusterName, action, "manifestwork", func() (string, error) {
+		return t.checkStatus(ctx, clusterName, action, templates)
+	})
+}
+
+// checkStatus checks whether the job carried by the manifestwork was applied on the spoke and finished
+// returns:			last condition found, error
+func (t *ManifestWorkTransport) checkStatus(ctx context.Context, clusterName string, action string, templates JobTemplates) (string, error) {
+	work, err := t.client.KubernetesClient.Resource(ManifestWorkGVR).Namespace(clusterName).Get(ctx, templates.Name, v1.GetOptions{})
+	if err != nil {
+		log.Errorf("Couldn't find manifestwork %s of cluster %s; err: %s", templates.Name, clusterName, err)
+		return "", err
+	}
+
+	manifests, _, _ := unstructured.NestedSlice(work.Object, "status", "resourceStatus", "manifests")
+	for _, m := range manifests {
+		manifest, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if resource, _, _ := unstructured.NestedString(manifest, "resourceMeta", "resource"); resource != "jobs" {
+			continue
+		}
+
+		if action != Complete {
+			conditions, _, _ := unstructured.NestedSlice(manifest, "conditions")
+			for _, c := range conditions {
+				condition, ok := c.(map[string]interface{})
+				if ok && condition["type"] == "Applied" {
+					state := fmt.Sprintf("type=Applied status=%v", condition["status"])
+					if condition["status"] == "True" {
+						log.Debug("The job has successfully launched")
+						return state, nil
+					}
+					return state, fmt.Errorf("job not applied yet on cluster: %s", clusterName)
+				}
+			}
+			return "", fmt.Errorf("job not applied yet on cluster: %s", clusterName)
+		}
+
+		feedback := statusFeedback(manifest)
+		state := fmt.Sprintf("succeeded=%s failed=%s active=%s", feedback[feedbackSucceeded], feedback[feedbackFailed], feedback[feedbackActive])
+		if feedback[feedbackSucceeded] != "" && feedback[feedbackSucceeded] != "0" {
+			log.Debug("The job has successfully finished")
+			return state, nil
+		}
+		if feedback[feedbackFailed] != "" && feedback[feedbackFailed] != "0" && (feedback[feedbackActive] == "" || feedback[feedbackActive] == "0") {
+			// the job gave up, the error reported by the backup image is only in its annotation on the spoke
+			namespace, _, _ := unstructured.NestedString(manifest, "resourceMeta", "namespace")
+			name, _, _ := unstructured.NestedString(manifest, "resourceMeta", "name")
+			return state, &JobFailedError{
+				ClusterName: clusterName,
+				Reason:      "Failed",
+				Message:     fmt.Sprintf("%s pod(s) failed, see the %s annotation of job %s/%s on the spoke", feedback[feedbackFailed], FailureAnnotation, namespace, name),
+			}
+		}
+		return state, fmt.Errorf("job not complete yet on cluster: %s", clusterName)
+	}
+	return "", fmt.Errorf("unable to find the job in manifestwork %s, maybe its status is not available yet", templates.Name)
+}
+
+// statusFeedback reads the status feedback values of a manifest of a manifestwork
+// returns:			map[string]string
+func statusFeedback(manifest map[string]interface{}) map[string]string {
+	feedback := map[string]string{}
+	values, _, _ := unstructured.NestedSlice(manifest, "statusFeedback", "values")
+	for _, v := range values {
+		value, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := value["name"].(string)
+		field, _ := value["fieldValue"].(map[string]interface{})
+		switch field["type"] {
+		case "Integer":
+			feedback[name] = fmt.Sprintf("%v", field["integer"])
+		case "String":
+			feedback[name], _ = field["string"].(string)
+		case "Boolean":
+			feedback[name] = fmt.Sprintf("%v", field["boolean"])
+		}
+	}
+	return feedback
+}
+
+// TeardownJob deletes the manifestwork of the job, the work agent deleting its namespace and thus the job on the
+// spoke
+// returns:			error
+func (t *ManifestWorkTransport) TeardownJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	err := t.client.KubernetesClient.Resource(ManifestWorkGVR).Namespace(clusterName).Delete(ctx, templates.Name, v1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		log.Debugf("The manifestwork named: [%s] for cluster: %s is already gone", templates.Name, clusterName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't delete manifestwork %s in the %s cluster err: %s", templates.Name, clusterName, err)
+	}
+	log.Info("Successfully deleted the manifestwork")
+	return nil
+}
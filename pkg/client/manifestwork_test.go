@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// feedbackValue returns a status feedback value of a manifestwork
+func feedbackValue(name string, kind string, value interface{}) interface{} {
+	field := map[string]interface{}{"type": kind}
+	switch kind {
+	case "Integer":
+		field["integer"] = value
+	case "String":
+		field["string"] = value
+	case "Boolean":
+		field["boolean"] = value
+	}
+	return map[string]interface{}{"name": name, "fieldValue": field}
+}
+
+// jobManifest returns the status of the job manifest of a manifestwork, applied or not, with its status feedback
+func jobManifest(applied string, values ...interface{}) map[string]interface{} {
+	manifest := map[string]interface{}{
+		"resourceMeta":   map[string]interface{}{"group": "batch", "resource": "jobs", "namespace": "backupresource", "name": "backupresource"},
+		"statusFeedback": map[string]interface{}{"values": values},
+	}
+	if applied != "" {
+		manifest["conditions"] = []interface{}{map[string]interface{}{"type": "Applied", "status": applied}}
+	}
+	return manifest
+}
+
+// backupWork returns the manifestwork of the backup job of spoke1, with the status of its manifests
+func backupWork(manifests ...interface{}) *unstructured.Unstructured {
+	work := newObject(ManifestWorkGVR.GroupVersion().String(), "ManifestWork", "spoke1", "backup-job", nil)
+	work.Object["status"] = map[string]interface{}{
+		"resourceStatus": map[string]interface{}{"manifests": manifests},
+	}
+	return work
+}
+
+func TestStatusFeedback(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest map[string]interface{}
+		want     map[string]string
+	}{
+		{
+			name:     "no feedback yet",
+			manifest: map[string]interface{}{"resourceMeta": map[string]interface{}{"resource": "jobs"}},
+			want:     map[string]string{},
+		},
+		{
+			name: "job counts",
+			manifest: jobManifest("True",
+				feedbackValue(feedbackSucceeded, "Integer", int64(1)),
+				feedbackValue(feedbackFailed, "Integer", int64(0)),
+				feedbackValue(feedbackActive, "Integer", int64(0)),
+			),
+			want: map[string]string{feedbackSucceeded: "1", feedbackFailed: "0", feedbackActive: "0"},
+		},
+		{
+			name: "strings and booleans",
+			manifest: jobManifest("True",
+				feedbackValue("phase", "String", "Running"),
+				feedbackValue("ready", "Boolean", true),
+			),
+			want: map[string]string{"phase": "Running", "ready": "true"},
+		},
+		{
+			name: "unknown types and invalid values",
+			manifest: jobManifest("True",
+				feedbackValue("raw", "JsonRaw", nil),
+				"invalid",
+				feedbackValue(feedbackActive, "Integer", int64(2)),
+			),
+			want: map[string]string{feedbackActive: "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusFeedback(tt.manifest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statusFeedback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestWorkCheckStatus(t *testing.T) {
+	other := map[string]interface{}{
+		"resourceMeta": map[string]interface{}{"resource": "namespaces"},
+		"conditions":   []interface{}{map[string]interface{}{"type": "Applied", "status": "True"}},
+	}
+	tests := []struct {
+		name       string
+		work       *unstructured.Unstructured
+		action     string
+		wantState  string
+		wantErr    bool
+		wantFailed bool
+	}{
+		{name: "no manifestwork", action: Launch, wantErr: true},
+		{name: "no status yet", work: backupWork(), action: Launch, wantErr: true},
+		{name: "job not applied yet", work: backupWork(other, jobManifest("")), action: Launch, wantErr: true},
+		{name: "job applying", work: backupWork(other, jobManifest("False")), action: Launch, wantState: "type=Applied status=False", wantErr: true},
+		{name: "job launched", work: backupWork(other, jobManifest("True")), action: Launch, wantState: "type=Applied status=True"},
+		{
+			name:      "job running",
+			work:      backupWork(jobManifest("True", feedbackValue(feedbackActive, "Integer", int64(1)))),
+			action:    Complete,
+			wantState: "succeeded= failed= active=1",
+			wantErr:   true,
+		},
+		{
+			name: "job succeeded",
+			work: backupWork(jobManifest("True",
+				feedbackValue(feedbackSucceeded, "Integer", int64(1)),
+				feedbackValue(feedbackFailed, "Integer", int64(0)),
+				feedbackValue(feedbackActive, "Integer", int64(0)),
+			)),
+			action:    Complete,
+			wantState: "succeeded=1 failed=0 active=0",
+		},
+		{
+			name: "pod failed, job retrying",
+			work: backupWork(jobManifest("True",
+				feedbackValue(feedbackFailed, "Integer", int64(1)),
+				feedbackValue(feedbackActive, "Integer", int64(1)),
+			)),
+			action:    Complete,
+			wantState: "succeeded= failed=1 active=1",
+			wantErr:   true,
+		},
+		{
+			name: "job failed",
+			work: backupWork(jobManifest("True",
+				feedbackValue(feedbackSucceeded, "Integer", int64(0)),
+				feedbackValue(feedbackFailed, "Integer", int64(1)),
+				feedbackValue(feedbackActive, "Integer", int64(0)),
+			)),
+			action:     Complete,
+			wantState:  "succeeded=0 failed=1 active=0",
+			wantErr:    true,
+			wantFailed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient()
+			if tt.work != nil {
+				client = newFakeClient(tt.work)
+			}
+			transport := NewManifestWorkTransport(client)
+
+			state, err := transport.checkStatus(context.Background(), "spoke1", tt.action, client.BackupJobTemplates())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if state != tt.wantState {
+				t.Errorf("checkStatus() state = %q, want %q", state, tt.wantState)
+			}
+			var failure *JobFailedError
+			if goerrors.As(err, &failure) != tt.wantFailed {
+				t.Errorf("checkStatus() error = %v, want a failed job %v", err, tt.wantFailed)
+			}
+			if failure != nil && !strings.Contains(failure.Message, "backupresource/backupresource") {
+				t.Errorf("failure message %q doesn't name the job", failure.Message)
+			}
+		})
+	}
+}
+
+func TestManifestWorkFeedbackRules(t *testing.T) {
+	client := newFakeClient()
+	work, err := NewManifestWorkTransport(client).manifestWork("spoke1", client.BackupJobTemplates())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configs, _, _ := unstructured.NestedSlice(work.Object, "spec", "manifestConfigs")
+	if len(configs) != 1 {
+		t.Fatalf("%d manifest configs, want 1", len(configs))
+	}
+	config := configs[0].(map[string]interface{})
+	if identifier := config["resourceIdentifier"]; !reflect.DeepEqual(identifier, map[string]interface{}{
+		"group": "batch", "resource": "jobs", "name": "backupresource", "namespace": "backupresource",
+	}) {
+		t.Errorf("resource identifier = %v", identifier)
+	}
+
+	// the work agent rejects the paths which aren't scalar
+	want := map[string]string{
+		feedbackSucceeded: ".status.succeeded",
+		feedbackFailed:    ".status.failed",
+		feedbackActive:    ".status.active",
+	}
+	got := map[string]string{}
+	rules, _, _ := unstructured.NestedSlice(config, "feedbackRules")
+	for _, r := range rules {
+		paths, _, _ := unstructured.NestedSlice(r.(map[string]interface{}), "jsonPaths")
+		for _, p := range paths {
+			path := p.(map[string]interface{})
+			got[path["name"].(string)] = path["path"].(string)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("feedback paths = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Default timeouts and poll intervals used by JobStatus
+var (
+	DefaultLaunchTimeout     = 2 * time.Minute
+	DefaultCompletionTimeout = 30 * time.Minute
+	DefaultPollInterval      = 5 * time.Second
+	DefaultMaxPollInterval   = time.Minute
+)
+
+// PollOptions controls how long and how often JobStatus polls the managedclusterview of each phase
+type PollOptions struct {
+	LaunchTimeout     time.Duration
+	CompletionTimeout time.Duration
+	Interval          time.Duration
+	MaxInterval       time.Duration
+}
+
+// DefaultPollOptions returns the poll options used when none are configured
+// returns:			PollOptions
+func DefaultPollOptions() PollOptions {
+	return PollOptions{
+		LaunchTimeout:     DefaultLaunchTimeout,
+		CompletionTimeout: DefaultCompletionTimeout,
+		Interval:          DefaultPollInterval,
+		MaxInterval:       DefaultMaxPollInterval,
+	}
+}
+
+// Validate checks the poll options are usable
+// returns:			error
+func (p PollOptions) Validate() error {
+	if p.LaunchTimeout <= 0 {
+		return fmt.Errorf("launch timeout must be positive")
+	}
+	if p.CompletionTimeout <= 0 {
+		return fmt.Errorf("completion timeout must be positive")
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("poll interval must be positive")
+	}
+	if p.MaxInterval < p.Interval {
+		return fmt.Errorf("max poll interval must not be lower than the poll interval")
+	}
+	return nil
+}
+
+// Timeout returns the timeout of the launched or completed phase
+// returns:			time.Duration
+func (p PollOptions) Timeout(action string) time.Duration {
+	if action == Complete {
+		return p.CompletionTimeout
+	}
+	return p.LaunchTimeout
+}
+
+// Backoff doubles the poll interval, up to the max poll interval
+// returns:			time.Duration
+func (p PollOptions) Backoff(interval time.Duration) time.Duration {
+	interval *= 2
+	if interval > p.MaxInterval {
+		interval = p.MaxInterval
+	}
+	return interval
+}
+
+// jitter spreads an interval by up to 20% either way, so that pollers of many spokes don't hit the hub in lockstep
+// returns:			time.Duration
+func jitter(interval time.Duration) time.Duration {
+	spread := int64(interval) / 5
+	if spread == 0 {
+		return interval
+	}
+	return interval - time.Duration(spread) + time.Duration(rand.Int63n(2*spread))
+}
+
+// phaseName returns the phase checked by JobStatus for an action
+// returns:			string
+func phaseName(action string) string {
+	if action == Complete {
+		return "completion"
+	}
+	return "launch"
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollOptionsBackoff(t *testing.T) {
+	p := PollOptions{Interval: time.Second, MaxInterval: 10 * time.Second}
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	interval := p.Interval
+	for i, w := range want {
+		interval = p.Backoff(interval)
+		if interval != w {
+			t.Fatalf("interval after %d backoffs = %s, want %s", i+1, interval, w)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	interval := 10 * time.Second
+	low, high := interval*4/5, interval*6/5
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		got := jitter(interval)
+		if got < low || got >= high {
+			t.Fatalf("jitter(%s) = %s, want it within [%s, %s)", interval, got, low, high)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("jitter(%s) always returned the same interval", interval)
+	}
+	if got := jitter(4 * time.Nanosecond); got != 4*time.Nanosecond {
+		t.Errorf("jitter(4ns) = %s, want the interval unchanged", got)
+	}
+}
+
+func TestPollOptionsTimeout(t *testing.T) {
+	p := PollOptions{LaunchTimeout: time.Minute, CompletionTimeout: time.Hour}
+	if got := p.Timeout(Launch); got != time.Minute {
+		t.Errorf("launch timeout = %s, want %s", got, time.Minute)
+	}
+	if got := p.Timeout(Complete); got != time.Hour {
+		t.Errorf("completion timeout = %s, want %s", got, time.Hour)
+	}
+}
+
+func TestPollOptionsValidate(t *testing.T) {
+	valid := DefaultPollOptions()
+	tests := []struct {
+		name   string
+		change func(p *PollOptions)
+		err    string
+	}{
+		{name: "defaults", change: func(p *PollOptions) {}},
+		{name: "no launch timeout", change: func(p *PollOptions) { p.LaunchTimeout = 0 }, err: "launch timeout must be positive"},
+		{name: "no completion timeout", change: func(p *PollOptions) { p.CompletionTimeout = -time.Second }, err: "completion timeout must be positive"},
+		{name: "no poll interval", change: func(p *PollOptions) { p.Interval = 0 }, err: "poll interval must be positive"},
+		{name: "max poll interval too low", change: func(p *PollOptions) { p.MaxInterval = p.Interval / 2 }, err: "max poll interval must not be lower than the poll interval"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := valid
+			test.change(&p)
+			err := p.Validate()
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestPollJob(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		// conditions are returned by the successive checks, the job reaching the phase after the last one
+		conditions []string
+		reached    bool
+		err        string
+		checks     int
+	}{
+		{
+			name:       "phase reached",
+			action:     Launch,
+			conditions: []string{"", "Applied False", "Applied True"},
+			reached:    true,
+			checks:     3,
+		},
+		{
+			name:       "launch timeout names the last condition",
+			action:     Launch,
+			conditions: []string{"Applied False", "Progressing True", ""},
+			err:        "launch phase timed out after 50ms for cluster: spoke1, last manifestwork condition: Progressing True",
+		},
+		{
+			name:   "completion timeout",
+			action: Complete,
+			err:    "completion phase timed out after 50ms for cluster: spoke1, last manifestwork condition: none",
+		},
+		{
+			name:       "failed job stops the polling",
+			action:     Complete,
+			conditions: []string{"Failed True"},
+			err:        "job failed on cluster: spoke1",
+			checks:     1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := Client{Poll: PollOptions{
+				LaunchTimeout:     time.Hour,
+				CompletionTimeout: time.Hour,
+				Interval:          time.Millisecond,
+				MaxInterval:       2 * time.Millisecond,
+			}}
+			// only the phase polled times out
+			if test.action == Complete {
+				client.Poll.CompletionTimeout = 50 * time.Millisecond
+			} else {
+				client.Poll.LaunchTimeout = 50 * time.Millisecond
+			}
+
+			checks := 0
+			err := client.pollJob(context.Background(), "spoke1", test.action, "manifestwork", func() (string, error) {
+				checks++
+				if checks > len(test.conditions) {
+					return "", fmt.Errorf("phase not reached")
+				}
+				condition := test.conditions[checks-1]
+				if strings.HasPrefix(condition, "Failed") {
+					return condition, &JobFailedError{ClusterName: "spoke1", Reason: "BackoffLimitExceeded"}
+				}
+				if test.reached && checks == len(test.conditions) {
+					return condition, nil
+				}
+				return condition, fmt.Errorf("phase not reached")
+			})
+
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("error = %v, want it to contain %q", err, test.err)
+			}
+			if test.checks != 0 && checks != test.checks {
+				t.Errorf("%d checks, want %d", checks, test.checks)
+			}
+		})
+	}
+}
+
+func TestPollJobCancelled(t *testing.T) {
+	client := Client{Poll: DefaultPollOptions()}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := client.pollJob(ctx, "spoke1", Launch, "manifestwork", func() (string, error) {
+		return "", fmt.Errorf("phase not reached")
+	})
+	if err != context.Canceled {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+}
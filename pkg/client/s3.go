@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// S3SecretKeys are the keys an S3 secret must hold, set as environment variables of the jobs
+var S3SecretKeys = []string{"S3_ENDPOINT", "S3_BUCKET", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}
+
+// S3SecretTemplates populates templates for creation of managedclusteraction resource to propagate the S3
+// secret of the backup job
+var S3SecretTemplates = []ResourceTemplate{
+	{"backup-create-s3secret", mngClusterActCreateS3Secret},
+}
+
+// RecoveryS3SecretTemplates populates templates for creation of managedclusteraction resource to propagate the S3
+// secret of the recovery job
+var RecoveryS3SecretTemplates = []ResourceTemplate{
+	{"recovery-create-s3secret", mngClusterActCreateS3Secret},
+}
+
+// withS3Secret inserts the S3 secret templates right before the job template, which always comes last,
+// when an S3 secret is configured
+// returns:			[]ResourceTemplate
+func (c Client) withS3Secret(actions []ResourceTemplate, s3Secret []ResourceTemplate) []ResourceTemplate {
+	if len(c.S3SecretData) == 0 {
+		return actions
+	}
+	return beforeJob(actions, s3Secret)
+}
+
+// FetchS3Secret reads the data of the secret locating the bucket the backups are exported to, and holding the
+// credentials to it, on the hub, referenced as namespace/name, so that it can be propagated to the spokes
+// returns:			base64 encoded data by key, error
+func (c Client) FetchS3Secret(ctx context.Context, ref string) (map[string]string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid S3 secret %q, expecting namespace/name", ref)
+	}
+
+	log.WithFields(log.Fields{"S3Secret": "Fetching"}).Debugf("Fetching the S3 secret: %s", ref)
+	secret, err := c.KubernetesClient.Resource(SecretGVR).Namespace(parts[0]).Get(ctx, parts[1], v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get S3 secret %s: %s", ref, err)
+	}
+
+	data, _, err := unstructured.NestedStringMap(secret.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("S3 secret %s: %s", ref, err)
+	}
+	for _, key := range S3SecretKeys {
+		if data[key] == "" {
+			return nil, fmt.Errorf("S3 secret %s has no %s", ref, key)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,358 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// the controller image has no time zone database
+	_ "time/tzdata"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Annotations, or labels, of a managedcluster configuring its maintenance windows
+const (
+	// MaintenanceWindowKey lists the windows in which the cluster may be backed up, e.g. Sat-Sun_0100-0500. The
+	// annotation may list several windows separated by commas, the label only one
+	MaintenanceWindowKey = "ztp.openshift.io/backup-window"
+	// MaintenanceWindowTimeZoneKey is the time zone of the windows, e.g. Europe/Paris. It can only be an annotation
+	MaintenanceWindowTimeZoneKey = "ztp.openshift.io/backup-window-timezone"
+)
+
+// cronMacros are the shorthands accepted in place of a cron expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// cronField describes the range and the names of a field of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+	// first is the value of the first name
+	first int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59, nil, 0},
+	{"hour", 0, 23, nil, 0},
+	{"day of month", 1, 31, nil, 0},
+	{"month", 1, 12, monthNames, 1},
+	// 7 is sunday as well
+	{"day of week", 0, 7, dayNames, 0},
+}
+
+// CronSchedule is a parsed cron expression: minute, hour, day of month, month and day of week, evaluated in a time zone
+type CronSchedule struct {
+	fields [5]uint64
+	// restricted days of month and of week match either, as in cron
+	domStar, dowStar bool
+	location         *time.Location
+}
+
+// ParseCron parses a standard five fields cron expression, or one of the @ macros, evaluated in a time zone
+// returns:			*CronSchedule, error
+func ParseCron(expr string, location *time.Location) (*CronSchedule, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, expecting minute, hour, day of month, month and day of week", expr)
+	}
+
+	schedule := &CronSchedule{location: location}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %s", expr, err)
+		}
+		schedule.fields[i] = bits
+	}
+	if schedule.fields[4]&(1<<7) != 0 {
+		schedule.fields[4] |= 1
+	}
+	schedule.domStar = strings.HasPrefix(parts[2], "*")
+	schedule.dowStar = strings.HasPrefix(parts[4], "*")
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps of a cron field
+// returns:			bitset of the values, error
+func parseCronField(part string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in the %s field", item[i+1:], field.name)
+			}
+			item = item[:i]
+		}
+
+		low, high := field.min, field.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], field); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = cronValue(bounds[1], field); err != nil {
+					return 0, err
+				}
+				if field.max == 7 && high == 0 {
+					// sat-sun
+					high = 7
+				}
+			} else if step > 1 {
+				// a/n runs from a to the end of the range
+				high = field.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q in the %s field", item, field.name)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a number or a name of a cron field
+// returns:			int, error
+func cronValue(value string, field cronField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(value, name) {
+			return field.first + i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < field.min || v > field.max {
+		return 0, fmt.Errorf("invalid value %q in the %s field, expecting %d to %d", value, field.name, field.min, field.max)
+	}
+	return v, nil
+}
+
+// matchDay checks whether the schedule runs on a day
+// returns:			bool
+func (s *CronSchedule) matchDay(day time.Time) bool {
+	if s.fields[3]&(1<<uint(day.Month())) == 0 {
+		return false
+	}
+	dom := s.fields[2]&(1<<uint(day.Day())) != 0
+	dow := s.fields[4]&(1<<uint(day.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time the schedule runs strictly after a time, or the zero time when it doesn't run in the
+// next five years
+// returns:			time.Time
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+	for i := 0; i < 5*366; i++ {
+		d := day.AddDate(0, 0, i)
+		if !s.matchDay(d) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if s.fields[1]&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if s.fields[0]&(1<<uint(m)) == 0 {
+					continue
+				}
+				if next := time.Date(d.Year(), d.Month(), d.Day(), h, m, 0, 0, s.location); next.After(t) {
+					return next
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Period returns the interval between the two next runs of the schedule after a time
+// returns:			time.Duration
+func (s *CronSchedule) Period(t time.Time) time.Duration {
+	next := s.Next(t)
+	return s.Next(next).Sub(next)
+}
+
+// MaintenanceWindow is a daily time range, on some days of the week, in which a cluster may be backed up. A window
+// ending before its start runs past midnight
+type MaintenanceWindow struct {
+	days       uint8
+	start, end int
+	location   *time.Location
+}
+
+// ParseMaintenanceWindows parses a comma separated list of windows, each one being days_HHMM-HHMM where days is
+// a day of the week, a range of days such as Mon-Fri, or Daily
+// returns:			[]MaintenanceWindow, error
+func ParseMaintenanceWindows(value string, location *time.Location) ([]MaintenanceWindow, error) {
+	windows := []MaintenanceWindow{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.Split(item, "_")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid maintenance window %q, expecting days_HHMM-HHMM, e.g. Sat-Sun_0100-0500", item)
+		}
+
+		window := MaintenanceWindow{location: location}
+		if strings.EqualFold(parts[0], "daily") {
+			window.days = 0x7f
+		} else {
+			bits, err := parseCronField(parts[0], cronFields[4])
+			if err != nil || strings.ContainsAny(parts[0], "*/") {
+				return nil, fmt.Errorf("invalid days in maintenance window %q", item)
+			}
+			window.days = uint8(bits&0x7f | bits>>7)
+		}
+
+		times := strings.Split(parts[1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid hours in maintenance window %q, expecting HHMM-HHMM", item)
+		}
+		var err error
+		if window.start, err = minuteOfDay(times[0]); err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %s", item, err)
+		}
+		if window.end, err = minuteOfDay(times[1]); err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %s", item, err)
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("invalid maintenance window %q: empty time range", item)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// minuteOfDay parses a HHMM time of the day
+// returns:			minutes since midnight, error
+func minuteOfDay(value string) (int, error) {
+	if len(value) != 4 {
+		return 0, fmt.Errorf("invalid time %q, expecting HHMM", value)
+	}
+	hhmm, err := strconv.Atoi(value)
+	if err != nil || hhmm/100 > 23 || hhmm%100 > 59 {
+		return 0, fmt.Errorf("invalid time %q, expecting HHMM", value)
+	}
+	return hhmm/100*60 + hhmm%100, nil
+}
+
+// Contains checks whether a time is within the window
+// returns:			bool
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	today := w.days&(1<<uint(t.Weekday())) != 0
+	if w.start < w.end {
+		return today && minute >= w.start && minute < w.end
+	}
+	yesterday := w.days&(1<<uint((t.Weekday()+6)%7)) != 0
+	return (today && minute >= w.start) || (yesterday && minute < w.end)
+}
+
+// NextOpen returns the time the window opens next, or the time itself when it is open
+// returns:			time.Time
+func (w MaintenanceWindow) NextOpen(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	t = t.In(w.location)
+	for i := 0; i <= 7; i++ {
+		// the wall clock time, minutes since midnight are off on the days the clock changes
+		open := time.Date(t.Year(), t.Month(), t.Day()+i, w.start/60, w.start%60, 0, 0, w.location)
+		if w.days&(1<<uint(open.Weekday())) != 0 && open.After(t) {
+			return open
+		}
+	}
+	return time.Time{}
+}
+
+// InMaintenanceWindow checks whether a time is within one of the windows of a cluster, a cluster without windows
+// may be backed up at any time
+// returns:			bool, time the next window opens when it isn't
+func InMaintenanceWindow(windows []MaintenanceWindow, t time.Time) (bool, time.Time) {
+	if len(windows) == 0 {
+		return true, t
+	}
+	next := time.Time{}
+	for _, window := range windows {
+		open := window.NextOpen(t)
+		if open.Equal(t) {
+			return true, t
+		}
+		if next.IsZero() || open.Before(next) {
+			next = open
+		}
+	}
+	return false, next
+}
+
+// MaintenanceWindows reads the maintenance windows of clusters from the annotations, or else the labels, of their
+// managedcluster. The windows are in the time zone of the cluster, falling back to the given one
+// returns:			windows of the clusters which have some, errors of the clusters whose windows are invalid, error
+func (c Client) MaintenanceWindows(ctx context.Context, clusters []string, location *time.Location) (map[string][]MaintenanceWindow, map[string]error, error) {
+	wanted := map[string]bool{}
+	for _, name := range clusters {
+		wanted[name] = true
+	}
+
+	log.WithFields(log.Fields{"MaintenanceWindows": "Listing"}).Debug("Listing the maintenance windows of the managedclusters")
+	list, err := c.KubernetesClient.Resource(ManagedClusterGVR).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't list managedclusters: %s", err)
+	}
+
+	windows := map[string][]MaintenanceWindow{}
+	invalid := map[string]error{}
+	for _, item := range list.Items {
+		name := item.GetName()
+		if !wanted[name] {
+			continue
+		}
+		value, ok := item.GetAnnotations()[MaintenanceWindowKey]
+		if !ok {
+			value, ok = item.GetLabels()[MaintenanceWindowKey]
+		}
+		if !ok {
+			continue
+		}
+
+		clusterLocation := location
+		if zone, ok := item.GetAnnotations()[MaintenanceWindowTimeZoneKey]; ok {
+			if clusterLocation, err = time.LoadLocation(zone); err != nil {
+				invalid[name] = fmt.Errorf("invalid maintenance window time zone %q: %s", zone, err)
+				continue
+			}
+		}
+		if windows[name], err = ParseMaintenanceWindows(value, clusterLocation); err != nil {
+			delete(windows, name)
+			invalid[name] = err
+		}
+	}
+	return windows, invalid, nil
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+// bitset returns the bits of a cron field holding values
+func bitset(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+// mustLocation loads a time zone
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+// mustTime parses a RFC 3339 time
+func mustTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "0 1 * * 6"},
+		{expr: "*/15 0-6,22-23 1-10/3 jan-mar mon-fri"},
+		{expr: "0 0 * * 7"},
+		{expr: "@weekly"},
+		{expr: " @hourly "},
+		{expr: "* * * *", wantErr: true},
+		{expr: "* * * * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 0 * foo *", wantErr: true},
+		{expr: "@fortnightly", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCron() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	minute, hour, dom, month, dow := cronFields[0], cronFields[1], cronFields[2], cronFields[3], cronFields[4]
+	tests := []struct {
+		part    string
+		field   cronField
+		want    uint64
+		wantErr bool
+	}{
+		{part: "*", field: hour, want: 1<<24 - 1},
+		{part: "5", field: minute, want: bitset(5)},
+		{part: "1,3,5", field: minute, want: bitset(1, 3, 5)},
+		{part: "1-5", field: hour, want: bitset(1, 2, 3, 4, 5)},
+		{part: "*/15", field: minute, want: bitset(0, 15, 30, 45)},
+		{part: "10/20", field: minute, want: bitset(10, 30, 50)},
+		{part: "1-10/3", field: dom, want: bitset(1, 4, 7, 10)},
+		{part: "jan,DEC", field: month, want: bitset(1, 12)},
+		{part: "mar-may", field: month, want: bitset(3, 4, 5)},
+		{part: "mon-fri", field: dow, want: bitset(1, 2, 3, 4, 5)},
+		{part: "sat-sun", field: dow, want: bitset(6, 7)},
+		{part: "5-0", field: dow, want: bitset(5, 6, 7)},
+		{part: "7", field: dow, want: bitset(7)},
+		{part: "0", field: dom, wantErr: true},
+		{part: "13", field: month, wantErr: true},
+		{part: "foo", field: dow, wantErr: true},
+		{part: "5-", field: minute, wantErr: true},
+		{part: "1-2-3", field: minute, wantErr: true},
+		{part: "/2", field: minute, wantErr: true},
+		{part: "1/x", field: minute, wantErr: true},
+		{part: "fri-mon", field: dow, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field.name+" "+tt.part, func(t *testing.T) {
+			got, err := parseCronField(tt.part, tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCronField() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseCronField() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		zone string
+		from string
+		want string
+	}{
+		{name: "weekly on saturday", expr: "0 1 * * 6", from: "2022-06-01T12:00:00Z", want: "2022-06-04T01:00:00Z"},
+		{name: "strictly after", expr: "0 * * * *", from: "2022-06-01T10:00:00Z", want: "2022-06-01T11:00:00Z"},
+		{name: "step", expr: "*/15 * * * *", from: "2022-06-01T10:07:00Z", want: "2022-06-01T10:15:00Z"},
+		{name: "hourly macro", expr: "@hourly", from: "2022-06-01T10:30:00Z", want: "2022-06-01T11:00:00Z"},
+		{name: "monthly", expr: "0 0 1 * *", from: "2022-01-31T12:00:00Z", want: "2022-02-01T00:00:00Z"},
+		{name: "leap day", expr: "0 0 29 2 *", from: "2022-03-01T00:00:00Z", want: "2024-02-29T00:00:00Z"},
+		{name: "never", expr: "0 0 31 2 *", from: "2022-03-01T00:00:00Z"},
+		{name: "month and day names", expr: "0 0 * jan-mar mon", from: "2022-03-29T00:00:00Z", want: "2023-01-02T00:00:00Z"},
+		{name: "day of month or day of week, on the day of week", expr: "0 0 13 * 5", from: "2022-06-01T12:00:00Z", want: "2022-06-03T00:00:00Z"},
+		{name: "day of month or day of week, on the day of month", expr: "0 0 13 * 5", from: "2022-06-11T00:00:00Z", want: "2022-06-13T00:00:00Z"},
+		{name: "day of month step and day of week", expr: "0 0 */2 * 5", from: "2022-06-04T00:00:00Z", want: "2022-06-17T00:00:00Z"},
+		{name: "wrapping range, saturday", expr: "0 0 * * sat-sun", from: "2022-06-03T12:00:00Z", want: "2022-06-04T00:00:00Z"},
+		{name: "wrapping range, sunday", expr: "0 0 * * sat-sun", from: "2022-06-04T01:00:00Z", want: "2022-06-05T00:00:00Z"},
+		{name: "sunday as 7", expr: "0 0 * * 7", from: "2022-06-01T00:00:00Z", want: "2022-06-05T00:00:00Z"},
+		{name: "time zone", expr: "0 1 * * *", zone: "Europe/Paris", from: "2022-06-01T00:00:00Z", want: "2022-06-01T23:00:00Z"},
+		{name: "skipped by the spring forward", expr: "30 2 * * *", zone: "Europe/Paris", from: "2022-03-26T12:00:00Z", want: "2022-03-27T01:30:00Z"},
+		{name: "after the spring forward", expr: "0 3 * * *", zone: "Europe/Paris", from: "2022-03-26T12:00:00Z", want: "2022-03-27T01:00:00Z"},
+		{name: "repeated by the fall back", expr: "30 1 * * *", zone: "America/New_York", from: "2022-11-06T05:00:00Z", want: "2022-11-06T05:30:00Z"},
+		{name: "once on the fall back", expr: "30 1 * * *", zone: "America/New_York", from: "2022-11-06T05:30:00Z", want: "2022-11-07T06:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := time.UTC
+			if tt.zone != "" {
+				location = mustLocation(t, tt.zone)
+			}
+			schedule, err := ParseCron(tt.expr, location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := time.Time{}
+			if tt.want != "" {
+				want = mustTime(t, tt.want)
+			}
+			if got := schedule.Next(mustTime(t, tt.from)); !got.Equal(want) {
+				t.Errorf("Next() = %s, want %s", got.UTC(), want)
+			}
+		})
+	}
+}
+
+func TestCronPeriod(t *testing.T) {
+	tests := []struct {
+		expr string
+		zone string
+		from string
+		want time.Duration
+	}{
+		{expr: "@weekly", from: "2022-06-01T00:00:00Z", want: 7 * 24 * time.Hour},
+		{expr: "0 1 * * 6", from: "2022-06-01T00:00:00Z", want: 7 * 24 * time.Hour},
+		{expr: "0 0,12 * * *", from: "2022-06-01T01:00:00Z", want: 12 * time.Hour},
+		{expr: "0 0 * * sat-sun", from: "2022-06-03T00:00:00Z", want: 24 * time.Hour},
+		{expr: "@daily", zone: "Europe/Paris", from: "2022-03-26T12:00:00Z", want: 23 * time.Hour},
+		{expr: "@daily", zone: "Europe/Paris", from: "2022-10-29T12:00:00Z", want: 25 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr+" "+tt.zone+" "+tt.from, func(t *testing.T) {
+			location := time.UTC
+			if tt.zone != "" {
+				location = mustLocation(t, tt.zone)
+			}
+			schedule, err := ParseCron(tt.expr, location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Period(mustTime(t, tt.from)); got != tt.want {
+				t.Errorf("Period() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []MaintenanceWindow
+		wantErr bool
+	}{
+		{value: "Sat-Sun_0100-0500", want: []MaintenanceWindow{{days: 0x41, start: 60, end: 300}}},
+		{value: "sat_0100-0500", want: []MaintenanceWindow{{days: 0x40, start: 60, end: 300}}},
+		{value: "Daily_2200-0200", want: []MaintenanceWindow{{days: 0x7f, start: 1320, end: 120}}},
+		{value: "7_0000-2359", want: []MaintenanceWindow{{days: 0x01, start: 0, end: 1439}}},
+		{value: "Mon-Fri_0130-0200, Sun_0300-0400", want: []MaintenanceWindow{
+			{days: 0x3e, start: 90, end: 120},
+			{days: 0x01, start: 180, end: 240},
+		}},
+		{value: "Sat_0100", wantErr: true},
+		{value: "Sat-0100-0500", wantErr: true},
+		{value: "Foo_0100-0500", wantErr: true},
+		{value: "*_0100-0500", wantErr: true},
+		{value: "Mon/2_0100-0500", wantErr: true},
+		{value: "Mon_2400-0100", wantErr: true},
+		{value: "Mon_0160-0200", wantErr: true},
+		{value: "Mon_100-0200", wantErr: true},
+		{value: "Mon_0100-0100", wantErr: true},
+		{value: "Mon_0100-0200,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMaintenanceWindows(tt.value, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMaintenanceWindows() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseMaintenanceWindows() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].days != tt.want[i].days || got[i].start != tt.want[i].start || got[i].end != tt.want[i].end {
+					t.Errorf("window %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	tests := []struct {
+		window string
+		zone   string
+		at     string
+		want   bool
+	}{
+		// 2022-06-04 is a saturday
+		{window: "Sat-Sun_0100-0500", at: "2022-06-04T01:00:00Z", want: true},
+		{window: "Sat-Sun_0100-0500", at: "2022-06-05T04:59:00Z", want: true},
+		{window: "Sat-Sun_0100-0500", at: "2022-06-04T00:59:00Z", want: false},
+		{window: "Sat-Sun_0100-0500", at: "2022-06-04T05:00:00Z", want: false},
+		{window: "Sat-Sun_0100-0500", at: "2022-06-06T02:00:00Z", want: false},
+		{window: "Fri_2200-0200", at: "2022-06-03T23:00:00Z", want: true},
+		{window: "Fri_2200-0200", at: "2022-06-04T01:59:00Z", want: true},
+		{window: "Fri_2200-0200", at: "2022-06-04T02:00:00Z", want: false},
+		{window: "Fri_2200-0200", at: "2022-06-03T21:59:00Z", want: false},
+		{window: "Fri_2200-0200", at: "2022-06-03T01:00:00Z", want: false},
+		{window: "Fri_2200-0200", at: "2022-06-02T23:00:00Z", want: false},
+		{window: "Sat-Sun_2200-0200", at: "2022-06-06T01:00:00Z", want: true},
+		{window: "Sat_0100-0500", zone: "Europe/Paris", at: "2022-06-03T23:30:00Z", want: true},
+		{window: "Sat_0100-0500", zone: "Europe/Paris", at: "2022-06-04T03:30:00Z", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window+" "+tt.zone+" "+tt.at, func(t *testing.T) {
+			location := time.UTC
+			if tt.zone != "" {
+				location = mustLocation(t, tt.zone)
+			}
+			windows, err := ParseMaintenanceWindows(tt.window, location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := windows[0].Contains(mustTime(t, tt.at)); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowNextOpen(t *testing.T) {
+	tests := []struct {
+		window string
+		zone   string
+		from   string
+		want   string
+	}{
+		{window: "Sat-Sun_0100-0500", from: "2022-06-01T12:00:00Z", want: "2022-06-04T01:00:00Z"},
+		{window: "Sat-Sun_0100-0500", from: "2022-06-04T02:00:00Z", want: "2022-06-04T02:00:00Z"},
+		{window: "Sat-Sun_0100-0500", from: "2022-06-05T06:00:00Z", want: "2022-06-11T01:00:00Z"},
+		{window: "Sat_0100-0500", from: "2022-06-04T00:30:00Z", want: "2022-06-04T01:00:00Z"},
+		{window: "Sat_0100-0500", from: "2022-06-04T05:00:00Z", want: "2022-06-11T01:00:00Z"},
+		{window: "Fri_2200-0200", from: "2022-06-04T01:00:00Z", want: "2022-06-04T01:00:00Z"},
+		{window: "Fri_2200-0200", from: "2022-06-04T03:00:00Z", want: "2022-06-10T22:00:00Z"},
+		{window: "Sat_0100-0500", zone: "Europe/Paris", from: "2022-06-01T12:00:00Z", want: "2022-06-03T23:00:00Z"},
+		{window: "Sun_0300-0500", zone: "Europe/Paris", from: "2022-03-26T23:30:00Z", want: "2022-03-27T01:00:00Z"},
+		{window: "Sun_0100-0500", zone: "Europe/Paris", from: "2022-10-29T12:00:00Z", want: "2022-10-29T23:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window+" "+tt.zone+" "+tt.from, func(t *testing.T) {
+			location := time.UTC
+			if tt.zone != "" {
+				location = mustLocation(t, tt.zone)
+			}
+			windows, err := ParseMaintenanceWindows(tt.window, location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := mustTime(t, tt.want)
+			if got := windows[0].NextOpen(mustTime(t, tt.from)); !got.Equal(want) {
+				t.Errorf("NextOpen() = %s, want %s", got.UTC(), want)
+			}
+		})
+	}
+}
+
+func TestInMaintenanceWindow(t *testing.T) {
+	windows, err := ParseMaintenanceWindows("Sat_0100-0500,Wed_2200-2300", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		windows  []MaintenanceWindow
+		at       string
+		wantOpen bool
+		wantNext string
+	}{
+		{name: "no windows", at: "2022-06-01T12:00:00Z", wantOpen: true, wantNext: "2022-06-01T12:00:00Z"},
+		{name: "within a window", windows: windows, at: "2022-06-04T02:00:00Z", wantOpen: true, wantNext: "2022-06-04T02:00:00Z"},
+		{name: "earliest window", windows: windows, at: "2022-06-01T12:00:00Z", wantNext: "2022-06-01T22:00:00Z"},
+		{name: "other window", windows: windows, at: "2022-06-02T12:00:00Z", wantNext: "2022-06-04T01:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next := InMaintenanceWindow(tt.windows, mustTime(t, tt.at))
+			if open != tt.wantOpen || !next.Equal(mustTime(t, tt.wantNext)) {
+				t.Errorf("InMaintenanceWindow() = %v, %s, want %v, %s", open, next.UTC(), tt.wantOpen, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ClusterSetLabel is the label ACM sets on a managedcluster that belongs to a managedclusterset
+const ClusterSetLabel = "cluster.open-cluster-management.io/clusterset"
+
+// ManagedClusterGVR represents the managedcluster resource on the hub
+var ManagedClusterGVR = schema.GroupVersionResource{
+	Group:    "cluster.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "managedclusters",
+}
+
+// ClusterSelector builds the label selector matching a label query and/or a managedclusterset
+// returns:			labels.Selector, error
+func ClusterSelector(selector string, clusterSet string) (labels.Selector, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %s", selector, err)
+	}
+	if clusterSet != "" {
+		set, err := labels.Parse(fmt.Sprintf("%s=%s", ClusterSetLabel, clusterSet))
+		if err != nil {
+			return nil, fmt.Errorf("invalid managedclusterset name %q: %s", clusterSet, err)
+		}
+		requirements, _ := set.Requirements()
+		parsed = parsed.Add(requirements...)
+	}
+	return parsed, nil
+}
+
+// ListSpokeClusters lists the managedclusters on the hub matching a label selector and/or a managedclusterset
+// returns:			sorted cluster names, error
+func (c Client) ListSpokeClusters(ctx context.Context, selector string, clusterSet string) ([]string, error) {
+	sel, err := ClusterSelector(selector, clusterSet)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{"SpokeSelector": "Listing"}).Debugf("Listing managedclusters matching: %s", sel)
+	clusters, err := c.KubernetesClient.Resource(ManagedClusterGVR).List(ctx, v1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list managedclusters matching %q: %s", sel, err)
+	}
+
+	names := []string{}
+	for _, item := range clusters.Items {
+		names = append(names, item.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
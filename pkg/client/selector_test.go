@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestClusterSelector(t *testing.T) {
+	tests := []struct {
+		name       string
+		selector   string
+		clusterSet string
+		want       string
+		wantErr    bool
+	}{
+		{name: "selector", selector: "du-profile=site-a", want: "du-profile=site-a"},
+		{name: "cluster set", clusterSet: "prod", want: ClusterSetLabel + "=prod"},
+		{name: "selector and cluster set", selector: "du-profile=site-a,env!=lab", clusterSet: "prod",
+			want: ClusterSetLabel + "=prod,du-profile=site-a,env!=lab"},
+		{name: "set based selector", selector: "env in (prod,staging)", want: "env in (prod,staging)"},
+		{name: "invalid selector", selector: "du-profile in (site-a", wantErr: true},
+		{name: "invalid cluster set", clusterSet: "prod/east", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ClusterSelector(tt.selector, tt.clusterSet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ClusterSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ClusterSelector() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestListSpokeClusters(t *testing.T) {
+	client := newFakeClient(
+		managedCluster("sno3", map[string]string{"du-profile": "site-a", ClusterSetLabel: "prod"}),
+		managedCluster("sno1", map[string]string{"du-profile": "site-a", ClusterSetLabel: "prod"}),
+		managedCluster("sno2", map[string]string{"du-profile": "site-a", ClusterSetLabel: "lab"}),
+		managedCluster("sno4", map[string]string{"du-profile": "site-b", ClusterSetLabel: "prod"}),
+	)
+	tests := []struct {
+		name       string
+		selector   string
+		clusterSet string
+		want       []string
+	}{
+		{name: "selector", selector: "du-profile=site-a", want: []string{"sno1", "sno2", "sno3"}},
+		{name: "cluster set", clusterSet: "prod", want: []string{"sno1", "sno3", "sno4"}},
+		{name: "selector and cluster set", selector: "du-profile=site-a", clusterSet: "prod", want: []string{"sno1", "sno3"}},
+		{name: "no match", selector: "du-profile=site-c", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.ListSpokeClusters(context.Background(), tt.selector, tt.clusterSet)
+			if err != nil {
+				t.Fatalf("ListSpokeClusters() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListSpokeClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// BackupStatus describes the backup held by a spoke, as reported by the backupStatus command of the backup image
+type BackupStatus struct {
+	Path               string    `json:"path"`
+	Generation         string    `json:"generation,omitempty"`
+	Exists             bool      `json:"exists"`
+	Complete           bool      `json:"complete"`
+	Timestamp          time.Time `json:"timestamp,omitempty"`
+	SizeBytes          int64     `json:"sizeBytes,omitempty"`
+	OCPVersion         string    `json:"ocpVersion,omitempty"`
+	PinnedDeployment   string    `json:"pinnedDeployment,omitempty"`
+	RecoveryInProgress bool      `json:"recoveryInProgress"`
+}
+
+// StatusCreateTemplates populates templates for creation of managedclusteraction resources launching the status pod
+var StatusCreateTemplates = []ResourceTemplate{
+	{"status-create-namespace", mngClusterActCreateNS},
+	{"status-create-serviceaccount", mngClusterActCreateSA},
+	{"status-create-rolebinding", mngClusterActCreateRB},
+	{"status-create-pod", mngClusterActCreateStatusPod},
+}
+
+// StatusPullSecretTemplates populates templates for creation of managedclusteraction resource to propagate the image
+// pull secret of the status pod
+var StatusPullSecretTemplates = []ResourceTemplate{
+	{"status-create-pullsecret", mngClusterActCreatePullSecret},
+}
+
+// StatusViewTemplates populates templates for creation of managedclusterview resource watching the status pod
+var StatusViewTemplates = []ResourceTemplate{
+	{"status-create-podview", mngClusterViewStatusPod},
+}
+
+// StatusDeleteTemplates populates templates for creation of managedclusteraction resource to delete the namespace
+// of the status pod in the spoke
+var StatusDeleteTemplates = []ResourceTemplate{
+	{"status-delete-ns", mngClusterActDeleteNS},
+}
+
+// StatusPodTemplates returns the templates launching, watching and deleting the pod reporting the backup status
+// returns:			JobTemplates
+func (c Client) StatusPodTemplates() JobTemplates {
+	return JobTemplates{c.withPullSecret(StatusCreateTemplates, StatusPullSecretTemplates), StatusViewTemplates, StatusDeleteTemplates, "status-pod"}
+}
+
+// PodTerminationMessage polls the pod watched by the view until it terminates, within the completion timeout
+// returns:			termination message of the pod, error
+func (c Client) PodTerminationMessage(ctx context.Context, clusterName string, view []ResourceTemplate) (string, error) {
+	timeout := c.Poll.CompletionTimeout
+	deadline := time.After(timeout)
+	interval := c.Poll.Interval
+	ticker := time.NewTimer(jitter(interval))
+	defer ticker.Stop()
+
+	lastPhase := "none"
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+
+		case <-deadline:
+			return "", fmt.Errorf("pod of cluster: %s didn't terminate within %s, last phase: %s", clusterName, timeout, lastPhase)
+
+		case <-ticker.C:
+			podView, err := c.ManageObjects(ctx, clusterName, view, MCV, "get")
+			if err != nil {
+				log.Debugf("Couldn't get managedclusterview from %s cluster; err: %s", clusterName, err)
+			} else {
+				phase, message, err := podTermination(podView)
+				if phase != "" {
+					lastPhase = phase
+				}
+				switch phase {
+				case "Succeeded":
+					return message, err
+				case "Failed":
+					return "", fmt.Errorf("pod of cluster: %s failed: %s", clusterName, message)
+				}
+			}
+			interval = c.Poll.Backoff(interval)
+			ticker.Reset(jitter(interval))
+		}
+	}
+}
+
+// podTermination extracts the phase and termination message of the pod returned by a managedclusterview
+// returns:			phase, termination message, error
+func podTermination(podView *unstructured.Unstructured) (string, string, error) {
+	phase, _, err := unstructured.NestedString(podView.Object, "status", "result", "status", "phase")
+	if err != nil {
+		return "", "", err
+	}
+	containers, _, err := unstructured.NestedSlice(podView.Object, "status", "result", "status", "containerStatuses")
+	if err != nil {
+		return phase, "", err
+	}
+	for _, container := range containers {
+		state, ok := container.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		message, found, _ := unstructured.NestedString(state, "state", "terminated", "message")
+		if found {
+			return phase, message, nil
+		}
+	}
+	return phase, "", nil
+}
+
+// ParseBackupStatus decodes the status reported by the backupStatus command
+// returns:			BackupStatus, error
+func ParseBackupStatus(message string) (BackupStatus, error) {
+	var status BackupStatus
+	if err := json.Unmarshal([]byte(message), &status); err != nil {
+		return status, fmt.Errorf("couldn't decode the backup status %q: %s", message, err)
+	}
+	return status, nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// statusPodView returns the managedclusterview of the status pod of a spoke, with the phase of the pod and the
+// termination message of its container when set
+func statusPodView(cluster string, phase string, message string) *unstructured.Unstructured {
+	obj := newObject("view.open-cluster-management.io/v1beta1", "ManagedClusterView", cluster, StatusViewTemplates[0].ResourceName, nil)
+	status := map[string]interface{}{}
+	if phase != "" {
+		status["phase"] = phase
+	}
+	if message != "" {
+		status["containerStatuses"] = []interface{}{
+			map[string]interface{}{"state": map[string]interface{}{"terminated": map[string]interface{}{"message": message}}},
+		}
+	}
+	obj.Object["status"] = map[string]interface{}{"result": map[string]interface{}{"status": status}}
+	return obj
+}
+
+func TestParseBackupStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    BackupStatus
+		err     string
+	}{
+		{
+			name:    "complete backup",
+			message: `{"path":"/var/recovery","generation":"20220520T101010Z","exists":true,"complete":true,"timestamp":"2022-05-20T10:10:10Z","sizeBytes":1536,"ocpVersion":"4.10.13","pinnedDeployment":"abc","recoveryInProgress":false}`,
+			want: BackupStatus{
+				Path:             "/var/recovery",
+				Generation:       "20220520T101010Z",
+				Exists:           true,
+				Complete:         true,
+				Timestamp:        time.Date(2022, 5, 20, 10, 10, 10, 0, time.UTC),
+				SizeBytes:        1536,
+				OCPVersion:       "4.10.13",
+				PinnedDeployment: "abc",
+			},
+		},
+		{
+			name:    "no backup",
+			message: `{"path":"/var/recovery","exists":false,"complete":false,"recoveryInProgress":true}`,
+			want:    BackupStatus{Path: "/var/recovery", RecoveryInProgress: true},
+		},
+		{
+			name:    "not a status",
+			message: "open /host/var/recovery: no such file or directory",
+			err:     `couldn't decode the backup status "open /host/var/recovery: no such file or directory"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseBackupStatus(test.message)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, want it to contain %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !got.Timestamp.Equal(test.want.Timestamp) {
+				t.Errorf("timestamp = %s, want %s", got.Timestamp, test.want.Timestamp)
+			}
+			got.Timestamp, test.want.Timestamp = time.Time{}, time.Time{}
+			if got != test.want {
+				t.Errorf("status = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPodTerminationMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		view    *unstructured.Unstructured
+		message string
+		err     string
+	}{
+		{
+			name:    "succeeded pod",
+			view:    statusPodView("spoke1", "Succeeded", `{"exists":false}`),
+			message: `{"exists":false}`,
+		},
+		{
+			name: "failed pod",
+			view: statusPodView("spoke1", "Failed", "no recovery partition"),
+			err:  "pod of cluster: spoke1 failed: no recovery partition",
+		},
+		{
+			name: "running pod",
+			view: statusPodView("spoke1", "Running", ""),
+			err:  "pod of cluster: spoke1 didn't terminate within 50ms, last phase: Running",
+		},
+		{
+			name: "missing view",
+			view: statusPodView("spoke2", "Succeeded", `{"exists":false}`),
+			err:  "last phase: none",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClient(test.view)
+			c.Poll = PollOptions{CompletionTimeout: 50 * time.Millisecond, Interval: time.Millisecond, MaxInterval: 2 * time.Millisecond}
+
+			message, err := c.PodTerminationMessage(context.Background(), "spoke1", StatusViewTemplates)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, want it to contain %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if message != test.message {
+				t.Errorf("message = %q, want %q", message, test.message)
+			}
+		})
+	}
+}
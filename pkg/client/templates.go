@@ -85,8 +85,32 @@ spec:
                 args:
                   - launchBackup
                   - "--BackupPath"
-                  - /var/recovery
-                image: 2620-52-0-1302--1db3.sslip.io:5000/olm/openshift-ai-image-backup:latest
+                  - "{{ .RecoveryPath }}"
+{{- range .BackupArgs }}
+                  - "{{ . }}"
+{{- end }}
+{{- if .EncryptionKeyData }}
+                  - "--encryption-key-file"
+                  - "/etc/backup-encryption/key"
+{{- end }}
+                env:
+                  -
+                    name: NODE_NAME
+                    valueFrom:
+                      fieldRef:
+                        fieldPath: spec.nodeName
+                  -
+                    name: JOB_NAME
+                    valueFrom:
+                      fieldRef:
+                        fieldPath: metadata.labels['job-name']
+{{- if .S3SecretData }}
+                envFrom:
+                  -
+                    secretRef:
+                      name: backupresource-s3
+{{- end }}
+                image: "{{ .Image }}"
                 name: container-image
                 securityContext:
                   privileged: true
@@ -96,15 +120,117 @@ spec:
                   -
                     mountPath: /host
                     name: backup
+{{- if .EncryptionKeyData }}
+                  -
+                    mountPath: /etc/backup-encryption
+                    name: encryption-key
+                    readOnly: true
+{{- end }}
+{{- if .RegistryAuthData }}
+                  -
+                    mountPath: /etc/backup-registry
+                    name: registry-auth
+                    readOnly: true
+{{- end }}
             restartPolicy: Never
             hostNetwork: true
             serviceAccountName: backupresource
+{{- if .PullSecretData }}
+            imagePullSecrets:
+              - name: backupresource-pull-secret
+{{- end }}
             volumes:
               -
                 hostPath:
                   path: /
                   type: Directory
                 name: backup
+{{- if .EncryptionKeyData }}
+              -
+                name: encryption-key
+                secret:
+                  secretName: backupresource-encryption-key
+{{- end }}
+{{- if .RegistryAuthData }}
+              -
+                name: registry-auth
+                secret:
+                  secretName: backupresource-registry-auth
+{{- end }}
+`
+const mngClusterActCreatePullSecret string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: secret
+    template:
+      apiVersion: v1
+      kind: Secret
+      metadata:
+        name: backupresource-pull-secret
+        namespace: backupresource
+      type: kubernetes.io/dockerconfigjson
+      data:
+        .dockerconfigjson: {{ .PullSecretData }}
+`
+const mngClusterActCreateRegistryAuth string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: secret
+    template:
+      apiVersion: v1
+      kind: Secret
+      metadata:
+        name: backupresource-registry-auth
+        namespace: backupresource
+      type: kubernetes.io/dockerconfigjson
+      data:
+        .dockerconfigjson: {{ .RegistryAuthData }}
+`
+const mngClusterActCreateEncryptionKey string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: secret
+    template:
+      apiVersion: v1
+      kind: Secret
+      metadata:
+        name: backupresource-encryption-key
+        namespace: backupresource
+      type: Opaque
+      data:
+        key: {{ .EncryptionKeyData }}
+`
+const mngClusterActCreateS3Secret string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: secret
+    template:
+      apiVersion: v1
+      kind: Secret
+      metadata:
+        name: backupresource-s3
+        namespace: backupresource
+      type: Opaque
+      data:
+{{- range $key, $value := .S3SecretData }}
+        {{ $key }}: {{ $value }}
+{{- end }}
 `
 const mngClusterActDeleteNS string = `
 {{ template "actionGVK"}}
@@ -115,6 +241,93 @@ spec:
     name: backupresource
     resource: namespace
 `
+const mngClusterActCreateRecoveryJob string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: job
+    template:
+      apiVersion: batch/v1
+      kind: Job
+      metadata:
+        name: recoveryresource
+      spec:
+        backoffLimit: 0
+        template:
+          spec:
+            containers:
+              -
+                args:
+                  - launchRecovery
+                  - "--BackupPath"
+                  - "{{ .RecoveryPath }}"
+{{- range .RecoveryArgs }}
+                  - "{{ . }}"
+{{- end }}
+{{- if .EncryptionKeyData }}
+                  - "--encryption-key-file"
+                  - "/etc/backup-encryption/key"
+{{- end }}
+                env:
+                  -
+                    name: JOB_NAME
+                    valueFrom:
+                      fieldRef:
+                        fieldPath: metadata.labels['job-name']
+{{- if .S3SecretData }}
+                envFrom:
+                  -
+                    secretRef:
+                      name: backupresource-s3
+{{- end }}
+                image: "{{ .Image }}"
+                name: container-image
+                securityContext:
+                  privileged: true
+                  runAsUser: 0
+                tty: true
+                volumeMounts:
+                  -
+                    mountPath: /host
+                    name: backup
+{{- if .EncryptionKeyData }}
+                  -
+                    mountPath: /etc/backup-encryption
+                    name: encryption-key
+                    readOnly: true
+{{- end }}
+            restartPolicy: Never
+            hostNetwork: true
+            serviceAccountName: backupresource
+{{- if .PullSecretData }}
+            imagePullSecrets:
+              - name: backupresource-pull-secret
+{{- end }}
+            volumes:
+              -
+                hostPath:
+                  path: /
+                  type: Directory
+                name: backup
+{{- if .EncryptionKeyData }}
+              -
+                name: encryption-key
+                secret:
+                  secretName: backupresource-encryption-key
+{{- end }}
+`
+const mngClusterViewRecoveryJob string = `
+{{ template "viewGVK"}}
+{{ template "metadata" . }}
+spec:
+  scope:
+    resource: jobs
+    name: recoveryresource
+    namespace: backupresource
+`
 const mngClusterViewJob string = `
 {{ template "viewGVK"}}
 {{ template "metadata" . }}
@@ -124,3 +337,66 @@ spec:
     name: backupresource
     namespace: backupresource
 `
+const mngClusterActCreateStatusPod string = `
+{{ template "actionGVK"}}
+{{ template "metadata" . }}
+spec:
+  actionType: Create
+  kube:
+    namespace: backupresource
+    resource: pod
+    template:
+      apiVersion: v1
+      kind: Pod
+      metadata:
+        name: backupstatus
+      spec:
+        containers:
+          -
+            args:
+              - backupStatus
+              - "--BackupPath"
+              - "{{ .RecoveryPath }}"
+            image: "{{ .Image }}"
+            name: container-image
+            securityContext:
+              privileged: true
+              runAsUser: 0
+            volumeMounts:
+              -
+                mountPath: /host
+                name: backup
+                readOnly: true
+        restartPolicy: Never
+        hostNetwork: true
+        serviceAccountName: backupresource
+{{- if .PullSecretData }}
+        imagePullSecrets:
+          - name: backupresource-pull-secret
+{{- end }}
+        volumes:
+          -
+            hostPath:
+              path: /
+              type: Directory
+            name: backup
+`
+const mngClusterViewStatusPod string = `
+{{ template "viewGVK"}}
+{{ template "metadata" . }}
+spec:
+  scope:
+    resource: pods
+    name: backupstatus
+    namespace: backupresource
+`
+const mngClusterViewClusterVersion string = `
+{{ template "viewGVK"}}
+{{ template "metadata" . }}
+spec:
+  scope:
+    apiGroup: config.openshift.io
+    kind: ClusterVersion
+    version: v1
+    name: version
+`
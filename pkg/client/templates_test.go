@@ -0,0 +1,140 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+)
+
+// renderActions renders the templates of a spoke as the hub objects they create
+func renderActions(t *testing.T, c Client, cluster string, templates []ResourceTemplate) []*unstructured.Unstructured {
+	t.Helper()
+	objects := []*unstructured.Unstructured{}
+	for _, item := range templates {
+		w, err := c.RenderYamlTemplate(item.ResourceName, item.Template, c.templateData(cluster))
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := &unstructured.Unstructured{}
+		if _, _, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(w.Bytes(), nil, obj); err != nil {
+			t.Fatalf("template %s doesn't render to yaml: %s\n%s", item.ResourceName, err, w.String())
+		}
+		if obj.GetName() != item.ResourceName || obj.GetNamespace() != cluster {
+			t.Errorf("template %s renders %s/%s", item.ResourceName, obj.GetNamespace(), obj.GetName())
+		}
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+func TestJobTemplates(t *testing.T) {
+	jobSpec := []string{"spec", "kube", "template", "spec", "template", "spec"}
+	podSpec := []string{"spec", "kube", "template", "spec"}
+	tests := []struct {
+		name       string
+		pullSecret string
+		templates  func(c Client) JobTemplates
+		actions    []string
+		spec       []string
+		args       []string
+	}{
+		{
+			name:      "backup job",
+			templates: Client.BackupJobTemplates,
+			actions:   []string{"backup-create-namespace", "backup-create-serviceaccount", "backup-create-rolebinding", "backup-create-job"},
+			spec:      jobSpec,
+			args:      []string{"launchBackup", "--BackupPath", "/var/recovery/sno", "--keep", "2"},
+		},
+		{
+			name:       "backup job pulled with a secret",
+			pullSecret: "eyJhdXRocyI6e319",
+			templates:  Client.BackupJobTemplates,
+			actions:    []string{"backup-create-namespace", "backup-create-serviceaccount", "backup-create-rolebinding", "backup-create-pullsecret", "backup-create-job"},
+			spec:       jobSpec,
+			args:       []string{"launchBackup", "--BackupPath", "/var/recovery/sno", "--keep", "2"},
+		},
+		{
+			name:      "recovery job",
+			templates: Client.RecoveryJobTemplates,
+			actions:   []string{"recovery-create-namespace", "recovery-create-serviceaccount", "recovery-create-rolebinding", "recovery-create-job"},
+			spec:      jobSpec,
+			args:      []string{"launchRecovery", "--BackupPath", "/var/recovery/sno", "--force"},
+		},
+		{
+			name:       "recovery job pulled with a secret",
+			pullSecret: "eyJhdXRocyI6e319",
+			templates:  Client.RecoveryJobTemplates,
+			actions:    []string{"recovery-create-namespace", "recovery-create-serviceaccount", "recovery-create-rolebinding", "recovery-create-pullsecret", "recovery-create-job"},
+			spec:       jobSpec,
+			args:       []string{"launchRecovery", "--BackupPath", "/var/recovery/sno", "--force"},
+		},
+		{
+			name:      "status pod",
+			templates: Client.StatusPodTemplates,
+			actions:   []string{"status-create-namespace", "status-create-serviceaccount", "status-create-rolebinding", "status-create-pod"},
+			spec:      podSpec,
+			args:      []string{"backupStatus", "--BackupPath", "/var/recovery/sno"},
+		},
+		{
+			name:       "status pod pulled with a secret",
+			pullSecret: "eyJhdXRocyI6e319",
+			templates:  Client.StatusPodTemplates,
+			actions:    []string{"status-create-namespace", "status-create-serviceaccount", "status-create-rolebinding", "status-create-pullsecret", "status-create-pod"},
+			spec:       podSpec,
+			args:       []string{"backupStatus", "--BackupPath", "/var/recovery/sno"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClient()
+			c.BackupPath = "/var/recovery/sno"
+			c.Image = "quay.io/redhat_ztp/openshift-ai-image-backup@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			c.PullSecretData = test.pullSecret
+			c.BackupArgs = []string{"--keep", "2"}
+			c.RecoveryArgs = []string{"--force"}
+
+			templates := test.templates(c)
+			if names := ResourceNames(templates.Actions); !reflect.DeepEqual(names, test.actions) {
+				t.Fatalf("actions = %v, want %v", names, test.actions)
+			}
+			actions := renderActions(t, c, "spoke1", templates.Actions)
+			renderActions(t, c, "spoke1", templates.Views)
+			renderActions(t, c, "spoke1", templates.Deletes)
+
+			spec, _, _ := unstructured.NestedMap(actions[len(actions)-1].Object, test.spec...)
+			containers, _, _ := unstructured.NestedSlice(spec, "containers")
+			if len(containers) != 1 {
+				t.Fatalf("%d containers, want 1", len(containers))
+			}
+			container := containers[0].(map[string]interface{})
+			if container["image"] != c.Image {
+				t.Errorf("image = %v, want %s", container["image"], c.Image)
+			}
+			args, _, _ := unstructured.NestedStringSlice(container, "args")
+			if !reflect.DeepEqual(args, test.args) {
+				t.Errorf("args = %v, want %v", args, test.args)
+			}
+
+			pullSecrets, found, _ := unstructured.NestedSlice(spec, "imagePullSecrets")
+			if test.pullSecret == "" {
+				if found {
+					t.Errorf("image pull secrets = %v, want none", pullSecrets)
+				}
+				return
+			}
+			if len(pullSecrets) != 1 || pullSecrets[0].(map[string]interface{})["name"] != "backupresource-pull-secret" {
+				t.Errorf("image pull secrets = %v, want backupresource-pull-secret", pullSecrets)
+			}
+			secret := actions[len(actions)-2]
+			data, _, _ := unstructured.NestedString(secret.Object, "spec", "kube", "template", "data", ".dockerconfigjson")
+			if data != test.pullSecret {
+				t.Errorf("propagated pull secret = %q, want %q", data, test.pullSecret)
+			}
+			if name, _, _ := unstructured.NestedString(secret.Object, "spec", "kube", "template", "metadata", "name"); name != "backupresource-pull-secret" {
+				t.Errorf("propagated pull secret name = %q, want backupresource-pull-secret", name)
+			}
+		})
+	}
+}
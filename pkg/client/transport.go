@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Transports carrying the jobs from the hub to the spokes
+const (
+	// TransportAction creates the job through managedclusteractions and watches it through a managedclusterview
+	TransportAction = "managedclusteraction"
+	// TransportManifestWork creates the job through a manifestwork and watches it through its status feedback
+	TransportManifestWork = "manifestwork"
+)
+
+// HubObjects names the objects created on the hub for a job on a spoke
+type HubObjects struct {
+	Actions []string
+	Views   []string
+	Works   []string
+}
+
+// Transport carries a job from the hub to a spoke and reports its status back to the hub
+type Transport interface {
+	// Objects names the objects created on the hub to launch and watch the job, and the ones created to tear it down
+	Objects(templates JobTemplates) (HubObjects, HubObjects)
+	// CreateJob creates the objects launching the job on the spoke, deleting them when the creation fails
+	CreateJob(ctx context.Context, clusterName string, templates JobTemplates) error
+	// WatchJob creates the objects reporting the status of the job to the hub, replacing the ones of a previous job
+	WatchJob(ctx context.Context, clusterName string, templates JobTemplates) error
+	// JobWatched checks whether the job is watched from the hub, so that it can be resumed
+	JobWatched(ctx context.Context, clusterName string, templates JobTemplates) (bool, error)
+	// ClearJob deletes the objects of a job left half created, without touching the spoke
+	ClearJob(ctx context.Context, clusterName string, templates JobTemplates) error
+	// JobStatus polls the job until it is launched or completed
+	JobStatus(ctx context.Context, clusterName string, action string, templates JobTemplates) error
+	// TeardownJob deletes the objects of the job on the hub and the namespace of the job on the spoke
+	TeardownJob(ctx context.Context, clusterName string, templates JobTemplates) error
+}
+
+// ValidateTransport checks the transport is supported
+// returns:			error
+func ValidateTransport(transport string) error {
+	switch transport {
+	case "", TransportAction, TransportManifestWork:
+		return nil
+	}
+	return fmt.Errorf("unsupported transport %q, expecting %s or %s", transport, TransportAction, TransportManifestWork)
+}
+
+// JobTransport returns the transport configured on the client, managedclusteractions by default
+// returns:			Transport
+func (c Client) JobTransport() Transport {
+	if c.Transport == TransportManifestWork {
+		return NewManifestWorkTransport(c)
+	}
+	return NewActionTransport(c)
+}
+
+// ActionTransport carries the job through managedclusteractions and watches it through a managedclusterview
+type ActionTransport struct {
+	client Client
+}
+
+// NewActionTransport creates the managedclusteraction transport of a client
+// returns:			*ActionTransport
+func NewActionTransport(c Client) *ActionTransport {
+	return &ActionTransport{client: c}
+}
+
+// Objects names the managedclusteractions and the managedclusterview of the job, and the managedclusteractions
+// deleting it
+// returns:			HubObjects, HubObjects
+func (t *ActionTransport) Objects(templates JobTemplates) (HubObjects, HubObjects) {
+	return HubObjects{Actions: ResourceNames(templates.Actions), Views: ResourceNames(templates.Views)},
+		HubObjects{Actions: ResourceNames(templates.Deletes)}
+}
+
+// CreateJob creates the managedclusteractions launching the job, deleting them when the creation fails
+// returns:			error
+func (t *ActionTransport) CreateJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	err := t.client.LaunchKubernetesObjects(ctx, clusterName, templates.Actions)
+	if err == nil {
+		log.Info("Successfully created all K8s mca objects")
+		return nil
+	}
+	if ctx.Err() != nil {
+		return err
+	}
+	log.Errorf("Couldn't launch k8s ManagedClusterAction objects in the %s cluster err: %s", clusterName, err)
+	log.Info("Deleting all mca objects")
+	if _, deleteErr := t.client.ManageObjects(ctx, clusterName, templates.Actions, MCA, "delete"); deleteErr != nil {
+		return fmt.Errorf("couldn't delete k8s ManagedClusterAction objects in the %s cluster err: %s", clusterName, deleteErr)
+	}
+	return err
+}
+
+// WatchJob creates the managedclusterview watching the job. A view left on the hub by a previous job is deleted
+// first, its result being the status of the previous job
+// returns:			error
+func (t *ActionTransport) WatchJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	_, err := t.client.ManageObjects(ctx, clusterName, templates.Views, MCV, "get")
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("couldn't get k8s ManagedclusterView object in the %s cluster err: %s", clusterName, err)
+	}
+	if err == nil {
+		log.Warnf("Deleting the ManagedclusterView object left in the %s cluster", clusterName)
+		if _, err := t.client.ManageObjects(ctx, clusterName, templates.Views, MCV, "delete"); err != nil {
+			return fmt.Errorf("couldn't delete existing ManagedclusterView object in the %s cluster err: %s", clusterName, err)
+		}
+	}
+	if err := t.client.LaunchKubernetesObjects(ctx, clusterName, templates.Views); err != nil {
+		return fmt.Errorf("couldn't launch k8s ManagedclusterView object the %s cluster err: %s", clusterName, err)
+	}
+	log.Info("Successfully created ManagedclusterView object")
+	return nil
+}
+
+// JobWatched checks whether the managedclusterview of the job is on the hub
+// returns:			bool, error
+func (t *ActionTransport) JobWatched(ctx context.Context, clusterName string, templates JobTemplates) (bool, error) {
+	_, err := t.client.ManageObjects(ctx, clusterName, templates.Views, MCV, "get")
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ClearJob deletes the managedclusteractions of a job left half created
+// returns:			error
+func (t *ActionTransport) ClearJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	if _, err := t.client.ManageObjects(ctx, clusterName, templates.Actions, MCA, "delete"); err != nil {
+		return fmt.Errorf("couldn't delete k8s ManagedClusterAction objects in the %s cluster err: %s", clusterName, err)
+	}
+	return nil
+}
+
+// JobStatus polls the job through its managedclusterview
+// returns:			error
+func (t *ActionTransport) JobStatus(ctx context.Context, clusterName string, action string, templates JobTemplates) error {
+	return t.client.JobStatus(ctx, clusterName, action, templates.Views)
+}
+
+// TeardownJob deletes the managedclusterview and managedclusteractions of the job on the hub, then deletes the
+// namespace in the spoke, which will delete the job and associated pod
+// returns:			error
+func (t *ActionTransport) TeardownJob(ctx context.Context, clusterName string, templates JobTemplates) error {
+	// delete managedclusterview
+	_, err := t.client.ManageObjects(ctx, clusterName, templates.Views, MCV, "delete")
+	if err != nil {
+		return fmt.Errorf("couldn't delete existing ManagedclusterView object in the %s cluster err: %s", clusterName, err)
+	}
+
+	// delete the managedclusteractions which created the job, and any previous namespace deletion
+	_, err = t.client.ManageObjects(ctx, clusterName, templates.Actions, MCA, "delete")
+	if err != nil {
+		return fmt.Errorf("couldn't delete k8s ManagedClusterAction objects in the %s cluster err: %s", clusterName, err)
+	}
+	_, err = t.client.ManageObjects(ctx, clusterName, templates.Deletes, MCA, "delete")
+	if err != nil {
+		return fmt.Errorf("couldn't delete k8s ManagedClusterAction objects in the %s cluster err: %s", clusterName, err)
+	}
+
+	//delete the namespace in the spoke, which will delete the completed job and associated pod.
+	err = t.client.LaunchKubernetesObjects(ctx, clusterName, templates.Deletes)
+	if err != nil {
+		return fmt.Errorf("couldn't launch k8 objects in the %s cluster err: %s", clusterName, err)
+	}
+	log.Info("Successfully deleted all Kubernetes objects")
+	return nil
+}
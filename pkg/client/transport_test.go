@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestActionTransportWatchJob(t *testing.T) {
+	viewGVR := schema.GroupVersionResource{Group: "view.open-cluster-management.io", Version: "v1beta1", Resource: MCV}
+	tests := []struct {
+		name       string
+		view       bool
+		getErr     error
+		wantErr    string
+		wantDelete bool
+	}{
+		{
+			name:    "view missing",
+			wantErr: "couldn't launch",
+		},
+		{
+			name:       "view left by a previous job",
+			view:       true,
+			wantErr:    "couldn't launch",
+			wantDelete: true,
+		},
+		{
+			name:    "view unreadable",
+			view:    true,
+			getErr:  errors.NewForbidden(viewGVR.GroupResource(), "backup-view", nil),
+			wantErr: "couldn't get",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{}
+			if tt.view {
+				objects = append(objects, newObject(viewGVR.GroupVersion().String(), "ManagedClusterView", "spoke1", ViewCreateTemplates[0].ResourceName, nil))
+			}
+			client := newFakeClient(objects...)
+			// the creation of the view needs a kubeconfig, it fails once the view is cleared
+			client.KubeconfigPath = filepath.Join(t.TempDir(), "missing")
+			fakeClient := client.KubernetesClient.(*fake.FakeDynamicClient)
+			if tt.getErr != nil {
+				fakeClient.PrependReactor("get", MCV, func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.getErr
+				})
+			}
+
+			err := NewActionTransport(client).WatchJob(context.Background(), "spoke1", client.BackupJobTemplates())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("WatchJob() error = %v, want %q", err, tt.wantErr)
+			}
+			deleted := false
+			for _, action := range fakeClient.Actions() {
+				if action.GetVerb() == "delete" && action.GetResource() == viewGVR {
+					deleted = true
+				}
+			}
+			if deleted != tt.wantDelete {
+				t.Errorf("view deleted = %v, want %v", deleted, tt.wantDelete)
+			}
+			if _, err := client.ManageObjects(context.Background(), "spoke1", ViewCreateTemplates, MCV, "get"); tt.wantDelete && !errors.IsNotFound(err) {
+				t.Errorf("the view of the previous job is still on the hub")
+			}
+		})
+	}
+}
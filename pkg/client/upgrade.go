@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Label and annotations of a managedcluster gating its upgrades on a backup of its current version
+const (
+	// UpgradeSafeLabel is "true" when the spoke holds a backup of its current version, "false" otherwise. Upgrade
+	// policies can select the spokes on it
+	UpgradeSafeLabel = "ztp.openshift.io/upgrade-safe"
+	// UpgradeGateAnnotation explains the upgrade-safe label
+	UpgradeGateAnnotation = "ztp.openshift.io/upgrade-gate"
+	// BackupVersionAnnotation is the version of the spoke when its last backup was taken
+	BackupVersionAnnotation = "ztp.openshift.io/backup-version"
+	// BackupTimeAnnotation is when the last backup of the spoke was taken
+	BackupTimeAnnotation = "ztp.openshift.io/backup-time"
+)
+
+// ClusterVersionViewTemplates populates templates for creation of managedclusterview resource watching the
+// clusterversion of the spoke
+var ClusterVersionViewTemplates = []ResourceTemplate{
+	{"backup-clusterversion-view", mngClusterViewClusterVersion},
+}
+
+// ClusterVersion sums up the clusterversion of a spoke
+type ClusterVersion struct {
+	// Current is the last version completely applied
+	Current string
+	// Desired is the version of spec.desiredUpdate, empty when no update is requested
+	Desired string
+}
+
+// Staged checks whether an upgrade to another version is requested or in progress
+// returns:			bool
+func (v ClusterVersion) Staged() bool {
+	return v.Desired != "" && v.Desired != v.Current
+}
+
+// SpokeClusterVersion reads the clusterversion of a spoke through its managedclusterview, creating the view the
+// first time
+// returns:			ClusterVersion, error
+func (c Client) SpokeClusterVersion(ctx context.Context, clusterName string) (ClusterVersion, error) {
+	view, err := c.ManageObjects(ctx, clusterName, ClusterVersionViewTemplates, MCV, "get")
+	if errors.IsNotFound(err) {
+		if err := c.LaunchKubernetesObjects(ctx, clusterName, ClusterVersionViewTemplates); err != nil {
+			return ClusterVersion{}, fmt.Errorf("couldn't launch the clusterversion managedclusterview of cluster %s: %s", clusterName, err)
+		}
+		return ClusterVersion{}, fmt.Errorf("clusterversion managedclusterview of cluster %s created, waiting for its result", clusterName)
+	}
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+	return parseClusterVersion(view)
+}
+
+// DeleteClusterVersionView deletes the managedclusterview watching the clusterversion of a spoke
+// returns:			error
+func (c Client) DeleteClusterVersionView(ctx context.Context, clusterName string) error {
+	if _, err := c.ManageObjects(ctx, clusterName, ClusterVersionViewTemplates, MCV, "delete"); err != nil {
+		return fmt.Errorf("couldn't delete the clusterversion managedclusterview of cluster %s: %s", clusterName, err)
+	}
+	return nil
+}
+
+// parseClusterVersion reads the current and desired versions from the result of the clusterversion view
+// returns:			ClusterVersion, error
+func parseClusterVersion(view *unstructured.Unstructured) (ClusterVersion, error) {
+	var version ClusterVersion
+	result, found, err := unstructured.NestedMap(view.Object, "status", "result")
+	if err != nil || !found {
+		return version, fmt.Errorf("clusterversion managedclusterview %s has no result yet", view.GetName())
+	}
+
+	version.Desired, _, _ = unstructured.NestedString(result, "spec", "desiredUpdate", "version")
+	if image, _, _ := unstructured.NestedString(result, "spec", "desiredUpdate", "image"); version.Desired == "" && image != "" {
+		// an update by image only, its version is known once the CVO loads it
+		version.Desired = image
+		if desiredImage, _, _ := unstructured.NestedString(result, "status", "desired", "image"); desiredImage == image {
+			version.Desired, _, _ = unstructured.NestedString(result, "status", "desired", "version")
+		}
+	}
+
+	history, _, _ := unstructured.NestedSlice(result, "status", "history")
+	for _, entry := range history {
+		entry, ok := entry.(map[string]interface{})
+		if ok && entry["state"] == "Completed" {
+			version.Current, _ = entry["version"].(string)
+			break
+		}
+	}
+	if version.Current == "" {
+		return version, fmt.Errorf("clusterversion managedclusterview %s has no completed version", view.GetName())
+	}
+	return version, nil
+}
+
+// UpgradeGate is the upgrade gate recorded on a managedcluster
+type UpgradeGate struct {
+	Safe          bool
+	Message       string
+	BackupVersion string
+	BackupTime    string
+}
+
+// GetUpgradeGate reads the upgrade gate recorded on a managedcluster
+// returns:			UpgradeGate, error
+func (c Client) GetUpgradeGate(ctx context.Context, clusterName string) (UpgradeGate, error) {
+	cluster, err := c.KubernetesClient.Resource(ManagedClusterGVR).Get(ctx, clusterName, v1.GetOptions{})
+	if err != nil {
+		return UpgradeGate{}, fmt.Errorf("couldn't get managedcluster %s: %s", clusterName, err)
+	}
+	annotations := cluster.GetAnnotations()
+	return UpgradeGate{
+		Safe:          cluster.GetLabels()[UpgradeSafeLabel] == "true",
+		Message:       annotations[UpgradeGateAnnotation],
+		BackupVersion: annotations[BackupVersionAnnotation],
+		BackupTime:    annotations[BackupTimeAnnotation],
+	}, nil
+}
+
+// SetUpgradeGate records the upgrade gate on a managedcluster
+// returns:			error
+func (c Client) SetUpgradeGate(ctx context.Context, clusterName string, gate UpgradeGate) error {
+	annotations := map[string]interface{}{UpgradeGateAnnotation: gate.Message}
+	if gate.BackupVersion != "" {
+		annotations[BackupVersionAnnotation] = gate.BackupVersion
+		annotations[BackupTimeAnnotation] = gate.BackupTime
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]interface{}{UpgradeSafeLabel: fmt.Sprintf("%t", gate.Safe)},
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"UpgradeGate": "Setting"}).Debugf("Setting the upgrade gate of cluster %s: safe=%t %s", clusterName, gate.Safe, gate.Message)
+	_, err = c.KubernetesClient.Resource(ManagedClusterGVR).Patch(ctx, clusterName, types.MergePatchType, patch, v1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("couldn't set the upgrade gate of managedcluster %s: %s", clusterName, err)
+	}
+	return nil
+}
+
+// BackupGate records a backup of the current version of a spoke, opening its upgrade gate
+// returns:			UpgradeGate
+func BackupGate(version string, taken time.Time) UpgradeGate {
+	return UpgradeGate{
+		Safe:          true,
+		Message:       fmt.Sprintf("backup of version %s taken", version),
+		BackupVersion: version,
+		BackupTime:    taken.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// historyEntry returns an entry of the update history of a clusterversion
+func historyEntry(state string, version string) interface{} {
+	return map[string]interface{}{"state": state, "version": version}
+}
+
+func TestParseClusterVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     map[string]interface{}
+		want       ClusterVersion
+		wantStaged bool
+		wantErr    bool
+	}{
+		{
+			name:    "no result yet",
+			wantErr: true,
+		},
+		{
+			name: "no update requested",
+			result: map[string]interface{}{
+				"status": map[string]interface{}{"history": []interface{}{historyEntry("Completed", "4.10.3")}},
+			},
+			want: ClusterVersion{Current: "4.10.3"},
+		},
+		{
+			name: "update to the current version",
+			result: map[string]interface{}{
+				"spec":   map[string]interface{}{"desiredUpdate": map[string]interface{}{"version": "4.10.3"}},
+				"status": map[string]interface{}{"history": []interface{}{historyEntry("Completed", "4.10.3")}},
+			},
+			want: ClusterVersion{Current: "4.10.3", Desired: "4.10.3"},
+		},
+		{
+			name: "staged update",
+			result: map[string]interface{}{
+				"spec":   map[string]interface{}{"desiredUpdate": map[string]interface{}{"version": "4.11.2"}},
+				"status": map[string]interface{}{"history": []interface{}{historyEntry("Completed", "4.10.3")}},
+			},
+			want:       ClusterVersion{Current: "4.10.3", Desired: "4.11.2"},
+			wantStaged: true,
+		},
+		{
+			name: "update in progress",
+			result: map[string]interface{}{
+				"spec": map[string]interface{}{"desiredUpdate": map[string]interface{}{"version": "4.11.2"}},
+				"status": map[string]interface{}{"history": []interface{}{
+					historyEntry("Partial", "4.11.2"),
+					historyEntry("Completed", "4.10.3"),
+				}},
+			},
+			want:       ClusterVersion{Current: "4.10.3", Desired: "4.11.2"},
+			wantStaged: true,
+		},
+		{
+			name: "update completed",
+			result: map[string]interface{}{
+				"spec": map[string]interface{}{"desiredUpdate": map[string]interface{}{"version": "4.11.2"}},
+				"status": map[string]interface{}{"history": []interface{}{
+					historyEntry("Completed", "4.11.2"),
+					historyEntry("Partial", "4.10.4"),
+					historyEntry("Completed", "4.10.3"),
+				}},
+			},
+			want: ClusterVersion{Current: "4.11.2", Desired: "4.11.2"},
+		},
+		{
+			name: "update by image not loaded yet",
+			result: map[string]interface{}{
+				"spec": map[string]interface{}{"desiredUpdate": map[string]interface{}{"image": "quay.io/openshift-release-dev/ocp-release@sha256:1234"}},
+				"status": map[string]interface{}{
+					"desired": map[string]interface{}{"image": "quay.io/openshift-release-dev/ocp-release@sha256:abcd", "version": "4.10.3"},
+					"history": []interface{}{historyEntry("Completed", "4.10.3")},
+				},
+			},
+			want:       ClusterVersion{Current: "4.10.3", Desired: "quay.io/openshift-release-dev/ocp-release@sha256:1234"},
+			wantStaged: true,
+		},
+		{
+			name: "update by image loaded",
+			result: map[string]interface{}{
+				"spec": map[string]interface{}{"desiredUpdate": map[string]interface{}{"image": "quay.io/openshift-release-dev/ocp-release@sha256:1234"}},
+				"status": map[string]interface{}{
+					"desired": map[string]interface{}{"image": "quay.io/openshift-release-dev/ocp-release@sha256:1234", "version": "4.11.2"},
+					"history": []interface{}{historyEntry("Partial", "4.11.2"), historyEntry("Completed", "4.10.3")},
+				},
+			},
+			want:       ClusterVersion{Current: "4.10.3", Desired: "4.11.2"},
+			wantStaged: true,
+		},
+		{
+			name: "installation in progress",
+			result: map[string]interface{}{
+				"status": map[string]interface{}{"history": []interface{}{historyEntry("Partial", "4.10.3")}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			view := newObject("view.open-cluster-management.io/v1beta1", "ManagedClusterView", "spoke1", "backup-clusterversion-view", nil)
+			if tt.result != nil {
+				view.Object["status"] = map[string]interface{}{"result": tt.result}
+			}
+			got, err := parseClusterVersion(view)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClusterVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseClusterVersion() = %+v, want %+v", got, tt.want)
+			}
+			if got.Staged() != tt.wantStaged {
+				t.Errorf("Staged() = %v, want %v", got.Staged(), tt.wantStaged)
+			}
+		})
+	}
+}
+
+func TestUpgradeGate(t *testing.T) {
+	taken := time.Date(2022, 6, 4, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		gates []UpgradeGate
+		want  UpgradeGate
+	}{
+		{
+			name:  "closed",
+			gates: []UpgradeGate{{Message: "no backup of version 4.10.3"}},
+			want:  UpgradeGate{Message: "no backup of version 4.10.3"},
+		},
+		{
+			name:  "opened by a backup",
+			gates: []UpgradeGate{BackupGate("4.10.3", taken)},
+			want:  UpgradeGate{Safe: true, Message: "backup of version 4.10.3 taken", BackupVersion: "4.10.3", BackupTime: "2022-06-04T01:00:00Z"},
+		},
+		{
+			name:  "closed again, the last backup is kept",
+			gates: []UpgradeGate{BackupGate("4.10.3", taken), {Message: "no backup of version 4.11.2"}},
+			want:  UpgradeGate{Message: "no backup of version 4.11.2", BackupVersion: "4.10.3", BackupTime: "2022-06-04T01:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeClient(managedCluster("spoke1", map[string]string{"du-profile": "site-a"}))
+			ctx := context.Background()
+			for _, gate := range tt.gates {
+				if err := client.SetUpgradeGate(ctx, "spoke1", gate); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := client.GetUpgradeGate(ctx, "spoke1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetUpgradeGate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteClusterVersionView(t *testing.T) {
+	client := newFakeClient(newObject("view.open-cluster-management.io/v1beta1", "ManagedClusterView", "spoke1", "backup-clusterversion-view", nil))
+	ctx := context.Background()
+	for _, name := range []string{"spoke1", "spoke2"} {
+		if err := client.DeleteClusterVersionView(ctx, name); err != nil {
+			t.Errorf("DeleteClusterVersionView(%s) error = %v", name, err)
+		}
+	}
+	if _, err := client.ManageObjects(ctx, "spoke1", ClusterVersionViewTemplates, MCV, "get"); err == nil {
+		t.Errorf("the clusterversion view of spoke1 is still on the hub")
+	}
+}